@@ -4,4 +4,6 @@ import "bytes"
 
 type Renderer interface {
 	TileImages(imageBufs []*bytes.Buffer) (*bytes.Buffer, error)
+	LabelledGrid(rows [][]*bytes.Buffer, columnLabels, rowLabels []string, title string) (*bytes.Buffer, error)
+	OutpaintCanvas(imageBuf *bytes.Buffer, width, height int, padding Padding) (*bytes.Buffer, *bytes.Buffer, error)
 }
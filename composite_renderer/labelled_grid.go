@@ -0,0 +1,159 @@
+package composite_renderer
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	// the webui returns webp images when that is its samples format
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	labelPadding = 8
+
+	// labels are drawn with a small bitmap font, and scaled up by one step for every labelScaleWidth pixels of cell
+	labelScaleWidth = 256
+)
+
+func (r *rendererImpl) LabelledGrid(rows [][]*bytes.Buffer, columnLabels, rowLabels []string, title string) (*bytes.Buffer, error) {
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		return nil, errors.New("no images to render")
+	}
+
+	if len(columnLabels) != len(rows[0]) {
+		return nil, errors.New("number of column labels does not match the number of columns")
+	}
+
+	if len(rowLabels) > 0 && len(rowLabels) != len(rows) {
+		return nil, errors.New("number of row labels does not match the number of rows")
+	}
+
+	images := make([][]image.Image, len(rows))
+	cellWidth := 0
+	cellHeight := 0
+
+	for rowIdx, row := range rows {
+		if len(row) != len(columnLabels) {
+			return nil, errors.New("rows are not the same length")
+		}
+
+		images[rowIdx] = make([]image.Image, len(row))
+
+		for columnIdx, buf := range row {
+			img, _, err := image.Decode(buf)
+			if err != nil {
+				return nil, err
+			}
+
+			images[rowIdx][columnIdx] = img
+
+			cellWidth = max(cellWidth, img.Bounds().Dx())
+			cellHeight = max(cellHeight, img.Bounds().Dy())
+		}
+	}
+
+	scale := max(1, cellWidth/labelScaleWidth)
+	face := basicfont.Face7x13
+	labelHeight := face.Height*scale + labelPadding*2
+
+	rowLabelWidth := 0
+
+	for _, label := range rowLabels {
+		rowLabelWidth = max(rowLabelWidth, font.MeasureString(face, label).Ceil()*scale+labelPadding*2)
+	}
+
+	rowLabelWidth = min(rowLabelWidth, cellWidth)
+
+	titleHeight := 0
+	if title != "" {
+		titleHeight = labelHeight
+	}
+
+	gridLeft := rowLabelWidth
+	gridTop := titleHeight + labelHeight
+
+	retImage := image.NewRGBA(image.Rect(0, 0, gridLeft+cellWidth*len(columnLabels), gridTop+cellHeight*len(rows)))
+
+	draw.Draw(retImage, retImage.Bounds(), image.White, image.Point{}, draw.Src)
+
+	if title != "" {
+		drawLabel(retImage, image.Rect(0, 0, retImage.Bounds().Dx(), titleHeight), title, scale)
+	}
+
+	for columnIdx, label := range columnLabels {
+		left := gridLeft + cellWidth*columnIdx
+
+		drawLabel(retImage, image.Rect(left, titleHeight, left+cellWidth, gridTop), label, scale)
+	}
+
+	for rowIdx, row := range images {
+		top := gridTop + cellHeight*rowIdx
+
+		if len(rowLabels) > 0 {
+			drawLabel(retImage, image.Rect(0, top, rowLabelWidth, top+cellHeight), rowLabels[rowIdx], scale)
+		}
+
+		for columnIdx, img := range row {
+			cellOrigin := image.Pt(gridLeft+cellWidth*columnIdx, top)
+
+			draw.Draw(retImage, img.Bounds().Sub(img.Bounds().Min).Add(cellOrigin), img, img.Bounds().Min, draw.Over)
+		}
+	}
+
+	imageBuf := new(bytes.Buffer)
+
+	err := png.Encode(imageBuf, retImage)
+	if err != nil {
+		return nil, err
+	}
+
+	return imageBuf, nil
+}
+
+// drawLabel draws the text centered in the rectangle, shortening it when it doesn't fit.
+func drawLabel(dst *image.RGBA, rect image.Rectangle, text string, scale int) {
+	face := basicfont.Face7x13
+
+	maxWidth := (rect.Dx() - labelPadding*2) / scale
+
+	// shortened by characters, so plot values outside of ASCII aren't cut in the middle of one
+	runes := []rune(text)
+
+	for len(runes) > 3 && font.MeasureString(face, text).Ceil() > maxWidth {
+		runes = append(runes[:len(runes)-4], '.', '.', '.')
+		text = string(runes)
+	}
+
+	textWidth := font.MeasureString(face, text).Ceil()
+	if textWidth <= 0 {
+		return
+	}
+
+	// render at the font's own size, then scale it up so labels stay readable on big images
+	textImage := image.NewRGBA(image.Rect(0, 0, textWidth, face.Height))
+
+	drawer := &font.Drawer{
+		Dst:  textImage,
+		Src:  image.NewUniform(color.Black),
+		Face: face,
+		Dot:  fixed.P(0, face.Ascent),
+	}
+	drawer.DrawString(text)
+
+	scaledWidth := textWidth * scale
+	scaledHeight := face.Height * scale
+
+	left := rect.Min.X + (rect.Dx()-scaledWidth)/2
+	top := rect.Min.Y + (rect.Dy()-scaledHeight)/2
+
+	draw.NearestNeighbor.Scale(dst, image.Rect(left, top, left+scaledWidth, top+scaledHeight),
+		textImage, textImage.Bounds(), draw.Over, nil)
+}
@@ -0,0 +1,96 @@
+package composite_renderer
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+
+	"golang.org/x/image/draw"
+)
+
+// maskOverlap is how far the repainted area reaches into the original image, so the seams blend in
+const maskOverlap = 16
+
+// Padding is how many pixels to add on each side of an image.
+type Padding struct {
+	Left   int
+	Top    int
+	Right  int
+	Bottom int
+}
+
+func (r *rendererImpl) OutpaintCanvas(imageBuf *bytes.Buffer, width, height int, padding Padding) (*bytes.Buffer, *bytes.Buffer, error) {
+	if width <= 0 || height <= 0 {
+		return nil, nil, errors.New("invalid image size")
+	}
+
+	if padding.Left < 0 || padding.Top < 0 || padding.Right < 0 || padding.Bottom < 0 {
+		return nil, nil, errors.New("invalid padding")
+	}
+
+	img, _, err := image.Decode(imageBuf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	source := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	draw.CatmullRom.Scale(source, source.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	canvasRect := image.Rect(0, 0, width+padding.Left+padding.Right, height+padding.Top+padding.Bottom)
+	canvas := image.NewRGBA(canvasRect)
+
+	// stretch the edge pixels into the padding, which gives the model colours to continue from
+	for y := 0; y < canvasRect.Dy(); y++ {
+		sourceY := min(max(y-padding.Top, 0), height-1)
+
+		for x := 0; x < canvasRect.Dx(); x++ {
+			sourceX := min(max(x-padding.Left, 0), width-1)
+
+			canvas.SetRGBA(x, y, source.RGBAAt(sourceX, sourceY))
+		}
+	}
+
+	mask := image.NewGray(canvasRect)
+
+	draw.Draw(mask, canvasRect, image.White, image.Point{}, draw.Src)
+
+	// keep the original image, except for a strip along the padded edges
+	keepRect := image.Rect(padding.Left, padding.Top, padding.Left+width, padding.Top+height)
+
+	if padding.Left > 0 {
+		keepRect.Min.X += maskOverlap
+	}
+
+	if padding.Top > 0 {
+		keepRect.Min.Y += maskOverlap
+	}
+
+	if padding.Right > 0 {
+		keepRect.Max.X -= maskOverlap
+	}
+
+	if padding.Bottom > 0 {
+		keepRect.Max.Y -= maskOverlap
+	}
+
+	draw.Draw(mask, keepRect, image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	canvasBuf := new(bytes.Buffer)
+
+	err = png.Encode(canvasBuf, canvas)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	maskBuf := new(bytes.Buffer)
+
+	err = png.Encode(maskBuf, mask)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return canvasBuf, maskBuf, nil
+}
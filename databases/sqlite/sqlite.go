@@ -82,6 +82,197 @@ const addGenerationBatchSizeColumnQuery string = `
 ALTER TABLE image_generations ADD COLUMN batch_count INTEGER NOT NULL DEFAULT 0;
 `
 
+const addGenerationCheckpointAndStyleColumnsQuery string = `
+ALTER TABLE image_generations ADD COLUMN checkpoint TEXT NOT NULL DEFAULT '';
+ALTER TABLE image_generations ADD COLUMN style TEXT NOT NULL DEFAULT '';
+`
+
+const addGenerationPromptTemplateColumnQuery string = `
+ALTER TABLE image_generations ADD COLUMN prompt_template TEXT NOT NULL DEFAULT '';
+`
+
+const addGenerationParentIDColumnQuery string = `
+ALTER TABLE image_generations ADD COLUMN parent_id INTEGER NOT NULL DEFAULT 0;
+`
+
+const addSettingsUpscaleColumnsQuery string = `
+ALTER TABLE default_settings ADD COLUMN upscale_method TEXT NOT NULL DEFAULT '';
+ALTER TABLE default_settings ADD COLUMN upscaler TEXT NOT NULL DEFAULT '';
+ALTER TABLE default_settings ADD COLUMN upscale_factor REAL NOT NULL DEFAULT 0;
+`
+
+const addGenerationVariationStrengthColumnQuery string = `
+ALTER TABLE image_generations ADD COLUMN variation_strength REAL NOT NULL DEFAULT 0;
+`
+
+const addSettingsVariationStrengthColumnsQuery string = `
+ALTER TABLE default_settings ADD COLUMN subtle_variation_strength REAL NOT NULL DEFAULT 0;
+ALTER TABLE default_settings ADD COLUMN strong_variation_strength REAL NOT NULL DEFAULT 0;
+`
+
+const addSettingsGenerationParameterColumnsQuery string = `
+ALTER TABLE default_settings ADD COLUMN negative_prompt TEXT NOT NULL DEFAULT '';
+ALTER TABLE default_settings ADD COLUMN sampler_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE default_settings ADD COLUMN steps INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE default_settings ADD COLUMN cfg_scale REAL NOT NULL DEFAULT 0;
+ALTER TABLE default_settings ADD COLUMN enable_hr INTEGER;
+ALTER TABLE default_settings ADD COLUMN restore_faces INTEGER;
+`
+
+const createChannelProfilesTableIfNotExistsQuery string = `
+CREATE TABLE IF NOT EXISTS channel_profiles (
+channel_id TEXT NOT NULL PRIMARY KEY,
+guild_id TEXT NOT NULL,
+model TEXT NOT NULL,
+negative_prompt TEXT NOT NULL,
+allowed_samplers TEXT NOT NULL,
+max_width INTEGER NOT NULL,
+max_height INTEGER NOT NULL,
+max_batch INTEGER NOT NULL
+);`
+
+const createChannelProfileGuildIndexIfNotExistsQuery string = `
+CREATE INDEX IF NOT EXISTS channel_profile_guild_index
+ON channel_profiles(guild_id);
+`
+
+const createAPIKeysTableIfNotExistsQuery string = `
+CREATE TABLE IF NOT EXISTS api_keys (
+id INTEGER NOT NULL PRIMARY KEY,
+name TEXT NOT NULL UNIQUE,
+key_hash TEXT NOT NULL UNIQUE,
+created_at DATETIME NOT NULL
+);`
+
+const addGenerationImageColumnsQuery string = `
+ALTER TABLE image_generations ADD COLUMN image_path TEXT NOT NULL DEFAULT '';
+ALTER TABLE image_generations ADD COLUMN image_hash TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS generation_image_path_index ON image_generations(image_path);
+`
+
+const createModerationRulesTableIfNotExistsQuery string = `
+CREATE TABLE IF NOT EXISTS moderation_rules (
+id INTEGER NOT NULL PRIMARY KEY,
+guild_id TEXT NOT NULL,
+channel_id TEXT NOT NULL,
+list TEXT NOT NULL,
+match_type TEXT NOT NULL,
+pattern TEXT NOT NULL,
+action TEXT NOT NULL,
+created_by TEXT NOT NULL,
+created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS moderation_rule_guild_index ON moderation_rules(guild_id);
+`
+
+const createModerationSettingsTableIfNotExistsQuery string = `
+CREATE TABLE IF NOT EXISTS moderation_settings (
+guild_id TEXT NOT NULL PRIMARY KEY,
+review_channel_id TEXT NOT NULL
+);`
+
+const createModerationDecisionsTableIfNotExistsQuery string = `
+CREATE TABLE IF NOT EXISTS moderation_decisions (
+id INTEGER NOT NULL PRIMARY KEY,
+guild_id TEXT NOT NULL,
+channel_id TEXT NOT NULL,
+member_id TEXT NOT NULL,
+prompt TEXT NOT NULL,
+rule_id INTEGER NOT NULL,
+pattern TEXT NOT NULL,
+decision TEXT NOT NULL,
+moderator_id TEXT NOT NULL,
+held_id INTEGER NOT NULL,
+created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS moderation_decision_guild_index ON moderation_decisions(guild_id, created_at);
+`
+
+const addGenerationRoutingColumnsQuery string = `
+ALTER TABLE image_generations ADD COLUMN routing TEXT NOT NULL DEFAULT '';
+ALTER TABLE image_generations ADD COLUMN routed_channel_id TEXT NOT NULL DEFAULT '';
+`
+
+const addModerationNSFWChannelColumnQuery string = `
+ALTER TABLE moderation_settings ADD COLUMN nsfw_channel_id TEXT NOT NULL DEFAULT '';
+`
+
+const addGenerationLocationColumnsQuery string = `
+ALTER TABLE image_generations ADD COLUMN guild_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE image_generations ADD COLUMN channel_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS generation_member_index ON image_generations(member_id, id);
+`
+
+const createGenerationSearchTableQuery string = `
+CREATE VIRTUAL TABLE IF NOT EXISTS image_generations_fts USING fts5(
+prompt,
+negative_prompt,
+content='image_generations',
+content_rowid='id'
+);
+INSERT INTO image_generations_fts(image_generations_fts) VALUES ('rebuild');
+CREATE TRIGGER IF NOT EXISTS image_generations_fts_insert AFTER INSERT ON image_generations BEGIN
+INSERT INTO image_generations_fts(rowid, prompt, negative_prompt) VALUES (new.id, new.prompt, new.negative_prompt);
+END;
+CREATE TRIGGER IF NOT EXISTS image_generations_fts_delete AFTER DELETE ON image_generations BEGIN
+INSERT INTO image_generations_fts(image_generations_fts, rowid, prompt, negative_prompt)
+VALUES ('delete', old.id, old.prompt, old.negative_prompt);
+END;
+CREATE TRIGGER IF NOT EXISTS image_generations_fts_update AFTER UPDATE OF prompt, negative_prompt ON image_generations BEGIN
+INSERT INTO image_generations_fts(image_generations_fts, rowid, prompt, negative_prompt)
+VALUES ('delete', old.id, old.prompt, old.negative_prompt);
+INSERT INTO image_generations_fts(rowid, prompt, negative_prompt) VALUES (new.id, new.prompt, new.negative_prompt);
+END;
+`
+
+const createFavoritesTableIfNotExistsQuery string = `
+CREATE TABLE IF NOT EXISTS favorites (
+id INTEGER NOT NULL PRIMARY KEY,
+message_id TEXT NOT NULL,
+guild_id TEXT NOT NULL,
+channel_id TEXT NOT NULL,
+member_id TEXT NOT NULL,
+created_at DATETIME NOT NULL,
+UNIQUE (message_id, member_id)
+);
+CREATE INDEX IF NOT EXISTS favorite_member_index ON favorites(member_id, id);
+`
+
+const createGalleryTablesIfNotExistsQuery string = `
+CREATE TABLE IF NOT EXISTS gallery_settings (
+guild_id TEXT NOT NULL PRIMARY KEY,
+channel_id TEXT NOT NULL,
+threshold INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS gallery_posts (
+message_id TEXT NOT NULL PRIMARY KEY,
+guild_id TEXT NOT NULL,
+gallery_message_id TEXT NOT NULL,
+created_at DATETIME NOT NULL
+);
+`
+
+const addGenerationInfotextColumnQuery string = `
+ALTER TABLE image_generations ADD COLUMN infotext TEXT NOT NULL DEFAULT '';
+`
+
+const addGenerationDeletedColumnQuery string = `
+ALTER TABLE image_generations ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0;
+`
+
+const createPermissionGrantsTableIfNotExistsQuery string = `
+CREATE TABLE IF NOT EXISTS permission_grants (
+id INTEGER NOT NULL PRIMARY KEY,
+guild_id TEXT NOT NULL,
+capability TEXT NOT NULL,
+subject_type TEXT NOT NULL,
+subject_id TEXT NOT NULL,
+created_by TEXT NOT NULL,
+created_at DATETIME NOT NULL,
+UNIQUE (guild_id, capability, subject_type, subject_id)
+);
+`
+
 type migration struct {
 	migrationName  string
 	migrationQuery string
@@ -97,6 +288,29 @@ var migrations = []migration{
 	{migrationName: "create default settings table", migrationQuery: createDefaultSettingsTableIfNotExistsQuery},
 	{migrationName: "add settings batch columns", migrationQuery: addSettingsBatchColumnsQuery},
 	{migrationName: "add generation batch count column", migrationQuery: addGenerationBatchSizeColumnQuery},
+	{migrationName: "add generation checkpoint and style columns", migrationQuery: addGenerationCheckpointAndStyleColumnsQuery},
+	{migrationName: "add generation prompt template column", migrationQuery: addGenerationPromptTemplateColumnQuery},
+	{migrationName: "add generation parent id column", migrationQuery: addGenerationParentIDColumnQuery},
+	{migrationName: "add settings upscale columns", migrationQuery: addSettingsUpscaleColumnsQuery},
+	{migrationName: "add generation variation strength column", migrationQuery: addGenerationVariationStrengthColumnQuery},
+	{migrationName: "add settings variation strength columns", migrationQuery: addSettingsVariationStrengthColumnsQuery},
+	{migrationName: "add settings generation parameter columns", migrationQuery: addSettingsGenerationParameterColumnsQuery},
+	{migrationName: "create channel profiles table", migrationQuery: createChannelProfilesTableIfNotExistsQuery},
+	{migrationName: "add channel profile guild index", migrationQuery: createChannelProfileGuildIndexIfNotExistsQuery},
+	{migrationName: "create api keys table", migrationQuery: createAPIKeysTableIfNotExistsQuery},
+	{migrationName: "add generation image columns", migrationQuery: addGenerationImageColumnsQuery},
+	{migrationName: "create moderation rules table", migrationQuery: createModerationRulesTableIfNotExistsQuery},
+	{migrationName: "create moderation settings table", migrationQuery: createModerationSettingsTableIfNotExistsQuery},
+	{migrationName: "create moderation decisions table", migrationQuery: createModerationDecisionsTableIfNotExistsQuery},
+	{migrationName: "add generation routing columns", migrationQuery: addGenerationRoutingColumnsQuery},
+	{migrationName: "add moderation nsfw channel column", migrationQuery: addModerationNSFWChannelColumnQuery},
+	{migrationName: "add generation location columns", migrationQuery: addGenerationLocationColumnsQuery},
+	{migrationName: "create generation search table", migrationQuery: createGenerationSearchTableQuery},
+	{migrationName: "create favorites table", migrationQuery: createFavoritesTableIfNotExistsQuery},
+	{migrationName: "create gallery tables", migrationQuery: createGalleryTablesIfNotExistsQuery},
+	{migrationName: "add generation infotext column", migrationQuery: addGenerationInfotextColumnQuery},
+	{migrationName: "add generation deleted column", migrationQuery: addGenerationDeletedColumnQuery},
+	{migrationName: "create permission grants table", migrationQuery: createPermissionGrantsTableIfNotExistsQuery},
 }
 
 func New(ctx context.Context) (*sql.DB, error) {
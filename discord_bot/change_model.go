@@ -0,0 +1,347 @@
+package discord_bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	changeModelOptionModel = `model`
+
+	changeModelMenuID = "imagine_change_model"
+	changeModelPageID = "imagine_change_model_page"
+
+	// maxChoices is how many choices Discord shows for a select menu or an autocompleted option
+	maxChoices = 25
+	// maxChoiceLength is the most characters Discord allows in the name and value of a choice
+	maxChoiceLength = 100
+
+	// modelLoadTimeout is how long to wait for the WebUI to load a model, big checkpoints on slow disks take a while
+	modelLoadTimeout = 3 * time.Minute
+	// modelLoadPollInterval is how often the WebUI is asked whether the model is loaded
+	modelLoadPollInterval = 2 * time.Second
+
+	changeModelRefusal = "Only members who may change the model can switch it, it changes the model for everyone."
+)
+
+func (b *botImpl) addChangeModelCommand() error {
+	log.Printf("Adding command '%s'...", b.changeModelCommandString())
+
+	manageServer := int64(discordgo.PermissionManageServer)
+
+	cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
+		Name:                     b.changeModelCommandString(),
+		Description:              "Change the model used for the imagine command",
+		DefaultMemberPermissions: &manageServer,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         changeModelOptionModel,
+				Description:  "The model to switch to, leave it out to pick from a list",
+				Required:     false,
+				Autocomplete: true,
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating '%s' command: %v", b.changeModelCommandString(), err)
+		return err
+	}
+
+	b.registeredCommands = append(b.registeredCommands, cmd)
+
+	return nil
+}
+
+// choiceValue cuts a model title to what fits in a choice. Titles are matched by their start, so a cut one still
+// finds its model.
+func choiceValue(title string) string {
+	runes := []rune(title)
+	if len(runes) <= maxChoiceLength {
+		return title
+	}
+
+	return string(runes[:maxChoiceLength])
+}
+
+// processChangeModelAutocomplete suggests the models whose titles contain what was typed so far.
+func (b *botImpl) processChangeModelAutocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var search string
+
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == changeModelOptionModel && option.Focused {
+			search = strings.ToLower(strings.TrimSpace(option.StringValue()))
+		}
+	}
+
+	var models []string
+
+	// members who can't change the model get no suggestions
+	if b.canChangeModel(i) {
+		var err error
+
+		models, err = b.stableDiffusionAPI.GetModels()
+		if err != nil {
+			log.Printf("Error fetching models: %v", err)
+		}
+	}
+
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, maxChoices)
+
+	for _, model := range models {
+		if len(choices) == maxChoices {
+			break
+		}
+
+		if !strings.Contains(strings.ToLower(model), search) {
+			continue
+		}
+
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  truncate(model, maxChoiceLength),
+			Value: choiceValue(model),
+		})
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to autocomplete: %v", err)
+	}
+}
+
+// processChangeModelCommand switches to the model given with the command, or shows the list of models to pick from.
+func (b *botImpl) processChangeModelCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !b.canChangeModel(i) {
+		respondEphemeral(s, i, changeModelRefusal)
+
+		return
+	}
+
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == changeModelOptionModel {
+			b.changeModel(s, i, option.StringValue())
+
+			return
+		}
+	}
+
+	models, err := b.stableDiffusionAPI.GetModels()
+	if err != nil {
+		log.Printf("Error fetching model titles: %v", err)
+
+		respondEphemeral(s, i, "Error getting the models...")
+
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: changeModelPage(models, 0),
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+// changeModelPage is a page of the models to pick from, the buttons under the menu go through the pages.
+func changeModelPage(models []string, page int) *discordgo.InteractionResponseData {
+	// Discord rejects a select menu without options
+	if len(models) == 0 {
+		return &discordgo.InteractionResponseData{
+			Content:    "No models are available, the WebUI didn't list any.",
+			Components: []discordgo.MessageComponent{},
+		}
+	}
+
+	pages := max(1, (len(models)+maxChoices-1)/maxChoices)
+	page = min(max(page, 0), pages-1)
+
+	pageModels := models[min(page*maxChoices, len(models)):min((page+1)*maxChoices, len(models))]
+
+	options := make([]discordgo.SelectMenuOption, len(pageModels))
+	for idx, title := range pageModels {
+		options[idx] = discordgo.SelectMenuOption{
+			Label: truncate(title, maxChoiceLength),
+			Value: choiceValue(title),
+		}
+	}
+
+	minValues := 1
+
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:    changeModelMenuID,
+					Placeholder: fmt.Sprintf("Models %d to %d of %d", page*maxChoices+1, page*maxChoices+len(options), len(models)),
+					MinValues:   &minValues,
+					MaxValues:   1,
+					Options:     options,
+				},
+			},
+		},
+	}
+
+	if pages > 1 {
+		components = append(components, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Previous",
+					Style:    discordgo.PrimaryButton,
+					CustomID: changeModelPageID + ":" + strconv.Itoa(page-1),
+					Disabled: page == 0,
+				},
+				discordgo.Button{
+					Label:    "Next",
+					Style:    discordgo.PrimaryButton,
+					CustomID: changeModelPageID + ":" + strconv.Itoa(page+1),
+					Disabled: page == pages-1,
+				},
+			},
+		})
+	}
+
+	content := "Choose a model for the imagine command:"
+	if pages > 1 {
+		content = fmt.Sprintf("Choose a model for the imagine command (page %d of %d):", page+1, pages)
+	}
+
+	return &discordgo.InteractionResponseData{
+		Content:    content,
+		Components: components,
+	}
+}
+
+func (b *botImpl) processChangeModelPage(s *discordgo.Session, i *discordgo.InteractionCreate, scope string) {
+	if !b.canChangeModel(i) {
+		respondEphemeral(s, i, changeModelRefusal)
+
+		return
+	}
+
+	page, err := strconv.Atoi(scope)
+	if err != nil {
+		log.Printf("Error parsing model page: %v", err)
+
+		return
+	}
+
+	models, err := b.stableDiffusionAPI.GetModels()
+	if err != nil {
+		log.Printf("Error fetching model titles: %v", err)
+
+		respondEphemeral(s, i, "Error getting the models...")
+
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: changeModelPage(models, page),
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+func (b *botImpl) processChangeModelMenu(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if !b.canChangeModel(i) {
+		respondEphemeral(s, i, changeModelRefusal)
+
+		return
+	}
+
+	if len(i.MessageComponentData().Values) == 0 {
+		log.Printf("No values for change model menu")
+
+		return
+	}
+
+	b.changeModel(s, i, i.MessageComponentData().Values[0])
+}
+
+// changeModel switches the WebUI to the model, and waits for it to be loaded before telling how long it took.
+func (b *botImpl) changeModel(s *discordgo.Session, i *discordgo.InteractionCreate, name string) {
+	title, ok := b.findModel(strings.TrimSpace(name))
+	if !ok {
+		respondEphemeral(s, i, fmt.Sprintf("I don't know the model \"%s\".", name))
+
+		return
+	}
+
+	// loading a model takes longer than Discord waits for a response
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		log.Printf("Error deferring interaction: %v", err)
+		return
+	}
+
+	var content string
+
+	started := time.Now()
+
+	hash, err := b.loadModel(title)
+
+	switch {
+	case errors.Is(err, errModelLoadTimeout):
+		content = fmt.Sprintf("I asked for %s, but it still wasn't loaded after %s.", title, modelLoadTimeout)
+	case err != nil:
+		log.Printf("Failed to change the model to %s: %v", title, err)
+
+		content = "Error updating the model. Please try again."
+	default:
+		content = fmt.Sprintf("Switched to %s in %s.", title, time.Since(started).Round(100*time.Millisecond))
+
+		if hash != "" {
+			content += fmt.Sprintf(" Hash: `%s`", hash)
+		}
+	}
+
+	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content: &content,
+	})
+	if err != nil {
+		log.Printf("Error editing interaction: %v", err)
+	}
+}
+
+var errModelLoadTimeout = errors.New("timed out waiting for the model to load")
+
+// loadModel sets the model of the WebUI, and polls its options until it reports the model as loaded. It returns the
+// hash of the loaded checkpoint.
+func (b *botImpl) loadModel(title string) (string, error) {
+	err := b.stableDiffusionAPI.SetSelectedModel(title)
+	if err != nil {
+		return "", err
+	}
+
+	deadline := time.Now().Add(modelLoadTimeout)
+
+	for {
+		options, err := b.stableDiffusionAPI.GetOptions()
+		if err != nil {
+			log.Printf("Error getting options: %v", err)
+		} else if options.SDModelCheckpoint == title {
+			return options.SDCheckpointHash, nil
+		}
+
+		if time.Now().After(deadline) {
+			return "", errModelLoadTimeout
+		}
+
+		time.Sleep(modelLoadPollInterval)
+	}
+}
@@ -0,0 +1,273 @@
+package discord_bot
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/imagine_queue"
+	"stable_diffusion_bot/stable_diffusion_api"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxUpscaleAll is how many images "Upscale all" upscales, the four of a grid
+const maxUpscaleAll = 4
+
+func (b *botImpl) reimagineMessageCommandString() string {
+	if b.developmentMode {
+		return "dev_Reimagine"
+	}
+
+	return "Reimagine"
+}
+
+func (b *botImpl) describeMessageCommandString() string {
+	if b.developmentMode {
+		return "dev_Describe"
+	}
+
+	return "Describe"
+}
+
+func (b *botImpl) upscaleAllMessageCommandString() string {
+	if b.developmentMode {
+		return "dev_Upscale all"
+	}
+
+	return "Upscale all"
+}
+
+// addImageMessageCommands adds "Reimagine", "Describe" and "Upscale all" to the Apps menu of messages. They work on
+// images posted by anyone, and on results whose buttons are gone.
+func (b *botImpl) addImageMessageCommands() error {
+	names := []string{
+		b.reimagineMessageCommandString(),
+		b.describeMessageCommandString(),
+		b.upscaleAllMessageCommandString(),
+	}
+
+	for _, name := range names {
+		log.Printf("Adding command '%s'...", name)
+
+		cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
+			Name: name,
+			Type: discordgo.MessageApplicationCommand,
+		})
+		if err != nil {
+			log.Printf("Error creating '%s' command: %v", name, err)
+			return err
+		}
+
+		b.registeredCommands = append(b.registeredCommands, cmd)
+	}
+
+	return nil
+}
+
+// targetMessage returns the message a message command was used on.
+func targetMessage(i *discordgo.InteractionCreate) *discordgo.Message {
+	data := i.ApplicationCommandData()
+
+	if data.Resolved == nil {
+		return nil
+	}
+
+	return data.Resolved.Messages[data.TargetID]
+}
+
+// messageImage returns the first image of the message and its size. Results that link to their images instead of
+// uploading them have them in embeds.
+func messageImage(message *discordgo.Message) (string, int, int, bool) {
+	if message == nil {
+		return "", 0, 0, false
+	}
+
+	for _, attachment := range message.Attachments {
+		if strings.HasPrefix(attachment.ContentType, "image/") {
+			return attachment.URL, attachment.Width, attachment.Height, true
+		}
+	}
+
+	for _, embed := range message.Embeds {
+		if embed.Image != nil && embed.Image.URL != "" {
+			return embed.Image.URL, embed.Image.Width, embed.Image.Height, true
+		}
+	}
+
+	return "", 0, 0, false
+}
+
+// processReimagineMessageCommand renders the image of the message again with img2img. Results of the bot keep their
+// prompt, other images get one from interrogating them.
+func (b *botImpl) processReimagineMessageCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	message := targetMessage(i)
+
+	imageURL, width, height, ok := messageImage(message)
+	if !ok {
+		respondEphemeral(s, i, "That message has no image I can reimagine.")
+
+		return
+	}
+
+	source := b.interactionJobSource(s, i.Interaction)
+	source.MessageID = message.ID
+	source.ImageURL = imageURL
+
+	options := imagine_queue.NewQueueItemOptions()
+	options.Width = width
+	options.Height = height
+
+	position, queueError := b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
+		Type:    imagine_queue.ItemTypeReimagine,
+		Options: options,
+		Source:  source,
+	})
+	if queueError != nil {
+		log.Printf("Error adding imagine to queue: %v\n", queueError)
+
+		b.respondQueueError(s, i, queueError)
+
+		return
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("I'm reimagining that image for you... You are currently #%d in line.", position),
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+// processDescribeMessageCommand interrogates the image of the message, and shows the prompt it came up with to
+// whoever asked.
+func (b *botImpl) processDescribeMessageCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	imageURL, _, _, ok := messageImage(targetMessage(i))
+	if !ok {
+		respondEphemeral(s, i, "That message has no image I can describe.")
+
+		return
+	}
+
+	// interrogating takes longer than Discord waits for a response
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error deferring interaction: %v", err)
+		return
+	}
+
+	content := "I couldn't describe that image..."
+
+	caption, err := b.describeImage(imageURL)
+	if err != nil {
+		log.Printf("Error describing image: %v", err)
+	} else {
+		content = truncate("I'd describe it as:\n```\n"+caption, maxMessageLength-4) + "\n```"
+	}
+
+	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content: &content,
+	})
+	if err != nil {
+		log.Printf("Error editing interaction: %v", err)
+	}
+}
+
+func (b *botImpl) describeImage(imageURL string) (string, error) {
+	image, err := imagine_queue.DownloadImage(imageURL)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := b.stableDiffusionAPI.Interrogate(&stable_diffusion_api.InterrogateRequest{
+		Image: base64.StdEncoding.EncodeToString(image.Bytes()),
+		Model: stable_diffusion_api.InterrogateModelClip,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Caption, nil
+}
+
+// processUpscaleAllMessageCommand queues an upscale of every image of a grid. The first one is posted as the response,
+// the others as followups.
+func (b *botImpl) processUpscaleAllMessageCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	messageID := i.ApplicationCommandData().TargetID
+
+	generations, err := b.imagineQueue.ListGenerationsByMessage(messageID)
+	if err != nil {
+		log.Printf("Error listing generations: %v", err)
+
+		respondEphemeral(s, i, "Error getting the images to upscale...")
+
+		return
+	}
+
+	var images []*entities.ImageGeneration
+
+	for _, generation := range generations {
+		if generation.SortOrder > 0 && !generation.Deleted {
+			images = append(images, generation)
+		}
+	}
+
+	if len(images) == 0 {
+		respondEphemeral(s, i, "I can only upscale all the images of a grid I imagined.")
+
+		return
+	}
+
+	if len(images) > maxUpscaleAll {
+		images = images[:maxUpscaleAll]
+	}
+
+	source := b.interactionJobSource(s, i.Interaction)
+	source.MessageID = messageID
+
+	var position int
+
+	for idx, generation := range images {
+		linePosition, queueError := b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
+			Type:             imagine_queue.ItemTypeUpscale,
+			InteractionIndex: generation.SortOrder,
+			Source:           source,
+			IsFollowup:       idx > 0,
+		})
+		if queueError != nil {
+			log.Printf("Error adding imagine to queue: %v\n", queueError)
+
+			if idx == 0 {
+				b.respondQueueError(s, i, queueError)
+
+				return
+			}
+
+			break
+		}
+
+		if idx == 0 {
+			position = linePosition
+		}
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("I'm upscaling %d images for you... You are currently #%d in line.", len(images), position),
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package discord_bot
+
+import (
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const deleteButtonID = "imagine_delete"
+
+func (b *botImpl) deleteMessageCommandString() string {
+	if b.developmentMode {
+		return "dev_Delete images"
+	}
+
+	return "Delete images"
+}
+
+// addDeleteMessageCommand adds "Delete images" to the Apps menu of messages, for results whose buttons are gone.
+func (b *botImpl) addDeleteMessageCommand() error {
+	log.Printf("Adding command '%s'...", b.deleteMessageCommandString())
+
+	cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
+		Name: b.deleteMessageCommandString(),
+		Type: discordgo.MessageApplicationCommand,
+	})
+	if err != nil {
+		log.Printf("Error creating '%s' command: %v", b.deleteMessageCommandString(), err)
+		return err
+	}
+
+	b.registeredCommands = append(b.registeredCommands, cmd)
+
+	return nil
+}
+
+// deleteButton deletes the result, for whoever asked for it and moderators.
+func deleteButton() discordgo.Button {
+	return discordgo.Button{
+		Label:    "Delete",
+		Style:    discordgo.DangerButton,
+		CustomID: deleteButtonID,
+		Emoji: discordgo.ComponentEmoji{
+			Name: "🗑️",
+		},
+	}
+}
+
+func (b *botImpl) processDeleteButton(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	b.deleteResult(s, i, i.Message.ID)
+}
+
+func (b *botImpl) processDeleteMessageCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	b.deleteResult(s, i, i.ApplicationCommandData().TargetID)
+}
+
+// deleteResult deletes a result message in the channel of the interaction, and marks its generations as deleted.
+func (b *botImpl) deleteResult(s *discordgo.Session, i *discordgo.InteractionCreate, messageID string) {
+	generations, err := b.imagineQueue.ListGenerationsByMessage(messageID)
+	if err != nil {
+		log.Printf("Error listing generations: %v", err)
+
+		respondEphemeral(s, i, "Error deleting the images...")
+
+		return
+	}
+
+	if len(generations) == 0 || generations[0].Deleted {
+		respondEphemeral(s, i, "That message has no images I imagined.")
+
+		return
+	}
+
+	if generations[0].MemberID != interactionMemberID(i.Interaction) && !b.canModerate(i, discordgo.PermissionManageMessages) {
+		respondEphemeral(s, i, "Only whoever asked for these images, or moderators, can delete them.")
+
+		return
+	}
+
+	err = s.ChannelMessageDelete(i.ChannelID, messageID)
+	if err != nil {
+		log.Printf("Error deleting message %v: %v", messageID, err)
+
+		respondEphemeral(s, i, "I couldn't delete the message...")
+
+		return
+	}
+
+	err = b.imagineQueue.DeleteGenerations(messageID)
+	if err != nil {
+		log.Printf("Error marking generations of message %v deleted: %v", messageID, err)
+	}
+
+	respondEphemeral(s, i, "Deleted the images.")
+}
@@ -7,7 +7,6 @@ import (
 	"strconv"
 	"strings"
 
-	"stable_diffusion_bot/entities"
 	"stable_diffusion_bot/imagine_queue"
 	"stable_diffusion_bot/stable_diffusion_api"
 
@@ -23,6 +22,7 @@ type botImpl struct {
 	imagineCommand     string
 	removeCommands     bool
 	stableDiffusionAPI stable_diffusion_api.StableDiffusionAPI
+	galleryLinks       bool
 }
 
 type Config struct {
@@ -33,6 +33,8 @@ type Config struct {
 	ImagineCommand     string
 	RemoveCommands     bool
 	StableDiffusionAPI stable_diffusion_api.StableDiffusionAPI
+	// GalleryLinks shows results that are too big to upload as links to the image store instead
+	GalleryLinks bool
 }
 
 func (b *botImpl) imagineCommandString() string {
@@ -67,6 +69,14 @@ func (b *botImpl) changeModelCommandString() string {
 	return b.imagineCommand + "_change_model"
 }
 
+func (b *botImpl) plotCommandString() string {
+	if b.developmentMode {
+		return "dev_" + b.imagineCommand + "_plot"
+	}
+
+	return b.imagineCommand + "_plot"
+}
+
 func New(cfg Config) (Bot, error) {
 	if cfg.BotToken == "" {
 		return nil, errors.New("missing bot token")
@@ -110,6 +120,7 @@ func New(cfg Config) (Bot, error) {
 		imagineCommand:     cfg.ImagineCommand,
 		removeCommands:     cfg.RemoveCommands,
 		stableDiffusionAPI: cfg.StableDiffusionAPI,
+		galleryLinks:       cfg.GalleryLinks,
 	}
 
 	err = bot.addImagineCommand()
@@ -132,6 +143,64 @@ func New(cfg Config) (Bot, error) {
 		return nil, err
 	}
 
+	err = bot.addPlotCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bot.addProfileCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bot.addModerationCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bot.addHistoryCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bot.addSearchCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bot.addFavoritesCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bot.addGalleryCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bot.addDeleteMessageCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bot.addImageMessageCommands()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bot.addPermissionsCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	botSession.AddHandler(func(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+		bot.processFavoriteReaction(s, r.MessageReaction, true)
+	})
+
+	botSession.AddHandler(func(s *discordgo.Session, r *discordgo.MessageReactionRemove) {
+		bot.processFavoriteReaction(s, r.MessageReaction, false)
+	})
+
 	botSession.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		switch i.Type {
 		case discordgo.InteractionApplicationCommand:
@@ -143,36 +212,142 @@ func New(cfg Config) (Bot, error) {
 			case bot.imagineSettingsCommandString():
 				bot.processImagineSettingsCommand(s, i)
 			case bot.changeModelCommandString():
-				bot.processModelSettingsCommand(s, i)
+				bot.processChangeModelCommand(s, i)
+			case bot.plotCommandString():
+				bot.processPlotCommand(s, i)
+			case bot.profileCommandString():
+				bot.processProfileCommand(s, i)
+			case bot.moderationCommandString():
+				bot.processModerationCommand(s, i)
+			case bot.historyCommandString():
+				bot.processHistoryCommand(s, i)
+			case bot.searchCommandString():
+				bot.processSearchCommand(s, i)
+			case bot.favoritesCommandString():
+				bot.processFavoritesCommand(s, i)
+			case bot.galleryCommandString():
+				bot.processGalleryCommand(s, i)
+			case bot.deleteMessageCommandString():
+				bot.processDeleteMessageCommand(s, i)
+			case bot.reimagineMessageCommandString():
+				bot.processReimagineMessageCommand(s, i)
+			case bot.describeMessageCommandString():
+				bot.processDescribeMessageCommand(s, i)
+			case bot.upscaleAllMessageCommandString():
+				bot.processUpscaleAllMessageCommand(s, i)
+			case bot.permissionsCommandString():
+				bot.processPermissionsCommand(s, i)
 			default:
 				log.Printf("Unknown command '%v'", i.ApplicationCommandData().Name)
 			}
+		case discordgo.InteractionApplicationCommandAutocomplete:
+			switch i.ApplicationCommandData().Name {
+			case bot.changeModelCommandString():
+				bot.processChangeModelAutocomplete(s, i)
+			default:
+				log.Printf("Unknown autocomplete '%v'", i.ApplicationCommandData().Name)
+			}
 		case discordgo.InteractionMessageComponent:
-			switch customID := i.MessageComponentData().CustomID; {
+			// settings menus carry the scope they edit after a colon
+			componentID, scope, _ := strings.Cut(i.MessageComponentData().CustomID, ":")
+			settingsKey := settingsKeyForInteraction(i.Interaction, settingsScope(scope))
+
+			switch customID := componentID; {
 			case customID == "imagine_reroll":
 				bot.processImagineReroll(s, i)
-			case strings.HasPrefix(customID, "imagine_upscale_"):
-				interactionIndex := strings.TrimPrefix(customID, "imagine_upscale_")
+			case customID == "imagine_remix":
+				bot.processImagineRemix(s, i)
+			case strings.HasPrefix(customID, "imagine_upscaler_menu_"):
+				if len(i.MessageComponentData().Values) == 0 {
+					log.Printf("No values for imagine upscaler menu")
 
-				interactionIndexInt, intErr := strconv.Atoi(interactionIndex)
-				if intErr != nil {
-					log.Printf("Error parsing interaction index: %v", err)
+					return
+				}
+
+				messageID, upscaleIndex, parseErr := parseUpscaleMenuID(customID, "imagine_upscaler_menu_")
+				if parseErr != nil {
+					log.Printf("Error parsing upscaler menu: %v", parseErr)
 
 					return
 				}
 
-				bot.processImagineUpscale(s, i, interactionIndexInt)
-			case strings.HasPrefix(customID, "imagine_variation_"):
-				interactionIndex := strings.TrimPrefix(customID, "imagine_variation_")
+				bot.processImagineUpscalerMenu(s, i, messageID, upscaleIndex, i.MessageComponentData().Values[0])
+			case strings.HasPrefix(customID, "imagine_upscale_factor_menu_"):
+				if len(i.MessageComponentData().Values) == 0 {
+					log.Printf("No values for imagine upscale factor menu")
+
+					return
+				}
+
+				messageID, upscaleIndex, parseErr := parseUpscaleMenuID(customID, "imagine_upscale_factor_menu_")
+				if parseErr != nil {
+					log.Printf("Error parsing upscale factor menu: %v", parseErr)
+
+					return
+				}
+
+				bot.processImagineUpscaleFactorMenu(s, i, messageID, upscaleIndex, i.MessageComponentData().Values[0])
+			case strings.HasPrefix(customID, "imagine_outpaint_zoom_"):
+				zoomFactor := strings.TrimPrefix(customID, "imagine_outpaint_zoom_")
+
+				zoomFactorFloat, floatErr := strconv.ParseFloat(zoomFactor, 64)
+				if floatErr != nil {
+					log.Printf("Error parsing zoom factor: %v", floatErr)
+
+					return
+				}
+
+				bot.processImagineOutpaint(s, i, &imagine_queue.OutpaintOptions{
+					Direction:  imagine_queue.OutpaintZoomOut,
+					ZoomFactor: zoomFactorFloat,
+				})
+			case strings.HasPrefix(customID, "imagine_outpaint_pan_"):
+				direction := strings.TrimPrefix(customID, "imagine_outpaint_pan_")
+
+				bot.processImagineOutpaint(s, i, &imagine_queue.OutpaintOptions{
+					Direction: imagine_queue.OutpaintDirection(direction),
+				})
+			case customID == "imagine_variation_strength_menu":
+				if len(i.MessageComponentData().Values) == 0 {
+					log.Printf("No values for imagine variation strength menu")
+
+					return
+				}
+
+				interactionIndex, variation, _ := strings.Cut(i.MessageComponentData().Values[0], "_")
 
 				interactionIndexInt, intErr := strconv.Atoi(interactionIndex)
 				if intErr != nil {
-					log.Printf("Error parsing interaction index: %v", err)
+					log.Printf("Error parsing interaction index: %v", intErr)
+
+					return
+				}
+
+				bot.processImagineVariation(s, i, interactionIndexInt, imagine_queue.VariationStrength(variation))
+			case customID == "imagine_variation_setting_menu":
+				if len(i.MessageComponentData().Values) == 0 {
+					log.Printf("No values for imagine variation setting menu")
+
+					return
+				}
+
+				subtle, strong, _ := strings.Cut(i.MessageComponentData().Values[0], "|")
+
+				subtleFloat, floatErr := strconv.ParseFloat(subtle, 64)
+				if floatErr != nil {
+					log.Printf("Error parsing subtle variation strength: %v", floatErr)
+
+					return
+				}
+
+				strongFloat, floatErr := strconv.ParseFloat(strong, 64)
+				if floatErr != nil {
+					log.Printf("Error parsing strong variation strength: %v", floatErr)
 
 					return
 				}
 
-				bot.processImagineVariation(s, i, interactionIndexInt)
+				bot.processImagineVariationSetting(s, i, settingsKey, subtleFloat, strongFloat)
 			case customID == "imagine_dimension_setting_menu":
 				if len(i.MessageComponentData().Values) == 0 {
 					log.Printf("No values for imagine dimension setting menu")
@@ -199,7 +374,7 @@ func New(cfg Config) (Bot, error) {
 					return
 				}
 
-				bot.processImagineDimensionSetting(s, i, widthInt, heightInt)
+				bot.processImagineDimensionSetting(s, i, settingsKey, widthInt, heightInt)
 			case customID == "imagine_batch_count_setting_menu":
 				if len(i.MessageComponentData().Values) == 0 {
 					log.Printf("No values for imagine batch count setting menu")
@@ -232,7 +407,7 @@ func New(cfg Config) (Bot, error) {
 					return
 				}
 
-				bot.processImagineBatchSetting(s, i, batchCountInt, batchSizeInt)
+				bot.processImagineBatchSetting(s, i, settingsKey, batchCountInt, batchSizeInt)
 			case customID == "imagine_batch_size_setting_menu":
 				if len(i.MessageComponentData().Values) == 0 {
 					log.Printf("No values for imagine batch count setting menu")
@@ -265,12 +440,128 @@ func New(cfg Config) (Bot, error) {
 					return
 				}
 
-				bot.processImagineBatchSetting(s, i, batchCountInt, batchSizeInt)
-			case customID == "imagine_change_model":
-				bot.processChangeModel(s, i)
+				bot.processImagineBatchSetting(s, i, settingsKey, batchCountInt, batchSizeInt)
+			case customID == "imagine_upscale_setting_menu":
+				if len(i.MessageComponentData().Values) == 0 {
+					log.Printf("No values for imagine upscale setting menu")
+
+					return
+				}
+
+				method, factor, _ := strings.Cut(i.MessageComponentData().Values[0], "|")
+
+				factorFloat, floatErr := strconv.ParseFloat(factor, 64)
+				if floatErr != nil {
+					log.Printf("Error parsing upscale factor: %v", floatErr)
+
+					return
+				}
+
+				bot.processImagineUpscaleSetting(s, i, settingsKey, imagine_queue.UpscaleMethod(method), factorFloat)
+			case customID == "imagine_upscaler_setting_menu":
+				if len(i.MessageComponentData().Values) == 0 {
+					log.Printf("No values for imagine upscaler setting menu")
+
+					return
+				}
+
+				bot.processImagineUpscalerSetting(s, i, settingsKey, i.MessageComponentData().Values[0])
+			case customID == "imagine_hires_setting_menu", customID == "imagine_restore_faces_setting_menu":
+				if len(i.MessageComponentData().Values) == 0 {
+					log.Printf("No values for %v", customID)
+
+					return
+				}
+
+				enabled, boolErr := strconv.ParseBool(i.MessageComponentData().Values[0])
+				if boolErr != nil {
+					log.Printf("Error parsing %v value: %v", customID, boolErr)
+
+					return
+				}
+
+				if customID == "imagine_hires_setting_menu" {
+					bot.processImagineHiresSetting(s, i, settingsKey, enabled)
+				} else {
+					bot.processImagineRestoreFacesSetting(s, i, settingsKey, enabled)
+				}
+			case customID == "imagine_settings_parameters":
+				bot.processImagineSettingsParameters(s, i, settingsKey)
+			case customID == "imagine_settings_reset":
+				bot.processImagineSettingsReset(s, i, settingsKey)
+			case strings.HasPrefix(customID, "imagine_settings_page_"):
+				bot.processImagineSettingsPage(s, i, settingsKey, settingsPage(strings.TrimPrefix(customID, "imagine_settings_page_")))
+			case customID == changeModelMenuID:
+				bot.processChangeModelMenu(s, i)
+			case customID == changeModelPageID:
+				bot.processChangeModelPage(s, i, scope)
+			case customID == "imagine_plot_upscale":
+				if len(i.MessageComponentData().Values) == 0 {
+					log.Printf("No values for imagine plot upscale menu")
+
+					return
+				}
+
+				cellIndexInt, intErr := strconv.Atoi(i.MessageComponentData().Values[0])
+				if intErr != nil {
+					log.Printf("Error parsing plot cell index: %v", intErr)
+
+					return
+				}
+
+				bot.processImagineUpscale(s, i, cellIndexInt)
+			case customID == historyPageID:
+				bot.processHistoryPage(s, i, scope)
+			case customID == historyRerunID:
+				bot.processHistoryRerun(s, i, scope)
+			case customID == historyRemixID:
+				bot.processHistoryRemix(s, i, scope)
+			case customID == reviewApproveID, customID == reviewDenyID:
+				bot.processReviewDecision(s, i, scope, customID == reviewApproveID)
+			case customID == favoriteButtonID:
+				bot.processFavoriteButton(s, i)
+			case customID == infoButtonID:
+				bot.processInfoButton(s, i)
+			case customID == deleteButtonID:
+				bot.processDeleteButton(s, i)
+			// the buttons of single images come after the exact IDs, which would otherwise match their prefixes, like
+			// "imagine_upscale_setting_menu" and "imagine_variation_strength_menu"
+			case strings.HasPrefix(customID, "imagine_upscale_"):
+				interactionIndex := strings.TrimPrefix(customID, "imagine_upscale_")
+
+				interactionIndexInt, intErr := strconv.Atoi(interactionIndex)
+				if intErr != nil {
+					log.Printf("Error parsing interaction index: %v", intErr)
+
+					return
+				}
+
+				bot.processImagineUpscale(s, i, interactionIndexInt)
+			case strings.HasPrefix(customID, "imagine_variation_"):
+				interactionIndex := strings.TrimPrefix(customID, "imagine_variation_")
+
+				interactionIndexInt, intErr := strconv.Atoi(interactionIndex)
+				if intErr != nil {
+					log.Printf("Error parsing interaction index: %v", intErr)
+
+					return
+				}
+
+				bot.processImagineVariation(s, i, interactionIndexInt, imagine_queue.VariationSubtle)
 			default:
 				log.Printf("Unknown message component '%v'", i.MessageComponentData().CustomID)
 			}
+		case discordgo.InteractionModalSubmit:
+			modalID, scope, _ := strings.Cut(i.ModalSubmitData().CustomID, ":")
+
+			switch customID := modalID; customID {
+			case "imagine_remix_modal":
+				bot.processImagineRemixModal(s, i, scope)
+			case "imagine_settings_modal":
+				bot.processImagineSettingsModal(s, i, settingsKeyForInteraction(i.Interaction, settingsScope(scope)))
+			default:
+				log.Printf("Unknown modal '%v'", customID)
+			}
 		}
 	})
 
@@ -278,7 +569,7 @@ func New(cfg Config) (Bot, error) {
 }
 
 func (b *botImpl) Start() {
-	b.imagineQueue.StartPolling(b.botSession)
+	b.imagineQueue.StartPolling()
 
 	err := b.teardown()
 	if err != nil {
@@ -403,13 +694,13 @@ func (b *botImpl) addImagineExtCommand() error {
 		{
 			Type:        discordgo.ApplicationCommandOptionBoolean,
 			Name:        extOptionRestoreFaces,
-			Description: "Restore faces" + fmt.Sprintf(" (%v)", imagine_queue.DefaultRestoreFaces),
+			Description: "Restore faces (your default setting)",
 			Required:    false,
 		},
 		{
 			Type:        discordgo.ApplicationCommandOptionNumber,
 			Name:        extOptionCFGScale,
-			Description: fmt.Sprintf("CFG Scale (%d)", imagine_queue.DefaultCFGScale),
+			Description: "CFG Scale (your default setting)",
 			Required:    false,
 			MinValue:    &minNum,
 			MaxValue:    30,
@@ -423,7 +714,7 @@ func (b *botImpl) addImagineExtCommand() error {
 		{
 			Type:        discordgo.ApplicationCommandOptionString,
 			Name:        extOptionSampler,
-			Description: fmt.Sprintf("Sampler (%s)", imagine_queue.DefaultSampler),
+			Description: "Sampler (your default setting)",
 			Required:    false,
 			Choices: []*discordgo.ApplicationCommandOptionChoice{
 				// TODO: move to config
@@ -440,7 +731,7 @@ func (b *botImpl) addImagineExtCommand() error {
 		{
 			Type:        discordgo.ApplicationCommandOptionInteger,
 			Name:        extOptionSteps,
-			Description: fmt.Sprintf("Sampling Steps (%d)", imagine_queue.DefaultSteps),
+			Description: "Sampling Steps (your default setting)",
 			MinValue:    &minNum,
 			MaxValue:    50,
 		},
@@ -498,6 +789,28 @@ func (b *botImpl) addImagineSettingsCommand() error {
 	cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
 		Name:        b.imagineSettingsCommandString(),
 		Description: "Change the default settings for the imagine command",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "scope",
+				Description: "Whose defaults to change, your own unless you manage the server",
+				Required:    false,
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{
+						Name:  "Just me",
+						Value: string(imagine_queue.SettingsScopeMember),
+					},
+					{
+						Name:  "This channel",
+						Value: string(imagine_queue.SettingsScopeChannel),
+					},
+					{
+						Name:  "This server",
+						Value: string(imagine_queue.SettingsScopeGuild),
+					},
+				},
+			},
+		},
 	})
 	if err != nil {
 		log.Printf("Error creating '%s' command: %v", b.imagineSettingsCommandString(), err)
@@ -509,15 +822,65 @@ func (b *botImpl) addImagineSettingsCommand() error {
 	return nil
 }
 
-func (b *botImpl) addChangeModelCommand() error {
-	log.Printf("Adding command '%s'...", b.changeModelCommandString())
+const (
+	plotOptionPrompt  = `prompt`
+	plotOptionXAxis   = `x_axis`
+	plotOptionXValues = `x_values`
+	plotOptionYAxis   = `y_axis`
+	plotOptionYValues = `y_values`
+	plotOptionZAxis   = `z_axis`
+	plotOptionZValues = `z_values`
+)
+
+func (b *botImpl) addPlotCommand() error {
+	log.Printf("Adding command '%s'...", b.plotCommandString())
+
+	axisChoices := make([]*discordgo.ApplicationCommandOptionChoice, len(imagine_queue.PlotAxisTypes))
+	for idx, axisType := range imagine_queue.PlotAxisTypes {
+		axisChoices[idx] = &discordgo.ApplicationCommandOptionChoice{
+			Name:  axisType.Label(),
+			Value: string(axisType),
+		}
+	}
+
+	axisOptions := func(axisName, axisOption, valuesOption string, required bool) []*discordgo.ApplicationCommandOption {
+		return []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        axisOption,
+				Description: axisName + " axis parameter",
+				Required:    required,
+				Choices:     axisChoices,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        valuesOption,
+				Description: axisName + " axis values, comma separated (e.g. 5, 7, 9)",
+				Required:    required,
+			},
+		}
+	}
+
+	commandOptions := []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        plotOptionPrompt,
+			Description: "The text prompt to imagine",
+			Required:    true,
+		},
+	}
+
+	commandOptions = append(commandOptions, axisOptions("X", plotOptionXAxis, plotOptionXValues, true)...)
+	commandOptions = append(commandOptions, axisOptions("Y", plotOptionYAxis, plotOptionYValues, false)...)
+	commandOptions = append(commandOptions, axisOptions("Z", plotOptionZAxis, plotOptionZValues, false)...)
 
 	cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
-		Name:        b.changeModelCommandString(),
-		Description: "Change the model used for the imagine command",
+		Name:        b.plotCommandString(),
+		Description: "Compare parameters side by side in a labelled grid",
+		Options:     commandOptions,
 	})
 	if err != nil {
-		log.Printf("Error creating '%s' command: %v", b.changeModelCommandString(), err)
+		log.Printf("Error creating '%s' command: %v", b.plotCommandString(), err)
 		return err
 	}
 
@@ -526,45 +889,163 @@ func (b *botImpl) addChangeModelCommand() error {
 	return nil
 }
 
-func (b *botImpl) processChangeModel(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func (b *botImpl) processImagineReroll(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	position, queueError := b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
+		Type:   imagine_queue.ItemTypeReroll,
+		Source: b.interactionJobSource(s, i.Interaction),
+	})
+	if queueError != nil {
+		log.Printf("Error adding imagine to queue: %v\n", queueError)
+	}
+
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("I'm reimagining that for you... You are currently #%d in line.", position),
+		},
 	})
 	if err != nil {
-		log.Printf("Error deferring interaction: %v", err)
-		return
+		log.Printf("Error responding to interaction: %v", err)
 	}
+}
 
-	selectedModel := i.MessageComponentData().Values[0] // grab the value from selected model
+// remixSortOrder is the first image of a grid, its seed regenerates the same grid when the batch settings match
+const remixSortOrder = 1
 
-	// set selected model via stable diffusion API
-	if err := b.stableDiffusionAPI.SetSelectedModel(selectedModel); err != nil {
-		log.Printf("Failed to post selected model: %v", err)
-		s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
-			Content: "Error updating the model. Please try again.",
+func (b *botImpl) processImagineRemix(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	b.respondRemixModal(s, i, b.interactionJobSource(s, i.Interaction), remixSortOrder, "")
+}
+
+// respondRemixModal opens the remix modal for the generation with the sort order on the message of the source. The
+// scope is added to the modal ID for generations of other messages than the one the modal was opened from.
+func (b *botImpl) respondRemixModal(s *discordgo.Session, i *discordgo.InteractionCreate, source imagine_queue.JobSource,
+	sortOrder int, scope string,
+) {
+	generation, err := b.imagineQueue.GetPreviousGeneration(&imagine_queue.QueueItem{
+		Source: source,
+	}, sortOrder)
+	if err != nil {
+		log.Printf("Error getting generation for remix: %v", err)
+
+		err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "I'm sorry, but I couldn't find the settings for that image.",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
 		})
+		if err != nil {
+			log.Printf("Error responding to interaction: %v", err)
+		}
+
 		return
 	}
 
-	s.FollowupMessageCreate(i.Interaction, false, &discordgo.WebhookParams{
-		Content: "Model updated successfully.",
+	modalID := "imagine_remix_modal"
+	if scope != "" {
+		modalID += ":" + scope
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: modalID,
+			Title:    "Remix",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:  "prompt",
+							Label:     "Prompt",
+							Style:     discordgo.TextInputParagraph,
+							Value:     generation.Prompt,
+							Required:  true,
+							MaxLength: 4000,
+						},
+					},
+				},
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:  "negative_prompt",
+							Label:     "Negative prompt",
+							Style:     discordgo.TextInputParagraph,
+							Value:     generation.NegativePrompt,
+							Required:  false,
+							MaxLength: 4000,
+						},
+					},
+				},
+			},
+		},
 	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+// modalTextValues collects the values of the text inputs in a modal submission, by custom ID.
+func modalTextValues(data discordgo.ModalSubmitInteractionData) map[string]string {
+	values := make(map[string]string)
+
+	for _, component := range data.Components {
+		row, ok := component.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
 
+		for _, rowComponent := range row.Components {
+			if textInput, ok := rowComponent.(*discordgo.TextInput); ok {
+				values[textInput.CustomID] = textInput.Value
+			}
+		}
+	}
+
+	return values
 }
 
-func (b *botImpl) processImagineReroll(s *discordgo.Session, i *discordgo.InteractionCreate) {
+// processImagineRemixModal queues the remix. The scope names the generation for modals opened from the history,
+// otherwise the first image of the message the modal was opened from is remixed.
+func (b *botImpl) processImagineRemixModal(s *discordgo.Session, i *discordgo.InteractionCreate, scope string) {
+	values := modalTextValues(i.ModalSubmitData())
+
+	queueOptions := imagine_queue.NewQueueItemOptions()
+	queueOptions.NegativePrompt = values["negative_prompt"]
+
+	source := b.interactionJobSource(s, i.Interaction)
+	sortOrder := remixSortOrder
+
+	if scope != "" {
+		messageID, historySortOrder, ok := parseHistoryEntry(scope)
+		if !ok {
+			log.Printf("Invalid remix modal scope '%v'", scope)
+
+			return
+		}
+
+		source.MessageID = messageID
+		sortOrder = historySortOrder
+	}
+
 	position, queueError := b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
-		Type:               imagine_queue.ItemTypeReroll,
-		DiscordInteraction: i.Interaction,
+		Prompt:           values["prompt"],
+		Options:          queueOptions,
+		Type:             imagine_queue.ItemTypeRemix,
+		InteractionIndex: sortOrder,
+		Source:           source,
 	})
 	if queueError != nil {
-		log.Printf("Error adding imagine to queue: %v\n", queueError)
+		log.Printf("Error adding remix to queue: %v\n", queueError)
+
+		b.respondQueueError(s, i, queueError)
+
+		return
 	}
 
 	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: fmt.Sprintf("I'm reimagining that for you... You are currently #%d in line.", position),
+			Content: fmt.Sprintf("I'm remixing that for you... You are currently #%d in line.", position),
 		},
 	})
 	if err != nil {
@@ -572,17 +1053,169 @@ func (b *botImpl) processImagineReroll(s *discordgo.Session, i *discordgo.Intera
 	}
 }
 
+// upscaleMenuDefault is the menu value for the server's default upscaler, select values can't be empty
+const upscaleMenuDefault = "default"
+
+// processImagineUpscale asks which upscaler to use, the factor is picked next in processImagineUpscalerMenu.
 func (b *botImpl) processImagineUpscale(s *discordgo.Session, i *discordgo.InteractionCreate, upscaleIndex int) {
+	botSettings, err := b.imagineQueue.GetDefaultSettings(
+		settingsKeyForInteraction(i.Interaction, imagine_queue.SettingsScopeMember))
+	if err != nil {
+		log.Printf("Error getting default settings for upscale: %v", err)
+
+		return
+	}
+
+	upscalers, err := b.stableDiffusionAPI.GetUpscalers()
+	if err != nil {
+		log.Printf("Error fetching upscalers: %v", err)
+	}
+
+	options := []discordgo.SelectMenuOption{
+		{
+			Label: fmt.Sprintf("Default (%s)", botSettings.Upscaler),
+			Value: upscaleMenuDefault,
+		},
+	}
+
+	for _, upscaler := range upscalers {
+		// Discord limits the number of choices to 25
+		if len(options) == 25 {
+			break
+		}
+
+		if upscaler == "None" {
+			continue
+		}
+
+		options = append(options, discordgo.SelectMenuOption{
+			Label: upscaler,
+			Value: upscaler,
+		})
+	}
+
+	minValues := 1
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Which upscaler should I use?",
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.SelectMenu{
+							CustomID:  fmt.Sprintf("imagine_upscaler_menu_%s_%d", i.Message.ID, upscaleIndex),
+							MinValues: &minValues,
+							MaxValues: 1,
+							Options:   options,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+// parseUpscaleMenuID reads the grid message ID and image index back from an upscale menu's custom ID.
+func parseUpscaleMenuID(customID, prefix string) (string, int, error) {
+	messageID, upscaleIndex, found := strings.Cut(strings.TrimPrefix(customID, prefix), "_")
+	if !found {
+		return "", 0, fmt.Errorf("invalid upscale menu ID: %s", customID)
+	}
+
+	upscaleIndexInt, err := strconv.Atoi(upscaleIndex)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return messageID, upscaleIndexInt, nil
+}
+
+func (b *botImpl) processImagineUpscalerMenu(s *discordgo.Session, i *discordgo.InteractionCreate, messageID string, upscaleIndex int, upscaler string) {
+	botSettings, err := b.imagineQueue.GetDefaultSettings(
+		settingsKeyForInteraction(i.Interaction, imagine_queue.SettingsScopeMember))
+	if err != nil {
+		log.Printf("Error getting default settings for upscale: %v", err)
+
+		return
+	}
+
+	options := make([]discordgo.SelectMenuOption, 0, len(imagine_queue.UpscaleFactors))
+
+	for _, factor := range imagine_queue.UpscaleFactors {
+		label := fmt.Sprintf("%gx", factor)
+		if factor == botSettings.UpscaleFactor {
+			label += " (default)"
+		}
+
+		// the upscaler travels along in the value, so the last menu has everything it needs
+		options = append(options, discordgo.SelectMenuOption{
+			Label: label,
+			Value: fmt.Sprintf("%g|%s", factor, upscaler),
+		})
+	}
+
+	minValues := 1
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content: "How much bigger should the image be?",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.SelectMenu{
+							CustomID:  fmt.Sprintf("imagine_upscale_factor_menu_%s_%d", messageID, upscaleIndex),
+							MinValues: &minValues,
+							MaxValues: 1,
+							Options:   options,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+func (b *botImpl) processImagineUpscaleFactorMenu(s *discordgo.Session, i *discordgo.InteractionCreate, messageID string, upscaleIndex int, value string) {
+	factor, upscaler, _ := strings.Cut(value, "|")
+
+	factorFloat, err := strconv.ParseFloat(factor, 64)
+	if err != nil {
+		log.Printf("Error parsing upscale factor: %v", err)
+
+		return
+	}
+
+	if upscaler == upscaleMenuDefault {
+		upscaler = ""
+	}
+
+	// the menu is on its own message, the images are on the message it was opened from
+	source := b.interactionJobSource(s, i.Interaction)
+	source.MessageID = messageID
+
 	position, queueError := b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
-		Type:               imagine_queue.ItemTypeUpscale,
-		InteractionIndex:   upscaleIndex,
-		DiscordInteraction: i.Interaction,
+		Type:             imagine_queue.ItemTypeUpscale,
+		InteractionIndex: upscaleIndex,
+		Upscale: &imagine_queue.UpscaleOptions{
+			Upscaler: upscaler,
+			Factor:   factorFloat,
+		},
+		Source: source,
 	})
 	if queueError != nil {
 		log.Printf("Error adding imagine to queue: %v\n", queueError)
 	}
 
-	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
 			Content: fmt.Sprintf("I'm upscaling that for you... You are currently #%d in line.", position),
@@ -593,11 +1226,12 @@ func (b *botImpl) processImagineUpscale(s *discordgo.Session, i *discordgo.Inter
 	}
 }
 
-func (b *botImpl) processImagineVariation(s *discordgo.Session, i *discordgo.InteractionCreate, variationIndex int) {
+func (b *botImpl) processImagineVariation(s *discordgo.Session, i *discordgo.InteractionCreate, variationIndex int, variation imagine_queue.VariationStrength) {
 	position, queueError := b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
-		Type:               imagine_queue.ItemTypeVariation,
-		InteractionIndex:   variationIndex,
-		DiscordInteraction: i.Interaction,
+		Type:             imagine_queue.ItemTypeVariation,
+		InteractionIndex: variationIndex,
+		Variation:        variation,
+		Source:           b.interactionJobSource(s, i.Interaction),
 	})
 	if queueError != nil {
 		log.Printf("Error adding imagine to queue: %v\n", queueError)
@@ -614,6 +1248,31 @@ func (b *botImpl) processImagineVariation(s *discordgo.Session, i *discordgo.Int
 	}
 }
 
+func (b *botImpl) processImagineOutpaint(s *discordgo.Session, i *discordgo.InteractionCreate, options *imagine_queue.OutpaintOptions) {
+	position, queueError := b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
+		Type:     imagine_queue.ItemTypeOutpaint,
+		Outpaint: options,
+		Source:   b.interactionJobSource(s, i.Interaction),
+	})
+	if queueError != nil {
+		log.Printf("Error adding imagine to queue: %v\n", queueError)
+
+		b.respondQueueError(s, i, queueError)
+
+		return
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("I'm extending your image... You are currently #%d in line.", position),
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
 func (b *botImpl) processImagineCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	options := i.ApplicationCommandData().Options
 
@@ -635,25 +1294,27 @@ func (b *botImpl) processImagineCommand(s *discordgo.Session, i *discordgo.Inter
 		negative = option.StringValue()
 	}
 
-	if negative != "" && prompt != "" {
+	if prompt != "" {
+		queueOptions := imagine_queue.NewQueueItemOptions()
+
+		if negative != "" {
+			queueOptions.NegativePrompt = negative
+		}
 
 		position, queueError = b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
-			Prompt:             prompt,
-			Options:            imagine_queue.NewQueueItemOptions(),
-			Type:               imagine_queue.ItemTypeImagine,
-			DiscordInteraction: i.Interaction,
-		})
-	} else if prompt != "" {
-		position, queueError = b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
-			Prompt:             prompt,
-			Options:            imagine_queue.NewQueueItemOptions(),
-			Type:               imagine_queue.ItemTypeImagine,
-			DiscordInteraction: i.Interaction,
+			Prompt:  prompt,
+			Options: queueOptions,
+			Type:    imagine_queue.ItemTypeImagine,
+			Source:  b.interactionJobSource(s, i.Interaction),
 		})
 	}
 
 	if queueError != nil {
 		log.Printf("Error adding imagine to queue: %v\n", queueError)
+
+		b.respondQueueError(s, i, queueError)
+
+		return
 	}
 
 	userID := ""
@@ -680,88 +1341,27 @@ func (b *botImpl) processImagineCommand(s *discordgo.Session, i *discordgo.Inter
 	}
 }
 
-func settingsMessageComponents(settings *entities.DefaultSettings) []discordgo.MessageComponent {
-	minValues := 1
+// respondQueueError tells the user why their imagine wasn't queued, only visible to them.
+func (b *botImpl) respondQueueError(s *discordgo.Session, i *discordgo.InteractionCreate, queueError error) {
+	if b.respondModerationError(s, i, queueError) {
+		return
+	}
 
-	return []discordgo.MessageComponent{
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.SelectMenu{
-					CustomID:  "imagine_dimension_setting_menu",
-					MinValues: &minValues,
-					MaxValues: 1,
-					Options: []discordgo.SelectMenuOption{
-						{
-							Label:   "Size: 512x512",
-							Value:   "512_512",
-							Default: settings.Width == 512 && settings.Height == 512,
-						},
-						{
-							Label:   "Size: 768x768",
-							Value:   "768_768",
-							Default: settings.Width == 768 && settings.Height == 768,
-						},
-						{
-							Label:   "Size: 1024x1024",
-							Value:   "1024_1024",
-							Default: settings.Width == 1024 && settings.Height == 1024,
-						},
-					},
-				},
-			},
-		},
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.SelectMenu{
-					CustomID:  "imagine_batch_count_setting_menu",
-					MinValues: &minValues,
-					MaxValues: 1,
-					Options: []discordgo.SelectMenuOption{
-						{
-							Label:   "Batch count: 1",
-							Value:   "1",
-							Default: settings.BatchCount == 1,
-						},
-						{
-							Label:   "Batch count: 2",
-							Value:   "2",
-							Default: settings.BatchCount == 2,
-						},
-						{
-							Label:   "Batch count: 4",
-							Value:   "4",
-							Default: settings.BatchCount == 4,
-						},
-					},
-				},
-			},
-		},
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.SelectMenu{
-					CustomID:  "imagine_batch_size_setting_menu",
-					MinValues: &minValues,
-					MaxValues: 1,
-					Options: []discordgo.SelectMenuOption{
-						{
-							Label:   "Batch size: 1",
-							Value:   "1",
-							Default: settings.BatchSize == 1,
-						},
-						{
-							Label:   "Batch size: 2",
-							Value:   "2",
-							Default: settings.BatchSize == 2,
-						},
-						{
-							Label:   "Batch size: 4",
-							Value:   "4",
-							Default: settings.BatchSize == 4,
-						},
-					},
-				},
-			},
+	content := "I'm sorry, but I couldn't add your prompt to the queue."
+
+	if imagine_queue.IsPromptError(queueError) {
+		content = fmt.Sprintf("I couldn't understand your prompt: %v", queueError)
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
 		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
 	}
 }
 
@@ -783,7 +1383,8 @@ func (b *botImpl) processImagineExtCommand(s *discordgo.Session, i *discordgo.In
 		case extOptionNegativePrompt:
 			queueOptions.NegativePrompt = opt.StringValue()
 		case extOptionRestoreFaces:
-			queueOptions.RestoreFaces = opt.BoolValue()
+			restoreFaces := opt.BoolValue()
+			queueOptions.RestoreFaces = &restoreFaces
 		case extOptionCFGScale:
 			queueOptions.CfgScale = opt.FloatValue()
 		case extOptionSeed:
@@ -809,14 +1410,18 @@ func (b *botImpl) processImagineExtCommand(s *discordgo.Session, i *discordgo.In
 	}
 
 	position, queueError = b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
-		Prompt:             queueOptions.Prompt,
-		Options:            queueOptions,
-		Type:               imagine_queue.ItemTypeImagine,
-		DiscordInteraction: i.Interaction,
+		Prompt:  queueOptions.Prompt,
+		Options: queueOptions,
+		Type:    imagine_queue.ItemTypeImagine,
+		Source:  b.interactionJobSource(s, i.Interaction),
 	})
 
 	if queueError != nil {
 		log.Printf("Error adding imagine to queue: %v\n", queueError)
+
+		b.respondQueueError(s, i, queueError)
+
+		return
 	}
 
 	message := fmt.Sprintf(
@@ -837,138 +1442,66 @@ func (b *botImpl) processImagineExtCommand(s *discordgo.Session, i *discordgo.In
 	}
 }
 
-func (b *botImpl) processImagineSettingsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	botSettings, err := b.imagineQueue.GetBotDefaultSettings()
-	if err != nil {
-		log.Printf("error getting default settings for settings command: %v", err)
-
-		return
-	}
-
-	messageComponents := settingsMessageComponents(botSettings)
-
-	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Title:      "Settings",
-			Content:    "Choose defaults settings for the imagine command:",
-			Components: messageComponents,
-		},
-	})
-	if err != nil {
-		log.Printf("Error responding to interaction: %v", err)
-	}
-}
+func (b *botImpl) processPlotCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	options := i.ApplicationCommandData().Options
 
-func (b *botImpl) processModelSettingsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	modelTitles, err := b.stableDiffusionAPI.GetModels()
-	if err != nil {
-		log.Printf("Error fetching model titles: %v", err)
-		return
+	optionMap := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options))
+	for _, opt := range options {
+		optionMap[opt.Name] = opt
 	}
 
-	messageComponents := changeModelMessageComponents(modelTitles)
+	prompt := ""
 
-	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content:    "Choose a model for the imagine command:",
-			Components: messageComponents,
-		},
-	})
-	if err != nil {
-		log.Printf("Error responding to interaction: %v", err)
+	if option, ok := optionMap[plotOptionPrompt]; ok {
+		prompt = option.StringValue()
 	}
-}
 
-func changeModelMessageComponents(models []string) []discordgo.MessageComponent {
-	minValues := 1
+	plot := &imagine_queue.PlotOptions{}
 
-	options := make([]discordgo.SelectMenuOption, len(models))
-	for i, title := range models {
-		options[i] = discordgo.SelectMenuOption{
-			Label: title,
-			Value: title,
-		}
+	for _, axisOptions := range [][2]string{
+		{plotOptionXAxis, plotOptionXValues},
+		{plotOptionYAxis, plotOptionYValues},
+		{plotOptionZAxis, plotOptionZValues},
+	} {
+		axisOption, hasAxis := optionMap[axisOptions[0]]
+		valuesOption, hasValues := optionMap[axisOptions[1]]
 
-		// Discord limits the number of choices to 25
-		if len(options) == 25 {
-			log.Printf("Loaded 25/%d models...", len(models))
-			break
+		if !hasAxis && !hasValues {
+			continue
 		}
-	}
 
-	return []discordgo.MessageComponent{
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.SelectMenu{
-					CustomID:  "imagine_change_model",
-					MinValues: &minValues,
-					MaxValues: 1,
-					Options:   options,
-				},
-			},
-		},
-	}
-}
+		axis := imagine_queue.PlotAxis{}
 
-func (b *botImpl) processImagineDimensionSetting(s *discordgo.Session, i *discordgo.InteractionCreate, height, width int) {
-	botSettings, err := b.imagineQueue.UpdateDefaultDimensions(width, height)
-	if err != nil {
-		log.Printf("error updating default dimensions: %v", err)
+		if hasAxis {
+			axis.Type = imagine_queue.PlotAxisType(axisOption.StringValue())
+		}
 
-		err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseUpdateMessage,
-			Data: &discordgo.InteractionResponseData{
-				Content: "Error updating default dimensions...",
-			},
-		})
-		if err != nil {
-			log.Printf("Error responding to interaction: %v", err)
+		if hasValues {
+			axis.Values = imagine_queue.ParsePlotAxisValues(valuesOption.StringValue())
 		}
 
-		return
+		plot.Axes = append(plot.Axes, axis)
 	}
 
-	messageComponents := settingsMessageComponents(botSettings)
-
-	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseUpdateMessage,
-		Data: &discordgo.InteractionResponseData{
-			Content:    "Choose defaults settings for the imagine command:",
-			Components: messageComponents,
-		},
+	position, queueError := b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
+		Prompt:  prompt,
+		Options: imagine_queue.NewQueueItemOptions(),
+		Type:    imagine_queue.ItemTypePlot,
+		Source:  b.interactionJobSource(s, i.Interaction),
+		Plot:    plot,
 	})
-	if err != nil {
-		log.Printf("Error responding to interaction: %v", err)
-	}
-}
-
-func (b *botImpl) processImagineBatchSetting(s *discordgo.Session, i *discordgo.InteractionCreate, batchCount, batchSize int) {
-	botSettings, err := b.imagineQueue.UpdateDefaultBatch(batchCount, batchSize)
-	if err != nil {
-		log.Printf("error updating batch settings: %v", err)
+	if queueError != nil {
+		log.Printf("Error adding plot to queue: %v\n", queueError)
 
-		err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseUpdateMessage,
-			Data: &discordgo.InteractionResponseData{
-				Content: "Error updating batch settings...",
-			},
-		})
-		if err != nil {
-			log.Printf("Error responding to interaction: %v", err)
-		}
+		b.respondQueueError(s, i, queueError)
 
 		return
 	}
 
-	messageComponents := settingsMessageComponents(botSettings)
-
-	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseUpdateMessage,
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content:    "Choose defaults settings for the imagine command:",
-			Components: messageComponents,
+			Content: fmt.Sprintf("I'm plotting that for you... You are currently #%d in line.", position),
 		},
 	})
 	if err != nil {
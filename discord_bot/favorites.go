@@ -0,0 +1,396 @@
+package discord_bot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/imagine_queue"
+	"stable_diffusion_bot/repositories"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	favoriteEmoji    = "⭐"
+	favoriteButtonID = "imagine_favorite"
+
+	galleryOptionChannel   = `channel`
+	galleryOptionThreshold = `threshold`
+
+	// favoritesListLimit is as many favorites as a message can have embeds
+	favoritesListLimit = maxEmbeds
+
+	// maxEmbedFieldLength is the most characters Discord allows in the value of an embed field
+	maxEmbedFieldLength = 1024
+)
+
+func (b *botImpl) favoritesCommandString() string {
+	if b.developmentMode {
+		return "dev_" + b.imagineCommand + "_favorites"
+	}
+
+	return b.imagineCommand + "_favorites"
+}
+
+func (b *botImpl) galleryCommandString() string {
+	if b.developmentMode {
+		return "dev_" + b.imagineCommand + "_gallery"
+	}
+
+	return b.imagineCommand + "_gallery"
+}
+
+func (b *botImpl) addFavoritesCommand() error {
+	log.Printf("Adding command '%s'...", b.favoritesCommandString())
+
+	cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
+		Name:        b.favoritesCommandString(),
+		Description: "List the images you favorited with " + favoriteEmoji,
+	})
+	if err != nil {
+		log.Printf("Error creating '%s' command: %v", b.favoritesCommandString(), err)
+		return err
+	}
+
+	b.registeredCommands = append(b.registeredCommands, cmd)
+
+	return nil
+}
+
+func (b *botImpl) addGalleryCommand() error {
+	log.Printf("Adding command '%s'...", b.galleryCommandString())
+
+	manageServer := int64(discordgo.PermissionManageServer)
+	minThreshold := 1.0
+
+	cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
+		Name:                     b.galleryCommandString(),
+		Description:              "Set the channel the most favorited images are reposted to",
+		DefaultMemberPermissions: &manageServer,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionChannel,
+				Name:         galleryOptionChannel,
+				Description:  "The gallery channel, stop reposting when not given",
+				Required:     false,
+				ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        galleryOptionThreshold,
+				Description: fmt.Sprintf("How many favorites an image needs, %d when not given", imagine_queue.DefaultGalleryThreshold),
+				Required:    false,
+				MinValue:    &minThreshold,
+				MaxValue:    100,
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating '%s' command: %v", b.galleryCommandString(), err)
+		return err
+	}
+
+	b.registeredCommands = append(b.registeredCommands, cmd)
+
+	return nil
+}
+
+// favoriteButton favorites the result, or stops favoriting it when pressed again.
+func favoriteButton() discordgo.Button {
+	return discordgo.Button{
+		Label:    "Favorite",
+		Style:    discordgo.SecondaryButton,
+		CustomID: favoriteButtonID,
+		Emoji: discordgo.ComponentEmoji{
+			Name: favoriteEmoji,
+		},
+	}
+}
+
+func (b *botImpl) processFavoritesCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	generations, err := b.imagineQueue.ListFavorites(interactionMemberID(i.Interaction), favoritesListLimit)
+	if err != nil {
+		log.Printf("Error listing favorites: %v", err)
+
+		respondEphemeral(s, i, "Error getting your favorites...")
+
+		return
+	}
+
+	if len(generations) == 0 {
+		respondEphemeral(s, i, fmt.Sprintf("You have no favorites yet, react with %s to an image to add one.",
+			favoriteEmoji))
+
+		return
+	}
+
+	embeds := make([]*discordgo.MessageEmbed, len(generations))
+
+	for idx, generation := range generations {
+		embeds[idx] = historyEmbed(generation)
+		embeds[idx].Fields = append(embeds[idx].Fields,
+			&discordgo.MessageEmbedField{Name: "Member", Value: fmt.Sprintf("<@%s>", generation.MemberID), Inline: true},
+		)
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Your latest favorites:",
+			Embeds:  embeds,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+func (b *botImpl) processGalleryCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Member == nil || i.GuildID == "" {
+		respondEphemeral(s, i, "The gallery can only be used in a server.")
+
+		return
+	}
+
+	if !b.canEditServerDefaults(i) {
+		respondEphemeral(s, i, "Only members who may edit the server defaults can change the gallery.")
+
+		return
+	}
+
+	settings := &entities.GallerySettings{
+		GuildID:   i.GuildID,
+		Threshold: imagine_queue.DefaultGalleryThreshold,
+	}
+
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case galleryOptionChannel:
+			settings.ChannelID = option.ChannelValue(nil).ID
+		case galleryOptionThreshold:
+			settings.Threshold = int(option.IntValue())
+		}
+	}
+
+	err := b.imagineQueue.SetGallerySettings(settings)
+	if err != nil {
+		log.Printf("Error setting gallery: %v", err)
+
+		respondEphemeral(s, i, "Error setting the gallery...")
+
+		return
+	}
+
+	if settings.ChannelID == "" {
+		respondEphemeral(s, i, "Favorited images aren't reposted anymore.")
+
+		return
+	}
+
+	respondEphemeral(s, i, fmt.Sprintf("Images with %d %s are now reposted in <#%s>.",
+		settings.Threshold, favoriteEmoji, settings.ChannelID))
+}
+
+// processFavoriteButton favorites the result of the message, or stops favoriting it.
+func (b *botImpl) processFavoriteButton(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	memberID := interactionMemberID(i.Interaction)
+
+	favorite := &entities.Favorite{
+		MessageID: i.Message.ID,
+		GuildID:   i.GuildID,
+		ChannelID: i.ChannelID,
+		MemberID:  memberID,
+	}
+
+	status, err := b.imagineQueue.AddFavorite(favorite)
+	if err != nil {
+		log.Printf("Error adding favorite: %v", err)
+
+		respondEphemeral(s, i, "Error favoriting the image...")
+
+		return
+	}
+
+	if !status.Added {
+		_, err = b.imagineQueue.RemoveFavorite(favorite.MessageID, memberID)
+		if err != nil {
+			log.Printf("Error removing favorite: %v", err)
+
+			respondEphemeral(s, i, "Error removing the image from your favorites...")
+
+			return
+		}
+
+		respondEphemeral(s, i, "Removed the image from your favorites.")
+
+		return
+	}
+
+	respondEphemeral(s, i, fmt.Sprintf("Added the image to your favorites, it has %d %s now.",
+		status.Count, favoriteEmoji))
+
+	b.postToGallery(s, favorite, status)
+}
+
+// processFavoriteReaction favorites a result when a member reacts to it with a star, and stops favoriting it when
+// the reaction is taken back. Reactions to other messages are left alone.
+func (b *botImpl) processFavoriteReaction(s *discordgo.Session, reaction *discordgo.MessageReaction, added bool) {
+	if reaction.Emoji.Name != favoriteEmoji || reaction.UserID == s.State.User.ID {
+		return
+	}
+
+	if !added {
+		_, err := b.imagineQueue.RemoveFavorite(reaction.MessageID, reaction.UserID)
+		if err != nil {
+			log.Printf("Error removing favorite: %v", err)
+		}
+
+		return
+	}
+
+	favorite := &entities.Favorite{
+		MessageID: reaction.MessageID,
+		GuildID:   reaction.GuildID,
+		ChannelID: reaction.ChannelID,
+		MemberID:  reaction.UserID,
+	}
+
+	status, err := b.imagineQueue.AddFavorite(favorite)
+	if err != nil {
+		// most reactions are to messages that aren't results
+		if !errors.Is(err, &repositories.NotFoundError{}) {
+			log.Printf("Error adding favorite: %v", err)
+		}
+
+		return
+	}
+
+	b.postToGallery(s, favorite, status)
+}
+
+// postToGallery reposts the result to the gallery, when the favorite was the one that made it reach the threshold.
+func (b *botImpl) postToGallery(s *discordgo.Session, favorite *entities.Favorite, status *imagine_queue.FavoriteStatus) {
+	if status.GalleryChannelID == "" {
+		return
+	}
+
+	generation := status.Generation
+
+	// sensitive results are only reposted when the gallery is age restricted too
+	if generation.Routing != "" && !isAgeRestricted(s, status.GalleryChannelID) {
+		log.Printf("Not reposting sensitive result %v to gallery %v", favorite.MessageID, status.GalleryChannelID)
+
+		b.releaseGalleryPost(favorite.MessageID)
+
+		return
+	}
+
+	message := &discordgo.MessageSend{
+		Embeds:          []*discordgo.MessageEmbed{galleryEmbed(generation, favorite, status.Count)},
+		Files:           b.messageImages(s, favorite.ChannelID, favorite.MessageID),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+
+	if len(message.Files) > 0 {
+		message.Embeds[0].Image = &discordgo.MessageEmbedImage{URL: "attachment://" + message.Files[0].Name}
+	}
+
+	galleryMessage, err := s.ChannelMessageSendComplex(status.GalleryChannelID, message)
+	if err != nil {
+		log.Printf("Error reposting to gallery: %v", err)
+
+		b.releaseGalleryPost(favorite.MessageID)
+
+		return
+	}
+
+	err = b.imagineQueue.SetGalleryMessage(favorite.MessageID, galleryMessage.ID)
+	if err != nil {
+		log.Printf("Error recording gallery message: %v", err)
+	}
+}
+
+func (b *botImpl) releaseGalleryPost(messageID string) {
+	err := b.imagineQueue.ReleaseGalleryPost(messageID)
+	if err != nil {
+		log.Printf("Error releasing gallery post: %v", err)
+	}
+}
+
+// messageImages downloads the images attached to the message, as many as can be uploaded again. Redirected results
+// have none, their images are in the NSFW channel.
+func (b *botImpl) messageImages(s *discordgo.Session, channelID, messageID string) []*discordgo.File {
+	message, err := s.ChannelMessage(channelID, messageID)
+	if err != nil {
+		log.Printf("Error getting message %v: %v", messageID, err)
+
+		return nil
+	}
+
+	var files []*discordgo.File
+
+	uploadSize := 0
+
+	for _, attachment := range message.Attachments {
+		if uploadSize+attachment.Size > maxUploadSize {
+			break
+		}
+
+		image, err := imagine_queue.DownloadImage(attachment.URL)
+		if err != nil {
+			log.Printf("Error downloading attachment %v: %v", attachment.Filename, err)
+
+			continue
+		}
+
+		uploadSize += attachment.Size
+
+		files = append(files, &discordgo.File{
+			ContentType: attachment.ContentType,
+			// the gallery embed shows the image, so it has no use for a spoiler
+			Name:   strings.TrimPrefix(attachment.Filename, spoilerPrefix),
+			Reader: bytes.NewReader(image.Bytes()),
+		})
+	}
+
+	return files
+}
+
+// galleryEmbed describes a reposted result, with its prompt and parameters and a link back to it.
+func galleryEmbed(generation *entities.ImageGeneration, favorite *entities.Favorite, count int) *discordgo.MessageEmbed {
+	model := generation.Checkpoint
+	if model == "" {
+		model = "default"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: truncate(generation.Prompt, maxEmbedTitleLength),
+		URL:   messageLink(favorite.GuildID, favorite.ChannelID, favorite.MessageID),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Member", Value: fmt.Sprintf("<@%s>", generation.MemberID), Inline: true},
+			{Name: "Favorites", Value: fmt.Sprintf("%d %s", count, favoriteEmoji), Inline: true},
+			{Name: "Model", Value: model, Inline: true},
+			{Name: "Seed", Value: strconv.Itoa(generation.Seed), Inline: true},
+			{Name: "Size", Value: fmt.Sprintf("%dx%d", generation.Width, generation.Height), Inline: true},
+			{Name: "Sampler", Value: generation.SamplerName, Inline: true},
+			{Name: "Steps", Value: strconv.Itoa(generation.Steps), Inline: true},
+			{Name: "CFG scale", Value: strconv.FormatFloat(generation.CfgScale, 'f', -1, 64), Inline: true},
+		},
+		Timestamp: generation.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
+
+	if generation.NegativePrompt != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Negative prompt",
+			Value: truncate(generation.NegativePrompt, maxEmbedFieldLength),
+		})
+	}
+
+	return embed
+}
@@ -0,0 +1,401 @@
+package discord_bot
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/imagine_queue"
+	"stable_diffusion_bot/repositories/image_generations"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	historyOptionFrom = `from`
+	historyOptionTo   = `to`
+
+	historyPageID  = "imagine_history_page"
+	historyRerunID = "imagine_history_rerun"
+	historyRemixID = "imagine_history_remix"
+
+	// historyPageSize leaves one of the five rows of buttons a message can have for the page buttons
+	historyPageSize = 4
+
+	historyDateLayout = "2006-01-02"
+
+	// maxEmbedTitleLength is the most characters Discord allows in the title of an embed
+	maxEmbedTitleLength = 256
+)
+
+func (b *botImpl) historyCommandString() string {
+	if b.developmentMode {
+		return "dev_" + b.imagineCommand + "_history"
+	}
+
+	return b.imagineCommand + "_history"
+}
+
+func (b *botImpl) addHistoryCommand() error {
+	log.Printf("Adding command '%s'...", b.historyCommandString())
+
+	cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
+		Name:        b.historyCommandString(),
+		Description: "Browse the images you imagined, to run them again or remix them",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        historyOptionFrom,
+				Description: "Only images from this day on, like 2023-06-30 (UTC)",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        historyOptionTo,
+				Description: "Only images up to this day, like 2023-07-31 (UTC)",
+				Required:    false,
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating '%s' command: %v", b.historyCommandString(), err)
+		return err
+	}
+
+	b.registeredCommands = append(b.registeredCommands, cmd)
+
+	return nil
+}
+
+// historyFilter is the dates the history is filtered by, as they were given, so they fit in the page buttons.
+type historyFilter struct {
+	From string
+	To   string
+}
+
+func (f historyFilter) listOptions(page int) (image_generations.ListOptions, error) {
+	options := image_generations.ListOptions{
+		Offset: page * historyPageSize,
+		// one more than fits, to know whether there is a next page
+		Limit: historyPageSize + 1,
+	}
+
+	var err error
+
+	options.Since, options.Until, err = parseDateRange(f.From, f.To)
+
+	return options, err
+}
+
+// parseDateRange parses the days of a date filter, both of which are included. Empty days don't limit the range.
+func parseDateRange(from, to string) (time.Time, time.Time, error) {
+	var since, until time.Time
+
+	if from != "" {
+		day, err := time.Parse(historyDateLayout, from)
+		if err != nil {
+			return since, until, fmt.Errorf("\"%s\" isn't a date like 2023-06-30", from)
+		}
+
+		since = day
+	}
+
+	if to != "" {
+		day, err := time.Parse(historyDateLayout, to)
+		if err != nil {
+			return since, until, fmt.Errorf("\"%s\" isn't a date like 2023-07-31", to)
+		}
+
+		until = day.AddDate(0, 0, 1)
+	}
+
+	return since, until, nil
+}
+
+// historyPageScope keeps the page and the filter in the ID of a page button.
+func historyPageScope(page int, filter historyFilter) string {
+	return fmt.Sprintf("%d,%s,%s", page, filter.From, filter.To)
+}
+
+func parseHistoryPageScope(scope string) (int, historyFilter, bool) {
+	parts := strings.Split(scope, ",")
+	if len(parts) != 3 {
+		return 0, historyFilter{}, false
+	}
+
+	page, err := strconv.Atoi(parts[0])
+	if err != nil || page < 0 {
+		return 0, historyFilter{}, false
+	}
+
+	return page, historyFilter{From: parts[1], To: parts[2]}, true
+}
+
+// historyEntry names a generation in the ID of a button, by its message and sort order.
+func historyEntry(generation *entities.ImageGeneration) string {
+	return fmt.Sprintf("%s,%d", generation.MessageID, generation.SortOrder)
+}
+
+func parseHistoryEntry(scope string) (string, int, bool) {
+	messageID, sortOrderValue, found := strings.Cut(scope, ",")
+	if !found || messageID == "" {
+		return "", 0, false
+	}
+
+	sortOrder, err := strconv.Atoi(sortOrderValue)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return messageID, sortOrder, true
+}
+
+// generationLink links to the message of the generation, empty when it isn't known where it was posted.
+func generationLink(generation *entities.ImageGeneration) string {
+	if generation.ChannelID == "" {
+		return ""
+	}
+
+	guildID := generation.GuildID
+	if guildID == "" {
+		guildID = "@me"
+	}
+
+	return messageLink(guildID, generation.ChannelID, generation.MessageID)
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+
+	return string(runes[:length-3]) + "..."
+}
+
+func historyEmbed(generation *entities.ImageGeneration) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title: truncate(generation.Prompt, maxEmbedTitleLength),
+		URL:   generationLink(generation),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Seed", Value: strconv.Itoa(generation.Seed), Inline: true},
+			{Name: "Size", Value: fmt.Sprintf("%dx%d", generation.Width, generation.Height), Inline: true},
+			{Name: "Imagined", Value: fmt.Sprintf("<t:%d:R>", generation.CreatedAt.Unix()), Inline: true},
+		},
+	}
+
+	if embed.URL == "" {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: "Imagined before messages were linked"}
+	}
+
+	return embed
+}
+
+// historyPage builds the embeds and buttons of a page of the member's history.
+func (b *botImpl) historyPage(memberID string, page int, filter historyFilter) (*discordgo.InteractionResponseData, error) {
+	options, err := filter.listOptions(page)
+	if err != nil {
+		return &discordgo.InteractionResponseData{Content: fmt.Sprintf("I couldn't filter your history, %v.", err)}, nil
+	}
+
+	generations, err := b.imagineQueue.ListGenerationsByMember(memberID, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(generations) == 0 {
+		content := "You haven't imagined anything yet."
+		if filter.From != "" || filter.To != "" || page > 0 {
+			content = "You didn't imagine anything in that time."
+		}
+
+		return &discordgo.InteractionResponseData{Content: content}, nil
+	}
+
+	hasNext := len(generations) > historyPageSize
+	if hasNext {
+		generations = generations[:historyPageSize]
+	}
+
+	embeds := make([]*discordgo.MessageEmbed, len(generations))
+	components := make([]discordgo.MessageComponent, 0, len(generations)+1)
+
+	for idx, generation := range generations {
+		number := page*historyPageSize + idx + 1
+
+		embeds[idx] = historyEmbed(generation)
+		embeds[idx].Author = &discordgo.MessageEmbedAuthor{Name: fmt.Sprintf("#%d", number)}
+
+		components = append(components, discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    fmt.Sprintf("Run #%d again", number),
+					Style:    discordgo.SecondaryButton,
+					CustomID: historyRerunID + ":" + historyEntry(generation),
+					Emoji:    discordgo.ComponentEmoji{Name: "🔁"},
+				},
+				discordgo.Button{
+					Label:    fmt.Sprintf("Remix #%d", number),
+					Style:    discordgo.SecondaryButton,
+					CustomID: historyRemixID + ":" + historyEntry(generation),
+					Emoji:    discordgo.ComponentEmoji{Name: "🎨"},
+				},
+			},
+		})
+	}
+
+	components = append(components, discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.Button{
+				Label:    "Newer",
+				Style:    discordgo.PrimaryButton,
+				CustomID: historyPageID + ":" + historyPageScope(page-1, filter),
+				Disabled: page == 0,
+			},
+			discordgo.Button{
+				Label:    "Older",
+				Style:    discordgo.PrimaryButton,
+				CustomID: historyPageID + ":" + historyPageScope(page+1, filter),
+				Disabled: !hasNext,
+			},
+		},
+	})
+
+	return &discordgo.InteractionResponseData{
+		Content:    fmt.Sprintf("Page %d of your images:", page+1),
+		Embeds:     embeds,
+		Components: components,
+	}, nil
+}
+
+func (b *botImpl) processHistoryCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var filter historyFilter
+
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case historyOptionFrom:
+			filter.From = strings.TrimSpace(option.StringValue())
+		case historyOptionTo:
+			filter.To = strings.TrimSpace(option.StringValue())
+		}
+	}
+
+	data, err := b.historyPage(interactionMemberID(i.Interaction), 0, filter)
+	if err != nil {
+		log.Printf("Error listing history: %v", err)
+
+		respondEphemeral(s, i, "Error getting your history...")
+
+		return
+	}
+
+	data.Flags = discordgo.MessageFlagsEphemeral
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: data,
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+func (b *botImpl) processHistoryPage(s *discordgo.Session, i *discordgo.InteractionCreate, scope string) {
+	page, filter, ok := parseHistoryPageScope(scope)
+	if !ok {
+		log.Printf("Invalid history page '%v'", scope)
+
+		return
+	}
+
+	data, err := b.historyPage(interactionMemberID(i.Interaction), page, filter)
+	if err != nil {
+		log.Printf("Error listing history: %v", err)
+
+		respondEphemeral(s, i, "Error getting your history...")
+
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: data,
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+// historySource is a job source that works on the message of a history entry, instead of the history message.
+func (b *botImpl) historySource(s *discordgo.Session, i *discordgo.InteractionCreate, scope string) (imagine_queue.JobSource, int, bool) {
+	source := b.interactionJobSource(s, i.Interaction)
+
+	messageID, sortOrder, ok := parseHistoryEntry(scope)
+	if !ok {
+		log.Printf("Invalid history entry '%v'", scope)
+
+		return source, 0, false
+	}
+
+	source.MessageID = messageID
+	source.ImageURL = ""
+
+	return source, sortOrder, true
+}
+
+// processHistoryRerun runs the generation again with its seed and settings, as a remix that changes nothing.
+func (b *botImpl) processHistoryRerun(s *discordgo.Session, i *discordgo.InteractionCreate, scope string) {
+	source, sortOrder, ok := b.historySource(s, i, scope)
+	if !ok {
+		return
+	}
+
+	generation, err := b.imagineQueue.GetPreviousGeneration(&imagine_queue.QueueItem{Source: source}, sortOrder)
+	if err != nil {
+		log.Printf("Error getting generation to run again: %v", err)
+
+		respondEphemeral(s, i, "I'm sorry, but I couldn't find the settings for that image.")
+
+		return
+	}
+
+	queueOptions := imagine_queue.NewQueueItemOptions()
+	queueOptions.NegativePrompt = generation.NegativePrompt
+
+	position, queueError := b.imagineQueue.AddImagine(&imagine_queue.QueueItem{
+		Prompt:           generation.Prompt,
+		Options:          queueOptions,
+		Type:             imagine_queue.ItemTypeRemix,
+		InteractionIndex: sortOrder,
+		Source:           source,
+	})
+	if queueError != nil {
+		log.Printf("Error adding rerun to queue: %v\n", queueError)
+
+		b.respondQueueError(s, i, queueError)
+
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("I'm imagining that again for you... You are currently #%d in line.", position),
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+func (b *botImpl) processHistoryRemix(s *discordgo.Session, i *discordgo.InteractionCreate, scope string) {
+	source, sortOrder, ok := b.historySource(s, i, scope)
+	if !ok {
+		return
+	}
+
+	b.respondRemixModal(s, i, source, sortOrder, scope)
+}
@@ -0,0 +1,550 @@
+package discord_bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/imagine_queue"
+	"stable_diffusion_bot/moderation"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	moderationSubcommandAdd           = `add`
+	moderationSubcommandRemove        = `remove`
+	moderationSubcommandList          = `list`
+	moderationSubcommandReviewChannel = `review_channel`
+	moderationSubcommandNSFWChannel   = `nsfw_channel`
+	moderationSubcommandTest          = `test`
+	moderationSubcommandLog           = `log`
+
+	moderationOptionPattern = `pattern`
+	moderationOptionAction  = `action`
+	moderationOptionList    = `list`
+	moderationOptionMatch   = `match`
+	moderationOptionChannel = `channel`
+	moderationOptionID      = `id`
+	moderationOptionPrompt  = `prompt`
+
+	reviewApproveID = "imagine_review_approve"
+	reviewDenyID    = "imagine_review_deny"
+)
+
+func (b *botImpl) moderationCommandString() string {
+	if b.developmentMode {
+		return "dev_" + b.imagineCommand + "_moderation"
+	}
+
+	return b.imagineCommand + "_moderation"
+}
+
+func stringChoices(values []string) []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, len(values))
+
+	for idx, value := range values {
+		choices[idx] = &discordgo.ApplicationCommandOptionChoice{Name: value, Value: value}
+	}
+
+	return choices
+}
+
+func (b *botImpl) addModerationCommand() error {
+	log.Printf("Adding command '%s'...", b.moderationCommandString())
+
+	manageServer := int64(discordgo.PermissionManageServer)
+	minID := 1.0
+
+	channelOption := &discordgo.ApplicationCommandOption{
+		Type:         discordgo.ApplicationCommandOptionChannel,
+		Name:         moderationOptionChannel,
+		Description:  "Only apply the rule in this channel, the whole server when not given",
+		Required:     false,
+		ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+	}
+
+	cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
+		Name:                     b.moderationCommandString(),
+		Description:              "Manage the terms that prompts may not contain",
+		DefaultMemberPermissions: &manageServer,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        moderationSubcommandAdd,
+				Description: "Add a rule, words match whole words in any spelling, like \"n4ked\" for \"naked\"",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        moderationOptionPattern,
+						Description: "The word, phrase or regular expression",
+						Required:    true,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        moderationOptionAction,
+						Description: "What happens to prompts with the term, reject when not given",
+						Required:    false,
+						Choices:     stringChoices(moderation.Actions),
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        moderationOptionList,
+						Description: "Block the term, or allow it in spite of the block rules, block when not given",
+						Required:    false,
+						Choices:     stringChoices(moderation.Lists),
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        moderationOptionMatch,
+						Description: "How the pattern is matched, word when not given. Checkpoints can only be sensitive",
+						Required:    false,
+						Choices:     stringChoices(moderation.Matches),
+					},
+					channelOption,
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        moderationSubcommandRemove,
+				Description: "Remove a rule",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        moderationOptionID,
+						Description: "The number of the rule, see the list",
+						Required:    true,
+						MinValue:    &minID,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        moderationSubcommandList,
+				Description: "List the rules of this server",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        moderationSubcommandReviewChannel,
+				Description: "Set the channel held prompts are posted to, without one they are rejected",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Name:         moderationOptionChannel,
+						Description:  "The review channel",
+						Required:     true,
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        moderationSubcommandNSFWChannel,
+				Description: "Set the channel sensitive results are posted to, without one they are spoilered",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Name:         moderationOptionChannel,
+						Description:  "The NSFW channel, spoiler the results again when not given",
+						Required:     false,
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        moderationSubcommandTest,
+				Description: "Show what the rules would do with a prompt",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        moderationOptionPrompt,
+						Description: "The prompt to check",
+						Required:    true,
+					},
+					{
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						Name:         moderationOptionChannel,
+						Description:  "Check with the rules of this channel, this channel when not given",
+						Required:     false,
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        moderationSubcommandLog,
+				Description: "Show the latest moderation decisions",
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating '%s' command: %v", b.moderationCommandString(), err)
+		return err
+	}
+
+	b.registeredCommands = append(b.registeredCommands, cmd)
+
+	return nil
+}
+
+// moderationRuleDescription shows the rule on one line.
+func moderationRuleDescription(rule *entities.ModerationRule) string {
+	where := "everywhere"
+	if rule.ChannelID != "" {
+		where = fmt.Sprintf("in <#%s>", rule.ChannelID)
+	}
+
+	if rule.List == moderation.ListAllow {
+		return fmt.Sprintf("#%d allow %s `%s` %s", rule.ID, rule.Match, rule.Pattern, where)
+	}
+
+	return fmt.Sprintf("#%d %s %s `%s` %s", rule.ID, rule.Action, rule.Match, rule.Pattern, where)
+}
+
+func (b *botImpl) processModerationCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Member == nil || i.GuildID == "" {
+		respondEphemeral(s, i, "Moderation rules can only be used in a server.")
+
+		return
+	}
+
+	if !b.canModerate(i, discordgo.PermissionManageServer) {
+		respondEphemeral(s, i, "Only moderators can change the moderation rules.")
+
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, option := range subcommand.Options {
+		options[option.Name] = option
+	}
+
+	stringOption := func(name, fallback string) string {
+		if option, ok := options[name]; ok {
+			return option.StringValue()
+		}
+
+		return fallback
+	}
+
+	channelOption := func(fallback string) string {
+		if option, ok := options[moderationOptionChannel]; ok {
+			return option.ChannelValue(nil).ID
+		}
+
+		return fallback
+	}
+
+	switch subcommand.Name {
+	case moderationSubcommandAdd:
+		rule, err := b.imagineQueue.AddModerationRule(&entities.ModerationRule{
+			GuildID:   i.GuildID,
+			ChannelID: channelOption(""),
+			List:      stringOption(moderationOptionList, moderation.ListBlock),
+			Match:     stringOption(moderationOptionMatch, moderation.MatchWord),
+			Pattern:   strings.TrimSpace(stringOption(moderationOptionPattern, "")),
+			Action:    stringOption(moderationOptionAction, moderation.ActionReject),
+			CreatedBy: i.Member.User.ID,
+		})
+		if err != nil {
+			if errors.Is(err, &moderation.InvalidRuleError{}) {
+				respondEphemeral(s, i, fmt.Sprintf("Could not add the rule, %v.", err))
+
+				return
+			}
+
+			log.Printf("Error adding moderation rule: %v", err)
+
+			respondEphemeral(s, i, "Error adding the rule...")
+
+			return
+		}
+
+		respondEphemeral(s, i, "Added "+moderationRuleDescription(rule))
+	case moderationSubcommandRemove:
+		id := options[moderationOptionID].IntValue()
+
+		deleted, err := b.imagineQueue.DeleteModerationRule(i.GuildID, id)
+		if err != nil {
+			log.Printf("Error deleting moderation rule: %v", err)
+
+			respondEphemeral(s, i, "Error removing the rule...")
+
+			return
+		}
+
+		if !deleted {
+			respondEphemeral(s, i, fmt.Sprintf("This server has no rule #%d.", id))
+
+			return
+		}
+
+		respondEphemeral(s, i, fmt.Sprintf("Removed rule #%d.", id))
+	case moderationSubcommandList:
+		rules, err := b.imagineQueue.ListModerationRules(i.GuildID)
+		if err != nil {
+			log.Printf("Error listing moderation rules: %v", err)
+
+			respondEphemeral(s, i, "Error listing the rules...")
+
+			return
+		}
+
+		if len(rules) == 0 {
+			respondEphemeral(s, i, "This server has no moderation rules.")
+
+			return
+		}
+
+		descriptions := make([]string, len(rules))
+		for idx, rule := range rules {
+			descriptions[idx] = moderationRuleDescription(rule)
+		}
+
+		reviewChannelID, err := b.imagineQueue.GetReviewChannel(i.GuildID)
+		if err != nil {
+			log.Printf("Error getting review channel: %v", err)
+		}
+
+		reviewChannel := "Held prompts are rejected, there is no review channel."
+		if reviewChannelID != "" {
+			reviewChannel = fmt.Sprintf("Held prompts are reviewed in <#%s>.", reviewChannelID)
+		}
+
+		nsfwChannelID, err := b.imagineQueue.GetNSFWChannel(i.GuildID)
+		if err != nil {
+			log.Printf("Error getting NSFW channel: %v", err)
+		}
+
+		nsfwChannel := "Sensitive results are spoilered, there is no NSFW channel."
+		if nsfwChannelID != "" {
+			nsfwChannel = fmt.Sprintf("Sensitive results are posted in <#%s>.", nsfwChannelID)
+		}
+
+		respondEphemeral(s, i, strings.Join(descriptions, "\n")+"\n\n"+reviewChannel+"\n"+nsfwChannel)
+	case moderationSubcommandReviewChannel:
+		channelID := channelOption("")
+
+		err := b.imagineQueue.SetReviewChannel(i.GuildID, channelID)
+		if err != nil {
+			log.Printf("Error setting review channel: %v", err)
+
+			respondEphemeral(s, i, "Error setting the review channel...")
+
+			return
+		}
+
+		respondEphemeral(s, i, fmt.Sprintf("Held prompts are now reviewed in <#%s>.", channelID))
+	case moderationSubcommandNSFWChannel:
+		channelID := channelOption("")
+
+		if channelID != "" {
+			channel, err := s.State.Channel(channelID)
+			if err == nil && !channel.NSFW {
+				respondEphemeral(s, i, fmt.Sprintf("<#%s> isn't age restricted, mark it as NSFW first.", channelID))
+
+				return
+			}
+		}
+
+		err := b.imagineQueue.SetNSFWChannel(i.GuildID, channelID)
+		if err != nil {
+			log.Printf("Error setting NSFW channel: %v", err)
+
+			respondEphemeral(s, i, "Error setting the NSFW channel...")
+
+			return
+		}
+
+		if channelID == "" {
+			respondEphemeral(s, i, "Sensitive results are now spoilered.")
+
+			return
+		}
+
+		respondEphemeral(s, i, fmt.Sprintf("Sensitive results are now posted in <#%s>.", channelID))
+	case moderationSubcommandTest:
+		result, err := b.imagineQueue.CheckPrompt(i.GuildID, channelOption(i.ChannelID), stringOption(moderationOptionPrompt, ""))
+		if err != nil {
+			log.Printf("Error checking prompt: %v", err)
+
+			respondEphemeral(s, i, "Error checking the prompt...")
+
+			return
+		}
+
+		if result.Action == "" {
+			respondEphemeral(s, i, "No rule matches the prompt.")
+
+			return
+		}
+
+		matches := make([]string, len(result.Matches))
+		for idx, match := range result.Matches {
+			matches[idx] = fmt.Sprintf("%s, matched \"%s\"", moderationRuleDescription(match.Rule), match.Text)
+		}
+
+		respondEphemeral(s, i, fmt.Sprintf("The prompt would be: %s\n%s", result.Action, strings.Join(matches, "\n")))
+	case moderationSubcommandLog:
+		decisions, err := b.imagineQueue.ListModerationDecisions(i.GuildID)
+		if err != nil {
+			log.Printf("Error listing moderation decisions: %v", err)
+
+			respondEphemeral(s, i, "Error listing the decisions...")
+
+			return
+		}
+
+		if len(decisions) == 0 {
+			respondEphemeral(s, i, "No prompt of this server matched a rule yet.")
+
+			return
+		}
+
+		lines := make([]string, len(decisions))
+		for idx, decision := range decisions {
+			lines[idx] = moderationDecisionDescription(decision)
+		}
+
+		respondEphemeral(s, i, strings.Join(lines, "\n"))
+	default:
+		log.Printf("Unknown moderation subcommand '%v'", subcommand.Name)
+	}
+}
+
+// moderationDecisionDescription shows the decision on one line.
+func moderationDecisionDescription(decision *entities.ModerationDecision) string {
+	by := ""
+	if decision.ModeratorID != "" {
+		by = fmt.Sprintf(" by <@%s>", decision.ModeratorID)
+	}
+
+	return fmt.Sprintf("<t:%d:R> prompt of <@%s> in <#%s> %s%s, rule #%d `%s`",
+		decision.CreatedAt.Unix(), decision.MemberID, decision.ChannelID, decision.Decision, by, decision.RuleID,
+		decision.Pattern)
+}
+
+// respondModerationError tells the member what moderation did with their prompt. Held prompts are posted to the
+// review channel, and the response stays visible, since the results replace it once the prompt is approved.
+func (b *botImpl) respondModerationError(s *discordgo.Session, i *discordgo.InteractionCreate, queueError error) bool {
+	if errors.Is(queueError, &imagine_queue.PromptRejectedError{}) {
+		respondEphemeral(s, i, "I'm sorry, but your prompt contains a term that isn't allowed here.")
+
+		return true
+	}
+
+	var heldError *imagine_queue.PromptHeldError
+	if !errors.As(queueError, &heldError) {
+		return false
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("<@%s>, your prompt is waiting for a moderator to approve it...", interactionMemberID(i.Interaction)),
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+
+	b.postReview(s, i, heldError)
+
+	return true
+}
+
+// postReview posts the held prompt to the review channel, with buttons to approve or deny it.
+func (b *botImpl) postReview(s *discordgo.Session, i *discordgo.InteractionCreate, heldError *imagine_queue.PromptHeldError) {
+	heldID := strconv.FormatInt(heldError.HeldID, 10)
+
+	prompt := truncate(heldError.Prompt, maxMessageLength/2)
+
+	// every prompt of a dynamic prompt is generated once approved, so the moderator sees them all
+	if len(heldError.Prompts) > 0 {
+		prompt = truncate("- "+strings.Join(heldError.Prompts, "\n- "), maxMessageLength/2)
+	}
+
+	_, err := s.ChannelMessageSendComplex(heldError.ReviewChannelID, &discordgo.MessageSend{
+		Content: fmt.Sprintf("Prompt of <@%s> in <#%s> matched rule #%d `%s`:\n>>> %s",
+			interactionMemberID(i.Interaction), i.ChannelID, heldError.RuleID, heldError.Pattern, prompt),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+		Components: []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Approve",
+						Style:    discordgo.SuccessButton,
+						CustomID: reviewApproveID + ":" + heldID,
+					},
+					discordgo.Button{
+						Label:    "Deny",
+						Style:    discordgo.DangerButton,
+						CustomID: reviewDenyID + ":" + heldID,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error posting held prompt %s for review: %v", heldID, err)
+	}
+}
+
+// processReviewDecision approves or denies a held prompt, and shows the decision on the review message.
+func (b *botImpl) processReviewDecision(s *discordgo.Session, i *discordgo.InteractionCreate, heldIDValue string, approve bool) {
+	if !b.canModerate(i, discordgo.PermissionManageMessages) {
+		respondEphemeral(s, i, "Only moderators can review prompts.")
+
+		return
+	}
+
+	heldID, err := strconv.ParseInt(heldIDValue, 10, 64)
+	if err != nil {
+		log.Printf("Error parsing held prompt ID: %v", err)
+
+		return
+	}
+
+	var outcome string
+
+	if approve {
+		_, err = b.imagineQueue.ApproveHeldPrompt(heldID, i.Member.User.ID)
+		outcome = "Approved"
+	} else {
+		err = b.imagineQueue.DenyHeldPrompt(heldID, i.Member.User.ID)
+		outcome = "Denied"
+	}
+
+	switch {
+	case errors.Is(err, &imagine_queue.HeldPromptNotFoundError{}):
+		outcome = "Already decided on, or waited too long"
+	case err != nil:
+		log.Printf("Error deciding on held prompt %d: %v", heldID, err)
+
+		respondEphemeral(s, i, "Error deciding on the prompt...")
+
+		return
+	default:
+		outcome += fmt.Sprintf(" by <@%s>", i.Member.User.ID)
+	}
+
+	content := truncate(i.Message.Content+"\n\n**"+outcome+"**", maxMessageLength)
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			Components:      []discordgo.MessageComponent{},
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+package discord_bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/imagine_queue"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	permissionsSubcommandGrant  = `grant`
+	permissionsSubcommandRevoke = `revoke`
+	permissionsSubcommandList   = `list`
+
+	permissionsOptionCapability = `capability`
+	permissionsOptionRole       = `role`
+	permissionsOptionUser       = `user`
+)
+
+func (b *botImpl) permissionsCommandString() string {
+	if b.developmentMode {
+		return "dev_" + b.imagineCommand + "_permissions"
+	}
+
+	return b.imagineCommand + "_permissions"
+}
+
+func capabilityChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, len(imagine_queue.Capabilities))
+
+	for idx, capability := range imagine_queue.Capabilities {
+		choices[idx] = &discordgo.ApplicationCommandOptionChoice{
+			Name:  imagine_queue.CapabilityLabel(capability),
+			Value: capability,
+		}
+	}
+
+	return choices
+}
+
+func (b *botImpl) addPermissionsCommand() error {
+	log.Printf("Adding command '%s'...", b.permissionsCommandString())
+
+	manageServer := int64(discordgo.PermissionManageServer)
+
+	// a grant goes to a role or a user, whichever is given
+	subjectOptions := []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        permissionsOptionCapability,
+			Description: "What the role or user may do",
+			Required:    true,
+			Choices:     capabilityChoices(),
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionRole,
+			Name:        permissionsOptionRole,
+			Description: "The role, give either a role or a user",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionUser,
+			Name:        permissionsOptionUser,
+			Description: "The user, give either a role or a user",
+			Required:    false,
+		},
+	}
+
+	cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
+		Name:                     b.permissionsCommandString(),
+		Description:              "Manage which roles and members may change the model, the defaults and the rest",
+		DefaultMemberPermissions: &manageServer,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        permissionsSubcommandGrant,
+				Description: "Give a role or a user a capability",
+				Options:     subjectOptions,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        permissionsSubcommandRevoke,
+				Description: "Take a capability from a role or a user",
+				Options:     subjectOptions,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        permissionsSubcommandList,
+				Description: "List who has which capability in this server",
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating '%s' command: %v", b.permissionsCommandString(), err)
+		return err
+	}
+
+	b.registeredCommands = append(b.registeredCommands, cmd)
+
+	return nil
+}
+
+// memberHasPermission checks a Discord permission of whoever used the interaction, which they only have in a server.
+func memberHasPermission(interaction *discordgo.Interaction, permission int64) bool {
+	return interaction.Member != nil && interaction.Member.Permissions&permission != 0
+}
+
+// hasCapability checks a capability of whoever used the interaction. Administrators have them all. Servers that
+// granted the capability to nobody, and direct messages, fall back to the given answer, which is what the handler
+// allowed before the server set up its permissions.
+func (b *botImpl) hasCapability(interaction *discordgo.Interaction, capability string, fallback bool) bool {
+	if interaction.Member == nil || interaction.GuildID == "" {
+		return fallback
+	}
+
+	if memberHasPermission(interaction, discordgo.PermissionAdministrator) {
+		return true
+	}
+
+	check, err := b.imagineQueue.CheckCapability(interaction.GuildID, interactionMemberID(interaction),
+		interaction.Member.Roles, capability)
+	if err != nil {
+		log.Printf("Error checking capability %s: %v", capability, err)
+
+		return fallback
+	}
+
+	if !check.Configured {
+		return fallback
+	}
+
+	return check.Granted
+}
+
+func (b *botImpl) canChangeModel(i *discordgo.InteractionCreate) bool {
+	return b.hasCapability(i.Interaction, imagine_queue.CapabilityChangeModel,
+		memberHasPermission(i.Interaction, discordgo.PermissionManageServer))
+}
+
+func (b *botImpl) canEditServerDefaults(i *discordgo.InteractionCreate) bool {
+	return b.hasCapability(i.Interaction, imagine_queue.CapabilityEditServerDefaults,
+		memberHasPermission(i.Interaction, discordgo.PermissionManageServer))
+}
+
+// canModerate checks that the member may review prompts and delete the results of others. The moderation rules fall
+// back to managing the server instead of managing messages.
+func (b *botImpl) canModerate(i *discordgo.InteractionCreate, fallbackPermission int64) bool {
+	return b.hasCapability(i.Interaction, imagine_queue.CapabilityModerate,
+		memberHasPermission(i.Interaction, fallbackPermission))
+}
+
+// permissionSubject returns the role or user the grant is for.
+func permissionSubject(options map[string]*discordgo.ApplicationCommandInteractionDataOption) (string, string, bool) {
+	role, hasRole := options[permissionsOptionRole]
+	user, hasUser := options[permissionsOptionUser]
+
+	switch {
+	case hasRole && !hasUser:
+		return imagine_queue.PermissionSubjectRole, role.RoleValue(nil, "").ID, true
+	case hasUser && !hasRole:
+		return imagine_queue.PermissionSubjectUser, user.UserValue(nil).ID, true
+	default:
+		return "", "", false
+	}
+}
+
+// permissionSubjectMention shows the role or user of the grant.
+func permissionSubjectMention(subjectType, subjectID string) string {
+	if subjectType == imagine_queue.PermissionSubjectRole {
+		return fmt.Sprintf("<@&%s>", subjectID)
+	}
+
+	return fmt.Sprintf("<@%s>", subjectID)
+}
+
+func (b *botImpl) processPermissionsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Member == nil || i.GuildID == "" {
+		respondEphemeral(s, i, "Permissions can only be used in a server.")
+
+		return
+	}
+
+	// managing the permissions isn't a capability itself, so nobody can be locked out of them
+	if !memberHasPermission(i.Interaction, discordgo.PermissionManageServer) &&
+		!memberHasPermission(i.Interaction, discordgo.PermissionAdministrator) {
+		respondEphemeral(s, i, "Only members who can manage the server can change the permissions.")
+
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, option := range subcommand.Options {
+		options[option.Name] = option
+	}
+
+	switch subcommand.Name {
+	case permissionsSubcommandList:
+		b.respondPermissionsList(s, i)
+
+		return
+	case permissionsSubcommandGrant, permissionsSubcommandRevoke:
+	default:
+		log.Printf("Unknown permissions subcommand '%v'", subcommand.Name)
+
+		return
+	}
+
+	subjectType, subjectID, ok := permissionSubject(options)
+	if !ok {
+		respondEphemeral(s, i, "Give either a role or a user.")
+
+		return
+	}
+
+	capability := options[permissionsOptionCapability].StringValue()
+	label := imagine_queue.CapabilityLabel(capability)
+	subject := permissionSubjectMention(subjectType, subjectID)
+
+	if subcommand.Name == permissionsSubcommandRevoke {
+		revoked, err := b.imagineQueue.RevokePermission(i.GuildID, capability, subjectType, subjectID)
+		if err != nil {
+			log.Printf("Error revoking permission: %v", err)
+
+			respondEphemeral(s, i, "Error revoking the permission...")
+
+			return
+		}
+
+		if !revoked {
+			respondEphemeral(s, i, fmt.Sprintf("%s didn't have **%s**.", subject, label))
+
+			return
+		}
+
+		respondEphemeral(s, i, fmt.Sprintf("%s no longer has **%s**.", subject, label))
+
+		return
+	}
+
+	added, err := b.imagineQueue.GrantPermission(&entities.PermissionGrant{
+		GuildID:     i.GuildID,
+		Capability:  capability,
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		CreatedBy:   interactionMemberID(i.Interaction),
+	})
+	if err != nil {
+		log.Printf("Error granting permission: %v", err)
+
+		respondEphemeral(s, i, fmt.Sprintf("Error granting the permission: %v", err))
+
+		return
+	}
+
+	if !added {
+		respondEphemeral(s, i, fmt.Sprintf("%s already has **%s**.", subject, label))
+
+		return
+	}
+
+	respondEphemeral(s, i, fmt.Sprintf("%s now has **%s**. Only the roles and users it is granted to have it from now on.",
+		subject, label))
+}
+
+func (b *botImpl) respondPermissionsList(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	grants, err := b.imagineQueue.ListPermissions(i.GuildID)
+	if err != nil {
+		log.Printf("Error listing permissions: %v", err)
+
+		respondEphemeral(s, i, "Error listing the permissions...")
+
+		return
+	}
+
+	subjects := make(map[string][]string)
+	for _, grant := range grants {
+		subjects[grant.Capability] = append(subjects[grant.Capability],
+			permissionSubjectMention(grant.SubjectType, grant.SubjectID))
+	}
+
+	lines := make([]string, len(imagine_queue.Capabilities))
+
+	for idx, capability := range imagine_queue.Capabilities {
+		who := "not granted, the Discord permissions decide"
+		if len(subjects[capability]) > 0 {
+			who = strings.Join(subjects[capability], ", ")
+		}
+
+		lines[idx] = fmt.Sprintf("**%s**: %s", imagine_queue.CapabilityLabel(capability), who)
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         truncate("Permissions of this server:\n"+strings.Join(lines, "\n"), maxMessageLength),
+			Flags:           discordgo.MessageFlagsEphemeral,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package discord_bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/imagine_queue"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	profileSubcommandSet    = `set`
+	profileSubcommandShow   = `show`
+	profileSubcommandList   = `list`
+	profileSubcommandDelete = `delete`
+
+	profileOptionChannel        = `channel`
+	profileOptionModel          = `model`
+	profileOptionNegativePrompt = `negative_prompt`
+	profileOptionSamplers       = `samplers`
+	profileOptionMaxWidth       = `max_width`
+	profileOptionMaxHeight      = `max_height`
+	profileOptionMaxBatch       = `max_batch`
+
+	// profileClearValue removes a text setting from a profile
+	profileClearValue = `none`
+)
+
+func (b *botImpl) profileCommandString() string {
+	if b.developmentMode {
+		return "dev_" + b.imagineCommand + "_profile"
+	}
+
+	return b.imagineCommand + "_profile"
+}
+
+func (b *botImpl) addProfileCommand() error {
+	log.Printf("Adding command '%s'...", b.profileCommandString())
+
+	manageServer := int64(discordgo.PermissionManageServer)
+	noLimit := 0.0
+
+	channelOption := &discordgo.ApplicationCommandOption{
+		Type:         discordgo.ApplicationCommandOptionChannel,
+		Name:         profileOptionChannel,
+		Description:  "The channel of the profile, this channel when not given",
+		Required:     false,
+		ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+	}
+
+	cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
+		Name:                     b.profileCommandString(),
+		Description:              "Manage the generation profiles of channels",
+		DefaultMemberPermissions: &manageServer,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        profileSubcommandSet,
+				Description: "Create or change the profile of a channel, only the given options change",
+				Options: []*discordgo.ApplicationCommandOption{
+					channelOption,
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        profileOptionModel,
+						Description: "Checkpoint every image in the channel uses, \"none\" to allow any",
+						Required:    false,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        profileOptionNegativePrompt,
+						Description: "Added to the negative prompt of every image, \"none\" to remove",
+						Required:    false,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        profileOptionSamplers,
+						Description: "Comma separated samplers that may be used, the first is the fallback, \"none\" for any",
+						Required:    false,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        profileOptionMaxWidth,
+						Description: "Largest width of an image, 0 for no limit",
+						Required:    false,
+						MinValue:    &noLimit,
+						MaxValue:    2048,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        profileOptionMaxHeight,
+						Description: "Largest height of an image, 0 for no limit",
+						Required:    false,
+						MinValue:    &noLimit,
+						MaxValue:    2048,
+					},
+					{
+						Type:        discordgo.ApplicationCommandOptionInteger,
+						Name:        profileOptionMaxBatch,
+						Description: "Most images per generation, 0 for no limit",
+						Required:    false,
+						MinValue:    &noLimit,
+						MaxValue:    4,
+					},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        profileSubcommandShow,
+				Description: "Show the profile of a channel",
+				Options:     []*discordgo.ApplicationCommandOption{channelOption},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        profileSubcommandList,
+				Description: "List the channels of this server that have a profile",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        profileSubcommandDelete,
+				Description: "Remove the profile of a channel",
+				Options:     []*discordgo.ApplicationCommandOption{channelOption},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating '%s' command: %v", b.profileCommandString(), err)
+		return err
+	}
+
+	b.registeredCommands = append(b.registeredCommands, cmd)
+
+	return nil
+}
+
+// profileDescription lists the settings of a profile, one per line.
+func profileDescription(profile *entities.ChannelProfile) string {
+	anyValue := func(value string) string {
+		if value == "" {
+			return "any"
+		}
+
+		return value
+	}
+
+	limit := func(value int) string {
+		if value == 0 {
+			return "no limit"
+		}
+
+		return fmt.Sprintf("%d", value)
+	}
+
+	negativePrompt := profile.NegativePrompt
+	if negativePrompt == "" {
+		negativePrompt = "nothing added"
+	}
+
+	return fmt.Sprintf("Profile of <#%s>:\n- Model: %s\n- Negative prompt: %s\n- Samplers: %s\n"+
+		"- Max width: %s\n- Max height: %s\n- Max images: %s",
+		profile.ChannelID, anyValue(profile.Model), negativePrompt, anyValue(strings.Join(profile.AllowedSamplers, ", ")),
+		limit(profile.MaxWidth), limit(profile.MaxHeight), limit(profile.MaxBatch))
+}
+
+// maxMessageLength is the most characters Discord allows in a message
+const maxMessageLength = 2000
+
+// respondEphemeral answers the interaction with a message only its user can see.
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	content = truncate(content, maxMessageLength)
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+// findModel matches the given name with the title of a model, titles end with the hash of the checkpoint.
+func (b *botImpl) findModel(name string) (string, bool) {
+	models, err := b.stableDiffusionAPI.GetModels()
+	if err != nil {
+		log.Printf("Error fetching models: %v", err)
+
+		// can't check it, so trust the admin
+		return name, true
+	}
+
+	for _, model := range models {
+		if model == name || strings.HasPrefix(model, name) {
+			return model, true
+		}
+	}
+
+	return "", false
+}
+
+func (b *botImpl) processProfileCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Member == nil || i.GuildID == "" {
+		respondEphemeral(s, i, "Profiles can only be used in a server.")
+
+		return
+	}
+
+	if !b.canEditServerDefaults(i) {
+		respondEphemeral(s, i, "Only members who may edit the server defaults can change the profiles of channels.")
+
+		return
+	}
+
+	subcommand := i.ApplicationCommandData().Options[0]
+
+	channelID := i.ChannelID
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+
+	for _, option := range subcommand.Options {
+		if option.Name == profileOptionChannel {
+			channelID = option.ChannelValue(nil).ID
+
+			continue
+		}
+
+		options[option.Name] = option
+	}
+
+	switch subcommand.Name {
+	case profileSubcommandSet:
+		b.processProfileSet(s, i, channelID, options)
+	case profileSubcommandShow:
+		profile, err := b.imagineQueue.GetChannelProfile(channelID)
+		if err != nil {
+			log.Printf("Error getting channel profile: %v", err)
+
+			respondEphemeral(s, i, "Error getting the profile...")
+
+			return
+		}
+
+		if profile == nil {
+			respondEphemeral(s, i, fmt.Sprintf("<#%s> has no profile, the defaults of the members apply.", channelID))
+
+			return
+		}
+
+		respondEphemeral(s, i, profileDescription(profile))
+	case profileSubcommandList:
+		profiles, err := b.imagineQueue.ListChannelProfiles(i.GuildID)
+		if err != nil {
+			log.Printf("Error listing channel profiles: %v", err)
+
+			respondEphemeral(s, i, "Error listing the profiles...")
+
+			return
+		}
+
+		if len(profiles) == 0 {
+			respondEphemeral(s, i, "No channel of this server has a profile.")
+
+			return
+		}
+
+		descriptions := make([]string, len(profiles))
+		for idx, profile := range profiles {
+			descriptions[idx] = profileDescription(profile)
+		}
+
+		respondEphemeral(s, i, strings.Join(descriptions, "\n\n"))
+	case profileSubcommandDelete:
+		err := b.imagineQueue.DeleteChannelProfile(channelID)
+		if err != nil {
+			log.Printf("Error deleting channel profile: %v", err)
+
+			respondEphemeral(s, i, "Error removing the profile...")
+
+			return
+		}
+
+		respondEphemeral(s, i, fmt.Sprintf("Removed the profile of <#%s>.", channelID))
+	default:
+		log.Printf("Unknown profile subcommand '%v'", subcommand.Name)
+	}
+}
+
+func (b *botImpl) processProfileSet(s *discordgo.Session, i *discordgo.InteractionCreate, channelID string,
+	options map[string]*discordgo.ApplicationCommandInteractionDataOption,
+) {
+	profile, err := b.imagineQueue.GetChannelProfile(channelID)
+	if err != nil {
+		log.Printf("Error getting channel profile: %v", err)
+
+		respondEphemeral(s, i, "Error getting the profile...")
+
+		return
+	}
+
+	if profile == nil {
+		profile = &entities.ChannelProfile{
+			ChannelID: channelID,
+			GuildID:   i.GuildID,
+		}
+	}
+
+	textValue := func(name string, current string) string {
+		option, ok := options[name]
+		if !ok {
+			return current
+		}
+
+		value := strings.TrimSpace(option.StringValue())
+		if strings.EqualFold(value, profileClearValue) {
+			return ""
+		}
+
+		return value
+	}
+
+	if model := textValue(profileOptionModel, profile.Model); model != profile.Model {
+		profile.Model = model
+
+		if model != "" {
+			title, ok := b.findModel(model)
+			if !ok {
+				respondEphemeral(s, i, fmt.Sprintf("I don't know the model \"%s\", see `/%s` for the models I have.",
+					model, b.changeModelCommandString()))
+
+				return
+			}
+
+			profile.Model = title
+		}
+	}
+
+	profile.NegativePrompt = textValue(profileOptionNegativePrompt, profile.NegativePrompt)
+
+	if samplers := textValue(profileOptionSamplers, strings.Join(profile.AllowedSamplers, ",")); samplers != "" {
+		profile.AllowedSamplers = nil
+
+		for _, sampler := range strings.Split(samplers, ",") {
+			if sampler = strings.TrimSpace(sampler); sampler != "" {
+				profile.AllowedSamplers = append(profile.AllowedSamplers, sampler)
+			}
+		}
+	} else {
+		profile.AllowedSamplers = nil
+	}
+
+	if option, ok := options[profileOptionMaxWidth]; ok {
+		profile.MaxWidth = int(option.IntValue())
+	}
+
+	if option, ok := options[profileOptionMaxHeight]; ok {
+		profile.MaxHeight = int(option.IntValue())
+	}
+
+	if option, ok := options[profileOptionMaxBatch]; ok {
+		profile.MaxBatch = int(option.IntValue())
+	}
+
+	profile, err = b.imagineQueue.UpdateChannelProfile(profile)
+	if err != nil {
+		if errors.Is(err, &imagine_queue.InvalidSettingError{}) {
+			respondEphemeral(s, i, fmt.Sprintf("Could not save the profile, %v.", err))
+
+			return
+		}
+
+		log.Printf("Error updating channel profile: %v", err)
+
+		respondEphemeral(s, i, "Error saving the profile...")
+
+		return
+	}
+
+	respondEphemeral(s, i, profileDescription(profile))
+}
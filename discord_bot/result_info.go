@@ -0,0 +1,229 @@
+package discord_bot
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/imagine_queue"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	infoButtonID = "imagine_info"
+
+	// maxEmbedDescriptionLength is the most characters Discord allows in the description of an embed
+	maxEmbedDescriptionLength = 4096
+	// maxEmbedsLength is the most characters Discord allows in all the embeds of a message together, less some for
+	// the titles and fields
+	maxEmbedsLength = 5500
+)
+
+// infoButton shows the seeds and the parameters text of the images, only to whoever presses it.
+func infoButton() discordgo.Button {
+	return discordgo.Button{
+		Label:    "Info",
+		Style:    discordgo.SecondaryButton,
+		CustomID: infoButtonID,
+		Emoji: discordgo.ComponentEmoji{
+			Name: "ℹ️",
+		},
+	}
+}
+
+// interactionUser returns whoever used the interaction, in a server or in a direct message.
+func interactionUser(interaction *discordgo.Interaction) *discordgo.User {
+	if interaction.Member != nil && interaction.Member.User != nil {
+		return interaction.Member.User
+	}
+
+	return interaction.User
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "Yes"
+	}
+
+	return "No"
+}
+
+// resultModel names the model of the result, as the API reported it when it did.
+func resultModel(generation *entities.ImageGeneration, model string) string {
+	if model != "" {
+		return model
+	}
+
+	if generation.Checkpoint != "" {
+		return generation.Checkpoint
+	}
+
+	return "default"
+}
+
+// resultEmbed shows the parameters of a finished job, with who asked for it and how long it took.
+func (sink *interactionSink) resultEmbed(result *imagine_queue.JobResult) *discordgo.MessageEmbed {
+	generation := result.Generation
+
+	seed := strconv.Itoa(generation.Seed)
+	if generation.Seed < 0 {
+		seed = "random"
+	}
+
+	size := fmt.Sprintf("%dx%d", generation.Width, generation.Height)
+	if generation.EnableHR && generation.HiresWidth > 0 && generation.HiresHeight > 0 {
+		size += fmt.Sprintf(" → %dx%d", generation.HiresWidth, generation.HiresHeight)
+	}
+
+	sampler := generation.SamplerName
+	if sampler == "" {
+		sampler = "default"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Description: truncate(generation.Prompt, maxEmbedDescriptionLength),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Model", Value: resultModel(generation, result.Model), Inline: true},
+			{Name: "Seed", Value: seed, Inline: true},
+			{Name: "Size", Value: size, Inline: true},
+			{Name: "Sampler", Value: sampler, Inline: true},
+			{Name: "Steps", Value: strconv.Itoa(generation.Steps), Inline: true},
+			{Name: "CFG scale", Value: strconv.FormatFloat(generation.CfgScale, 'f', -1, 64), Inline: true},
+			{Name: "Batch", Value: fmt.Sprintf("%d x %d", generation.BatchCount, generation.BatchSize), Inline: true},
+			{Name: "Hires fix", Value: yesNo(generation.EnableHR), Inline: true},
+			{Name: "Restore faces", Value: yesNo(generation.RestoreFaces), Inline: true},
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+
+	if generation.EnableHR {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Denoising strength",
+			Value:  strconv.FormatFloat(generation.DenoisingStrength, 'f', -1, 64),
+			Inline: true,
+		})
+	}
+
+	if generation.SubseedStrength > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Variation strength",
+			Value:  strconv.FormatFloat(generation.SubseedStrength, 'f', -1, 64),
+			Inline: true,
+		})
+	}
+
+	if generation.Style != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Style", Value: generation.Style, Inline: true})
+	}
+
+	if generation.NegativePrompt != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Negative prompt",
+			Value: truncate(generation.NegativePrompt, maxEmbedFieldLength),
+		})
+	}
+
+	if user := interactionUser(sink.interaction); user != nil {
+		embed.Author = &discordgo.MessageEmbedAuthor{
+			Name:    user.Username,
+			IconURL: user.AvatarURL(""),
+		}
+	}
+
+	if result.Elapsed > 0 {
+		embed.Footer = &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Took %s", result.Elapsed.Round(100*time.Millisecond)),
+		}
+	}
+
+	return embed
+}
+
+// infoEmbed shows the seeds and the parameters text of an image, the text cut to the given length.
+func infoEmbed(generation *entities.ImageGeneration, length int) *discordgo.MessageEmbed {
+	title := "Image"
+	if generation.SortOrder > 0 {
+		title = fmt.Sprintf("Image #%d", generation.SortOrder)
+	}
+
+	description := "The web UI didn't send the parameters of this image."
+	if generation.Infotext != "" {
+		description = "```\n" + truncate(generation.Infotext, length-8) + "\n```"
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       title,
+		Description: description,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Seed", Value: strconv.Itoa(generation.Seed), Inline: true},
+			{Name: "Subseed", Value: strconv.Itoa(generation.Subseed), Inline: true},
+		},
+	}
+}
+
+// processInfoButton shows the seeds and the parameters text of the images of a result.
+func (b *botImpl) processInfoButton(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	generations, err := b.imagineQueue.ListGenerationsByMessage(i.Message.ID)
+	if err != nil {
+		log.Printf("Error listing generations: %v", err)
+
+		respondEphemeral(s, i, "Error getting the parameters of the images...")
+
+		return
+	}
+
+	// a grid has its batch first, without seeds of its own
+	images := make([]*entities.ImageGeneration, 0, len(generations))
+
+	for _, generation := range generations {
+		if generation.SortOrder > 0 {
+			images = append(images, generation)
+		}
+	}
+
+	if len(images) == 0 {
+		images = generations
+	}
+
+	if len(images) == 0 {
+		respondEphemeral(s, i, "I couldn't find the parameters of these images.")
+
+		return
+	}
+
+	seeds := make([]string, len(images))
+	for idx, generation := range images {
+		seeds[idx] = strconv.Itoa(generation.Seed)
+	}
+
+	content := "Seeds: " + strings.Join(seeds, ", ")
+
+	if len(images) > maxEmbeds {
+		content += fmt.Sprintf("\nShowing the parameters of the first %d images.", maxEmbeds)
+		images = images[:maxEmbeds]
+	}
+
+	// the embeds share the length Discord allows
+	length := min(maxEmbedDescriptionLength, maxEmbedsLength/len(images))
+
+	embeds := make([]*discordgo.MessageEmbed, len(images))
+	for idx, generation := range images {
+		embeds[idx] = infoEmbed(generation, length)
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: truncate(content, maxMessageLength),
+			Embeds:  embeds,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
@@ -0,0 +1,691 @@
+package discord_bot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/imagine_queue"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxUploadSize is how much a message can upload on a server without boosts. Bigger results are linked to when
+// gallery links are enabled.
+const maxUploadSize = 10 * 1024 * 1024
+
+// maxEmbeds is how many embeds a message can have.
+const maxEmbeds = 10
+
+// interactionSink posts the progress and results of a job by editing the response to the interaction that queued
+// it. The extra jobs of a combinatorial prompt post their own followup messages instead.
+type interactionSink struct {
+	session     *discordgo.Session
+	interaction *discordgo.Interaction
+	// galleryLinks shows results that are too big to upload as links to the image store
+	galleryLinks bool
+}
+
+// interactionMemberID returns the ID of whoever used the interaction, in a server or in a direct message.
+func interactionMemberID(interaction *discordgo.Interaction) string {
+	if interaction.Member != nil && interaction.Member.User != nil {
+		return interaction.Member.User.ID
+	}
+
+	if interaction.User != nil {
+		return interaction.User.ID
+	}
+
+	return ""
+}
+
+// settingsKeyForInteraction builds the key for the given scope, from where the interaction happened.
+func settingsKeyForInteraction(interaction *discordgo.Interaction, scope imagine_queue.SettingsScope) imagine_queue.SettingsKey {
+	return imagine_queue.SettingsKey{
+		Scope:     scope,
+		MemberID:  interactionMemberID(interaction),
+		ChannelID: interaction.ChannelID,
+		GuildID:   interaction.GuildID,
+	}
+}
+
+// interactionJobSource describes a job queued with the interaction. Jobs started from a message with images work on
+// that message.
+func (b *botImpl) interactionJobSource(s *discordgo.Session, interaction *discordgo.Interaction) imagine_queue.JobSource {
+	source := imagine_queue.JobSource{
+		RequestID: interaction.ID,
+		MemberID:  interactionMemberID(interaction),
+		ChannelID: interaction.ChannelID,
+		GuildID:   interaction.GuildID,
+		// threads are age restricted when the channel they are in is
+		AgeRestricted: isAgeRestricted(s, interaction.ChannelID),
+		// everyone may use hires fix and no one may go past the batch limits, until the server grants them
+		HiresDenied:      !b.hasCapability(interaction, imagine_queue.CapabilityUseHires, true),
+		IgnoreBatchLimit: b.hasCapability(interaction, imagine_queue.CapabilityExceedBatchLimits, false),
+		Sink: &interactionSink{
+			session:      s,
+			interaction:  interaction,
+			galleryLinks: b.galleryLinks,
+		},
+	}
+
+	if interaction.Message != nil {
+		source.MessageID = interaction.Message.ID
+
+		if len(interaction.Message.Attachments) > 0 {
+			source.ImageURL = interaction.Message.Attachments[0].URL
+		}
+	}
+
+	return source
+}
+
+// isAgeRestricted reports whether the channel is marked NSFW, or is a thread in such a channel.
+func isAgeRestricted(s *discordgo.Session, channelID string) bool {
+	channel, err := s.State.Channel(channelID)
+	if err != nil {
+		channel, err = s.Channel(channelID)
+		if err != nil {
+			log.Printf("Error getting channel %s: %v", channelID, err)
+
+			return false
+		}
+	}
+
+	if channel.IsThread() && channel.ParentID != "" {
+		return isAgeRestricted(s, channel.ParentID)
+	}
+
+	return channel.NSFW
+}
+
+// edit changes the message of the item. Followup items create their own message the first time, and edit that one
+// afterwards.
+func (sink *interactionSink) edit(item *imagine_queue.QueueItem, edit *discordgo.WebhookEdit) (*discordgo.Message, error) {
+	if !item.IsFollowup {
+		return sink.session.InteractionResponseEdit(sink.interaction, edit)
+	}
+
+	if item.ResultMessageID != "" {
+		return sink.session.FollowupMessageEdit(sink.interaction, item.ResultMessageID, edit)
+	}
+
+	params := &discordgo.WebhookParams{
+		Files: edit.Files,
+	}
+
+	if edit.Content != nil {
+		params.Content = *edit.Content
+	}
+
+	if edit.Components != nil {
+		params.Components = *edit.Components
+	}
+
+	if edit.Embeds != nil {
+		params.Embeds = *edit.Embeds
+	}
+
+	return sink.session.FollowupMessageCreate(sink.interaction, true, params)
+}
+
+// statusContent is the message shown while the job runs, or once it is done when progress is 1.
+func statusContent(item *imagine_queue.QueueItem, status imagine_queue.JobStatus) string {
+	userID := item.Source.MemberID
+
+	switch item.Type {
+	case imagine_queue.ItemTypePlot:
+		return plotMessageContent(status.Generation, userID, status.CellsDone, status.CellsTotal)
+	case imagine_queue.ItemTypeUpscale:
+		return upscaleMessageContent(userID, status.Progress, status.UpscaleProgress)
+	case imagine_queue.ItemTypeOutpaint:
+		return outpaintMessageContent(item.Outpaint, userID, status.Progress)
+	case imagine_queue.ItemTypeReimagine:
+		return reimagineMessageContent(userID, status.Progress)
+	default:
+		return imagineMessageContent(status.Generation, userID, status.Progress)
+	}
+}
+
+func (sink *interactionSink) Started(item *imagine_queue.QueueItem, status imagine_queue.JobStatus) (string, error) {
+	content := statusContent(item, status)
+
+	message, err := sink.edit(item, &discordgo.WebhookEdit{
+		Content: &content,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return message.ID, nil
+}
+
+func (sink *interactionSink) Progress(item *imagine_queue.QueueItem, status imagine_queue.JobStatus) error {
+	content := statusContent(item, status)
+
+	_, err := sink.edit(item, &discordgo.WebhookEdit{
+		Content: &content,
+	})
+
+	return err
+}
+
+func (sink *interactionSink) Finished(item *imagine_queue.QueueItem, result *imagine_queue.JobResult) error {
+	var content string
+	var components []discordgo.MessageComponent
+
+	switch item.Type {
+	case imagine_queue.ItemTypePlot:
+		content = plotMessageContent(result.Generation, item.Source.MemberID, len(result.PlotCells), len(result.PlotCells))
+		components = plotMessageComponents(result.PlotCells)
+	case imagine_queue.ItemTypeUpscale:
+		content = upscaleMessageContent(item.Source.MemberID, 1, 1)
+		components = []discordgo.MessageComponent{
+			outpaintMessageComponents(),
+			outpaintPanDownComponents(),
+		}
+	case imagine_queue.ItemTypeOutpaint:
+		content = outpaintMessageContent(item.Outpaint, item.Source.MemberID, 1)
+		components = []discordgo.MessageComponent{
+			outpaintMessageComponents(),
+			outpaintPanDownComponents(),
+		}
+	case imagine_queue.ItemTypeReimagine:
+		content = reimagineMessageContent(item.Source.MemberID, 1)
+		components = []discordgo.MessageComponent{
+			outpaintMessageComponents(),
+			outpaintPanDownComponents(),
+		}
+	default:
+		content = imagineMessageContent(result.Generation, item.Source.MemberID, 1)
+		components = imagineMessageComponents()
+	}
+
+	components = append(components, resultActionsRow())
+
+	embeds := []*discordgo.MessageEmbed{sink.resultEmbed(result)}
+
+	edit := &discordgo.WebhookEdit{
+		Content:    &content,
+		Components: &components,
+		Embeds:     &embeds,
+	}
+
+	routing := result.Generation.Routing
+
+	if routing == imagine_queue.RoutingRedirect {
+		return sink.redirect(item, result, content, embeds, components)
+	}
+
+	// embedded images can't be spoilered, so sensitive results are always uploaded
+	if routing != imagine_queue.RoutingSpoiler && sink.shouldLinkImages(result.Images) {
+		embeds = append(embeds, galleryEmbeds(result.Images)...)
+	} else {
+		edit.Files = resultFiles(result.Images, routing == imagine_queue.RoutingSpoiler)
+	}
+
+	_, err := sink.edit(item, edit)
+
+	return err
+}
+
+// spoilerPrefix makes Discord blur an attachment until it is clicked
+const spoilerPrefix = "SPOILER_"
+
+func resultFiles(images []imagine_queue.ResultImage, spoiler bool) []*discordgo.File {
+	files := make([]*discordgo.File, len(images))
+
+	for idx, image := range images {
+		name := image.Name
+		if spoiler {
+			name = spoilerPrefix + name
+		}
+
+		files[idx] = &discordgo.File{
+			ContentType: image.ContentType,
+			Name:        name,
+			Reader:      bytes.NewReader(image.Data),
+		}
+	}
+
+	return files
+}
+
+// messageLink links to a message in a server.
+func messageLink(guildID, channelID, messageID string) string {
+	return fmt.Sprintf("https://discord.com/channels/%s/%s/%s", guildID, channelID, messageID)
+}
+
+// redirect posts the results in the NSFW channel, linking back to the message they were asked for in. That message
+// keeps its buttons, and links to the results instead of showing them.
+func (sink *interactionSink) redirect(item *imagine_queue.QueueItem, result *imagine_queue.JobResult, content string,
+	embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent,
+) error {
+	channelID := result.Generation.RoutedChannelID
+	sourceLink := messageLink(item.Source.GuildID, item.Source.ChannelID, item.ResultMessageID)
+
+	message := &discordgo.MessageSend{
+		Content:         fmt.Sprintf("%s\nAsked for in %s", content, sourceLink),
+		Embeds:          embeds,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+
+	if sink.shouldLinkImages(result.Images) {
+		message.Embeds = append(embeds, galleryEmbeds(result.Images)...)
+	} else {
+		message.Files = resultFiles(result.Images, false)
+	}
+
+	posted, err := sink.session.ChannelMessageSendComplex(channelID, message)
+	if err != nil {
+		return err
+	}
+
+	content = fmt.Sprintf("%s\nThe results may be sensitive, so they are in <#%s>: %s",
+		content, channelID, messageLink(item.Source.GuildID, channelID, posted.ID))
+
+	_, err = sink.edit(item, &discordgo.WebhookEdit{
+		Content:    &content,
+		Components: &components,
+		Embeds:     &embeds,
+	})
+
+	return err
+}
+
+// shouldLinkImages reports whether the images are too big to upload, and can all be linked to instead. The results
+// embed leaves room for one image less than a message has embeds.
+func (sink *interactionSink) shouldLinkImages(images []imagine_queue.ResultImage) bool {
+	if !sink.galleryLinks || len(images) == 0 || len(images) > maxEmbeds-1 {
+		return false
+	}
+
+	totalSize := 0
+
+	for _, image := range images {
+		if image.URL == "" {
+			return false
+		}
+
+		totalSize += len(image.Data)
+	}
+
+	return totalSize > maxUploadSize
+}
+
+// galleryEmbeds shows the images from the image store, instead of uploading them.
+func galleryEmbeds(images []imagine_queue.ResultImage) []*discordgo.MessageEmbed {
+	embeds := make([]*discordgo.MessageEmbed, len(images))
+
+	for idx, image := range images {
+		embeds[idx] = &discordgo.MessageEmbed{
+			Title: fmt.Sprintf("Image %d", idx+1),
+			URL:   image.URL,
+			Image: &discordgo.MessageEmbedImage{URL: image.URL},
+		}
+	}
+
+	return embeds
+}
+
+func (sink *interactionSink) Failed(item *imagine_queue.QueueItem, jobErr error) {
+	var content string
+
+	switch {
+	case errors.Is(jobErr, &imagine_queue.PromptDeniedError{}):
+		content = "I'm sorry, but a moderator didn't approve your prompt."
+	case errors.Is(jobErr, &imagine_queue.PromptHoldExpiredError{}):
+		content = "I'm sorry, but no moderator looked at your prompt in time."
+	case item.Type == imagine_queue.ItemTypePlot:
+		content = "I'm sorry, but I had a problem imagining your plot."
+	case item.Type == imagine_queue.ItemTypeUpscale:
+		content = "I'm sorry, but I had a problem upscaling your image."
+	case item.Type == imagine_queue.ItemTypeOutpaint:
+		content = "I'm sorry, but I had a problem outpainting your image."
+	case item.Type == imagine_queue.ItemTypeReimagine:
+		content = "I'm sorry, but I had a problem reimagining your image."
+	default:
+		content = "I'm sorry, but I had a problem imagining your image."
+	}
+
+	_, err := sink.edit(item, &discordgo.WebhookEdit{
+		Content: &content,
+	})
+	if err != nil {
+		log.Printf("Error editing interaction: %v\n", err)
+	}
+}
+
+func imagineMessageContent(generation *entities.ImageGeneration, userID string, progress float64) string {
+	if progress >= 0 && progress < 1 {
+		return fmt.Sprintf("<@%s> asked me to imagine \"%s\" without \"%s\". Currently dreaming it up for them. Progress: %.0f%%",
+			userID, generation.Prompt, generation.NegativePrompt, progress*100)
+	} else {
+		return fmt.Sprintf("<@%s> asked me to imagine this, here is what I imagined for them.", userID)
+	}
+}
+
+// resultActionsRow holds the buttons every result has.
+func resultActionsRow() discordgo.ActionsRow {
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			favoriteButton(),
+			infoButton(),
+			deleteButton(),
+		},
+	}
+}
+
+// imagineMessageComponents are the buttons under a grid of images.
+func imagineMessageComponents() []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					// Label is what the user will see on the button.
+					Label: "V1",
+					// Style provides coloring of the button. There are not so many styles tho.
+					Style: discordgo.SecondaryButton,
+					// Disabled allows bot to disable some buttons for users.
+					Disabled: false,
+					// CustomID is a thing telling Discord which data to send when this button will be pressed.
+					CustomID: "imagine_variation_1",
+					Emoji: discordgo.ComponentEmoji{
+						Name: "♻️",
+					},
+				},
+				discordgo.Button{
+					// Label is what the user will see on the button.
+					Label: "V2",
+					// Style provides coloring of the button. There are not so many styles tho.
+					Style: discordgo.SecondaryButton,
+					// Disabled allows bot to disable some buttons for users.
+					Disabled: false,
+					// CustomID is a thing telling Discord which data to send when this button will be pressed.
+					CustomID: "imagine_variation_2",
+					Emoji: discordgo.ComponentEmoji{
+						Name: "♻️",
+					},
+				},
+				discordgo.Button{
+					// Label is what the user will see on the button.
+					Label: "V3",
+					// Style provides coloring of the button. There are not so many styles tho.
+					Style: discordgo.SecondaryButton,
+					// Disabled allows bot to disable some buttons for users.
+					Disabled: false,
+					// CustomID is a thing telling Discord which data to send when this button will be pressed.
+					CustomID: "imagine_variation_3",
+					Emoji: discordgo.ComponentEmoji{
+						Name: "♻️",
+					},
+				},
+				discordgo.Button{
+					// Label is what the user will see on the button.
+					Label: "V4",
+					// Style provides coloring of the button. There are not so many styles tho.
+					Style: discordgo.SecondaryButton,
+					// Disabled allows bot to disable some buttons for users.
+					Disabled: false,
+					// CustomID is a thing telling Discord which data to send when this button will be pressed.
+					CustomID: "imagine_variation_4",
+					Emoji: discordgo.ComponentEmoji{
+						Name: "♻️",
+					},
+				},
+				discordgo.Button{
+					// Label is what the user will see on the button.
+					Label: "Re-roll",
+					// Style provides coloring of the button. There are not so many styles tho.
+					Style: discordgo.PrimaryButton,
+					// Disabled allows bot to disable some buttons for users.
+					Disabled: false,
+					// CustomID is a thing telling Discord which data to send when this button will be pressed.
+					CustomID: "imagine_reroll",
+					Emoji: discordgo.ComponentEmoji{
+						Name: "🎲",
+					},
+				},
+			},
+		},
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					// Label is what the user will see on the button.
+					Label: "U1",
+					// Style provides coloring of the button. There are not so many styles tho.
+					Style: discordgo.SecondaryButton,
+					// Disabled allows bot to disable some buttons for users.
+					Disabled: false,
+					// CustomID is a thing telling Discord which data to send when this button will be pressed.
+					CustomID: "imagine_upscale_1",
+					Emoji: discordgo.ComponentEmoji{
+						Name: "⬆️",
+					},
+				},
+				discordgo.Button{
+					// Label is what the user will see on the button.
+					Label: "U2",
+					// Style provides coloring of the button. There are not so many styles tho.
+					Style: discordgo.SecondaryButton,
+					// Disabled allows bot to disable some buttons for users.
+					Disabled: false,
+					// CustomID is a thing telling Discord which data to send when this button will be pressed.
+					CustomID: "imagine_upscale_2",
+					Emoji: discordgo.ComponentEmoji{
+						Name: "⬆️",
+					},
+				},
+				discordgo.Button{
+					// Label is what the user will see on the button.
+					Label: "U3",
+					// Style provides coloring of the button. There are not so many styles tho.
+					Style: discordgo.SecondaryButton,
+					// Disabled allows bot to disable some buttons for users.
+					Disabled: false,
+					// CustomID is a thing telling Discord which data to send when this button will be pressed.
+					CustomID: "imagine_upscale_3",
+					Emoji: discordgo.ComponentEmoji{
+						Name: "⬆️",
+					},
+				},
+				discordgo.Button{
+					// Label is what the user will see on the button.
+					Label: "U4",
+					// Style provides coloring of the button. There are not so many styles tho.
+					Style: discordgo.SecondaryButton,
+					// Disabled allows bot to disable some buttons for users.
+					Disabled: false,
+					// CustomID is a thing telling Discord which data to send when this button will be pressed.
+					CustomID: "imagine_upscale_4",
+					Emoji: discordgo.ComponentEmoji{
+						Name: "⬆️",
+					},
+				},
+				discordgo.Button{
+					// Label is what the user will see on the button.
+					Label: "Remix",
+					// Style provides coloring of the button. There are not so many styles tho.
+					Style: discordgo.PrimaryButton,
+					// Disabled allows bot to disable some buttons for users.
+					Disabled: false,
+					// CustomID is a thing telling Discord which data to send when this button will be pressed.
+					CustomID: "imagine_remix",
+					Emoji: discordgo.ComponentEmoji{
+						Name: "🎨",
+					},
+				},
+			},
+		},
+		variationStrengthMenu(),
+	}
+}
+
+func variationStrengthMenu() discordgo.ActionsRow {
+	minValues := 1
+
+	options := make([]discordgo.SelectMenuOption, 0, 4*len(imagine_queue.VariationStrengths))
+
+	for idx := 1; idx <= 4; idx++ {
+		for _, variation := range imagine_queue.VariationStrengths {
+			options = append(options, discordgo.SelectMenuOption{
+				Label: fmt.Sprintf("Vary V%d (%s)", idx, variation.Label()),
+				Value: fmt.Sprintf("%d_%s", idx, variation),
+			})
+		}
+	}
+
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.SelectMenu{
+				CustomID:    "imagine_variation_strength_menu",
+				Placeholder: "Vary (Subtle) or Vary (Strong)...",
+				MinValues:   &minValues,
+				MaxValues:   1,
+				Options:     options,
+			},
+		},
+	}
+}
+
+func plotMessageContent(generation *entities.ImageGeneration, userID string, cellsDone, cellsTotal int) string {
+	if cellsDone < cellsTotal {
+		return fmt.Sprintf("<@%s> asked me to plot \"%s\". Currently dreaming it up for them. Cell %d of %d",
+			userID, generation.Prompt, cellsDone+1, cellsTotal)
+	}
+
+	return fmt.Sprintf("<@%s> asked me to plot \"%s\", here is what I imagined for them.",
+		userID, generation.Prompt)
+}
+
+// plotMessageComponents is the menu to upscale a cell of a finished plot.
+func plotMessageComponents(cells []imagine_queue.PlotCell) []discordgo.MessageComponent {
+	minValues := 1
+
+	cellOptions := make([]discordgo.SelectMenuOption, len(cells))
+
+	for idx, cell := range cells {
+		// Discord limits select option labels to 100 characters
+		cellLabel := truncate(fmt.Sprintf("Upscale #%d: %s", cell.SortOrder, cell.Label), 100)
+
+		cellOptions[idx] = discordgo.SelectMenuOption{
+			Label: cellLabel,
+			Value: strconv.Itoa(cell.SortOrder),
+		}
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:    "imagine_plot_upscale",
+					Placeholder: "Upscale a cell",
+					MinValues:   &minValues,
+					MaxValues:   1,
+					Options:     cellOptions,
+				},
+			},
+		},
+	}
+}
+
+func upscaleMessageContent(userID string, fetchProgress, upscaleProgress float64) string {
+	if fetchProgress >= 0 && fetchProgress <= 1 && upscaleProgress < 1 {
+		if upscaleProgress == 0 {
+			return fmt.Sprintf("Currently upscaling the image for you... Fetch progress: %.0f%%", fetchProgress*100)
+		} else {
+			return fmt.Sprintf("Currently upscaling the image for you... Fetch progress: %.0f%% Upscale progress: %.0f%%",
+				fetchProgress*100, upscaleProgress*100)
+		}
+	} else {
+		return fmt.Sprintf("<@%s> asked me to upscale their image. Here's the result:",
+			userID)
+	}
+}
+
+// outpaintDescription tells what was asked for, e.g. "zoom out their image by 2x".
+func outpaintDescription(o *imagine_queue.OutpaintOptions) string {
+	if o.Direction == imagine_queue.OutpaintZoomOut {
+		return fmt.Sprintf("zoom out their image by %gx", o.ZoomFactor)
+	}
+
+	return fmt.Sprintf("pan their image %s", o.Direction)
+}
+
+func outpaintMessageContent(options *imagine_queue.OutpaintOptions, userID string, progress float64) string {
+	if progress >= 0 && progress < 1 {
+		return fmt.Sprintf("Currently outpainting the image for you... Progress: %.0f%%", progress*100)
+	}
+
+	return fmt.Sprintf("<@%s> asked me to %s. Here's the result:", userID, outpaintDescription(options))
+}
+
+func reimagineMessageContent(userID string, progress float64) string {
+	if progress >= 0 && progress < 1 {
+		return fmt.Sprintf("Currently reimagining the image for you... Progress: %.0f%%", progress*100)
+	}
+
+	return fmt.Sprintf("<@%s> asked me to reimagine an image. Here's the result:", userID)
+}
+
+func outpaintMessageComponents() discordgo.ActionsRow {
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.Button{
+				Label:    "Zoom Out 1.5x",
+				Style:    discordgo.SecondaryButton,
+				CustomID: "imagine_outpaint_zoom_1.5",
+				Emoji: discordgo.ComponentEmoji{
+					Name: "🔍",
+				},
+			},
+			discordgo.Button{
+				Label:    "Zoom Out 2x",
+				Style:    discordgo.SecondaryButton,
+				CustomID: "imagine_outpaint_zoom_2",
+				Emoji: discordgo.ComponentEmoji{
+					Name: "🔍",
+				},
+			},
+			discordgo.Button{
+				Style:    discordgo.SecondaryButton,
+				CustomID: "imagine_outpaint_pan_left",
+				Emoji: discordgo.ComponentEmoji{
+					Name: "⬅️",
+				},
+			},
+			discordgo.Button{
+				Style:    discordgo.SecondaryButton,
+				CustomID: "imagine_outpaint_pan_right",
+				Emoji: discordgo.ComponentEmoji{
+					Name: "➡️",
+				},
+			},
+			discordgo.Button{
+				Style:    discordgo.SecondaryButton,
+				CustomID: "imagine_outpaint_pan_up",
+				Emoji: discordgo.ComponentEmoji{
+					Name: "⬆️",
+				},
+			},
+		},
+	}
+}
+
+// outpaintPanDownComponents holds the last direction, Discord allows at most 5 buttons in a row.
+func outpaintPanDownComponents() discordgo.ActionsRow {
+	return discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.Button{
+				Style:    discordgo.SecondaryButton,
+				CustomID: "imagine_outpaint_pan_down",
+				Emoji: discordgo.ComponentEmoji{
+					Name: "⬇️",
+				},
+			},
+		},
+	}
+}
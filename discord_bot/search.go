@@ -0,0 +1,161 @@
+package discord_bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"stable_diffusion_bot/repositories/image_generations"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	searchOptionQuery  = `query`
+	searchOptionMember = `member`
+	searchOptionModel  = `model`
+	searchOptionFrom   = `from`
+	searchOptionTo     = `to`
+
+	// searchResultLimit is as many results as a message can have embeds
+	searchResultLimit = maxEmbeds
+)
+
+func (b *botImpl) searchCommandString() string {
+	if b.developmentMode {
+		return "dev_" + b.imagineCommand + "_search"
+	}
+
+	return b.imagineCommand + "_search"
+}
+
+func (b *botImpl) addSearchCommand() error {
+	log.Printf("Adding command '%s'...", b.searchCommandString())
+
+	cmd, err := b.botSession.ApplicationCommandCreate(b.botSession.State.User.ID, b.guildID, &discordgo.ApplicationCommand{
+		Name:        b.searchCommandString(),
+		Description: "Find images imagined in this server by the words in their prompts",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        searchOptionQuery,
+				Description: "Words the prompt contains, like \"castle sunset\"",
+				Required:    true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        searchOptionMember,
+				Description: "Only images of this member",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        searchOptionModel,
+				Description: "Only images of a model whose name contains this",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        searchOptionFrom,
+				Description: "Only images from this day on, like 2023-06-30 (UTC)",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        searchOptionTo,
+				Description: "Only images up to this day, like 2023-07-31 (UTC)",
+				Required:    false,
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating '%s' command: %v", b.searchCommandString(), err)
+		return err
+	}
+
+	b.registeredCommands = append(b.registeredCommands, cmd)
+
+	return nil
+}
+
+func (b *botImpl) processSearchCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var query, from, to string
+
+	filters := image_generations.SearchFilters{
+		GuildID: i.GuildID,
+		Limit:   searchResultLimit,
+	}
+
+	// outside of a server there are only your own images
+	if i.GuildID == "" {
+		filters.MemberID = interactionMemberID(i.Interaction)
+	}
+
+	for _, option := range i.ApplicationCommandData().Options {
+		switch option.Name {
+		case searchOptionQuery:
+			query = option.StringValue()
+		case searchOptionMember:
+			if i.GuildID != "" {
+				filters.MemberID = option.UserValue(nil).ID
+			}
+		case searchOptionModel:
+			filters.Model = strings.TrimSpace(option.StringValue())
+		case searchOptionFrom:
+			from = strings.TrimSpace(option.StringValue())
+		case searchOptionTo:
+			to = strings.TrimSpace(option.StringValue())
+		}
+	}
+
+	var err error
+
+	filters.Since, filters.Until, err = parseDateRange(from, to)
+	if err != nil {
+		respondEphemeral(s, i, fmt.Sprintf("I couldn't filter the images, %v.", err))
+
+		return
+	}
+
+	generations, err := b.imagineQueue.SearchGenerations(query, filters)
+	if err != nil {
+		log.Printf("Error searching generations: %v", err)
+
+		respondEphemeral(s, i, "Error searching the images...")
+
+		return
+	}
+
+	if len(generations) == 0 {
+		respondEphemeral(s, i, "I couldn't find any image like that.")
+
+		return
+	}
+
+	embeds := make([]*discordgo.MessageEmbed, len(generations))
+
+	for idx, generation := range generations {
+		model := generation.Checkpoint
+		if model == "" {
+			model = "default"
+		}
+
+		embeds[idx] = historyEmbed(generation)
+		embeds[idx].Fields = append(embeds[idx].Fields,
+			&discordgo.MessageEmbedField{Name: "Member", Value: fmt.Sprintf("<@%s>", generation.MemberID), Inline: true},
+			&discordgo.MessageEmbedField{Name: "Model", Value: model, Inline: true},
+		)
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: fmt.Sprintf("The best matches for \"%s\":", truncate(query, maxMessageLength/2)),
+			Embeds:  embeds,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
@@ -0,0 +1,626 @@
+package discord_bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/imagine_queue"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// settingsPage is one page of the settings message, Discord allows only 5 rows of components per message.
+type settingsPage string
+
+const (
+	settingsPageImage   settingsPage = "image"
+	settingsPageUpscale settingsPage = "upscale"
+)
+
+// variationStrengthPresets are the subtle and strong subseed strengths to choose from in the settings
+var variationStrengthPresets = [][2]float64{
+	{0.05, 0.25},
+	{0.1, 0.3},
+	{0.15, 0.4},
+	{0.2, 0.5},
+	{0.25, 0.6},
+}
+
+// settingsScope reads the scope from a settings component ID, members edit their own settings by default.
+func settingsScope(scope string) imagine_queue.SettingsScope {
+	switch imagine_queue.SettingsScope(scope) {
+	case imagine_queue.SettingsScopeChannel:
+		return imagine_queue.SettingsScopeChannel
+	case imagine_queue.SettingsScopeGuild:
+		return imagine_queue.SettingsScopeGuild
+	default:
+		return imagine_queue.SettingsScopeMember
+	}
+}
+
+// settingsComponentID adds the scope to the ID of a settings component, so the next interaction edits the same scope.
+func settingsComponentID(customID string, scope imagine_queue.SettingsScope) string {
+	if scope == imagine_queue.SettingsScopeMember {
+		return customID
+	}
+
+	return customID + ":" + string(scope)
+}
+
+// canEditSettings checks that the member may change the defaults of the whole channel or server.
+func (b *botImpl) canEditSettings(i *discordgo.InteractionCreate, key imagine_queue.SettingsKey) bool {
+	if key.Scope == imagine_queue.SettingsScopeMember {
+		return true
+	}
+
+	if i.Member == nil || key.GuildID == "" {
+		return false
+	}
+
+	return b.canEditServerDefaults(i)
+}
+
+func settingsMessageContent(scope imagine_queue.SettingsScope) string {
+	switch scope {
+	case imagine_queue.SettingsScopeChannel:
+		return "Choose the default settings of this channel for the imagine command:"
+	case imagine_queue.SettingsScopeGuild:
+		return "Choose the default settings of this server for the imagine command:"
+	default:
+		return "Choose your default settings for the imagine command:"
+	}
+}
+
+func (b *botImpl) settingsMessageComponents(settings *entities.DefaultSettings, scope imagine_queue.SettingsScope, page settingsPage) []discordgo.MessageComponent {
+	var components []discordgo.MessageComponent
+
+	if page == settingsPageUpscale {
+		components = b.upscaleSettingsComponents(settings, scope)
+	} else {
+		components = imageSettingsComponents(settings, scope)
+	}
+
+	otherPage := discordgo.Button{
+		Label:    "Upscaling & variations",
+		Style:    discordgo.SecondaryButton,
+		CustomID: settingsComponentID("imagine_settings_page_"+string(settingsPageUpscale), scope),
+	}
+
+	if page == settingsPageUpscale {
+		otherPage.Label = "Image settings"
+		otherPage.CustomID = settingsComponentID("imagine_settings_page_"+string(settingsPageImage), scope)
+	}
+
+	resetLabel := "Reset to server defaults"
+	if scope == imagine_queue.SettingsScopeGuild {
+		resetLabel = "Reset to bot defaults"
+	}
+
+	return append(components, discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			otherPage,
+			discordgo.Button{
+				Label:    "Edit parameters...",
+				Style:    discordgo.SecondaryButton,
+				CustomID: settingsComponentID("imagine_settings_parameters", scope),
+			},
+			discordgo.Button{
+				Label:    resetLabel,
+				Style:    discordgo.DangerButton,
+				CustomID: settingsComponentID("imagine_settings_reset", scope),
+			},
+		},
+	})
+}
+
+// onOffOptions are the choices of a setting that can be switched on or off, with the current value selected.
+func onOffOptions(name string, value *bool) []discordgo.SelectMenuOption {
+	return []discordgo.SelectMenuOption{
+		{
+			Label:   name + ": on",
+			Value:   "true",
+			Default: value != nil && *value,
+		},
+		{
+			Label:   name + ": off",
+			Value:   "false",
+			Default: value != nil && !*value,
+		},
+	}
+}
+
+func imageSettingsComponents(settings *entities.DefaultSettings, scope imagine_queue.SettingsScope) []discordgo.MessageComponent {
+	minValues := 1
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:  settingsComponentID("imagine_dimension_setting_menu", scope),
+					MinValues: &minValues,
+					MaxValues: 1,
+					Options: []discordgo.SelectMenuOption{
+						{
+							Label:   "Size: 512x512",
+							Value:   "512_512",
+							Default: settings.Width == 512 && settings.Height == 512,
+						},
+						{
+							Label:   "Size: 768x768",
+							Value:   "768_768",
+							Default: settings.Width == 768 && settings.Height == 768,
+						},
+						{
+							Label:   "Size: 1024x1024",
+							Value:   "1024_1024",
+							Default: settings.Width == 1024 && settings.Height == 1024,
+						},
+					},
+				},
+			},
+		},
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:  settingsComponentID("imagine_batch_count_setting_menu", scope),
+					MinValues: &minValues,
+					MaxValues: 1,
+					Options: []discordgo.SelectMenuOption{
+						{
+							Label:   "Batch count: 1, batch size: 4",
+							Value:   "1",
+							Default: settings.BatchCount == 1,
+						},
+						{
+							Label:   "Batch count: 2, batch size: 2",
+							Value:   "2",
+							Default: settings.BatchCount == 2,
+						},
+						{
+							Label:   "Batch count: 4, batch size: 1",
+							Value:   "4",
+							Default: settings.BatchCount == 4,
+						},
+					},
+				},
+			},
+		},
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:  settingsComponentID("imagine_hires_setting_menu", scope),
+					MinValues: &minValues,
+					MaxValues: 1,
+					Options:   onOffOptions("Hires fix", settings.EnableHR),
+				},
+			},
+		},
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:  settingsComponentID("imagine_restore_faces_setting_menu", scope),
+					MinValues: &minValues,
+					MaxValues: 1,
+					Options:   onOffOptions("Restore faces", settings.RestoreFaces),
+				},
+			},
+		},
+	}
+}
+
+func (b *botImpl) upscaleSettingsComponents(settings *entities.DefaultSettings, scope imagine_queue.SettingsScope) []discordgo.MessageComponent {
+	minValues := 1
+
+	upscaleOptions := make([]discordgo.SelectMenuOption, 0, len(imagine_queue.UpscaleMethods)*len(imagine_queue.UpscaleFactors))
+
+	for _, method := range imagine_queue.UpscaleMethods {
+		for _, factor := range imagine_queue.UpscaleFactors {
+			upscaleOptions = append(upscaleOptions, discordgo.SelectMenuOption{
+				Label:   fmt.Sprintf("Upscale: %s, %gx", method.Label(), factor),
+				Value:   fmt.Sprintf("%s|%g", method, factor),
+				Default: settings.UpscaleMethod == string(method) && settings.UpscaleFactor == factor,
+			})
+		}
+	}
+
+	variationOptions := make([]discordgo.SelectMenuOption, 0, len(variationStrengthPresets))
+
+	for _, preset := range variationStrengthPresets {
+		variationOptions = append(variationOptions, discordgo.SelectMenuOption{
+			Label:   fmt.Sprintf("Variations: subtle %g, strong %g", preset[0], preset[1]),
+			Value:   fmt.Sprintf("%g|%g", preset[0], preset[1]),
+			Default: settings.SubtleVariationStrength == preset[0] && settings.StrongVariationStrength == preset[1],
+		})
+	}
+
+	upscalers, err := b.stableDiffusionAPI.GetUpscalers()
+	if err != nil {
+		log.Printf("Error fetching upscalers: %v", err)
+	}
+
+	upscalerOptions := []discordgo.SelectMenuOption{
+		{
+			Label:   "Upscaler: " + settings.Upscaler,
+			Value:   settings.Upscaler,
+			Default: true,
+		},
+	}
+
+	for _, upscaler := range upscalers {
+		// Discord limits the number of choices to 25
+		if len(upscalerOptions) == 25 {
+			break
+		}
+
+		if upscaler == settings.Upscaler || upscaler == "None" {
+			continue
+		}
+
+		upscalerOptions = append(upscalerOptions, discordgo.SelectMenuOption{
+			Label: "Upscaler: " + upscaler,
+			Value: upscaler,
+		})
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:  settingsComponentID("imagine_variation_setting_menu", scope),
+					MinValues: &minValues,
+					MaxValues: 1,
+					Options:   variationOptions,
+				},
+			},
+		},
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:  settingsComponentID("imagine_upscale_setting_menu", scope),
+					MinValues: &minValues,
+					MaxValues: 1,
+					Options:   upscaleOptions,
+				},
+			},
+		},
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:  settingsComponentID("imagine_upscaler_setting_menu", scope),
+					MinValues: &minValues,
+					MaxValues: 1,
+					Options:   upscalerOptions,
+				},
+			},
+		},
+	}
+}
+
+func (b *botImpl) processImagineSettingsCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	scope := imagine_queue.SettingsScopeMember
+
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == "scope" {
+			scope = settingsScope(option.StringValue())
+		}
+	}
+
+	key := settingsKeyForInteraction(i.Interaction, scope)
+
+	if !b.canEditSettings(i, key) {
+		respondSettingsRefusal(s, i, discordgo.InteractionResponseChannelMessageWithSource)
+
+		return
+	}
+
+	settings, err := b.imagineQueue.GetDefaultSettings(key)
+	if err != nil {
+		log.Printf("error getting default settings for settings command: %v", err)
+
+		return
+	}
+
+	messageComponents := b.settingsMessageComponents(settings, scope, settingsPageImage)
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Title:      "Settings",
+			Content:    settingsMessageContent(scope),
+			Components: messageComponents,
+			Flags:      discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+func respondSettingsRefusal(s *discordgo.Session, i *discordgo.InteractionCreate, responseType discordgo.InteractionResponseType) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: responseType,
+		Data: &discordgo.InteractionResponseData{
+			Content: "Only members who may edit the server defaults can change the defaults of a channel or the server.",
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+// respondSettingsUpdate shows the settings page again after a change, or the error when the change failed.
+func (b *botImpl) respondSettingsUpdate(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey,
+	page settingsPage, settings *entities.DefaultSettings, updateErr error, errorContent string,
+) {
+	if updateErr != nil {
+		log.Printf("error updating settings: %v", updateErr)
+
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Content: errorContent,
+			},
+		})
+		if err != nil {
+			log.Printf("Error responding to interaction: %v", err)
+		}
+
+		return
+	}
+
+	messageComponents := b.settingsMessageComponents(settings, key.Scope, page)
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    settingsMessageContent(key.Scope),
+			Components: messageComponents,
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+func (b *botImpl) processImagineDimensionSetting(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey, height, width int) {
+	if !b.canEditSettings(i, key) {
+		respondSettingsRefusal(s, i, discordgo.InteractionResponseChannelMessageWithSource)
+
+		return
+	}
+
+	settings, err := b.imagineQueue.UpdateDefaultDimensions(key, width, height)
+
+	b.respondSettingsUpdate(s, i, key, settingsPageImage, settings, err, "Error updating default dimensions...")
+}
+
+func (b *botImpl) processImagineBatchSetting(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey, batchCount, batchSize int) {
+	if !b.canEditSettings(i, key) {
+		respondSettingsRefusal(s, i, discordgo.InteractionResponseChannelMessageWithSource)
+
+		return
+	}
+
+	settings, err := b.imagineQueue.UpdateDefaultBatch(key, batchCount, batchSize)
+
+	b.respondSettingsUpdate(s, i, key, settingsPageImage, settings, err, "Error updating batch settings...")
+}
+
+func (b *botImpl) processImagineHiresSetting(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey, enableHR bool) {
+	if !b.canEditSettings(i, key) {
+		respondSettingsRefusal(s, i, discordgo.InteractionResponseChannelMessageWithSource)
+
+		return
+	}
+
+	settings, err := b.imagineQueue.UpdateDefaultHiresFix(key, enableHR)
+
+	b.respondSettingsUpdate(s, i, key, settingsPageImage, settings, err, "Error updating hires fix setting...")
+}
+
+func (b *botImpl) processImagineRestoreFacesSetting(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey, restoreFaces bool) {
+	if !b.canEditSettings(i, key) {
+		respondSettingsRefusal(s, i, discordgo.InteractionResponseChannelMessageWithSource)
+
+		return
+	}
+
+	settings, err := b.imagineQueue.UpdateDefaultRestoreFaces(key, restoreFaces)
+
+	b.respondSettingsUpdate(s, i, key, settingsPageImage, settings, err, "Error updating restore faces setting...")
+}
+
+// processImagineSettingsParameters opens a modal to edit the parameters that don't fit in a select menu.
+func (b *botImpl) processImagineSettingsParameters(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey) {
+	if !b.canEditSettings(i, key) {
+		respondSettingsRefusal(s, i, discordgo.InteractionResponseChannelMessageWithSource)
+
+		return
+	}
+
+	settings, err := b.imagineQueue.GetDefaultSettings(key)
+	if err != nil {
+		log.Printf("error getting default settings for parameters modal: %v", err)
+
+		return
+	}
+
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: settingsComponentID("imagine_settings_modal", key.Scope),
+			Title:    "Default parameters",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:  "negative_prompt",
+							Label:     "Negative prompt",
+							Style:     discordgo.TextInputParagraph,
+							Value:     settings.NegativePrompt,
+							Required:  false,
+							MaxLength: 4000,
+						},
+					},
+				},
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID: "sampler_name",
+							Label:    "Sampler",
+							Style:    discordgo.TextInputShort,
+							Value:    settings.SamplerName,
+							Required: false,
+						},
+					},
+				},
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "steps",
+							Label:       "Sampling steps",
+							Style:       discordgo.TextInputShort,
+							Value:       strconv.Itoa(settings.Steps),
+							Placeholder: "20",
+							Required:    false,
+						},
+					},
+				},
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "cfg_scale",
+							Label:       "CFG scale",
+							Style:       discordgo.TextInputShort,
+							Value:       strconv.FormatFloat(settings.CfgScale, 'f', -1, 64),
+							Placeholder: "7",
+							Required:    false,
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error responding to interaction: %v", err)
+	}
+}
+
+// parseGenerationDefaults reads the parameters modal, empty fields inherit the value of the next scope.
+func parseGenerationDefaults(values map[string]string) (imagine_queue.GenerationDefaults, error) {
+	defaults := imagine_queue.GenerationDefaults{
+		NegativePrompt: strings.TrimSpace(values["negative_prompt"]),
+		SamplerName:    strings.TrimSpace(values["sampler_name"]),
+	}
+
+	if steps := strings.TrimSpace(values["steps"]); steps != "" {
+		stepsInt, err := strconv.Atoi(steps)
+		if err != nil {
+			return defaults, &imagine_queue.InvalidSettingError{Setting: "steps", Reason: "must be a whole number"}
+		}
+
+		defaults.Steps = stepsInt
+	}
+
+	if cfgScale := strings.TrimSpace(values["cfg_scale"]); cfgScale != "" {
+		cfgScaleFloat, err := strconv.ParseFloat(cfgScale, 64)
+		if err != nil {
+			return defaults, &imagine_queue.InvalidSettingError{Setting: "CFG scale", Reason: "must be a number"}
+		}
+
+		defaults.CfgScale = cfgScaleFloat
+	}
+
+	return defaults, nil
+}
+
+func (b *botImpl) processImagineSettingsModal(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey) {
+	if !b.canEditSettings(i, key) {
+		respondSettingsRefusal(s, i, discordgo.InteractionResponseChannelMessageWithSource)
+
+		return
+	}
+
+	defaults, err := parseGenerationDefaults(modalTextValues(i.ModalSubmitData()))
+
+	var settings *entities.DefaultSettings
+	if err == nil {
+		settings, err = b.imagineQueue.UpdateDefaultGenerationParameters(key, defaults)
+	}
+
+	if errors.Is(err, &imagine_queue.InvalidSettingError{}) {
+		respondErr := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: fmt.Sprintf("Could not save the parameters, %v.", err),
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		if respondErr != nil {
+			log.Printf("Error responding to interaction: %v", respondErr)
+		}
+
+		return
+	}
+
+	b.respondSettingsUpdate(s, i, key, settingsPageImage, settings, err, "Error updating default parameters...")
+}
+
+func (b *botImpl) processImagineUpscaleSetting(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey, method imagine_queue.UpscaleMethod, factor float64) {
+	if !b.canEditSettings(i, key) {
+		respondSettingsRefusal(s, i, discordgo.InteractionResponseChannelMessageWithSource)
+
+		return
+	}
+
+	settings, err := b.imagineQueue.UpdateDefaultUpscale(key, method, factor)
+
+	b.respondSettingsUpdate(s, i, key, settingsPageUpscale, settings, err, "Error updating upscale settings...")
+}
+
+func (b *botImpl) processImagineUpscalerSetting(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey, upscaler string) {
+	if !b.canEditSettings(i, key) {
+		respondSettingsRefusal(s, i, discordgo.InteractionResponseChannelMessageWithSource)
+
+		return
+	}
+
+	settings, err := b.imagineQueue.UpdateDefaultUpscaler(key, upscaler)
+
+	b.respondSettingsUpdate(s, i, key, settingsPageUpscale, settings, err, "Error updating upscaler setting...")
+}
+
+func (b *botImpl) processImagineVariationSetting(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey, subtle, strong float64) {
+	if !b.canEditSettings(i, key) {
+		respondSettingsRefusal(s, i, discordgo.InteractionResponseChannelMessageWithSource)
+
+		return
+	}
+
+	settings, err := b.imagineQueue.UpdateDefaultVariationStrengths(key, subtle, strong)
+
+	b.respondSettingsUpdate(s, i, key, settingsPageUpscale, settings, err, "Error updating variation settings...")
+}
+
+func (b *botImpl) processImagineSettingsReset(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey) {
+	if !b.canEditSettings(i, key) {
+		respondSettingsRefusal(s, i, discordgo.InteractionResponseChannelMessageWithSource)
+
+		return
+	}
+
+	settings, err := b.imagineQueue.ResetDefaultSettings(key)
+
+	b.respondSettingsUpdate(s, i, key, settingsPageImage, settings, err, "Error resetting settings...")
+}
+
+func (b *botImpl) processImagineSettingsPage(s *discordgo.Session, i *discordgo.InteractionCreate, key imagine_queue.SettingsKey, page settingsPage) {
+	settings, err := b.imagineQueue.GetDefaultSettings(key)
+
+	b.respondSettingsUpdate(s, i, key, page, settings, err, "Error getting settings...")
+}
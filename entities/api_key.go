@@ -0,0 +1,11 @@
+package entities
+
+import "time"
+
+// APIKey lets a client use the HTTP API. Only the SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	KeyHash   string    `json:"key_hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
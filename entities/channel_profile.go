@@ -0,0 +1,18 @@
+package entities
+
+// ChannelProfile holds the generation limits of a channel, applied to every image generated in it.
+type ChannelProfile struct {
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id"`
+	// Model is the checkpoint every generation in the channel uses, empty to allow any
+	Model string `json:"model"`
+	// NegativePrompt is added to the negative prompt of every generation in the channel
+	NegativePrompt string `json:"negative_prompt"`
+	// AllowedSamplers is empty to allow any sampler, otherwise the first one replaces samplers that aren't allowed
+	AllowedSamplers []string `json:"allowed_samplers"`
+	// MaxWidth and MaxHeight limit the size of the final image, 0 for no limit
+	MaxWidth  int `json:"max_width"`
+	MaxHeight int `json:"max_height"`
+	// MaxBatch limits the number of images per generation, 0 for no limit
+	MaxBatch int `json:"max_batch"`
+}
@@ -1,10 +1,23 @@
 package entities
 
 type DefaultSettings struct {
-	MemberID       string `json:"member_id"`
-	Width          int    `json:"width"`
-	Height         int    `json:"height"`
-	BatchCount     int    `json:"batch_count"`
-	BatchSize      int    `json:"batch_size"`
-	NegativePrompt string `json:"negative_prompt"`
+	MemberID       string  `json:"member_id"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	BatchCount     int     `json:"batch_count"`
+	BatchSize      int     `json:"batch_size"`
+	NegativePrompt string  `json:"negative_prompt"`
+	SamplerName    string  `json:"sampler_name"`
+	Steps          int     `json:"steps"`
+	CfgScale       float64 `json:"cfg_scale"`
+	// EnableHR and RestoreFaces are nil when not set, so they come from the channel, server or bot
+	EnableHR     *bool `json:"enable_hr"`
+	RestoreFaces *bool `json:"restore_faces"`
+	// UpscaleMethod is how the U buttons upscale an image, see imagine_queue.UpscaleMethod
+	UpscaleMethod string  `json:"upscale_method"`
+	Upscaler      string  `json:"upscaler"`
+	UpscaleFactor float64 `json:"upscale_factor"`
+	// subseed strengths of the subtle and strong variations
+	SubtleVariationStrength float64 `json:"subtle_variation_strength"`
+	StrongVariationStrength float64 `json:"strong_variation_strength"`
 }
@@ -0,0 +1,22 @@
+package entities
+
+import "time"
+
+// Favorite is a result message a member starred, with the ⭐ reaction or the Favorite button.
+type Favorite struct {
+	ID        int64     `json:"id"`
+	MessageID string    `json:"message_id"`
+	GuildID   string    `json:"guild_id"`
+	ChannelID string    `json:"channel_id"`
+	MemberID  string    `json:"member_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GallerySettings is where a server reposts the results its members favorite the most.
+type GallerySettings struct {
+	GuildID string `json:"guild_id"`
+	// ChannelID is the gallery channel, empty when the server has no gallery
+	ChannelID string `json:"channel_id"`
+	// Threshold is how many favorites a result needs to be reposted
+	Threshold int `json:"threshold"`
+}
@@ -3,28 +3,49 @@ package entities
 import "time"
 
 type ImageGeneration struct {
-	ID                int64     `json:"id"`
-	InteractionID     string    `json:"interaction_id"`
-	MessageID         string    `json:"message_id"`
-	MemberID          string    `json:"member_id"`
-	SortOrder         int       `json:"sort_order"`
-	Prompt            string    `json:"prompt"`
-	NegativePrompt    string    `json:"negative_prompt"`
-	Width             int       `json:"width"`
-	Height            int       `json:"height"`
-	RestoreFaces      bool      `json:"restore_faces"`
-	EnableHR          bool      `json:"enable_hr"`
-	HiresWidth        int       `json:"hires_width"`
-	HiresHeight       int       `json:"hires_height"`
-	DenoisingStrength float64   `json:"denoising_strength"`
-	BatchCount        int       `json:"batch_count"`
-	BatchSize         int       `json:"batch_size"`
-	Seed              int       `json:"seed"`
-	Subseed           int       `json:"subseed"`
-	SubseedStrength   float64   `json:"subseed_strength"`
-	SamplerName       string    `json:"sampler_name"`
-	CfgScale          float64   `json:"cfg_scale"`
-	Steps             int       `json:"steps"`
-	Processed         bool      `json:"processed"`
-	CreatedAt         time.Time `json:"created_at"`
+	ID            int64  `json:"id"`
+	ParentID      int64  `json:"parent_id"`
+	InteractionID string `json:"interaction_id"`
+	MessageID     string `json:"message_id"`
+	MemberID      string `json:"member_id"`
+	// GuildID and ChannelID are where the generation was asked for, empty for direct messages and the HTTP API
+	GuildID           string  `json:"guild_id"`
+	ChannelID         string  `json:"channel_id"`
+	SortOrder         int     `json:"sort_order"`
+	Prompt            string  `json:"prompt"`
+	PromptTemplate    string  `json:"prompt_template"`
+	NegativePrompt    string  `json:"negative_prompt"`
+	Width             int     `json:"width"`
+	Height            int     `json:"height"`
+	RestoreFaces      bool    `json:"restore_faces"`
+	EnableHR          bool    `json:"enable_hr"`
+	HiresWidth        int     `json:"hires_width"`
+	HiresHeight       int     `json:"hires_height"`
+	DenoisingStrength float64 `json:"denoising_strength"`
+	BatchCount        int     `json:"batch_count"`
+	BatchSize         int     `json:"batch_size"`
+	Seed              int     `json:"seed"`
+	Subseed           int     `json:"subseed"`
+	SubseedStrength   float64 `json:"subseed_strength"`
+	// VariationStrength is the strength that was picked for a variation, SubseedStrength may be more when stacked
+	VariationStrength float64 `json:"variation_strength"`
+	SamplerName       string  `json:"sampler_name"`
+	CfgScale          float64 `json:"cfg_scale"`
+	Steps             int     `json:"steps"`
+	Checkpoint        string  `json:"checkpoint"`
+	Style             string  `json:"style"`
+	// ImagePath is where the image is archived in the image store, empty when it isn't
+	ImagePath string `json:"image_path"`
+	// ImageHash is the hex SHA-256 of the archived image
+	ImageHash string `json:"image_hash"`
+	// Routing is how a sensitive result was posted: empty when it was posted as usual, "spoiler" or "redirect"
+	Routing string `json:"routing"`
+	// RoutedChannelID is the channel a redirected result was posted in
+	RoutedChannelID string `json:"routed_channel_id"`
+	// Infotext is the parameters text the web UI wrote for the image, empty for batches and older images
+	Infotext string `json:"infotext"`
+	// Deleted is set once the message of the generation was deleted
+	Deleted   bool      `json:"deleted"`
+	Processed bool      `json:"processed"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,38 @@
+package entities
+
+import "time"
+
+// ModerationRule blocks or allows prompt terms in a server, or in one of its channels.
+type ModerationRule struct {
+	ID      int64  `json:"id"`
+	GuildID string `json:"guild_id"`
+	// ChannelID limits the rule to a channel, empty for the whole server
+	ChannelID string `json:"channel_id"`
+	// List is "block" or "allow", allowed terms are left out before the blocked ones are matched
+	List string `json:"list"`
+	// Match is "word" to match whole words, or "regex"
+	Match   string `json:"match"`
+	Pattern string `json:"pattern"`
+	// Action is what happens to a prompt a block rule matches: "reject", "negative" or "hold"
+	Action    string    `json:"action"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ModerationDecision records what happened to a prompt that matched a rule, and what a moderator decided about it.
+type ModerationDecision struct {
+	ID        int64  `json:"id"`
+	GuildID   string `json:"guild_id"`
+	ChannelID string `json:"channel_id"`
+	MemberID  string `json:"member_id"`
+	Prompt    string `json:"prompt"`
+	RuleID    int64  `json:"rule_id"`
+	Pattern   string `json:"pattern"`
+	// Decision is "rejected", "negative", "held", "approved", "denied" or "expired"
+	Decision string `json:"decision"`
+	// ModeratorID is who approved or denied a held prompt
+	ModeratorID string `json:"moderator_id"`
+	// HeldID links the approval or denial to the decision that held the prompt
+	HeldID    int64     `json:"held_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
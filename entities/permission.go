@@ -0,0 +1,16 @@
+package entities
+
+import "time"
+
+// PermissionGrant gives a role or a member of a server a capability of the bot.
+type PermissionGrant struct {
+	ID      int64  `json:"id"`
+	GuildID string `json:"guild_id"`
+	// Capability is what the grant allows, like "change_model"
+	Capability string `json:"capability"`
+	// SubjectType is "role" or "user", SubjectID is the ID of the role or the user
+	SubjectType string    `json:"subject_type"`
+	SubjectID   string    `json:"subject_id"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -6,6 +6,7 @@ toolchain go1.22.0
 
 require (
 	github.com/bwmarrin/discordgo v0.26.3
+	golang.org/x/image v0.15.0
 	modernc.org/sqlite v1.29.2
 )
 
@@ -0,0 +1,7 @@
+package http_api
+
+type Server interface {
+	// Start serves the API until Close is called.
+	Start() error
+	Close() error
+}
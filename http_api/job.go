@@ -0,0 +1,181 @@
+package http_api
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"stable_diffusion_bot/imagine_queue"
+)
+
+type jobState string
+
+const (
+	jobQueued    jobState = "queued"
+	jobRunning   jobState = "running"
+	jobSucceeded jobState = "succeeded"
+	jobFailed    jobState = "failed"
+)
+
+// finishedJobTTL is how long the images of a finished job can still be downloaded.
+const finishedJobTTL = 1 * time.Hour
+
+// job is a generation queued through the API. It is the result sink of its queue item, so it follows the job while it
+// runs and keeps the images once it is done.
+type job struct {
+	id       string
+	keyName  string
+	queuedAt time.Time
+
+	mu              sync.Mutex
+	state           jobState
+	progress        float64
+	upscaleProgress float64
+	images          []imagine_queue.ResultImage
+	err             error
+	finishedAt      time.Time
+	listeners       map[chan struct{}]struct{}
+}
+
+func newJob(id, keyName string, queuedAt time.Time) *job {
+	return &job{
+		id:        id,
+		keyName:   keyName,
+		queuedAt:  queuedAt,
+		state:     jobQueued,
+		listeners: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Started stores the generations of the job under the job ID, so they can be looked up like a Discord message.
+func (j *job) Started(_ *imagine_queue.QueueItem, status imagine_queue.JobStatus) (string, error) {
+	j.update(func() {
+		j.state = jobRunning
+		j.progress = status.Progress
+	})
+
+	return j.id, nil
+}
+
+func (j *job) Progress(_ *imagine_queue.QueueItem, status imagine_queue.JobStatus) error {
+	j.update(func() {
+		j.progress = status.Progress
+		j.upscaleProgress = status.UpscaleProgress
+	})
+
+	return nil
+}
+
+func (j *job) Finished(_ *imagine_queue.QueueItem, result *imagine_queue.JobResult) error {
+	if result == nil || len(result.Images) == 0 {
+		j.Failed(nil, errors.New("no images returned"))
+
+		return nil
+	}
+
+	j.update(func() {
+		j.state = jobSucceeded
+		j.progress = 1
+		j.images = result.Images
+		j.finishedAt = time.Now()
+	})
+
+	return nil
+}
+
+func (j *job) Failed(_ *imagine_queue.QueueItem, err error) {
+	j.update(func() {
+		j.state = jobFailed
+		j.err = err
+		j.finishedAt = time.Now()
+	})
+}
+
+// update changes the job, and wakes up everyone streaming its events.
+func (j *job) update(change func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	change()
+
+	for listener := range j.listeners {
+		select {
+		case listener <- struct{}{}:
+		default:
+			// the listener hasn't caught up with the previous change yet, it will read the latest state anyway
+		}
+	}
+}
+
+// subscribe returns a channel that receives a value whenever the job changes, and a function to stop listening.
+func (j *job) subscribe() (<-chan struct{}, func()) {
+	listener := make(chan struct{}, 1)
+
+	j.mu.Lock()
+	j.listeners[listener] = struct{}{}
+	j.mu.Unlock()
+
+	return listener, func() {
+		j.mu.Lock()
+		delete(j.listeners, listener)
+		j.mu.Unlock()
+	}
+}
+
+func (j *job) isDone() bool {
+	return j.state == jobSucceeded || j.state == jobFailed
+}
+
+// jobStore keeps the jobs queued through the API in memory. The generations themselves are in the database, but the
+// images only live here until they expire.
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: make(map[string]*job)}
+}
+
+func (s *jobStore) add(newJob *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[newJob.id] = newJob
+}
+
+func (s *jobStore) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, id)
+}
+
+// get returns the job, if it belongs to the API key.
+func (s *jobStore) get(id, keyName string) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.jobs[id]
+	if !ok || found.keyName != keyName {
+		return nil
+	}
+
+	return found
+}
+
+// sweep forgets the jobs that finished longer than finishedJobTTL ago.
+func (s *jobStore) sweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, storedJob := range s.jobs {
+		storedJob.mu.Lock()
+		expired := storedJob.isDone() && now.Sub(storedJob.finishedAt) > finishedJobTTL
+		storedJob.mu.Unlock()
+
+		if expired {
+			delete(s.jobs, id)
+		}
+	}
+}
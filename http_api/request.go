@@ -0,0 +1,95 @@
+package http_api
+
+import (
+	"errors"
+	"strings"
+
+	"stable_diffusion_bot/imagine_queue"
+)
+
+// overrideSettings are the web UI settings a request can change, like in the A1111 API.
+type overrideSettings struct {
+	SDModelCheckpoint string `json:"sd_model_checkpoint"`
+}
+
+// submitRequest has the field names of the A1111 txt2img API, so existing clients can send the same body. Fields
+// that are left out come from the bot's default settings.
+type submitRequest struct {
+	Prompt          string           `json:"prompt"`
+	NegativePrompt  string           `json:"negative_prompt"`
+	Width           int              `json:"width"`
+	Height          int              `json:"height"`
+	Seed            *int             `json:"seed"`
+	SubseedStrength float64          `json:"subseed_strength"`
+	SamplerName     string           `json:"sampler_name"`
+	Steps           int              `json:"steps"`
+	CfgScale        float64          `json:"cfg_scale"`
+	RestoreFaces    *bool            `json:"restore_faces"`
+	EnableHR        *bool            `json:"enable_hr"`
+	NIter           int              `json:"n_iter"`
+	BatchSize       int              `json:"batch_size"`
+	Styles          []string         `json:"styles"`
+	Override        overrideSettings `json:"override_settings"`
+}
+
+// queueItemOptions turns the request into the options of a queue item. The prompt may still contain "--" flags,
+// which the queue parses like for Discord.
+func (r *submitRequest) queueItemOptions() (imagine_queue.QueueItemOptions, error) {
+	options := imagine_queue.NewQueueItemOptions()
+
+	if strings.TrimSpace(r.Prompt) == "" {
+		return options, errors.New("prompt is required")
+	}
+
+	// combinatorial prompts fan out into several jobs, which a single job ID can't follow
+	if strings.Contains(strings.ToLower(r.Prompt), "--combinatorial") {
+		return options, errors.New("--combinatorial is not supported by the API")
+	}
+
+	if len(r.Styles) > 1 {
+		return options, errors.New("at most one style is supported")
+	}
+
+	if (r.Width > 0) != (r.Height > 0) || r.Width < 0 || r.Height < 0 {
+		return options, errors.New("width and height must be given together")
+	}
+
+	if r.Width > r.Height*4 || r.Height > r.Width*4 {
+		return options, errors.New("aspect ratio can be at most 4:1")
+	}
+
+	options.Prompt = r.Prompt
+	options.NegativePrompt = r.NegativePrompt
+	options.SamplerName = r.SamplerName
+	options.Steps = r.Steps
+	options.CfgScale = r.CfgScale
+	options.SubseedStrength = r.SubseedStrength
+	options.RestoreFaces = r.RestoreFaces
+	options.HiresOverride = r.EnableHR
+	options.Model = r.Override.SDModelCheckpoint
+
+	// the size is an aspect ratio of the default size, like "--ar", so the webui isn't asked for more than the bot
+	// allows
+	options.AspectWidth = r.Width
+	options.AspectHeight = r.Height
+
+	if r.Seed != nil {
+		options.Seed = *r.Seed
+	}
+
+	if r.NIter > 0 || r.BatchSize > 0 {
+		options.BatchCount = max(r.NIter, 1)
+		options.BatchSize = max(r.BatchSize, 1)
+	}
+
+	if len(r.Styles) == 1 {
+		options.Style = r.Styles[0]
+	}
+
+	err := options.Validate()
+	if err != nil {
+		return options, err
+	}
+
+	return options, nil
+}
@@ -0,0 +1,467 @@
+package http_api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"stable_diffusion_bot/clock"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/imagine_queue"
+	"stable_diffusion_bot/repositories"
+	"stable_diffusion_bot/repositories/api_keys"
+	"stable_diffusion_bot/repositories/image_generations"
+	"stable_diffusion_bot/stable_diffusion_api"
+)
+
+const (
+	apiKeyPrefix = "sdb_"
+	jobsPath     = "/api/v1/jobs"
+
+	// maxRequestBodySize limits the JSON body of a submitted job
+	maxRequestBodySize = 1 << 20
+)
+
+type serverImpl struct {
+	httpServer          *http.Server
+	imagineQueue        imagine_queue.Queue
+	imageGenerationRepo image_generations.Repository
+	apiKeyRepo          api_keys.Repository
+	jobs                *jobStore
+	clock               clock.Clock
+}
+
+type Config struct {
+	// Listen is the address to listen on, e.g. ":8080"
+	Listen              string
+	ImagineQueue        imagine_queue.Queue
+	ImageGenerationRepo image_generations.Repository
+	APIKeyRepo          api_keys.Repository
+}
+
+func New(cfg Config) (Server, error) {
+	if cfg.Listen == "" {
+		return nil, errors.New("missing listen address")
+	}
+
+	if cfg.ImagineQueue == nil {
+		return nil, errors.New("missing imagine queue")
+	}
+
+	if cfg.ImageGenerationRepo == nil {
+		return nil, errors.New("missing image generation repository")
+	}
+
+	if cfg.APIKeyRepo == nil {
+		return nil, errors.New("missing API key repository")
+	}
+
+	server := &serverImpl{
+		imagineQueue:        cfg.ImagineQueue,
+		imageGenerationRepo: cfg.ImageGenerationRepo,
+		apiKeyRepo:          cfg.APIKeyRepo,
+		jobs:                newJobStore(),
+		clock:               clock.NewClock(),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(jobsPath, server.authenticated(server.handleSubmit))
+	mux.Handle(jobsPath+"/", server.authenticated(server.handleJob))
+
+	server.httpServer = &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return server, nil
+}
+
+func (s *serverImpl) Start() error {
+	log.Printf("HTTP API listening on %s", s.httpServer.Addr)
+
+	err := s.httpServer.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *serverImpl) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return s.httpServer.Shutdown(ctx)
+}
+
+// GenerateAPIKey returns a new random API key, and the hash it is stored under.
+func GenerateAPIKey() (string, string, error) {
+	randomBytes := make([]byte, 24)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := apiKeyPrefix + hex.EncodeToString(randomBytes)
+
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hash an API key is stored under.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+type authenticatedHandler func(w http.ResponseWriter, r *http.Request, key *entities.APIKey)
+
+// authenticated looks up the API key of the request, passed as a bearer token or in the X-API-Key header.
+func (s *serverImpl) authenticated(next authenticatedHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawKey := r.Header.Get("X-API-Key")
+
+		if authorization := r.Header.Get("Authorization"); rawKey == "" && authorization != "" {
+			scheme, token, found := strings.Cut(authorization, " ")
+			if found && strings.EqualFold(scheme, "Bearer") {
+				rawKey = strings.TrimSpace(token)
+			}
+		}
+
+		if rawKey == "" {
+			writeError(w, http.StatusUnauthorized, "missing API key")
+
+			return
+		}
+
+		key, err := s.apiKeyRepo.GetByKeyHash(r.Context(), HashAPIKey(rawKey))
+		if err != nil {
+			if errors.Is(err, &repositories.NotFoundError{}) {
+				writeError(w, http.StatusUnauthorized, "invalid API key")
+
+				return
+			}
+
+			log.Printf("Error looking up API key: %v", err)
+
+			writeError(w, http.StatusInternalServerError, "could not check the API key")
+
+			return
+		}
+
+		next(w, r, key)
+	})
+}
+
+type submitResponse struct {
+	ID        string `json:"id"`
+	Position  int    `json:"position"`
+	StatusURL string `json:"status_url"`
+	EventsURL string `json:"events_url"`
+}
+
+// handleSubmit queues a txt2img job, POST /api/v1/jobs.
+func (s *serverImpl) handleSubmit(w http.ResponseWriter, r *http.Request, key *entities.APIKey) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	var request submitRequest
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+
+		return
+	}
+
+	options, err := request.queueItemOptions()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	jobID, err := newJobID()
+	if err != nil {
+		log.Printf("Error creating job ID: %v", err)
+
+		writeError(w, http.StatusInternalServerError, "could not create the job")
+
+		return
+	}
+
+	s.jobs.sweep(s.clock.Now())
+
+	newJob := newJob(jobID, key.Name, s.clock.Now())
+	s.jobs.add(newJob)
+
+	position, err := s.imagineQueue.AddImagine(&imagine_queue.QueueItem{
+		Prompt:  options.Prompt,
+		Options: options,
+		Type:    imagine_queue.ItemTypeImagine,
+		Source: imagine_queue.JobSource{
+			RequestID: jobID,
+			MemberID:  "api_" + key.Name,
+			Sink:      newJob,
+		},
+	})
+	if err != nil {
+		s.jobs.remove(jobID)
+
+		var unknownFlagErr *imagine_queue.UnknownFlagError
+		var invalidFlagErr *imagine_queue.InvalidFlagValueError
+		var invalidSettingErr *imagine_queue.InvalidSettingError
+
+		if errors.As(err, &unknownFlagErr) || errors.As(err, &invalidFlagErr) || errors.As(err, &invalidSettingErr) {
+			writeError(w, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		log.Printf("Error adding API job to queue: %v", err)
+
+		writeError(w, http.StatusInternalServerError, "could not queue the job")
+
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, submitResponse{
+		ID:        jobID,
+		Position:  position,
+		StatusURL: jobsPath + "/" + jobID,
+		EventsURL: jobsPath + "/" + jobID + "/events",
+	})
+}
+
+// handleJob serves everything under /api/v1/jobs/{id}.
+func (s *serverImpl) handleJob(w http.ResponseWriter, r *http.Request, key *entities.APIKey) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, jobsPath+"/"), "/")
+
+	foundJob := s.jobs.get(parts[0], key.Name)
+	if foundJob == nil {
+		writeError(w, http.StatusNotFound, "job not found")
+
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		s.handleStatus(w, r, foundJob)
+	case len(parts) == 2 && parts[1] == "events":
+		s.handleEvents(w, r, foundJob)
+	case len(parts) == 3 && parts[1] == "images":
+		s.handleImage(w, foundJob, parts[2])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+type imageStatus struct {
+	Index int    `json:"index"`
+	URL   string `json:"url"`
+	// Parameters render the image again with the A1111 txt2img API
+	Parameters *stable_diffusion_api.TextToImageRequest `json:"parameters,omitempty"`
+}
+
+type jobStatus struct {
+	ID              string        `json:"id"`
+	Status          jobState      `json:"status"`
+	Progress        float64       `json:"progress"`
+	UpscaleProgress float64       `json:"upscale_progress,omitempty"`
+	Error           string        `json:"error,omitempty"`
+	QueuedAt        time.Time     `json:"queued_at"`
+	FinishedAt      *time.Time    `json:"finished_at,omitempty"`
+	Images          []imageStatus `json:"images,omitempty"`
+}
+
+// status returns the state of the job, with the parameters of every image once it has succeeded.
+func (s *serverImpl) status(ctx context.Context, statusJob *job) jobStatus {
+	statusJob.mu.Lock()
+
+	status := jobStatus{
+		ID:              statusJob.id,
+		Status:          statusJob.state,
+		Progress:        statusJob.progress,
+		UpscaleProgress: statusJob.upscaleProgress,
+		QueuedAt:        statusJob.queuedAt,
+	}
+
+	if statusJob.err != nil {
+		status.Error = statusJob.err.Error()
+	}
+
+	if statusJob.isDone() {
+		finishedAt := statusJob.finishedAt
+		status.FinishedAt = &finishedAt
+	}
+
+	imageCount := len(statusJob.images)
+
+	statusJob.mu.Unlock()
+
+	for idx := 0; idx < imageCount; idx++ {
+		image := imageStatus{
+			Index: idx,
+			URL:   fmt.Sprintf("%s/%s/images/%d", jobsPath, statusJob.id, idx),
+		}
+
+		// the images of a generation are stored after its base row, which has sort order 0
+		generation, err := s.imageGenerationRepo.GetByMessageAndSort(ctx, statusJob.id, idx+1)
+		if err != nil {
+			log.Printf("Error getting image generation for API job %s: %v", statusJob.id, err)
+		} else {
+			image.Parameters = imagine_queue.GenerationParameters(generation)
+		}
+
+		status.Images = append(status.Images, image)
+	}
+
+	return status
+}
+
+// handleStatus returns the state of the job, GET /api/v1/jobs/{id}.
+func (s *serverImpl) handleStatus(w http.ResponseWriter, r *http.Request, statusJob *job) {
+	writeJSON(w, http.StatusOK, s.status(r.Context(), statusJob))
+}
+
+// handleEvents streams the state of the job as server-sent events until it is done, GET /api/v1/jobs/{id}/events.
+// The event name is the status of the job.
+func (s *serverImpl) handleEvents(w http.ResponseWriter, r *http.Request, eventsJob *job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+
+		return
+	}
+
+	changed, unsubscribe := eventsJob.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		status := s.status(r.Context(), eventsJob)
+
+		data, err := json.Marshal(status)
+		if err != nil {
+			log.Printf("Error encoding job status: %v", err)
+
+			return
+		}
+
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", status.Status, data)
+		if err != nil {
+			return
+		}
+
+		flusher.Flush()
+
+		if status.Status == jobSucceeded || status.Status == jobFailed {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-time.After(15 * time.Second):
+			// a comment keeps proxies from closing the connection while the job waits in the queue
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+
+			flusher.Flush()
+		}
+	}
+}
+
+// handleImage downloads an image of a finished job, GET /api/v1/jobs/{id}/images/{n}.
+func (s *serverImpl) handleImage(w http.ResponseWriter, imageJob *job, rawIndex string) {
+	index, err := strconv.Atoi(rawIndex)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "image not found")
+
+		return
+	}
+
+	imageJob.mu.Lock()
+	images := imageJob.images
+	imageJob.mu.Unlock()
+
+	if index < 0 || index >= len(images) {
+		writeError(w, http.StatusNotFound, "image not found")
+
+		return
+	}
+
+	image := images[index]
+
+	// the webui decides the actual format of the samples, so the stored content type can't be trusted
+	w.Header().Set("Content-Type", http.DetectContentType(image.Data))
+	w.Header().Set("Content-Length", strconv.Itoa(len(image.Data)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", image.Name))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(image.Data)
+	if err != nil {
+		log.Printf("Error writing image: %v", err)
+	}
+}
+
+func newJobID() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(randomBytes), nil
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, errorResponse{Error: message})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
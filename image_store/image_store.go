@@ -0,0 +1,35 @@
+package image_store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// hashImage returns the hex SHA-256 of the image.
+func hashImage(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// imageExtension picks the file extension from the content, the webui decides the format of its samples.
+func imageExtension(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	case "image/gif":
+		return ".gif"
+	default:
+		return ".bin"
+	}
+}
+
+// objectPath spreads the images over directories by the first byte of the hash, e.g. "ab/abcdef....png".
+func objectPath(hash, extension string) string {
+	return hash[:2] + "/" + hash + extension
+}
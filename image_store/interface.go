@@ -0,0 +1,37 @@
+package image_store
+
+import (
+	"context"
+	"time"
+)
+
+// StoredImage is where an image was archived.
+type StoredImage struct {
+	// Path identifies the image in the store, it is what gets recorded on the generation
+	Path string
+	// Hash is the hex SHA-256 of the image
+	Hash string
+	Size int64
+}
+
+// ObjectInfo describes an archived image, for the retention policy.
+type ObjectInfo struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Store archives generated images by the SHA-256 of their content, so storing the same image twice keeps one copy.
+type Store interface {
+	Put(ctx context.Context, data []byte) (*StoredImage, error)
+	// Get returns a repositories.NotFoundError when the image isn't in the store (anymore)
+	Get(ctx context.Context, path string) ([]byte, error)
+	Delete(ctx context.Context, path string) error
+	List(ctx context.Context) ([]ObjectInfo, error)
+}
+
+// URLStore is a store whose images can be linked to, e.g. with presigned URLs.
+type URLStore interface {
+	Store
+	URL(ctx context.Context, path string) (string, error)
+}
@@ -0,0 +1,178 @@
+package image_store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"stable_diffusion_bot/repositories"
+)
+
+type localStore struct {
+	dir string
+}
+
+type LocalConfig struct {
+	// Dir is the directory the images are stored in, it is created when missing
+	Dir string
+}
+
+// NewLocalStore returns a store that keeps the images on the local filesystem.
+func NewLocalStore(cfg LocalConfig) (Store, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("missing image directory")
+	}
+
+	err := os.MkdirAll(cfg.Dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localStore{dir: cfg.Dir}, nil
+}
+
+// filePath turns a stored path back into a file path, refusing anything outside of the store.
+func (s *localStore) filePath(storedPath string) (string, error) {
+	cleaned := path.Clean(storedPath)
+
+	if cleaned == "." || path.IsAbs(cleaned) || strings.HasPrefix(cleaned, "../") || cleaned == ".." {
+		return "", fmt.Errorf("invalid image path: %s", storedPath)
+	}
+
+	return filepath.Join(s.dir, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *localStore) Put(_ context.Context, data []byte) (*StoredImage, error) {
+	hash := hashImage(data)
+	storedPath := objectPath(hash, imageExtension(data))
+
+	filePath, err := s.filePath(storedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &StoredImage{Path: storedPath, Hash: hash, Size: int64(len(data))}
+
+	// the name is the hash of the content, so an existing file already has the same image. It counts as new again for
+	// the retention policy.
+	if _, err = os.Stat(filePath); err == nil {
+		now := time.Now()
+
+		err = os.Chtimes(filePath, now, now)
+		if err != nil {
+			return nil, err
+		}
+
+		return stored, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(filePath), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	// write to a temporary file first, so a crash never leaves half an image under the final name
+	tempFile, err := os.CreateTemp(filepath.Dir(filePath), ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tempFile.Write(data)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tempFile.Name())
+
+		return nil, err
+	}
+
+	err = os.Rename(tempFile.Name(), filePath)
+	if err != nil {
+		_ = os.Remove(tempFile.Name())
+
+		return nil, err
+	}
+
+	return stored, nil
+}
+
+func (s *localStore) Get(_ context.Context, storedPath string) ([]byte, error) {
+	filePath, err := s.filePath(storedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, repositories.NewNotFoundError("image")
+		}
+
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *localStore) Delete(_ context.Context, storedPath string) error {
+	filePath, err := s.filePath(storedPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *localStore) List(ctx context.Context) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	err := filepath.WalkDir(s.dir, func(filePath string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(s.dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, ObjectInfo{
+			Path:    filepath.ToSlash(relativePath),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,93 @@
+package image_store
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy limits how much the store keeps. Zero values don't limit anything.
+type RetentionPolicy struct {
+	// MaxAge removes images stored longer ago
+	MaxAge time.Duration
+	// MaxTotalSize removes the oldest images until the rest fits, in bytes
+	MaxTotalSize int64
+}
+
+func (p RetentionPolicy) IsEmpty() bool {
+	return p.MaxAge <= 0 && p.MaxTotalSize <= 0
+}
+
+// ApplyRetention removes the images the policy doesn't keep, oldest first, and returns their paths.
+func ApplyRetention(ctx context.Context, store Store, policy RetentionPolicy, now time.Time) ([]string, error) {
+	if policy.IsEmpty() {
+		return nil, nil
+	}
+
+	objects, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].ModTime.Before(objects[j].ModTime)
+	})
+
+	var totalSize int64
+
+	for _, object := range objects {
+		totalSize += object.Size
+	}
+
+	var removed []string
+
+	for _, object := range objects {
+		expired := policy.MaxAge > 0 && now.Sub(object.ModTime) > policy.MaxAge
+		tooBig := policy.MaxTotalSize > 0 && totalSize > policy.MaxTotalSize
+
+		if !expired && !tooBig {
+			// the rest is newer, and already fits
+			break
+		}
+
+		err = store.Delete(ctx, object.Path)
+		if err != nil {
+			return removed, err
+		}
+
+		totalSize -= object.Size
+		removed = append(removed, object.Path)
+	}
+
+	return removed, nil
+}
+
+// StartRetention applies the policy now and then at every interval, until the context is done. The paths of removed
+// images are passed to onRemoved, so they can be forgotten by the generations.
+func StartRetention(ctx context.Context, store Store, policy RetentionPolicy, interval time.Duration, onRemoved func(paths []string)) {
+	if policy.IsEmpty() {
+		return
+	}
+
+	go func() {
+		for {
+			removed, err := ApplyRetention(ctx, store, policy, time.Now())
+			if err != nil {
+				log.Printf("Error applying image retention: %v", err)
+			}
+
+			if len(removed) > 0 {
+				log.Printf("Removed %d archived images", len(removed))
+
+				onRemoved(removed)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
@@ -0,0 +1,266 @@
+package image_store
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"stable_diffusion_bot/repositories"
+)
+
+const defaultPresignExpiry = 7 * 24 * time.Hour
+
+type s3Store struct {
+	endpoint      *url.URL
+	bucket        string
+	prefix        string
+	pathStyle     bool
+	presignExpiry time.Duration
+	signer        *sigV4Signer
+	client        *http.Client
+}
+
+type S3Config struct {
+	// Endpoint is the URL of the S3 API, e.g. "http://127.0.0.1:9000" for MinIO. Default is AWS in the region.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is put in front of every object key, e.g. "images/"
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle puts the bucket in the path instead of the host name, which MinIO and most other servers need
+	PathStyle bool
+	// PresignExpiry is how long presigned URLs work, at most 7 days
+	PresignExpiry time.Duration
+}
+
+// NewS3Store returns a store that keeps the images in an S3-compatible bucket.
+func NewS3Store(cfg S3Config) (Store, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("missing S3 bucket")
+	}
+
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("missing S3 credentials")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	rawEndpoint := cfg.Endpoint
+	if rawEndpoint == "" {
+		rawEndpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	endpoint, err := url.Parse(strings.TrimSuffix(rawEndpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid S3 endpoint: %s", rawEndpoint)
+	}
+
+	presignExpiry := cfg.PresignExpiry
+	if presignExpiry <= 0 || presignExpiry > defaultPresignExpiry {
+		presignExpiry = defaultPresignExpiry
+	}
+
+	return &s3Store{
+		endpoint:      endpoint,
+		bucket:        cfg.Bucket,
+		prefix:        cfg.Prefix,
+		pathStyle:     cfg.PathStyle,
+		presignExpiry: presignExpiry,
+		signer: &sigV4Signer{
+			accessKeyID:     cfg.AccessKeyID,
+			secretAccessKey: cfg.SecretAccessKey,
+			region:          region,
+		},
+		client: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// objectURL returns the URL of the object with the key, or of the bucket when the key is empty.
+func (s *s3Store) objectURL(key string) *url.URL {
+	target := *s.endpoint
+
+	objectPath := "/" + key
+	if s.pathStyle {
+		objectPath = "/" + s.bucket + objectPath
+	} else {
+		target.Host = s.bucket + "." + target.Host
+	}
+
+	target.Path = objectPath
+	target.RawPath = uriEncode(objectPath, true)
+
+	return &target
+}
+
+func (s *s3Store) do(ctx context.Context, method string, target *url.URL, body []byte, contentType string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	s.signer.sign(request, hexSHA256(body), time.Now())
+
+	return s.client.Do(request)
+}
+
+// s3Error reads the error the server responded with.
+func s3Error(response *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1<<16))
+
+	var apiErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+
+	if xml.Unmarshal(body, &apiErr) == nil && apiErr.Code != "" {
+		return fmt.Errorf("S3 error %s: %s", apiErr.Code, apiErr.Message)
+	}
+
+	return fmt.Errorf("unexpected S3 status: %s", response.Status)
+}
+
+func (s *s3Store) Put(ctx context.Context, data []byte) (*StoredImage, error) {
+	hash := hashImage(data)
+	storedPath := objectPath(hash, imageExtension(data))
+
+	// putting the same content again only refreshes the object for the retention policy
+	response, err := s.do(ctx, http.MethodPut, s.objectURL(s.prefix+storedPath), data, http.DetectContentType(data))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, s3Error(response)
+	}
+
+	return &StoredImage{Path: storedPath, Hash: hash, Size: int64(len(data))}, nil
+}
+
+func (s *s3Store) Get(ctx context.Context, storedPath string) ([]byte, error) {
+	response, err := s.do(ctx, http.MethodGet, s.objectURL(s.prefix+storedPath), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, repositories.NewNotFoundError("image")
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, s3Error(response)
+	}
+
+	return io.ReadAll(response.Body)
+}
+
+func (s *s3Store) Delete(ctx context.Context, storedPath string) error {
+	response, err := s.do(ctx, http.MethodDelete, s.objectURL(s.prefix+storedPath), nil, "")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK &&
+		response.StatusCode != http.StatusNotFound {
+		return s3Error(response)
+	}
+
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3Store) List(ctx context.Context) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	continuationToken := ""
+
+	for {
+		target := s.objectURL("")
+
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.prefix)
+
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		target.RawQuery = canonicalQuery(query)
+
+		page, err := s.listPage(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Path:    strings.TrimPrefix(object.Key, s.prefix),
+				Size:    object.Size,
+				ModTime: object.LastModified,
+			})
+		}
+
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+
+		continuationToken = page.NextContinuationToken
+	}
+}
+
+func (s *s3Store) listPage(ctx context.Context, target *url.URL) (*listBucketResult, error) {
+	response, err := s.do(ctx, http.MethodGet, target, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, s3Error(response)
+	}
+
+	page := &listBucketResult{}
+
+	err = xml.NewDecoder(response.Body).Decode(page)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// URL returns a presigned URL of the image, which works without credentials until it expires.
+func (s *s3Store) URL(_ context.Context, storedPath string) (string, error) {
+	return s.signer.presign(http.MethodGet, s.objectURL(s.prefix+storedPath), s.presignExpiry, time.Now()), nil
+}
@@ -0,0 +1,307 @@
+package image_store
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"stable_diffusion_bot/repositories"
+)
+
+const (
+	testAccessKeyID     = "test-access-key"
+	testSecretAccessKey = "test-secret-key"
+	testBucket          = "images"
+)
+
+// fakeS3 is an in-memory bucket that checks the signature of every request, like MinIO does.
+type fakeS3 struct {
+	t       *testing.T
+	signer  *sigV4Signer
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3(t *testing.T) *httptest.Server {
+	fake := &fakeS3{
+		t: t,
+		signer: &sigV4Signer{
+			accessKeyID:     testAccessKeyID,
+			secretAccessKey: testSecretAccessKey,
+			region:          "us-east-1",
+		},
+		objects: make(map[string][]byte),
+	}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, "<Error><Code>"+code+"</Code><Message>"+code+"</Message></Error>")
+}
+
+// verify recomputes the signature of the request from what arrived, with the headers it claims to have signed.
+func (f *fakeS3) verify(r *http.Request, body []byte) bool {
+	query := r.URL.Query()
+
+	if query.Get("X-Amz-Signature") != "" {
+		signature := query.Get("X-Amz-Signature")
+		query.Del("X-Amz-Signature")
+
+		now, err := time.Parse(sigV4DateFormat, query.Get("X-Amz-Date"))
+		if err != nil {
+			return false
+		}
+
+		canonicalRequest := strings.Join([]string{
+			r.Method,
+			uriEncode(r.URL.Path, true),
+			canonicalQuery(query),
+			"host:" + r.Host + "\n",
+			"host",
+			unsignedPayload,
+		}, "\n")
+
+		return signature == f.signer.signature(now, canonicalRequest)
+	}
+
+	authorization := r.Header.Get("Authorization")
+
+	var signedHeaders, signature string
+
+	for _, part := range strings.Split(strings.TrimPrefix(authorization, sigV4Algorithm+" "), ", ") {
+		name, value, _ := strings.Cut(part, "=")
+
+		switch name {
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			signature = value
+		case "Credential":
+			if !strings.HasPrefix(value, testAccessKeyID+"/") {
+				return false
+			}
+		}
+	}
+
+	payloadHash := r.Header.Get(amzContentHeader)
+	if payloadHash != hexSHA256(body) {
+		return false
+	}
+
+	now, err := time.Parse(sigV4DateFormat, r.Header.Get(amzDateHeader))
+	if err != nil {
+		return false
+	}
+
+	names := strings.Split(signedHeaders, ";")
+	sort.Strings(names)
+
+	var headers strings.Builder
+
+	for _, name := range names {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+
+		headers.WriteString(name + ":" + value + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, true),
+		canonicalQuery(query),
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	return signature == f.signer.signature(now, canonicalRequest)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		f.t.Errorf("reading request body: %v", err)
+	}
+
+	if !f.verify(r, body) {
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch")
+
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != testBucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodGet:
+		object, ok := f.objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+
+			return
+		}
+
+		_, _ = w.Write(object)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	}
+
+	result := struct {
+		XMLName  xml.Name  `xml:"ListBucketResult"`
+		Contents []content `xml:"Contents"`
+	}{}
+
+	for key, object := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{Key: key, LastModified: time.Now().UTC(), Size: int64(len(object))})
+		}
+	}
+
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func newTestS3Store(t *testing.T, endpoint string, secretAccessKey string) Store {
+	t.Helper()
+
+	store, err := NewS3Store(S3Config{
+		Endpoint:        endpoint,
+		Bucket:          testBucket,
+		Prefix:          "archive/",
+		AccessKeyID:     testAccessKeyID,
+		SecretAccessKey: secretAccessKey,
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func TestS3StoreRoundTrip(t *testing.T) {
+	server := newFakeS3(t)
+	store := newTestS3Store(t, server.URL, testSecretAccessKey)
+	ctx := context.Background()
+
+	// a PNG signature is enough for the content type
+	data := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{1, 2, 3}, 100)...)
+
+	stored, err := store.Put(ctx, data)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if stored.Hash != hashImage(data) || !strings.HasSuffix(stored.Path, ".png") || stored.Size != int64(len(data)) {
+		t.Errorf("Put = %+v, want the hash, a .png path and the size", stored)
+	}
+
+	got, err := store.Get(ctx, stored.Path)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("Get returned %d bytes, want the %d put", len(got), len(data))
+	}
+
+	objects, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+
+	if len(objects) != 1 || objects[0].Path != stored.Path || objects[0].Size != int64(len(data)) {
+		t.Errorf("List = %+v, want the stored image without the prefix", objects)
+	}
+
+	imageURL, err := store.(URLStore).URL(ctx, stored.Path)
+	if err != nil {
+		t.Fatalf("URL: %v", err)
+	}
+
+	// the presigned URL works without credentials
+	response, err := http.Get(imageURL)
+	if err != nil {
+		t.Fatalf("fetching presigned URL: %v", err)
+	}
+
+	presignedData, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK || !bytes.Equal(presignedData, data) {
+		t.Errorf("presigned URL returned %s with %d bytes, want the image", response.Status, len(presignedData))
+	}
+
+	err = store.Delete(ctx, stored.Path)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	_, err = store.Get(ctx, stored.Path)
+	if !errors.Is(err, &repositories.NotFoundError{}) {
+		t.Errorf("Get after Delete = %v, want a NotFoundError", err)
+	}
+}
+
+func TestS3StoreRejectedSignature(t *testing.T) {
+	server := newFakeS3(t)
+	store := newTestS3Store(t, server.URL, "wrong-secret")
+
+	_, err := store.Put(context.Background(), []byte("image"))
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("Put with the wrong secret = %v, want the S3 error", err)
+	}
+}
+
+func TestS3ObjectURL(t *testing.T) {
+	endpoint, _ := url.Parse("https://s3.example.com")
+
+	virtualHosted := &s3Store{endpoint: endpoint, bucket: "images"}
+	if got := virtualHosted.objectURL("a b.png").String(); got != "https://images.s3.example.com/a%20b.png" {
+		t.Errorf("virtual hosted URL = %s", got)
+	}
+
+	pathStyle := &s3Store{endpoint: endpoint, bucket: "images", pathStyle: true}
+	if got := pathStyle.objectURL("a b.png").String(); got != "https://s3.example.com/images/a%20b.png" {
+		t.Errorf("path style URL = %s", got)
+	}
+}
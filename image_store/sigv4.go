@@ -0,0 +1,185 @@
+package image_store
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, as described in https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html.
+// It is all an S3 client needs, without pulling in the AWS SDK.
+
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4DateFormat  = "20060102T150405Z"
+	sigV4Service     = "s3"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	amzDateHeader    = "X-Amz-Date"
+	amzContentHeader = "X-Amz-Content-Sha256"
+)
+
+type sigV4Signer struct {
+	accessKeyID     string
+	secretAccessKey string
+	region          string
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// uriEncode escapes everything but the unreserved characters, and the slashes of a path when asked to.
+func uriEncode(value string, keepSlash bool) string {
+	var encoded strings.Builder
+
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '.', b == '_', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && keepSlash:
+			encoded.WriteByte(b)
+		default:
+			encoded.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{b})))
+		}
+	}
+
+	return encoded.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var pairs []string
+
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, false)+"="+uriEncode(value, false))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// canonicalHeaders returns the signed headers and their canonical form. The host and every header set on the request
+// are signed, the headers the transport adds later aren't on it yet.
+func canonicalHeaders(request *http.Request) (string, string) {
+	headers := map[string]string{"host": request.URL.Host}
+
+	for name, values := range request.Header {
+		lowerName := strings.ToLower(name)
+		if lowerName == "authorization" {
+			continue
+		}
+
+		// values are trimmed, and runs of spaces inside them collapsed
+		headers[lowerName] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonical strings.Builder
+
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	return strings.Join(names, ";"), canonical.String()
+}
+
+func (s *sigV4Signer) credentialScope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/" + sigV4Service + "/aws4_request"
+}
+
+func (s *sigV4Signer) signature(now time.Time, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		now.Format(sigV4DateFormat),
+		s.credentialScope(now),
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, sigV4Service)
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// sign adds the Authorization header to the request, for a body with the given hash.
+func (s *sigV4Signer) sign(request *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+
+	request.Header.Set(amzDateHeader, now.Format(sigV4DateFormat))
+	request.Header.Set(amzContentHeader, payloadHash)
+
+	signedHeaders, headers := canonicalHeaders(request)
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		uriEncode(request.URL.Path, true),
+		canonicalQuery(request.URL.Query()),
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	request.Header.Set("Authorization", sigV4Algorithm+
+		" Credential="+s.accessKeyID+"/"+s.credentialScope(now)+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+s.signature(now, canonicalRequest))
+}
+
+// presign returns the URL with the signature in the query, so it can be fetched without credentials until it expires.
+func (s *sigV4Signer) presign(method string, target *url.URL, expiry time.Duration, now time.Time) string {
+	now = now.UTC()
+
+	query := target.Query()
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", s.accessKeyID+"/"+s.credentialScope(now))
+	query.Set("X-Amz-Date", now.Format(sigV4DateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		uriEncode(target.Path, true),
+		canonicalQuery(query),
+		"host:" + target.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	presigned := *target
+	presigned.RawQuery = canonicalQuery(query) + "&X-Amz-Signature=" + s.signature(now, canonicalRequest)
+
+	return presigned.String()
+}
@@ -0,0 +1,82 @@
+package imagine_queue
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/image_store"
+	"stable_diffusion_bot/repositories"
+)
+
+// archive stores the image in the image store. It returns nil when there is no store, or the image couldn't be
+// archived, the job doesn't fail because of it.
+func (q *queueImpl) archive(data []byte) *image_store.StoredImage {
+	if q.imageStore == nil || len(data) == 0 {
+		return nil
+	}
+
+	stored, err := q.imageStore.Put(context.Background(), data)
+	if err != nil {
+		log.Printf("Error archiving image: %v", err)
+
+		return nil
+	}
+
+	return stored
+}
+
+// setArchivedImage records where the image of the generation was archived.
+func setArchivedImage(generation *entities.ImageGeneration, stored *image_store.StoredImage) {
+	if stored == nil {
+		return
+	}
+
+	generation.ImagePath = stored.Path
+	generation.ImageHash = stored.Hash
+}
+
+// archiveImage archives the image, and records where on the generation.
+func (q *queueImpl) archiveImage(generation *entities.ImageGeneration, data []byte) *image_store.StoredImage {
+	stored := q.archive(data)
+
+	setArchivedImage(generation, stored)
+
+	return stored
+}
+
+// archivedImageURL returns a link to the archived image, when the store can be linked to.
+func (q *queueImpl) archivedImageURL(stored *image_store.StoredImage) string {
+	urlStore, ok := q.imageStore.(image_store.URLStore)
+	if !ok || stored == nil {
+		return ""
+	}
+
+	imageURL, err := urlStore.URL(context.Background(), stored.Path)
+	if err != nil {
+		log.Printf("Error getting URL of archived image %s: %v", stored.Path, err)
+
+		return ""
+	}
+
+	return imageURL
+}
+
+// archivedImage returns the archived image of the generation, or nil when there is none.
+func (q *queueImpl) archivedImage(generation *entities.ImageGeneration) []byte {
+	if q.imageStore == nil || generation.ImagePath == "" {
+		return nil
+	}
+
+	data, err := q.imageStore.Get(context.Background(), generation.ImagePath)
+	if err != nil {
+		if !errors.Is(err, &repositories.NotFoundError{}) {
+			log.Printf("Error getting archived image %s: %v", generation.ImagePath, err)
+		}
+
+		return nil
+	}
+
+	return data
+}
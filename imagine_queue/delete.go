@@ -0,0 +1,58 @@
+package imagine_queue
+
+import (
+	"context"
+	"log"
+)
+
+// DeleteGenerations marks the generations of a result message as deleted, once the message is gone. When deleted
+// images are purged, the archived images that no other generation uses are removed from the image store too.
+func (q *queueImpl) DeleteGenerations(messageID string) error {
+	ctx := context.Background()
+
+	generations, err := q.imageGenerationRepo.ListByMessage(ctx, messageID)
+	if err != nil {
+		return err
+	}
+
+	err = q.imageGenerationRepo.MarkDeleted(ctx, messageID)
+	if err != nil {
+		return err
+	}
+
+	if !q.purgeDeletedImages || q.imageStore == nil {
+		return nil
+	}
+
+	for _, generation := range generations {
+		if generation.ImagePath == "" {
+			continue
+		}
+
+		// the store keeps one copy of the same image, which an upscale or another result may still use
+		count, countErr := q.imageGenerationRepo.CountByImagePath(ctx, generation.ImagePath)
+		if countErr != nil {
+			log.Printf("Error counting generations of archived image %s: %v", generation.ImagePath, countErr)
+
+			continue
+		}
+
+		if count > 0 {
+			continue
+		}
+
+		deleteErr := q.imageStore.Delete(ctx, generation.ImagePath)
+		if deleteErr != nil {
+			log.Printf("Error purging archived image %s: %v", generation.ImagePath, deleteErr)
+
+			continue
+		}
+
+		clearErr := q.imageGenerationRepo.ClearImagePath(ctx, generation.ImagePath)
+		if clearErr != nil {
+			log.Printf("Error clearing archived image %s: %v", generation.ImagePath, clearErr)
+		}
+	}
+
+	return nil
+}
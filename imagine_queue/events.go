@@ -0,0 +1,212 @@
+package imagine_queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/repositories/image_generations"
+)
+
+type EventType string
+
+const (
+	EventJobQueued    EventType = "job.queued"
+	EventJobStarted   EventType = "job.started"
+	EventJobProgress  EventType = "job.progress"
+	EventJobSucceeded EventType = "job.succeeded"
+	EventJobFailed    EventType = "job.failed"
+)
+
+// Event is something that happened to a queued job.
+type Event interface {
+	Type() EventType
+	// Job is the queue item the event is about
+	Job() *QueueItem
+	OccurredAt() time.Time
+}
+
+// JobEvent holds what all events have in common.
+type JobEvent struct {
+	Item *QueueItem
+	Time time.Time
+}
+
+func (e JobEvent) Job() *QueueItem {
+	return e.Item
+}
+
+func (e JobEvent) OccurredAt() time.Time {
+	return e.Time
+}
+
+// JobQueued is published when a job is added to the queue.
+type JobQueued struct {
+	JobEvent
+	// Position is the place in the queue, 1 being next
+	Position int
+}
+
+func (JobQueued) Type() EventType { return EventJobQueued }
+
+// JobStarted is published when a job is taken from the queue.
+type JobStarted struct {
+	JobEvent
+	// MessageID is the message the results are posted in, which the generations are stored under
+	MessageID string
+	Status    JobStatus
+}
+
+func (JobStarted) Type() EventType { return EventJobStarted }
+
+// JobProgress is published about every second while a job runs.
+type JobProgress struct {
+	JobEvent
+	Status JobStatus
+}
+
+func (JobProgress) Type() EventType { return EventJobProgress }
+
+// JobSucceeded is published when a job has posted its results.
+type JobSucceeded struct {
+	JobEvent
+	Result *JobResult
+	// Generations are the rows stored for the result message, the base row first
+	Generations []*entities.ImageGeneration
+}
+
+func (JobSucceeded) Type() EventType { return EventJobSucceeded }
+
+// JobFailed is published when a job could not be finished.
+type JobFailed struct {
+	JobEvent
+	Err error
+}
+
+func (JobFailed) Type() EventType { return EventJobFailed }
+
+// Subscriber reacts to the events of the queue, e.g. to mirror results somewhere else.
+type Subscriber interface {
+	// HandleEvent is called for every event, one at a time and in order. It runs apart from the queue, so a slow
+	// subscriber doesn't hold up the generations, but it misses events when it falls too far behind.
+	HandleEvent(event Event)
+}
+
+// subscriberBufferSize is how many events a subscriber can fall behind before it misses some.
+const subscriberBufferSize = 100
+
+// EventBus hands the events of the queue to its subscribers.
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers []chan Event
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe starts handing events to the subscriber.
+func (b *EventBus) Subscribe(subscriber Subscriber) {
+	events := make(chan Event, subscriberBufferSize)
+
+	go func() {
+		for event := range events {
+			subscriber.HandleEvent(event)
+		}
+	}()
+
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, events)
+	b.mu.Unlock()
+}
+
+// Publish hands the event to every subscriber without waiting for them.
+func (b *EventBus) Publish(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+			log.Printf("Dropping %s event, a subscriber is falling behind", event.Type())
+		}
+	}
+}
+
+// publishingSink passes everything on to the sink of the frontend, and publishes it as events.
+type publishingSink struct {
+	next                ResultSink
+	bus                 *EventBus
+	imageGenerationRepo image_generations.Repository
+}
+
+func (s *publishingSink) Started(item *QueueItem, status JobStatus) (string, error) {
+	messageID, err := s.next.Started(item, status)
+	if err != nil {
+		s.bus.Publish(JobFailed{JobEvent: JobEvent{Item: item, Time: time.Now()}, Err: err})
+
+		return "", err
+	}
+
+	s.bus.Publish(JobStarted{
+		JobEvent:  JobEvent{Item: item, Time: time.Now()},
+		MessageID: messageID,
+		Status:    status,
+	})
+
+	return messageID, nil
+}
+
+func (s *publishingSink) Progress(item *QueueItem, status JobStatus) error {
+	s.bus.Publish(JobProgress{
+		JobEvent: JobEvent{Item: item, Time: time.Now()},
+		Status:   status,
+	})
+
+	return s.next.Progress(item, status)
+}
+
+func (s *publishingSink) Finished(item *QueueItem, result *JobResult) error {
+	err := s.next.Finished(item, result)
+	if err != nil {
+		s.bus.Publish(JobFailed{JobEvent: JobEvent{Item: item, Time: time.Now()}, Err: err})
+
+		return err
+	}
+
+	generations, err := s.imageGenerationRepo.ListByMessage(context.Background(), item.ResultMessageID)
+	if err != nil {
+		log.Printf("Error listing generations of message %s: %v", item.ResultMessageID, err)
+	}
+
+	s.bus.Publish(JobSucceeded{
+		JobEvent:    JobEvent{Item: item, Time: time.Now()},
+		Result:      result,
+		Generations: generations,
+	})
+
+	return nil
+}
+
+func (s *publishingSink) Failed(item *QueueItem, err error) {
+	s.next.Failed(item, err)
+
+	s.bus.Publish(JobFailed{JobEvent: JobEvent{Item: item, Time: time.Now()}, Err: err})
+}
+
+func newJobID() string {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		// the ID only tells events apart, so a clash is harmless
+		return time.Now().Format("20060102150405.000000000")
+	}
+
+	return hex.EncodeToString(randomBytes)
+}
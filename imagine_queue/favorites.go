@@ -0,0 +1,159 @@
+package imagine_queue
+
+import (
+	"context"
+	"log"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/repositories"
+)
+
+// DefaultGalleryThreshold is how many favorites a result needs to be reposted, when a server doesn't choose.
+const DefaultGalleryThreshold = 3
+
+// FavoriteStatus is what favoriting a result did.
+type FavoriteStatus struct {
+	// Added is false when the member had already favorited the result
+	Added bool
+	// Count is how many members favorited the result
+	Count int
+	// Generation is the first image of the result
+	Generation *entities.ImageGeneration
+	// GalleryChannelID is where the result should be reposted now that it has enough favorites, empty otherwise.
+	// Only one favorite gets it, the repost is then confirmed with SetGalleryMessage or given up with
+	// ReleaseGalleryPost.
+	GalleryChannelID string
+}
+
+// resultGeneration returns the first image of a result message. The first row of a grid is the batch it was asked
+// for, and doesn't have the seed of an image.
+func (q *queueImpl) resultGeneration(ctx context.Context, messageID string) (*entities.ImageGeneration, error) {
+	generations, err := q.imageGenerationRepo.ListByMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	// deleted results can't be favorited anymore
+	if len(generations) == 0 || generations[0].Deleted {
+		return nil, repositories.NewNotFoundError("image generation for message " + messageID)
+	}
+
+	for _, generation := range generations {
+		if generation.SortOrder > 0 {
+			return generation, nil
+		}
+	}
+
+	return generations[0], nil
+}
+
+// AddFavorite stores the favorite of a result message. Messages that aren't results give a not found error.
+func (q *queueImpl) AddFavorite(favorite *entities.Favorite) (*FavoriteStatus, error) {
+	ctx := context.Background()
+
+	generation, err := q.resultGeneration(ctx, favorite.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := q.favoriteRepo.Add(ctx, favorite)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := q.favoriteRepo.CountByMessage(ctx, favorite.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &FavoriteStatus{
+		Added:      added,
+		Count:      count,
+		Generation: generation,
+	}
+
+	if !added || favorite.GuildID == "" {
+		return status, nil
+	}
+
+	settings, err := q.GetGallerySettings(favorite.GuildID)
+	if err != nil {
+		log.Printf("Error getting gallery settings: %v", err)
+
+		return status, nil
+	}
+
+	if settings.ChannelID == "" || count < settings.Threshold {
+		return status, nil
+	}
+
+	claimed, err := q.favoriteRepo.ClaimGalleryPost(ctx, favorite.MessageID, favorite.GuildID)
+	if err != nil {
+		log.Printf("Error claiming gallery post: %v", err)
+
+		return status, nil
+	}
+
+	if claimed {
+		status.GalleryChannelID = settings.ChannelID
+	}
+
+	return status, nil
+}
+
+// RemoveFavorite forgets the favorite of the member, and reports whether there was one. A result that was already
+// reposted stays in the gallery.
+func (q *queueImpl) RemoveFavorite(messageID, memberID string) (bool, error) {
+	return q.favoriteRepo.Remove(context.Background(), messageID, memberID)
+}
+
+// ListFavorites returns the first image of the results the member favorited, the latest favorite first.
+func (q *queueImpl) ListFavorites(memberID string, limit int) ([]*entities.ImageGeneration, error) {
+	ctx := context.Background()
+
+	favorites, err := q.favoriteRepo.ListByMember(ctx, memberID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	generations := make([]*entities.ImageGeneration, 0, len(favorites))
+
+	for _, favorite := range favorites {
+		generation, err := q.resultGeneration(ctx, favorite.MessageID)
+		if err != nil {
+			log.Printf("Error getting favorite generation %v: %v", favorite.MessageID, err)
+
+			continue
+		}
+
+		generations = append(generations, generation)
+	}
+
+	return generations, nil
+}
+
+// GetGallerySettings returns the gallery of the server, with the default threshold when it was never set.
+func (q *queueImpl) GetGallerySettings(guildID string) (*entities.GallerySettings, error) {
+	settings, err := q.favoriteRepo.GetGallerySettings(context.Background(), guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings.Threshold <= 0 {
+		settings.Threshold = DefaultGalleryThreshold
+	}
+
+	return settings, nil
+}
+
+func (q *queueImpl) SetGallerySettings(settings *entities.GallerySettings) error {
+	return q.favoriteRepo.SetGallerySettings(context.Background(), settings)
+}
+
+func (q *queueImpl) SetGalleryMessage(messageID, galleryMessageID string) error {
+	return q.favoriteRepo.SetGalleryMessage(context.Background(), messageID, galleryMessageID)
+}
+
+func (q *queueImpl) ReleaseGalleryPost(messageID string) error {
+	return q.favoriteRepo.ReleaseGalleryPost(context.Background(), messageID)
+}
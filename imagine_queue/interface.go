@@ -2,14 +2,53 @@ package imagine_queue
 
 import (
 	"stable_diffusion_bot/entities"
-
-	"github.com/bwmarrin/discordgo"
+	"stable_diffusion_bot/moderation"
+	"stable_diffusion_bot/repositories/image_generations"
 )
 
 type Queue interface {
 	AddImagine(item *QueueItem) (int, error)
-	StartPolling(botSession *discordgo.Session)
-	GetBotDefaultSettings() (*entities.DefaultSettings, error)
-	UpdateDefaultDimensions(width, height int) (*entities.DefaultSettings, error)
-	UpdateDefaultBatch(batchCount, batchSize int) (*entities.DefaultSettings, error)
+	GetPreviousGeneration(imagine *QueueItem, sortOrder int) (*entities.ImageGeneration, error)
+	ListGenerationsByMessage(messageID string) ([]*entities.ImageGeneration, error)
+	ListGenerationsByMember(memberID string, options image_generations.ListOptions) ([]*entities.ImageGeneration, error)
+	DeleteGenerations(messageID string) error
+	SearchGenerations(query string, filters image_generations.SearchFilters) ([]*entities.ImageGeneration, error)
+	StartPolling()
+	Subscribe(subscriber Subscriber)
+	GetDefaultSettings(key SettingsKey) (*entities.DefaultSettings, error)
+	UpdateDefaultDimensions(key SettingsKey, width, height int) (*entities.DefaultSettings, error)
+	UpdateDefaultBatch(key SettingsKey, batchCount, batchSize int) (*entities.DefaultSettings, error)
+	UpdateDefaultUpscale(key SettingsKey, method UpscaleMethod, factor float64) (*entities.DefaultSettings, error)
+	UpdateDefaultUpscaler(key SettingsKey, upscaler string) (*entities.DefaultSettings, error)
+	UpdateDefaultVariationStrengths(key SettingsKey, subtle, strong float64) (*entities.DefaultSettings, error)
+	UpdateDefaultGenerationParameters(key SettingsKey, defaults GenerationDefaults) (*entities.DefaultSettings, error)
+	UpdateDefaultHiresFix(key SettingsKey, enableHR bool) (*entities.DefaultSettings, error)
+	UpdateDefaultRestoreFaces(key SettingsKey, restoreFaces bool) (*entities.DefaultSettings, error)
+	ResetDefaultSettings(key SettingsKey) (*entities.DefaultSettings, error)
+	GetChannelProfile(channelID string) (*entities.ChannelProfile, error)
+	ListChannelProfiles(guildID string) ([]*entities.ChannelProfile, error)
+	UpdateChannelProfile(profile *entities.ChannelProfile) (*entities.ChannelProfile, error)
+	DeleteChannelProfile(channelID string) error
+	AddModerationRule(rule *entities.ModerationRule) (*entities.ModerationRule, error)
+	ListModerationRules(guildID string) ([]*entities.ModerationRule, error)
+	DeleteModerationRule(guildID string, id int64) (bool, error)
+	GetReviewChannel(guildID string) (string, error)
+	SetReviewChannel(guildID, channelID string) error
+	GetNSFWChannel(guildID string) (string, error)
+	SetNSFWChannel(guildID, channelID string) error
+	ListModerationDecisions(guildID string) ([]*entities.ModerationDecision, error)
+	CheckPrompt(guildID, channelID, prompt string) (*moderation.Result, error)
+	ApproveHeldPrompt(heldID int64, moderatorID string) (int, error)
+	DenyHeldPrompt(heldID int64, moderatorID string) error
+	AddFavorite(favorite *entities.Favorite) (*FavoriteStatus, error)
+	RemoveFavorite(messageID, memberID string) (bool, error)
+	ListFavorites(memberID string, limit int) ([]*entities.ImageGeneration, error)
+	GetGallerySettings(guildID string) (*entities.GallerySettings, error)
+	SetGallerySettings(settings *entities.GallerySettings) error
+	SetGalleryMessage(messageID, galleryMessageID string) error
+	ReleaseGalleryPost(messageID string) error
+	GrantPermission(grant *entities.PermissionGrant) (bool, error)
+	RevokePermission(guildID, capability, subjectType, subjectID string) (bool, error)
+	ListPermissions(guildID string) ([]*entities.PermissionGrant, error)
+	CheckCapability(guildID, memberID string, roleIDs []string, capability string) (CapabilityCheck, error)
 }
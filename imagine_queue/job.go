@@ -0,0 +1,92 @@
+package imagine_queue
+
+import (
+	"stable_diffusion_bot/entities"
+	"time"
+)
+
+// JobSource says who queued a job, where from, and where its results go. It is filled in by the frontend, so the
+// queue doesn't need to know about Discord or any other frontend.
+type JobSource struct {
+	// RequestID identifies the request that queued the job, it is stored as the interaction ID of the generations
+	RequestID string
+	MemberID  string
+	ChannelID string
+	GuildID   string
+	// AgeRestricted is set when the channel may show sensitive results, like Discord's NSFW channels
+	AgeRestricted bool
+	// HiresDenied turns hires fix off, for members who may not use it
+	HiresDenied bool
+	// IgnoreBatchLimit lets the job render more images than the profile of the channel allows
+	IgnoreBatchLimit bool
+	// MessageID is the message with the images the job works on, for rerolls, variations, remixes and upscales
+	MessageID string
+	// ImageURL is where the image to outpaint or reimagine can be downloaded from
+	ImageURL string
+	// Sink shows the progress and results of the job
+	Sink ResultSink
+}
+
+// SettingsKey returns the key of the default settings for the given scope, from where the job was queued.
+func (s JobSource) SettingsKey(scope SettingsScope) SettingsKey {
+	return SettingsKey{
+		Scope:     scope,
+		MemberID:  s.MemberID,
+		ChannelID: s.ChannelID,
+		GuildID:   s.GuildID,
+	}
+}
+
+// JobStatus is how far a running job has come.
+type JobStatus struct {
+	// Generation is what is being rendered, for upscales and outpaints it is the generation the job started from
+	Generation *entities.ImageGeneration
+	// Progress is between 0 and 1, for upscales it is the progress of rendering the image again
+	Progress float64
+	// UpscaleProgress is the progress of the upscaler, once the image has been rendered again
+	UpscaleProgress float64
+	// CellsDone and CellsTotal count the cells of a plot
+	CellsDone  int
+	CellsTotal int
+}
+
+// ResultImage is an image file produced by a job.
+type ResultImage struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	// URL links to the archived image, when the image store can be linked to
+	URL string
+}
+
+// PlotCell is a cell of a finished plot, which can be upscaled by its sort order.
+type PlotCell struct {
+	SortOrder int
+	Label     string
+}
+
+// JobResult is what a finished job produced.
+type JobResult struct {
+	// Generation is the generation the images belong to, the first one for images with their own seeds
+	Generation *entities.ImageGeneration
+	Images     []ResultImage
+	// PlotCells lists the cells of a plot, in the order they were rendered
+	PlotCells []PlotCell
+	// Model is the model the images were rendered with as the API reports it, when the generation doesn't name one
+	Model string
+	// Elapsed is how long the job took, from when it was taken from the queue
+	Elapsed time.Duration
+}
+
+// ResultSink shows the progress and results of jobs to whoever queued them. Every frontend has its own.
+type ResultSink interface {
+	// Started is called when the job is taken from the queue. It returns the ID of the message the results are posted
+	// in, which the generations of the job are stored under.
+	Started(item *QueueItem, status JobStatus) (string, error)
+	// Progress is called about every second while the job runs.
+	Progress(item *QueueItem, status JobStatus) error
+	// Finished posts the results of the job.
+	Finished(item *QueueItem, result *JobResult) error
+	// Failed tells that the job could not be finished.
+	Failed(item *QueueItem, err error)
+}
@@ -0,0 +1,456 @@
+package imagine_queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/moderation"
+	"strings"
+	"time"
+)
+
+const (
+	DecisionRejected = "rejected"
+	DecisionNegative = "negative"
+	DecisionHeld     = "held"
+	DecisionApproved = "approved"
+	DecisionDenied   = "denied"
+	DecisionExpired  = "expired"
+
+	// maxHoldTime is how long a held prompt waits for a moderator. Discord can only post the results of an
+	// interaction for 15 minutes.
+	maxHoldTime = 14 * time.Minute
+
+	// moderationLogLimit is how many decisions are listed at most
+	moderationLogLimit = 25
+)
+
+// PromptRejectedError is returned when a moderation rule rejects the prompt.
+type PromptRejectedError struct {
+	RuleID int64
+}
+
+func (e *PromptRejectedError) Error() string {
+	return fmt.Sprintf("prompt rejected by moderation rule %d", e.RuleID)
+}
+
+func (e *PromptRejectedError) Is(tgt error) bool {
+	_, ok := tgt.(*PromptRejectedError)
+
+	return ok
+}
+
+// PromptHeldError is returned when the prompt waits for a moderator to approve it. The item is queued once it is
+// approved, or failed when it is denied or waits too long.
+type PromptHeldError struct {
+	HeldID          int64
+	RuleID          int64
+	Pattern         string
+	Prompt          string
+	ReviewChannelID string
+	// Prompts are all the prompts that are generated once approved, when the prompt expanded to more than one
+	Prompts []string
+}
+
+func (e *PromptHeldError) Error() string {
+	return fmt.Sprintf("prompt held for review by moderation rule %d", e.RuleID)
+}
+
+func (e *PromptHeldError) Is(tgt error) bool {
+	_, ok := tgt.(*PromptHeldError)
+
+	return ok
+}
+
+// PromptDeniedError fails a held item that a moderator didn't approve.
+type PromptDeniedError struct{}
+
+func (e *PromptDeniedError) Error() string {
+	return "prompt denied by a moderator"
+}
+
+func (e *PromptDeniedError) Is(tgt error) bool {
+	_, ok := tgt.(*PromptDeniedError)
+
+	return ok
+}
+
+// PromptHoldExpiredError fails a held item that no moderator looked at in time.
+type PromptHoldExpiredError struct{}
+
+func (e *PromptHoldExpiredError) Error() string {
+	return "prompt was not reviewed in time"
+}
+
+func (e *PromptHoldExpiredError) Is(tgt error) bool {
+	_, ok := tgt.(*PromptHoldExpiredError)
+
+	return ok
+}
+
+// HeldPromptNotFoundError is returned for a held prompt that was already decided on, or expired.
+type HeldPromptNotFoundError struct {
+	HeldID int64
+}
+
+func (e *HeldPromptNotFoundError) Error() string {
+	return fmt.Sprintf("held prompt %d not found", e.HeldID)
+}
+
+func (e *HeldPromptNotFoundError) Is(tgt error) bool {
+	_, ok := tgt.(*HeldPromptNotFoundError)
+
+	return ok
+}
+
+// heldPrompt is an item waiting for a moderator.
+type heldPrompt struct {
+	item *QueueItem
+	// expanded are the items of a dynamic prompt whose expansion was held, they are queued as they are once approved
+	expanded []*QueueItem
+	rule     *entities.ModerationRule
+	expiry   *time.Timer
+}
+
+// isModerated reports whether the prompt of the item type is checked, the others reuse a prompt that already was.
+func isModerated(itemType ItemType) bool {
+	return itemType == ItemTypeImagine || itemType == ItemTypePlot || itemType == ItemTypeRemix
+}
+
+// moderationRules returns the rules of the server that apply to the channel.
+func (q *queueImpl) moderationRules(guildID, channelID string) ([]*entities.ModerationRule, error) {
+	rules, err := q.moderationRuleRepo.ListByGuildID(context.Background(), guildID)
+	if err != nil {
+		return nil, err
+	}
+
+	var channelRules []*entities.ModerationRule
+
+	for _, rule := range rules {
+		if rule.ChannelID == "" || rule.ChannelID == channelID {
+			channelRules = append(channelRules, rule)
+		}
+	}
+
+	return channelRules, nil
+}
+
+// moderate checks the prompt of the item with the rules of where it was queued. It returns the terms to add to the
+// negative prompt, or an error when the prompt is rejected or held.
+func (q *queueImpl) moderate(item *QueueItem) ([]string, error) {
+	if item.Source.GuildID == "" || !isModerated(item.Type) {
+		return nil, nil
+	}
+
+	rules, err := q.moderationRules(item.Source.GuildID, item.Source.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+
+	return q.decide(item, moderation.Check(rules, item.Prompt))
+}
+
+// moderateExpanded checks the prompts that dynamic prompts expanded to, the check of the template can't see what
+// wildcards pick. Every prompt is checked before anything is held, so a rejected one rejects them all and each gets the
+// terms of negative rules. When any of the prompts is held, all the items wait for the moderator.
+func (q *queueImpl) moderateExpanded(items []*QueueItem) error {
+	if items[0].Source.GuildID == "" || !isModerated(items[0].Type) {
+		return nil
+	}
+
+	rules, err := q.moderationRules(items[0].Source.GuildID, items[0].Source.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	var (
+		heldItem *QueueItem
+		heldRule *entities.ModerationRule
+	)
+
+	for _, item := range items {
+		// prompts without a template weren't expanded, so they were checked already
+		if item.Options.PromptTemplate == "" || item.Options.PromptTemplate == item.Prompt {
+			continue
+		}
+
+		result := moderation.Check(rules, item.Prompt)
+
+		appendNegativeTerms(&item.Options, result.NegativeTerms())
+
+		// an approved prompt was only held, any other rules still add their terms
+		if result.Action == "" || item.moderationApproved {
+			continue
+		}
+
+		rule := result.Rule()
+
+		switch result.Action {
+		case moderation.ActionReject:
+			q.logModerationDecision(item, rule, DecisionRejected, "", 0)
+
+			return &PromptRejectedError{RuleID: rule.ID}
+		case moderation.ActionHold:
+			if heldItem == nil {
+				heldItem, heldRule = item, rule
+			}
+		case moderation.ActionNegative:
+			q.logModerationDecision(item, rule, DecisionNegative, "", 0)
+		}
+	}
+
+	if heldItem == nil {
+		return nil
+	}
+
+	return q.holdPrompt(heldItem, heldRule, items)
+}
+
+// decide acts on what the rules said about the prompt of the item. It returns the terms to add to the negative prompt,
+// or an error when the prompt is rejected or held.
+func (q *queueImpl) decide(item *QueueItem, result *moderation.Result) ([]string, error) {
+	// an approved prompt was only held, any other rules still add their terms
+	if result.Action == "" || item.moderationApproved {
+		return result.NegativeTerms(), nil
+	}
+
+	rule := result.Rule()
+
+	switch result.Action {
+	case moderation.ActionReject:
+		q.logModerationDecision(item, rule, DecisionRejected, "", 0)
+
+		return nil, &PromptRejectedError{RuleID: rule.ID}
+	case moderation.ActionHold:
+		return nil, q.holdPrompt(item, rule, nil)
+	case moderation.ActionNegative:
+		q.logModerationDecision(item, rule, DecisionNegative, "", 0)
+
+		return result.NegativeTerms(), nil
+	default:
+		// sensitive prompts are let through, their results are routed once they are done
+		return nil, nil
+	}
+}
+
+// appendNegativeTerms adds the terms of negative moderation rules to the negative prompt, unless they are in it.
+func appendNegativeTerms(options *QueueItemOptions, terms []string) {
+	for _, term := range terms {
+		if term == "" || strings.Contains(strings.ToLower(options.NegativePrompt), term) {
+			continue
+		}
+
+		if options.NegativePrompt == "" {
+			options.NegativePrompt = term
+		} else {
+			options.NegativePrompt += ", " + term
+		}
+	}
+}
+
+// holdPrompt keeps the item until a moderator approves or denies it. Servers without a review channel can't review
+// prompts, so they are rejected instead.
+func (q *queueImpl) holdPrompt(item *QueueItem, rule *entities.ModerationRule, expanded []*QueueItem) error {
+	reviewChannelID, err := q.moderationRuleRepo.GetReviewChannel(context.Background(), item.Source.GuildID)
+	if err != nil {
+		return err
+	}
+
+	if reviewChannelID == "" {
+		q.logModerationDecision(item, rule, DecisionRejected, "", 0)
+
+		return &PromptRejectedError{RuleID: rule.ID}
+	}
+
+	decision := q.logModerationDecision(item, rule, DecisionHeld, "", 0)
+	if decision == nil {
+		return fmt.Errorf("could not hold the prompt for review")
+	}
+
+	held := &heldPrompt{
+		item:     item,
+		expanded: expanded,
+		rule:     rule,
+	}
+
+	q.heldMu.Lock()
+	q.heldPrompts[decision.ID] = held
+	held.expiry = time.AfterFunc(maxHoldTime, func() {
+		q.expireHeldPrompt(decision.ID)
+	})
+	q.heldMu.Unlock()
+
+	heldError := &PromptHeldError{
+		HeldID:          decision.ID,
+		RuleID:          rule.ID,
+		Pattern:         rule.Pattern,
+		Prompt:          item.Prompt,
+		ReviewChannelID: reviewChannelID,
+	}
+
+	if len(expanded) > 1 {
+		for _, expandedItem := range expanded {
+			heldError.Prompts = append(heldError.Prompts, expandedItem.Prompt)
+		}
+	}
+
+	return heldError
+}
+
+// takeHeldPrompt removes the held prompt, so only one decision is made about it.
+func (q *queueImpl) takeHeldPrompt(heldID int64) (*heldPrompt, error) {
+	q.heldMu.Lock()
+	defer q.heldMu.Unlock()
+
+	held, ok := q.heldPrompts[heldID]
+	if !ok {
+		return nil, &HeldPromptNotFoundError{HeldID: heldID}
+	}
+
+	delete(q.heldPrompts, heldID)
+
+	held.expiry.Stop()
+
+	return held, nil
+}
+
+// ApproveHeldPrompt queues the held prompt, and returns its position in the queue.
+func (q *queueImpl) ApproveHeldPrompt(heldID int64, moderatorID string) (int, error) {
+	held, err := q.takeHeldPrompt(heldID)
+	if err != nil {
+		return 0, err
+	}
+
+	q.logModerationDecision(held.item, held.rule, DecisionApproved, moderatorID, heldID)
+
+	held.item.moderationApproved = true
+
+	// the expansion was already made, so it is queued as the moderator saw it
+	if held.expanded != nil {
+		return q.enqueue(held.expanded), nil
+	}
+
+	position, err := q.AddImagine(held.item)
+	if err != nil {
+		held.item.Source.Sink.Failed(held.item, err)
+
+		return 0, err
+	}
+
+	return position, nil
+}
+
+// DenyHeldPrompt drops the held prompt, and tells whoever queued it.
+func (q *queueImpl) DenyHeldPrompt(heldID int64, moderatorID string) error {
+	held, err := q.takeHeldPrompt(heldID)
+	if err != nil {
+		return err
+	}
+
+	q.logModerationDecision(held.item, held.rule, DecisionDenied, moderatorID, heldID)
+
+	held.item.Source.Sink.Failed(held.item, &PromptDeniedError{})
+
+	return nil
+}
+
+func (q *queueImpl) expireHeldPrompt(heldID int64) {
+	held, err := q.takeHeldPrompt(heldID)
+	if err != nil {
+		// it was decided on just in time
+		return
+	}
+
+	q.logModerationDecision(held.item, held.rule, DecisionExpired, "", heldID)
+
+	held.item.Source.Sink.Failed(held.item, &PromptHoldExpiredError{})
+}
+
+// logModerationDecision stores what happened to the prompt of the item. Errors are only logged, the decision is made
+// either way.
+func (q *queueImpl) logModerationDecision(item *QueueItem, rule *entities.ModerationRule, decision, moderatorID string,
+	heldID int64,
+) *entities.ModerationDecision {
+	log.Printf("Moderation rule %d: prompt of member %s %s\n", rule.ID, item.Source.MemberID, decision)
+
+	stored, err := q.moderationDecisionRepo.Create(context.Background(), &entities.ModerationDecision{
+		GuildID:     item.Source.GuildID,
+		ChannelID:   item.Source.ChannelID,
+		MemberID:    item.Source.MemberID,
+		Prompt:      item.Prompt,
+		RuleID:      rule.ID,
+		Pattern:     rule.Pattern,
+		Decision:    decision,
+		ModeratorID: moderatorID,
+		HeldID:      heldID,
+	})
+	if err != nil {
+		log.Printf("Error storing moderation decision: %v", err)
+
+		return nil
+	}
+
+	return stored
+}
+
+// CheckPrompt shows what the rules of the channel would do with the prompt, without logging a decision.
+func (q *queueImpl) CheckPrompt(guildID, channelID, prompt string) (*moderation.Result, error) {
+	rules, err := q.moderationRules(guildID, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	return moderation.Check(rules, prompt), nil
+}
+
+func (q *queueImpl) AddModerationRule(rule *entities.ModerationRule) (*entities.ModerationRule, error) {
+	if rule.List == moderation.ListAllow {
+		rule.Action = ""
+	}
+
+	err := moderation.Validate(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	rule, err = q.moderationRuleRepo.Create(context.Background(), rule)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Added moderation rule %d to server %s: %+v\n", rule.ID, rule.GuildID, rule)
+
+	return rule, nil
+}
+
+func (q *queueImpl) ListModerationRules(guildID string) ([]*entities.ModerationRule, error) {
+	return q.moderationRuleRepo.ListByGuildID(context.Background(), guildID)
+}
+
+// DeleteModerationRule removes the rule of the server, and reports whether there was one.
+func (q *queueImpl) DeleteModerationRule(guildID string, id int64) (bool, error) {
+	deleted, err := q.moderationRuleRepo.Delete(context.Background(), guildID, id)
+	if err != nil {
+		return false, err
+	}
+
+	if deleted {
+		log.Printf("Deleted moderation rule %d of server %s\n", id, guildID)
+	}
+
+	return deleted, nil
+}
+
+func (q *queueImpl) GetReviewChannel(guildID string) (string, error) {
+	return q.moderationRuleRepo.GetReviewChannel(context.Background(), guildID)
+}
+
+func (q *queueImpl) SetReviewChannel(guildID, channelID string) error {
+	return q.moderationRuleRepo.SetReviewChannel(context.Background(), guildID, channelID)
+}
+
+// ListModerationDecisions returns the latest decisions of the server first.
+func (q *queueImpl) ListModerationDecisions(guildID string) ([]*entities.ModerationDecision, error) {
+	return q.moderationDecisionRepo.ListByGuildID(context.Background(), guildID, moderationLogLimit)
+}
@@ -0,0 +1,330 @@
+package imagine_queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"stable_diffusion_bot/composite_renderer"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/stable_diffusion_api"
+	"time"
+)
+
+const (
+	outpaintDenoisingStrength = 0.9
+	outpaintMaskBlur          = 8
+
+	// the image is scaled down to at most this size before padding, so a zoomed out canvas stays renderable
+	maxOutpaintSourceSize = 768
+)
+
+type OutpaintDirection string
+
+const (
+	OutpaintZoomOut  OutpaintDirection = "zoom"
+	OutpaintPanLeft  OutpaintDirection = "left"
+	OutpaintPanRight OutpaintDirection = "right"
+	OutpaintPanUp    OutpaintDirection = "up"
+	OutpaintPanDown  OutpaintDirection = "down"
+)
+
+type OutpaintOptions struct {
+	Direction OutpaintDirection
+	// ZoomFactor is how much bigger the canvas gets when zooming out, e.g. 1.5 or 2
+	ZoomFactor float64
+}
+
+// roundDownTo8 rounds down to the nearest multiple of 8, which the model expects for image dimensions
+func roundDownTo8(value int) int {
+	return value &^ 7
+}
+
+// padding returns how much to extend an image of the given size, keeping the canvas dimensions multiples of 8.
+func (o *OutpaintOptions) padding(width, height int) composite_renderer.Padding {
+	switch o.Direction {
+	case OutpaintZoomOut:
+		extraWidth := roundDownTo8(int(float64(width) * (o.ZoomFactor - 1)))
+		extraHeight := roundDownTo8(int(float64(height) * (o.ZoomFactor - 1)))
+
+		left := roundDownTo8(extraWidth / 2)
+		top := roundDownTo8(extraHeight / 2)
+
+		return composite_renderer.Padding{
+			Left:   left,
+			Top:    top,
+			Right:  extraWidth - left,
+			Bottom: extraHeight - top,
+		}
+	case OutpaintPanLeft:
+		return composite_renderer.Padding{Left: roundDownTo8(width / 2)}
+	case OutpaintPanRight:
+		return composite_renderer.Padding{Right: roundDownTo8(width / 2)}
+	case OutpaintPanUp:
+		return composite_renderer.Padding{Top: roundDownTo8(height / 2)}
+	case OutpaintPanDown:
+		return composite_renderer.Padding{Bottom: roundDownTo8(height / 2)}
+	default:
+		return composite_renderer.Padding{}
+	}
+}
+
+// outpaintSourceSize scales the image size down to fit maxOutpaintSourceSize, keeping the aspect ratio.
+func outpaintSourceSize(width, height int) (int, int) {
+	longestSide := max(width, height)
+
+	if longestSide <= maxOutpaintSourceSize {
+		return width, height
+	}
+
+	scale := float64(maxOutpaintSourceSize) / float64(longestSide)
+
+	return roundDownTo8(int(float64(width) * scale)), roundDownTo8(int(float64(height) * scale))
+}
+
+// outpaintLayout returns the size the image is scaled to and how much it is extended, keeping the canvas within the
+// size limit of the profile.
+func outpaintLayout(options *OutpaintOptions, width, height int, profile *entities.ChannelProfile) (int, int, composite_renderer.Padding) {
+	width, height = outpaintSourceSize(width, height)
+	padding := options.padding(width, height)
+
+	if profile == nil {
+		return width, height, padding
+	}
+
+	canvasWidth := width + padding.Left + padding.Right
+	canvasHeight := height + padding.Top + padding.Bottom
+
+	fittedWidth, fittedHeight := fitWithin(canvasWidth, canvasHeight, profile.MaxWidth, profile.MaxHeight)
+	if fittedWidth == canvasWidth && fittedHeight == canvasHeight {
+		return width, height, padding
+	}
+
+	width = roundDownTo8(width * fittedWidth / canvasWidth)
+	height = roundDownTo8(height * fittedHeight / canvasHeight)
+
+	return width, height, options.padding(width, height)
+}
+
+// DownloadImage fetches an image, like the attachment of a message.
+func DownloadImage(url string) (*bytes.Buffer, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status downloading image: %s", response.Status)
+	}
+
+	imageBuf := new(bytes.Buffer)
+
+	_, err = io.Copy(imageBuf, response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return imageBuf, nil
+}
+
+func (q *queueImpl) processOutpaintImagine(imagine *QueueItem) {
+	err := q.outpaintImagine(imagine, imagine.Source.RequestID, imagine.Source.MemberID)
+	if err != nil {
+		log.Printf("Error processing outpaint: %v\n", err)
+
+		imagine.Source.Sink.Failed(imagine, err)
+	}
+}
+
+func (q *queueImpl) outpaintImagine(imagine *QueueItem, interactionID, userID string) error {
+	if imagine.Outpaint == nil {
+		return errors.New("missing outpaint options")
+	}
+
+	generation, err := q.GetPreviousGeneration(imagine, 0)
+	if err != nil {
+		return err
+	}
+
+	profile, err := q.applyProfile(imagine, generation)
+	if err != nil {
+		return err
+	}
+
+	archivedImage := q.archivedImage(generation)
+
+	if archivedImage == nil && imagine.Source.ImageURL == "" {
+		return errors.New("no image to outpaint")
+	}
+
+	resultMessageID, err := q.startJob(imagine, JobStatus{Generation: generation})
+	if err != nil {
+		return err
+	}
+
+	// the archived image saves downloading it again, and still works when the attachment is gone
+	sourceImage := bytes.NewBuffer(archivedImage)
+
+	if archivedImage == nil {
+		sourceImage, err = DownloadImage(imagine.Source.ImageURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	sourceWidth, sourceHeight, padding := outpaintLayout(imagine.Outpaint, generation.Width, generation.Height, profile)
+
+	canvas, mask, err := q.compositeRenderer.OutpaintCanvas(sourceImage, sourceWidth, sourceHeight, padding)
+	if err != nil {
+		return err
+	}
+
+	canvasWidth := sourceWidth + padding.Left + padding.Right
+	canvasHeight := sourceHeight + padding.Top + padding.Bottom
+
+	generationDone := make(chan bool)
+
+	go func() {
+		for {
+			select {
+			case <-generationDone:
+				return
+			case <-time.After(1 * time.Second):
+				progress, progressErr := q.stableDiffusionAPI.GetCurrentProgress()
+				if progressErr != nil {
+					log.Printf("Error getting current progress: %v", progressErr)
+
+					return
+				}
+
+				if progress.Progress == 0 {
+					continue
+				}
+
+				progressErr = imagine.Source.Sink.Progress(imagine, JobStatus{
+					Generation: generation,
+					Progress:   progress.Progress,
+				})
+				if progressErr != nil {
+					log.Printf("Error reporting progress: %v", progressErr)
+				}
+			}
+		}
+	}()
+
+	resp, err := q.stableDiffusionAPI.ImageToImage(&stable_diffusion_api.ImageToImageRequest{
+		InitImages:        []string{base64.StdEncoding.EncodeToString(canvas.Bytes())},
+		Mask:              base64.StdEncoding.EncodeToString(mask.Bytes()),
+		MaskBlur:          outpaintMaskBlur,
+		InpaintingFill:    stable_diffusion_api.InpaintingFillOriginal,
+		Prompt:            generation.Prompt,
+		NegativePrompt:    generation.NegativePrompt,
+		Width:             canvasWidth,
+		Height:            canvasHeight,
+		RestoreFaces:      generation.RestoreFaces,
+		DenoisingStrength: outpaintDenoisingStrength,
+		BatchSize:         1,
+		Seed:              generation.Seed,
+		Subseed:           generation.Subseed,
+		SubseedStrength:   generation.SubseedStrength,
+		SamplerName:       generation.SamplerName,
+		CfgScale:          generation.CfgScale,
+		Steps:             generation.Steps,
+		NIter:             1,
+		Styles:            generationStyles(generation),
+		SaveImages:        true,
+		OverrideSettings: stable_diffusion_api.Txt2ImgOverrideSettings{
+			SamplesFormat:     "webp",
+			SDModelCheckpoint: generation.Checkpoint,
+		},
+	})
+
+	// closed rather than sent to, the progress loop stops by itself when the progress can't be fetched
+	close(generationDone)
+
+	if err != nil {
+		return err
+	}
+
+	if len(resp.Images) == 0 {
+		return errors.New("no image returned")
+	}
+
+	decodedImage, err := base64.StdEncoding.DecodeString(resp.Images[0])
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Successfully outpainted image: %v, Direction: %v", interactionID, imagine.Outpaint.Direction)
+
+	storedImage := q.archive(decodedImage)
+
+	// the outpainted image is a child of the one it was extended from, stored before the result is posted so the events
+	// of the job have it
+	outpaintedGeneration := *generation
+	outpaintedGeneration.ID = 0
+	outpaintedGeneration.ParentID = generation.ID
+	outpaintedGeneration.InteractionID = interactionID
+	outpaintedGeneration.MessageID = resultMessageID
+	outpaintedGeneration.MemberID = userID
+	outpaintedGeneration.GuildID = imagine.Source.GuildID
+	outpaintedGeneration.ChannelID = imagine.Source.ChannelID
+	outpaintedGeneration.SortOrder = 0
+	outpaintedGeneration.Width = canvasWidth
+	outpaintedGeneration.Height = canvasHeight
+	outpaintedGeneration.EnableHR = false
+	outpaintedGeneration.HiresWidth = 0
+	outpaintedGeneration.HiresHeight = 0
+	outpaintedGeneration.DenoisingStrength = outpaintDenoisingStrength
+	outpaintedGeneration.BatchCount = 1
+	outpaintedGeneration.BatchSize = 1
+	outpaintedGeneration.Processed = true
+	outpaintedGeneration.ImagePath = ""
+	outpaintedGeneration.ImageHash = ""
+
+	if len(resp.Seeds) > 0 {
+		outpaintedGeneration.Seed = resp.Seeds[0]
+	}
+
+	if len(resp.Subseeds) > 0 {
+		outpaintedGeneration.Subseed = resp.Subseeds[0]
+	}
+
+	outpaintedGeneration.Infotext = ""
+	if len(resp.Infotexts) > 0 {
+		outpaintedGeneration.Infotext = resp.Infotexts[0]
+	}
+
+	setArchivedImage(&outpaintedGeneration, storedImage)
+
+	_, err = q.imageGenerationRepo.Create(context.Background(), &outpaintedGeneration)
+	if err != nil {
+		log.Printf("Error creating image generation record: %v\n", err)
+	}
+
+	err = q.finishJob(imagine, &JobResult{
+		Generation: generation,
+		Model:      resp.Model,
+		Images: []ResultImage{
+			{
+				ContentType: "image/png",
+				Name:        fmt.Sprintf("outpaint-seed-%d.png", generation.Seed),
+				Data:        decodedImage,
+				URL:         q.archivedImageURL(storedImage),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
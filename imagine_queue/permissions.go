@@ -0,0 +1,147 @@
+package imagine_queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"stable_diffusion_bot/entities"
+)
+
+// The capabilities a server can grant to roles and members. Bypass quota is stored like the others, but has no effect
+// until the bot has quotas.
+const (
+	CapabilityChangeModel        = "change_model"
+	CapabilityEditServerDefaults = "edit_server_defaults"
+	CapabilityUseHires           = "use_hires"
+	CapabilityExceedBatchLimits  = "exceed_batch_limits"
+	CapabilityBypassQuota        = "bypass_quota"
+	CapabilityModerate           = "moderate"
+)
+
+var Capabilities = []string{
+	CapabilityChangeModel,
+	CapabilityEditServerDefaults,
+	CapabilityUseHires,
+	CapabilityExceedBatchLimits,
+	CapabilityBypassQuota,
+	CapabilityModerate,
+}
+
+const (
+	PermissionSubjectRole = "role"
+	PermissionSubjectUser = "user"
+)
+
+// CapabilityLabel names the capability for people.
+func CapabilityLabel(capability string) string {
+	switch capability {
+	case CapabilityChangeModel:
+		return "Change model"
+	case CapabilityEditServerDefaults:
+		return "Edit server defaults"
+	case CapabilityUseHires:
+		return "Use hires fix"
+	case CapabilityExceedBatchLimits:
+		return "Exceed batch limits"
+	case CapabilityBypassQuota:
+		return "Bypass quota"
+	case CapabilityModerate:
+		return "Moderate"
+	default:
+		return capability
+	}
+}
+
+// CapabilityCheck is what a server granted of a capability to a member.
+type CapabilityCheck struct {
+	// Granted is set when the member, or one of their roles, has the capability
+	Granted bool
+	// Configured is set when the server granted the capability to anyone. Frontends fall back to their own rules for
+	// capabilities that aren't, so servers work as before until they set them up.
+	Configured bool
+}
+
+func validatePermissionGrant(grant *entities.PermissionGrant) error {
+	if !slices.Contains(Capabilities, grant.Capability) {
+		return &InvalidSettingError{Setting: "capability", Reason: fmt.Sprintf("unknown capability %q", grant.Capability)}
+	}
+
+	if grant.SubjectType != PermissionSubjectRole && grant.SubjectType != PermissionSubjectUser {
+		return &InvalidSettingError{Setting: "subject", Reason: "must be a role or a user"}
+	}
+
+	if grant.GuildID == "" || grant.SubjectID == "" {
+		return &InvalidSettingError{Setting: "subject", Reason: "must be a role or a user of a server"}
+	}
+
+	return nil
+}
+
+// GrantPermission gives the role or user the capability, and reports whether they didn't have it yet.
+func (q *queueImpl) GrantPermission(grant *entities.PermissionGrant) (bool, error) {
+	err := validatePermissionGrant(grant)
+	if err != nil {
+		return false, err
+	}
+
+	added, err := q.permissionRepo.Grant(context.Background(), grant)
+	if err != nil {
+		return false, err
+	}
+
+	if added {
+		log.Printf("Granted %s to %s %s in server %s\n", grant.Capability, grant.SubjectType, grant.SubjectID, grant.GuildID)
+	}
+
+	return added, nil
+}
+
+// RevokePermission takes the capability from the role or user, and reports whether they had it.
+func (q *queueImpl) RevokePermission(guildID, capability, subjectType, subjectID string) (bool, error) {
+	revoked, err := q.permissionRepo.Revoke(context.Background(), guildID, capability, subjectType, subjectID)
+	if err != nil {
+		return false, err
+	}
+
+	if revoked {
+		log.Printf("Revoked %s from %s %s in server %s\n", capability, subjectType, subjectID, guildID)
+	}
+
+	return revoked, nil
+}
+
+func (q *queueImpl) ListPermissions(guildID string) ([]*entities.PermissionGrant, error) {
+	return q.permissionRepo.ListByGuildID(context.Background(), guildID)
+}
+
+// CheckCapability looks up whether the server granted the capability to the member, or to one of their roles.
+func (q *queueImpl) CheckCapability(guildID, memberID string, roleIDs []string, capability string) (CapabilityCheck, error) {
+	var check CapabilityCheck
+
+	if guildID == "" {
+		return check, nil
+	}
+
+	grants, err := q.permissionRepo.ListByGuildID(context.Background(), guildID)
+	if err != nil {
+		return check, err
+	}
+
+	for _, grant := range grants {
+		if grant.Capability != capability {
+			continue
+		}
+
+		check.Configured = true
+
+		switch grant.SubjectType {
+		case PermissionSubjectUser:
+			check.Granted = check.Granted || grant.SubjectID == memberID
+		case PermissionSubjectRole:
+			check.Granted = check.Granted || slices.Contains(roleIDs, grant.SubjectID)
+		}
+	}
+
+	return check, nil
+}
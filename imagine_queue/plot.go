@@ -0,0 +1,469 @@
+package imagine_queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/stable_diffusion_api"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxPlotAxes = 3
+
+	// every cell can be picked for upscaling from a select menu, which holds at most 25 options
+	maxPlotCells = 25
+)
+
+type PlotAxisType string
+
+const (
+	PlotAxisCFGScale      PlotAxisType = "cfg"
+	PlotAxisSteps         PlotAxisType = "steps"
+	PlotAxisSampler       PlotAxisType = "sampler"
+	PlotAxisSeed          PlotAxisType = "seed"
+	PlotAxisCheckpoint    PlotAxisType = "checkpoint"
+	PlotAxisPromptReplace PlotAxisType = "prompt_sr"
+)
+
+// PlotAxisTypes lists the axis types in the order they are offered to users.
+var PlotAxisTypes = []PlotAxisType{
+	PlotAxisCFGScale,
+	PlotAxisSteps,
+	PlotAxisSampler,
+	PlotAxisSeed,
+	PlotAxisCheckpoint,
+	PlotAxisPromptReplace,
+}
+
+// Label is the human readable name of the axis type, used in the grid headers.
+func (t PlotAxisType) Label() string {
+	switch t {
+	case PlotAxisCFGScale:
+		return "CFG Scale"
+	case PlotAxisSteps:
+		return "Steps"
+	case PlotAxisSampler:
+		return "Sampler"
+	case PlotAxisSeed:
+		return "Seed"
+	case PlotAxisCheckpoint:
+		return "Checkpoint"
+	case PlotAxisPromptReplace:
+		return "Prompt S/R"
+	default:
+		return string(t)
+	}
+}
+
+// PlotAxis is one dimension of a plot. For prompt S/R, the first value is searched for in the prompt, and replaced
+// with each of the values in turn.
+type PlotAxis struct {
+	Type   PlotAxisType
+	Values []string
+}
+
+type PlotOptions struct {
+	Axes []PlotAxis
+}
+
+// InvalidPlotAxisError is returned when a plot axis has an unknown type or a value that doesn't fit it.
+type InvalidPlotAxisError struct {
+	Axis   PlotAxisType
+	Value  string
+	Reason string
+}
+
+func (e *InvalidPlotAxisError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("invalid %s axis: %s", e.Axis.Label(), e.Reason)
+	}
+
+	return fmt.Sprintf("invalid value \"%s\" for the %s axis: %s", e.Value, e.Axis.Label(), e.Reason)
+}
+
+func (e *InvalidPlotAxisError) Is(err error) bool {
+	_, ok := err.(*InvalidPlotAxisError)
+	return ok
+}
+
+// ParsePlotAxisValues splits a comma separated list of axis values.
+func ParsePlotAxisValues(values string) []string {
+	var parsed []string
+
+	for _, value := range strings.Split(values, ",") {
+		value = strings.TrimSpace(value)
+
+		if value != "" {
+			parsed = append(parsed, value)
+		}
+	}
+
+	return parsed
+}
+
+func (p *PlotOptions) validate(prompt string) error {
+	if len(p.Axes) == 0 {
+		return &InvalidPlotAxisError{Reason: "at least one axis is required"}
+	}
+
+	if len(p.Axes) > maxPlotAxes {
+		return &InvalidPlotAxisError{Reason: fmt.Sprintf("at most %d axes are supported", maxPlotAxes)}
+	}
+
+	cells := 1
+
+	for _, axis := range p.Axes {
+		if len(axis.Values) == 0 {
+			return &InvalidPlotAxisError{Axis: axis.Type, Reason: "no values"}
+		}
+
+		for _, value := range axis.Values {
+			err := axis.validateValue(value)
+			if err != nil {
+				return err
+			}
+		}
+
+		if axis.Type == PlotAxisPromptReplace && !strings.Contains(prompt, axis.Values[0]) {
+			return &InvalidPlotAxisError{Axis: axis.Type, Value: axis.Values[0], Reason: "not found in the prompt"}
+		}
+
+		cells *= len(axis.Values)
+	}
+
+	if cells > maxPlotCells {
+		return &InvalidPlotAxisError{Reason: fmt.Sprintf("%d cells is more than the limit of %d", cells, maxPlotCells)}
+	}
+
+	return nil
+}
+
+func (a PlotAxis) validateValue(value string) error {
+	switch a.Type {
+	case PlotAxisCFGScale:
+		cfgScale, err := strconv.ParseFloat(value, 64)
+		if err != nil || cfgScale < minCFGScale || cfgScale > maxCFGScale {
+			return &InvalidPlotAxisError{Axis: a.Type, Value: value,
+				Reason: fmt.Sprintf("must be a number between %d and %d", minCFGScale, maxCFGScale)}
+		}
+	case PlotAxisSteps:
+		steps, err := strconv.Atoi(value)
+		if err != nil || steps < minSteps || steps > maxSteps {
+			return &InvalidPlotAxisError{Axis: a.Type, Value: value,
+				Reason: fmt.Sprintf("must be a whole number between %d and %d", minSteps, maxSteps)}
+		}
+	case PlotAxisSeed:
+		seed, err := strconv.Atoi(value)
+		if err != nil || seed < minSeed || seed > maxSeed {
+			return &InvalidPlotAxisError{Axis: a.Type, Value: value,
+				Reason: fmt.Sprintf("must be a whole number between %d and %d", minSeed, maxSeed)}
+		}
+	case PlotAxisSampler, PlotAxisCheckpoint, PlotAxisPromptReplace:
+	default:
+		return &InvalidPlotAxisError{Axis: a.Type, Reason: "unknown axis type"}
+	}
+
+	return nil
+}
+
+// apply sets the axis value on the cell's generation. Values have already been validated.
+func (a PlotAxis) apply(generation *entities.ImageGeneration, value string) {
+	switch a.Type {
+	case PlotAxisCFGScale:
+		generation.CfgScale, _ = strconv.ParseFloat(value, 64)
+	case PlotAxisSteps:
+		generation.Steps, _ = strconv.Atoi(value)
+	case PlotAxisSampler:
+		generation.SamplerName = value
+	case PlotAxisSeed:
+		generation.Seed, _ = strconv.Atoi(value)
+	case PlotAxisCheckpoint:
+		generation.Checkpoint = value
+	case PlotAxisPromptReplace:
+		generation.Prompt = strings.ReplaceAll(generation.Prompt, a.Values[0], value)
+	}
+}
+
+func (a PlotAxis) label(value string) string {
+	return a.Type.Label() + ": " + value
+}
+
+// plotCell is one image of the plot, with the index of the value it uses on each axis.
+type plotCell struct {
+	sortOrder  int
+	valueIndex [maxPlotAxes]int
+}
+
+// cells returns the plot cells, with the first axis changing fastest.
+func (p *PlotOptions) cells() []plotCell {
+	cells := []plotCell{{}}
+
+	for axisIdx := len(p.Axes) - 1; axisIdx >= 0; axisIdx-- {
+		var expanded []plotCell
+
+		for _, cell := range cells {
+			for valueIdx := range p.Axes[axisIdx].Values {
+				newCell := cell
+				newCell.valueIndex[axisIdx] = valueIdx
+
+				expanded = append(expanded, newCell)
+			}
+		}
+
+		cells = expanded
+	}
+
+	for idx := range cells {
+		cells[idx].sortOrder = idx + 1
+	}
+
+	return cells
+}
+
+func (p *PlotOptions) cellLabel(cell plotCell) string {
+	labels := make([]string, len(p.Axes))
+
+	for axisIdx, axis := range p.Axes {
+		labels[axisIdx] = axis.label(axis.Values[cell.valueIndex[axisIdx]])
+	}
+
+	return strings.Join(labels, ", ")
+}
+
+func (q *queueImpl) processPlotImagine(imagine *QueueItem) {
+	interactionID := imagine.Source.RequestID
+	userID := imagine.Source.MemberID
+
+	baseGeneration, err := q.newGenerationFromOptions(imagine)
+	if err != nil {
+		log.Printf("Error creating generation from options: %v", err)
+
+		imagine.Source.Sink.Failed(imagine, err)
+
+		return
+	}
+
+	profile, err := q.applyProfile(imagine, baseGeneration)
+	if err != nil {
+		log.Printf("Error getting channel profile: %v", err)
+
+		imagine.Source.Sink.Failed(imagine, err)
+
+		return
+	}
+
+	if imagine.Source.HiresDenied {
+		withoutHires(baseGeneration)
+	}
+
+	// every cell uses the same seed unless it is plotted, so only the plotted parameters differ
+	if baseGeneration.Seed == -1 {
+		baseGeneration.Seed = int(rand.Int31())
+	}
+
+	plot := imagine.Plot
+	cells := plot.cells()
+
+	startTime := time.Now()
+
+	log.Printf("Processing plot #%s: %v, %d cells\n", interactionID, baseGeneration.Prompt, len(cells))
+
+	messageID, err := q.startJob(imagine, JobStatus{Generation: baseGeneration, CellsTotal: len(cells)})
+	if err != nil {
+		log.Printf("Error starting plot: %v", err)
+
+		return
+	}
+
+	baseGeneration.InteractionID = interactionID
+	baseGeneration.MessageID = messageID
+	baseGeneration.MemberID = userID
+	baseGeneration.GuildID = imagine.Source.GuildID
+	baseGeneration.ChannelID = imagine.Source.ChannelID
+	baseGeneration.SortOrder = 0
+	baseGeneration.BatchCount = 1
+	baseGeneration.BatchSize = 1
+	baseGeneration.Processed = true
+
+	_, err = q.imageGenerationRepo.Create(context.Background(), baseGeneration)
+	if err != nil {
+		log.Printf("Error creating image generation record: %v\n", err)
+	}
+
+	// images are kept per Z value, then per row (Y value) and column (X value)
+	zCount := 1
+	yCount := 1
+
+	if len(plot.Axes) > 2 {
+		zCount = len(plot.Axes[2].Values)
+	}
+
+	if len(plot.Axes) > 1 {
+		yCount = len(plot.Axes[1].Values)
+	}
+
+	grids := make([][][]*bytes.Buffer, zCount)
+	for zIdx := range grids {
+		grids[zIdx] = make([][]*bytes.Buffer, yCount)
+
+		for yIdx := range grids[zIdx] {
+			grids[zIdx][yIdx] = make([]*bytes.Buffer, len(plot.Axes[0].Values))
+		}
+	}
+
+	plotCells := make([]PlotCell, 0, len(cells))
+
+	for cellIdx, cell := range cells {
+		cellGeneration := *baseGeneration
+		cellGeneration.ID = 0
+		cellGeneration.SortOrder = cell.sortOrder
+
+		for axisIdx, axis := range plot.Axes {
+			axis.apply(&cellGeneration, axis.Values[cell.valueIndex[axisIdx]])
+		}
+
+		// the axes were checked against the profile when the plot was queued, but the profile may have changed since
+		if profile != nil {
+			applyChannelProfile(&cellGeneration, profile)
+		}
+
+		resp, err := q.stableDiffusionAPI.TextToImage(&stable_diffusion_api.TextToImageRequest{
+			Prompt:            cellGeneration.Prompt,
+			NegativePrompt:    cellGeneration.NegativePrompt,
+			Width:             cellGeneration.Width,
+			Height:            cellGeneration.Height,
+			RestoreFaces:      cellGeneration.RestoreFaces,
+			EnableHR:          cellGeneration.EnableHR,
+			HRResizeX:         cellGeneration.HiresWidth,
+			HRResizeY:         cellGeneration.HiresHeight,
+			DenoisingStrength: cellGeneration.DenoisingStrength,
+			BatchSize:         1,
+			Seed:              cellGeneration.Seed,
+			Subseed:           cellGeneration.Subseed,
+			SubseedStrength:   cellGeneration.SubseedStrength,
+			SamplerName:       cellGeneration.SamplerName,
+			CfgScale:          cellGeneration.CfgScale,
+			Steps:             cellGeneration.Steps,
+			NIter:             1,
+			Styles:            generationStyles(&cellGeneration),
+			SaveImages:        true,
+			OverrideSettings: stable_diffusion_api.Txt2ImgOverrideSettings{
+				SamplesFormat:     "webp",
+				SDModelCheckpoint: cellGeneration.Checkpoint,
+			},
+		})
+		if err == nil && len(resp.Images) == 0 {
+			err = errors.New("no image returned")
+		}
+
+		if err != nil {
+			log.Printf("Error processing plot cell %d: %v\n", cell.sortOrder, err)
+
+			imagine.Source.Sink.Failed(imagine, err)
+
+			return
+		}
+
+		decodedImage, decodeErr := base64.StdEncoding.DecodeString(resp.Images[0])
+		if decodeErr != nil {
+			log.Printf("Error decoding image: %v\n", decodeErr)
+
+			imagine.Source.Sink.Failed(imagine, decodeErr)
+
+			return
+		}
+
+		grids[cell.valueIndex[2]][cell.valueIndex[1]][cell.valueIndex[0]] = bytes.NewBuffer(decodedImage)
+
+		if len(resp.Seeds) > 0 {
+			cellGeneration.Seed = resp.Seeds[0]
+		}
+
+		if len(resp.Subseeds) > 0 {
+			cellGeneration.Subseed = resp.Subseeds[0]
+		}
+
+		if len(resp.Infotexts) > 0 {
+			cellGeneration.Infotext = resp.Infotexts[0]
+		}
+
+		q.archiveImage(&cellGeneration, decodedImage)
+
+		_, createErr := q.imageGenerationRepo.Create(context.Background(), &cellGeneration)
+		if createErr != nil {
+			log.Printf("Error creating image generation record: %v\n", createErr)
+		}
+
+		plotCells = append(plotCells, PlotCell{
+			SortOrder: cell.sortOrder,
+			Label:     plot.cellLabel(cell),
+		})
+
+		err = imagine.Source.Sink.Progress(imagine, JobStatus{
+			Generation: baseGeneration,
+			CellsDone:  cellIdx + 1,
+			CellsTotal: len(cells),
+		})
+		if err != nil {
+			log.Printf("Error reporting progress: %v\n", err)
+		}
+	}
+
+	columnLabels := make([]string, len(plot.Axes[0].Values))
+	for idx, value := range plot.Axes[0].Values {
+		columnLabels[idx] = plot.Axes[0].label(value)
+	}
+
+	var rowLabels []string
+
+	if len(plot.Axes) > 1 {
+		for _, value := range plot.Axes[1].Values {
+			rowLabels = append(rowLabels, plot.Axes[1].label(value))
+		}
+	}
+
+	images := make([]ResultImage, 0, zCount)
+
+	for zIdx, grid := range grids {
+		title := ""
+		if len(plot.Axes) > 2 {
+			title = plot.Axes[2].label(plot.Axes[2].Values[zIdx])
+		}
+
+		gridImage, renderErr := q.compositeRenderer.LabelledGrid(grid, columnLabels, rowLabels, title)
+		if renderErr != nil {
+			log.Printf("Error rendering plot grid: %v\n", renderErr)
+
+			imagine.Source.Sink.Failed(imagine, renderErr)
+
+			return
+		}
+
+		// the grids have no generation of their own, they are only archived to be linked to
+		images = append(images, ResultImage{
+			ContentType: "image/png",
+			Name:        fmt.Sprintf("plot-%d-%d.png", baseGeneration.Seed, zIdx+1),
+			Data:        gridImage.Bytes(),
+			URL:         q.archivedImageURL(q.archive(gridImage.Bytes())),
+		})
+	}
+
+	err = q.finishJob(imagine, &JobResult{
+		Generation: baseGeneration,
+		Images:     images,
+		PlotCells:  plotCells,
+	})
+	if err != nil {
+		log.Printf("Error posting plot result: %v\n", err)
+	}
+
+	log.Printf("Finished plot #%s in %v\n", interactionID, time.Since(startTime).Round(time.Second))
+}
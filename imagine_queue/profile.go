@@ -0,0 +1,244 @@
+package imagine_queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/repositories"
+	"strings"
+)
+
+const (
+	minProfileSize = 64
+	maxProfileSize = 2048
+)
+
+// fitWithin scales the size down, keeping the aspect ratio, until it is no bigger than the maximum. A maximum of 0
+// is no limit.
+func fitWithin(width, height, maxWidth, maxHeight int) (int, int) {
+	scale := 1.0
+
+	if maxWidth > 0 && width > maxWidth {
+		scale = min(scale, float64(maxWidth)/float64(width))
+	}
+
+	if maxHeight > 0 && height > maxHeight {
+		scale = min(scale, float64(maxHeight)/float64(height))
+	}
+
+	if scale == 1 {
+		return width, height
+	}
+
+	return roundDownTo8(int(float64(width) * scale)), roundDownTo8(int(float64(height) * scale))
+}
+
+func profileAllowsSampler(profile *entities.ChannelProfile, samplerName string) bool {
+	if len(profile.AllowedSamplers) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(profile.AllowedSamplers, func(sampler string) bool {
+		return strings.EqualFold(sampler, samplerName)
+	})
+}
+
+// applyChannelProfile changes the generation to stay within the limits of the channel, and returns what was changed.
+func applyChannelProfile(generation *entities.ImageGeneration, profile *entities.ChannelProfile) []string {
+	var changes []string
+
+	if profile.Model != "" && generation.Checkpoint != profile.Model {
+		generation.Checkpoint = profile.Model
+
+		changes = append(changes, "model "+profile.Model)
+	}
+
+	if profile.NegativePrompt != "" && !strings.Contains(generation.NegativePrompt, profile.NegativePrompt) {
+		if generation.NegativePrompt == "" {
+			generation.NegativePrompt = profile.NegativePrompt
+		} else {
+			generation.NegativePrompt += ", " + profile.NegativePrompt
+		}
+
+		changes = append(changes, "negative prompt")
+	}
+
+	if !profileAllowsSampler(profile, generation.SamplerName) {
+		generation.SamplerName = profile.AllowedSamplers[0]
+
+		changes = append(changes, "sampler "+generation.SamplerName)
+	}
+
+	width, height := fitWithin(generation.Width, generation.Height, profile.MaxWidth, profile.MaxHeight)
+	if width != generation.Width || height != generation.Height {
+		generation.Width = width
+		generation.Height = height
+
+		changes = append(changes, fmt.Sprintf("size %dx%d", width, height))
+	}
+
+	if generation.EnableHR {
+		hiresWidth, hiresHeight := fitWithin(generation.HiresWidth, generation.HiresHeight, profile.MaxWidth, profile.MaxHeight)
+
+		if hiresWidth <= generation.Width && hiresHeight <= generation.Height {
+			// nothing is left to upscale
+			generation.EnableHR = false
+			generation.HiresWidth = 0
+			generation.HiresHeight = 0
+
+			changes = append(changes, "hires fix off")
+		} else if hiresWidth != generation.HiresWidth || hiresHeight != generation.HiresHeight {
+			generation.HiresWidth = hiresWidth
+			generation.HiresHeight = hiresHeight
+
+			changes = append(changes, fmt.Sprintf("hires size %dx%d", hiresWidth, hiresHeight))
+		}
+	}
+
+	if profile.MaxBatch > 0 && generation.BatchCount*generation.BatchSize > profile.MaxBatch {
+		generation.BatchSize = min(generation.BatchSize, profile.MaxBatch)
+		generation.BatchCount = profile.MaxBatch / generation.BatchSize
+
+		changes = append(changes, fmt.Sprintf("batch %dx%d", generation.BatchCount, generation.BatchSize))
+	}
+
+	return changes
+}
+
+// channelProfile returns the profile of the channel the item was queued in, or nil when the channel has none. The
+// batch limit is left out for members who may exceed it.
+func (q *queueImpl) channelProfile(imagine *QueueItem) (*entities.ChannelProfile, error) {
+	profile, err := q.GetChannelProfile(imagine.Source.ChannelID)
+	if err != nil || profile == nil {
+		return nil, err
+	}
+
+	if imagine.Source.IgnoreBatchLimit {
+		profile.MaxBatch = 0
+	}
+
+	return profile, nil
+}
+
+// applyProfile keeps the generation within the profile of the channel the item was queued in, and returns the
+// profile. Every kind of job applies it, so the channel's limits hold whatever the member asked for.
+func (q *queueImpl) applyProfile(imagine *QueueItem, generation *entities.ImageGeneration) (*entities.ChannelProfile, error) {
+	profile, err := q.channelProfile(imagine)
+	if err != nil || profile == nil {
+		return nil, err
+	}
+
+	if changes := applyChannelProfile(generation, profile); len(changes) > 0 {
+		log.Printf("Applied profile of channel %s: %s", profile.ChannelID, strings.Join(changes, ", "))
+	}
+
+	return profile, nil
+}
+
+// profileUpscaleFactor lowers the upscale factor until the upscaled image fits the size limit of the profile.
+func profileUpscaleFactor(generation *entities.ImageGeneration, profile *entities.ChannelProfile, factor float64) float64 {
+	if profile == nil {
+		return factor
+	}
+
+	width, height := shownSize(generation)
+
+	if profile.MaxWidth > 0 && width > 0 {
+		factor = min(factor, float64(roundDownTo8(profile.MaxWidth))/float64(width))
+	}
+
+	if profile.MaxHeight > 0 && height > 0 {
+		factor = min(factor, float64(roundDownTo8(profile.MaxHeight))/float64(height))
+	}
+
+	return factor
+}
+
+// validateProfile checks that the plot only uses the checkpoint and samplers the channel allows.
+func (p *PlotOptions) validateProfile(profile *entities.ChannelProfile) error {
+	if profile == nil {
+		return nil
+	}
+
+	for _, axis := range p.Axes {
+		for _, value := range axis.Values {
+			switch {
+			case axis.Type == PlotAxisCheckpoint && profile.Model != "" && value != profile.Model:
+				return &InvalidPlotAxisError{Axis: axis.Type, Value: value,
+					Reason: "this channel only uses " + profile.Model}
+			case axis.Type == PlotAxisSampler && !profileAllowsSampler(profile, value):
+				return &InvalidPlotAxisError{Axis: axis.Type, Value: value,
+					Reason: "this channel only allows " + strings.Join(profile.AllowedSamplers, ", ")}
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateChannelProfile(profile *entities.ChannelProfile) error {
+	for _, size := range []int{profile.MaxWidth, profile.MaxHeight} {
+		if size != 0 && (size < minProfileSize || size > maxProfileSize) {
+			return &InvalidSettingError{Setting: "max resolution",
+				Reason: fmt.Sprintf("must be between %d and %d", minProfileSize, maxProfileSize)}
+		}
+	}
+
+	if profile.MaxBatch < 0 || profile.MaxBatch > maxBatch {
+		return &InvalidSettingError{Setting: "max batch", Reason: fmt.Sprintf("must be between %d and %d", minBatch, maxBatch)}
+	}
+
+	return nil
+}
+
+// GetChannelProfile returns the profile of the channel, or nil when the channel has none.
+func (q *queueImpl) GetChannelProfile(channelID string) (*entities.ChannelProfile, error) {
+	if channelID == "" {
+		return nil, nil
+	}
+
+	profile, err := q.channelProfileRepo.GetByChannelID(context.Background(), channelID)
+	if err != nil {
+		if errors.Is(err, &repositories.NotFoundError{}) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+func (q *queueImpl) ListChannelProfiles(guildID string) ([]*entities.ChannelProfile, error) {
+	return q.channelProfileRepo.ListByGuildID(context.Background(), guildID)
+}
+
+func (q *queueImpl) UpdateChannelProfile(profile *entities.ChannelProfile) (*entities.ChannelProfile, error) {
+	err := validateChannelProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err = q.channelProfileRepo.Upsert(context.Background(), profile)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Updated profile of channel %s: %+v\n", profile.ChannelID, profile)
+
+	return profile, nil
+}
+
+func (q *queueImpl) DeleteChannelProfile(channelID string) error {
+	err := q.channelProfileRepo.Delete(context.Background(), channelID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deleted profile of channel %s\n", channelID)
+
+	return nil
+}
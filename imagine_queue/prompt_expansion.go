@@ -0,0 +1,339 @@
+package imagine_queue
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxExpansionDepth stops wildcard files that reference each other from recursing forever
+	maxExpansionDepth = 10
+
+	// maxCombinatorialPrompts is how many queue items a single combinatorial prompt can fan out into
+	maxCombinatorialPrompts = 10
+)
+
+// UnknownWildcardError is returned when a prompt references a "__wildcard__" that has no file.
+type UnknownWildcardError struct {
+	Name string
+}
+
+func (e *UnknownWildcardError) Error() string {
+	return fmt.Sprintf("unknown wildcard __%s__", e.Name)
+}
+
+func (e *UnknownWildcardError) Is(err error) bool {
+	_, ok := err.(*UnknownWildcardError)
+	return ok
+}
+
+// PromptTemplateError is returned when a dynamic prompt can't be expanded, like unbalanced braces.
+type PromptTemplateError struct {
+	Reason string
+}
+
+func (e *PromptTemplateError) Error() string {
+	return fmt.Sprintf("invalid dynamic prompt: %s", e.Reason)
+}
+
+func (e *PromptTemplateError) Is(err error) bool {
+	_, ok := err.(*PromptTemplateError)
+	return ok
+}
+
+// IsPromptError reports whether the error was caused by the user's prompt, and should be shown back to them.
+func IsPromptError(err error) bool {
+	return errors.Is(err, &UnknownFlagError{}) ||
+		errors.Is(err, &InvalidFlagValueError{}) ||
+		errors.Is(err, &UnknownWildcardError{}) ||
+		errors.Is(err, &PromptTemplateError{}) ||
+		errors.Is(err, &InvalidPlotAxisError{})
+}
+
+var wildcardRegex = regexp.MustCompile(`__([\w\-/]+)__`)
+
+// isDynamicPrompt reports whether the prompt has alternations or wildcards to expand.
+func isDynamicPrompt(prompt string) bool {
+	return strings.ContainsAny(prompt, "{}") || wildcardRegex.MatchString(prompt)
+}
+
+// promptNode is one part of a parsed dynamic prompt. Exactly one of the fields is set.
+type promptNode struct {
+	text         string
+	wildcard     string
+	alternatives [][]promptNode
+}
+
+type promptExpander struct {
+	wildcardsDir string
+}
+
+func newPromptExpander(wildcardsDir string) *promptExpander {
+	return &promptExpander{wildcardsDir: wildcardsDir}
+}
+
+// parseTemplate turns "a {red|__colors__} car" into a sequence of text, wildcard and alternation nodes.
+func parseTemplate(template string) ([]promptNode, error) {
+	nodes, rest, err := parseSequence(template, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if rest != "" {
+		return nil, &PromptTemplateError{Reason: "unexpected \"" + rest[:1] + "\""}
+	}
+
+	return nodes, nil
+}
+
+// parseSequence parses until the end of the template, or the end of the current alternative when nested.
+func parseSequence(template string, nested bool) ([]promptNode, string, error) {
+	var nodes []promptNode
+
+	var text strings.Builder
+
+	flushText := func() {
+		nodes = append(nodes, splitWildcards(text.String())...)
+		text.Reset()
+	}
+
+	for len(template) > 0 {
+		switch template[0] {
+		case '{':
+			flushText()
+
+			alternatives, rest, err := parseAlternation(template[1:])
+			if err != nil {
+				return nil, "", err
+			}
+
+			nodes = append(nodes, promptNode{alternatives: alternatives})
+			template = rest
+		case '|', '}':
+			if !nested {
+				return nil, "", &PromptTemplateError{Reason: "unexpected \"" + template[:1] + "\""}
+			}
+
+			flushText()
+
+			return nodes, template, nil
+		default:
+			text.WriteByte(template[0])
+			template = template[1:]
+		}
+	}
+
+	if nested {
+		return nil, "", &PromptTemplateError{Reason: "missing closing \"}\""}
+	}
+
+	flushText()
+
+	return nodes, "", nil
+}
+
+func parseAlternation(template string) ([][]promptNode, string, error) {
+	var alternatives [][]promptNode
+
+	for {
+		alternative, rest, err := parseSequence(template, true)
+		if err != nil {
+			return nil, "", err
+		}
+
+		alternatives = append(alternatives, alternative)
+
+		if rest[0] == '}' {
+			return alternatives, rest[1:], nil
+		}
+
+		template = rest[1:]
+	}
+}
+
+func splitWildcards(text string) []promptNode {
+	if text == "" {
+		return nil
+	}
+
+	var nodes []promptNode
+
+	for _, match := range wildcardRegex.FindAllStringSubmatchIndex(text, -1) {
+		if match[0] > 0 {
+			nodes = append(nodes, promptNode{text: text[:match[0]]})
+		}
+
+		nodes = append(nodes, promptNode{wildcard: text[match[2]:match[3]]})
+
+		text = text[match[1]:]
+	}
+
+	if text != "" {
+		nodes = append(nodes, promptNode{text: text})
+	}
+
+	return nodes
+}
+
+// wildcardOptions reads the options for "__name__" from name.txt in the wildcards directory, one per line.
+func (e *promptExpander) wildcardOptions(name string) ([]string, error) {
+	if e.wildcardsDir == "" {
+		return nil, &UnknownWildcardError{Name: name}
+	}
+
+	contents, err := os.ReadFile(filepath.Join(e.wildcardsDir, filepath.FromSlash(name)+".txt"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &UnknownWildcardError{Name: name}
+		}
+
+		return nil, err
+	}
+
+	var options []string
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		options = append(options, line)
+	}
+
+	if len(options) == 0 {
+		return nil, &PromptTemplateError{Reason: fmt.Sprintf("wildcard __%s__ has no options", name)}
+	}
+
+	return options, nil
+}
+
+// expandRandom picks one option for every alternation and wildcard, so the same seed gives the same prompt.
+func (e *promptExpander) expandRandom(template string, seed int64) (string, error) {
+	return e.expandRandomDepth(template, rand.New(rand.NewSource(seed)), 0)
+}
+
+func (e *promptExpander) expandRandomDepth(template string, rng *rand.Rand, depth int) (string, error) {
+	if depth > maxExpansionDepth {
+		return "", &PromptTemplateError{Reason: "wildcards are nested too deeply"}
+	}
+
+	nodes, err := parseTemplate(template)
+	if err != nil {
+		return "", err
+	}
+
+	var result strings.Builder
+
+	err = e.writeRandom(&result, nodes, rng, depth)
+	if err != nil {
+		return "", err
+	}
+
+	return result.String(), nil
+}
+
+func (e *promptExpander) writeRandom(result *strings.Builder, nodes []promptNode, rng *rand.Rand, depth int) error {
+	for _, node := range nodes {
+		switch {
+		case node.alternatives != nil:
+			err := e.writeRandom(result, node.alternatives[rng.Intn(len(node.alternatives))], rng, depth)
+			if err != nil {
+				return err
+			}
+		case node.wildcard != "":
+			options, err := e.wildcardOptions(node.wildcard)
+			if err != nil {
+				return err
+			}
+
+			expanded, err := e.expandRandomDepth(options[rng.Intn(len(options))], rng, depth+1)
+			if err != nil {
+				return err
+			}
+
+			result.WriteString(expanded)
+		default:
+			result.WriteString(node.text)
+		}
+	}
+
+	return nil
+}
+
+// expandAll returns every combination of the template's alternations and wildcards, up to limit prompts.
+func (e *promptExpander) expandAll(template string, limit int) ([]string, error) {
+	return e.expandAllDepth(template, limit, 0)
+}
+
+func (e *promptExpander) expandAllDepth(template string, limit, depth int) ([]string, error) {
+	if depth > maxExpansionDepth {
+		return nil, &PromptTemplateError{Reason: "wildcards are nested too deeply"}
+	}
+
+	nodes, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.combineSequence(nodes, limit, depth)
+}
+
+func (e *promptExpander) combineSequence(nodes []promptNode, limit, depth int) ([]string, error) {
+	results := []string{""}
+
+	for _, node := range nodes {
+		var options []string
+
+		switch {
+		case node.alternatives != nil:
+			for _, alternative := range node.alternatives {
+				expanded, err := e.combineSequence(alternative, limit, depth)
+				if err != nil {
+					return nil, err
+				}
+
+				options = append(options, expanded...)
+			}
+		case node.wildcard != "":
+			lines, err := e.wildcardOptions(node.wildcard)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, line := range lines {
+				expanded, err := e.expandAllDepth(line, limit, depth+1)
+				if err != nil {
+					return nil, err
+				}
+
+				options = append(options, expanded...)
+			}
+		default:
+			options = []string{node.text}
+		}
+
+		combined := make([]string, 0, min(len(results)*len(options), limit))
+
+	combine:
+		for _, prefix := range results {
+			for _, option := range options {
+				if len(combined) == limit {
+					break combine
+				}
+
+				combined = append(combined, prefix+option)
+			}
+		}
+
+		results = combined
+	}
+
+	return results, nil
+}
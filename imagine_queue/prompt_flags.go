@@ -0,0 +1,465 @@
+package imagine_queue
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	minSteps = 1
+	maxSteps = 150
+
+	minCFGScale = 1
+	maxCFGScale = 30
+
+	minBatch = 1
+	maxBatch = 4
+
+	minSeed = -1
+	// maxSeed is the biggest seed of the WebUI, or of an int on 32-bit builds
+	maxSeed = min(4294967295, math.MaxInt)
+)
+
+// UnknownFlagError is returned when a prompt contains a "--flag" the parser does not understand.
+type UnknownFlagError struct {
+	Flag string
+}
+
+func (e *UnknownFlagError) Error() string {
+	return fmt.Sprintf("unknown parameter --%s", e.Flag)
+}
+
+func (e *UnknownFlagError) Is(err error) bool {
+	_, ok := err.(*UnknownFlagError)
+	return ok
+}
+
+// InvalidFlagValueError is returned when a known prompt flag has a missing, malformed or out-of-range value.
+type InvalidFlagValueError struct {
+	Flag   string
+	Value  string
+	Reason string
+}
+
+func (e *InvalidFlagValueError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("missing value for --%s: %s", e.Flag, e.Reason)
+	}
+
+	return fmt.Sprintf("invalid value \"%s\" for --%s: %s", e.Value, e.Flag, e.Reason)
+}
+
+func (e *InvalidFlagValueError) Is(err error) bool {
+	_, ok := err.(*InvalidFlagValueError)
+	return ok
+}
+
+// promptFlags holds the parameters extracted from a prompt. Pointer fields are nil when the flag was not passed.
+type promptFlags struct {
+	Prompt          string
+	AspectWidth     int
+	AspectHeight    int
+	Seed            *int
+	Steps           *int
+	CfgScale        *float64
+	Negative        string
+	SamplerName     string
+	Model           string
+	EnableHR        *bool
+	Batch           *int
+	Style           string
+	SubseedStrength *float64
+	Combinatorial   bool
+}
+
+// multiWordFlags consume every word up to the next flag, the rest only take a single word.
+var multiWordFlags = map[string]bool{
+	"no":      true,
+	"sampler": true,
+	"model":   true,
+}
+
+// promptToken is a word of the prompt, or a whole quoted value like "DPM++ 2M Karras" without its quotes.
+type promptToken struct {
+	text   string
+	quoted bool
+}
+
+func (t promptToken) String() string {
+	if t.quoted {
+		return `"` + t.text + `"`
+	}
+
+	return t.text
+}
+
+func isFlagToken(token promptToken) bool {
+	return !token.quoted && len(token.text) > 2 && strings.HasPrefix(token.text, "--")
+}
+
+// isQuote also accepts the curly quotes phones autocorrect to.
+func isQuote(r rune) bool {
+	return r == '"' || r == '“' || r == '”'
+}
+
+// splitPromptTokens splits the prompt on whitespace, keeping quoted text together. A quote that isn't closed is kept
+// as part of its word.
+func splitPromptTokens(prompt string) []promptToken {
+	runes := []rune(prompt)
+	tokens := make([]promptToken, 0)
+
+	for idx := 0; idx < len(runes); {
+		if unicode.IsSpace(runes[idx]) {
+			idx++
+
+			continue
+		}
+
+		if isQuote(runes[idx]) {
+			end := idx + 1
+			for end < len(runes) && !isQuote(runes[end]) {
+				end++
+			}
+
+			if end < len(runes) {
+				tokens = append(tokens, promptToken{text: string(runes[idx+1 : end]), quoted: true})
+				idx = end + 1
+
+				continue
+			}
+		}
+
+		end := idx
+		for end < len(runes) && !unicode.IsSpace(runes[end]) {
+			end++
+		}
+
+		tokens = append(tokens, promptToken{text: string(runes[idx:end])})
+		idx = end
+	}
+
+	return tokens
+}
+
+// parsePromptFlags splits a Midjourney style prompt (e.g. "a cat --ar 16:9 --seed 42") into the prompt text and
+// its parameters. Values with spaces can be quoted, e.g. --sampler "DPM++ 2M Karras", to end them before the rest of
+// the prompt.
+func parsePromptFlags(prompt string) (*promptFlags, error) {
+	// Sanitize em dashes. Some phones will autocorrect to em dashes
+	tokens := splitPromptTokens(fixEmDash(prompt))
+
+	flags := &promptFlags{}
+	promptWords := make([]string, 0, len(tokens))
+
+	for idx := 0; idx < len(tokens); {
+		if !isFlagToken(tokens[idx]) {
+			promptWords = append(promptWords, tokens[idx].String())
+			idx++
+
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimPrefix(tokens[idx].text, "--"))
+		idx++
+
+		var values []promptToken
+
+		for idx < len(tokens) && !isFlagToken(tokens[idx]) {
+			values = append(values, tokens[idx])
+			idx++
+		}
+
+		// a quoted value is the whole value, even of flags that take several words
+		var flagValues []string
+
+		if len(values) > 0 && values[0].quoted {
+			flagValues = []string{values[0].text}
+		} else {
+			for _, value := range values {
+				flagValues = append(flagValues, value.text)
+			}
+		}
+
+		consumed, err := flags.apply(name, flagValues)
+		if err != nil {
+			return nil, err
+		}
+
+		// anything the flag didn't consume is part of the prompt, like with the old "--ar" handling
+		for _, value := range values[consumed:] {
+			promptWords = append(promptWords, value.String())
+		}
+	}
+
+	flags.Prompt = strings.Join(promptWords, " ")
+
+	return flags, nil
+}
+
+// apply sets the flag on the result, and returns how many of the values it consumed.
+func (f *promptFlags) apply(name string, values []string) (int, error) {
+	value := ""
+
+	if multiWordFlags[name] {
+		value = strings.Join(values, " ")
+	} else if len(values) > 0 {
+		value = values[0]
+	}
+
+	switch name {
+	case "ar":
+		width, height, err := parseAspectRatio(value)
+		if err != nil {
+			return 0, err
+		}
+
+		f.AspectWidth = width
+		f.AspectHeight = height
+	case "seed":
+		seed, err := parseIntFlag(name, value, minSeed, maxSeed)
+		if err != nil {
+			return 0, err
+		}
+
+		f.Seed = &seed
+	case "steps":
+		steps, err := parseIntFlag(name, value, minSteps, maxSteps)
+		if err != nil {
+			return 0, err
+		}
+
+		f.Steps = &steps
+	case "cfg":
+		cfgScale, err := parseFloatFlag(name, value, minCFGScale, maxCFGScale)
+		if err != nil {
+			return 0, err
+		}
+
+		f.CfgScale = &cfgScale
+	case "batch":
+		batch, err := parseIntFlag(name, value, minBatch, maxBatch)
+		if err != nil {
+			return 0, err
+		}
+
+		f.Batch = &batch
+	case "v":
+		strength, err := parseFloatFlag(name, value, 0, 1)
+		if err != nil {
+			return 0, err
+		}
+
+		f.SubseedStrength = &strength
+	case "no":
+		if value == "" {
+			return 0, &InvalidFlagValueError{Flag: name, Reason: "expected the terms to leave out"}
+		}
+
+		f.Negative = value
+	case "sampler":
+		if value == "" {
+			return 0, &InvalidFlagValueError{Flag: name, Reason: "expected a sampler name"}
+		}
+
+		f.SamplerName = value
+	case "model":
+		if value == "" {
+			return 0, &InvalidFlagValueError{Flag: name, Reason: "expected a model name"}
+		}
+
+		f.Model = value
+	case "style":
+		if value == "" {
+			return 0, &InvalidFlagValueError{Flag: name, Reason: "expected a style name"}
+		}
+
+		f.Style = value
+	case "combinatorial":
+		f.Combinatorial = true
+
+		return 0, nil
+	case "hires":
+		enableHR := true
+		f.EnableHR = &enableHR
+
+		if value == "" {
+			return 0, nil
+		}
+
+		switch strings.ToLower(value) {
+		case "on", "true", "yes", "1":
+		case "off", "false", "no", "0":
+			enableHR = false
+		default:
+			// a bare "--hires" followed by prompt text
+			return 0, nil
+		}
+	default:
+		return 0, &UnknownFlagError{Flag: name}
+	}
+
+	if multiWordFlags[name] {
+		return len(values), nil
+	}
+
+	return min(len(values), 1), nil
+}
+
+// Validate checks the options that were set directly instead of as prompt flags, e.g. by an API client. Options left
+// at 0 are filled in from the defaults.
+func (o *QueueItemOptions) Validate() error {
+	if o.Steps != 0 && (o.Steps < minSteps || o.Steps > maxSteps) {
+		return &InvalidSettingError{Setting: "steps", Reason: fmt.Sprintf("must be between %d and %d", minSteps, maxSteps)}
+	}
+
+	if o.CfgScale != 0 && (o.CfgScale < minCFGScale || o.CfgScale > maxCFGScale) {
+		return &InvalidSettingError{Setting: "CFG scale", Reason: fmt.Sprintf("must be between %d and %d", minCFGScale, maxCFGScale)}
+	}
+
+	for _, batch := range []int{o.BatchCount, o.BatchSize} {
+		if batch != 0 && (batch < minBatch || batch > maxBatch) {
+			return &InvalidSettingError{Setting: "batch", Reason: fmt.Sprintf("must be between %d and %d", minBatch, maxBatch)}
+		}
+	}
+
+	if o.BatchCount*o.BatchSize > maxBatch {
+		return &InvalidSettingError{Setting: "batch", Reason: fmt.Sprintf("at most %d images per generation", maxBatch)}
+	}
+
+	if o.Seed < minSeed || o.Seed > maxSeed {
+		return &InvalidSettingError{Setting: "seed", Reason: fmt.Sprintf("must be between %d and %d", minSeed, maxSeed)}
+	}
+
+	if o.SubseedStrength < 0 || o.SubseedStrength > 1 {
+		return &InvalidSettingError{Setting: "subseed strength", Reason: "must be between 0 and 1"}
+	}
+
+	return nil
+}
+
+func parseAspectRatio(value string) (int, int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, 0, &InvalidFlagValueError{Flag: "ar", Value: value, Reason: "expected <width>:<height>"}
+	}
+
+	width, err := strconv.Atoi(parts[0])
+	if err != nil || width <= 0 {
+		return 0, 0, &InvalidFlagValueError{Flag: "ar", Value: value, Reason: "expected <width>:<height>"}
+	}
+
+	height, err := strconv.Atoi(parts[1])
+	if err != nil || height <= 0 {
+		return 0, 0, &InvalidFlagValueError{Flag: "ar", Value: value, Reason: "expected <width>:<height>"}
+	}
+
+	if width > height*4 || height > width*4 {
+		return 0, 0, &InvalidFlagValueError{Flag: "ar", Value: value, Reason: "aspect ratio can be at most 4:1"}
+	}
+
+	return width, height, nil
+}
+
+func parseIntFlag(name, value string, minValue, maxValue int) (int, error) {
+	if value == "" {
+		return 0, &InvalidFlagValueError{Flag: name, Reason: "expected a whole number"}
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &InvalidFlagValueError{Flag: name, Value: value, Reason: "expected a whole number"}
+	}
+
+	if parsed < minValue || parsed > maxValue {
+		return 0, &InvalidFlagValueError{
+			Flag:   name,
+			Value:  value,
+			Reason: fmt.Sprintf("must be between %d and %d", minValue, maxValue),
+		}
+	}
+
+	return parsed, nil
+}
+
+func parseFloatFlag(name, value string, minValue, maxValue float64) (float64, error) {
+	if value == "" {
+		return 0, &InvalidFlagValueError{Flag: name, Reason: "expected a number"}
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, &InvalidFlagValueError{Flag: name, Value: value, Reason: "expected a number"}
+	}
+
+	if parsed < minValue || parsed > maxValue {
+		return 0, &InvalidFlagValueError{
+			Flag:   name,
+			Value:  value,
+			Reason: fmt.Sprintf("must be between %v and %v", minValue, maxValue),
+		}
+	}
+
+	return parsed, nil
+}
+
+// applyTo overrides the queue item options with the parameters that were passed in the prompt.
+func (f *promptFlags) applyTo(options *QueueItemOptions) {
+	options.Prompt = f.Prompt
+
+	if f.AspectWidth > 0 && f.AspectHeight > 0 {
+		options.AspectWidth = f.AspectWidth
+		options.AspectHeight = f.AspectHeight
+	}
+
+	if f.Seed != nil {
+		options.Seed = *f.Seed
+	}
+
+	if f.Steps != nil {
+		options.Steps = *f.Steps
+	}
+
+	if f.CfgScale != nil {
+		options.CfgScale = *f.CfgScale
+	}
+
+	if f.Negative != "" {
+		if options.NegativePrompt == "" {
+			options.NegativePrompt = f.Negative
+		} else {
+			options.NegativePrompt += ", " + f.Negative
+		}
+	}
+
+	if f.SamplerName != "" {
+		options.SamplerName = f.SamplerName
+	}
+
+	if f.Model != "" {
+		options.Model = f.Model
+	}
+
+	if f.EnableHR != nil {
+		options.HiresOverride = f.EnableHR
+	}
+
+	if f.Batch != nil {
+		options.BatchCount = *f.Batch
+		options.BatchSize = 1
+	}
+
+	if f.Style != "" {
+		options.Style = f.Style
+	}
+
+	if f.SubseedStrength != nil {
+		options.SubseedStrength = *f.SubseedStrength
+	}
+
+	if f.Combinatorial {
+		options.Combinatorial = true
+	}
+}
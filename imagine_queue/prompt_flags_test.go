@@ -0,0 +1,254 @@
+package imagine_queue
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func intPointer(value int) *int {
+	return &value
+}
+
+func floatPointer(value float64) *float64 {
+	return &value
+}
+
+func boolPointer(value bool) *bool {
+	return &value
+}
+
+func TestParsePromptFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		prompt  string
+		want    *promptFlags
+		wantErr error
+	}{
+		{
+			name:   "no flags",
+			prompt: "a cat in a hat",
+			want:   &promptFlags{Prompt: "a cat in a hat"},
+		},
+		{
+			name:   "aspect ratio",
+			prompt: "a cat --ar 16:9",
+			want:   &promptFlags{Prompt: "a cat", AspectWidth: 16, AspectHeight: 9},
+		},
+		{
+			name:   "em dash",
+			prompt: "a cat —ar 2:3",
+			want:   &promptFlags{Prompt: "a cat", AspectWidth: 2, AspectHeight: 3},
+		},
+		{
+			name:   "seed",
+			prompt: "a cat --seed 42",
+			want:   &promptFlags{Prompt: "a cat", Seed: intPointer(42)},
+		},
+		{
+			name:   "steps",
+			prompt: "a cat --steps 30",
+			want:   &promptFlags{Prompt: "a cat", Steps: intPointer(30)},
+		},
+		{
+			name:   "cfg",
+			prompt: "a cat --cfg 7.5",
+			want:   &promptFlags{Prompt: "a cat", CfgScale: floatPointer(7.5)},
+		},
+		{
+			name:   "negative terms",
+			prompt: "a cat --no blurry hands --seed 1",
+			want:   &promptFlags{Prompt: "a cat", Negative: "blurry hands", Seed: intPointer(1)},
+		},
+		{
+			name:   "sampler",
+			prompt: "a cat --sampler Euler a",
+			want:   &promptFlags{Prompt: "a cat", SamplerName: "Euler a"},
+		},
+		{
+			name:   "model",
+			prompt: "--model sd_xl_base_1.0 --steps 20",
+			want:   &promptFlags{Model: "sd_xl_base_1.0", Steps: intPointer(20)},
+		},
+		{
+			name:   "hires",
+			prompt: "a cat --hires",
+			want:   &promptFlags{Prompt: "a cat", EnableHR: boolPointer(true)},
+		},
+		{
+			name:   "hires off",
+			prompt: "a cat --hires off",
+			want:   &promptFlags{Prompt: "a cat", EnableHR: boolPointer(false)},
+		},
+		{
+			name:   "hires before the prompt",
+			prompt: "--hires a cat",
+			want:   &promptFlags{Prompt: "a cat", EnableHR: boolPointer(true)},
+		},
+		{
+			name:   "batch",
+			prompt: "a cat --batch 2",
+			want:   &promptFlags{Prompt: "a cat", Batch: intPointer(2)},
+		},
+		{
+			name:   "style",
+			prompt: "a cat --style cinematic",
+			want:   &promptFlags{Prompt: "a cat", Style: "cinematic"},
+		},
+		{
+			name:   "variation strength",
+			prompt: "a cat --v 0.3",
+			want:   &promptFlags{Prompt: "a cat", SubseedStrength: floatPointer(0.3)},
+		},
+		{
+			name:   "combinatorial",
+			prompt: "a {red|blue} cat --combinatorial",
+			want:   &promptFlags{Prompt: "a {red|blue} cat", Combinatorial: true},
+		},
+		{
+			name:   "single word flag leaves the rest to the prompt",
+			prompt: "--seed 42 a cat",
+			want:   &promptFlags{Prompt: "a cat", Seed: intPointer(42)},
+		},
+		{
+			name:   "uppercase flag",
+			prompt: "a cat --SEED 42",
+			want:   &promptFlags{Prompt: "a cat", Seed: intPointer(42)},
+		},
+		{
+			name:   "quoted sampler",
+			prompt: `--sampler "DPM++ 2M Karras" a cat`,
+			want:   &promptFlags{Prompt: "a cat", SamplerName: "DPM++ 2M Karras"},
+		},
+		{
+			name:   "quoted negative terms with a flag inside",
+			prompt: `a cat --no "text, --watermark"`,
+			want:   &promptFlags{Prompt: "a cat", Negative: "text, --watermark"},
+		},
+		{
+			name:   "curly quotes",
+			prompt: "a cat --model “my model” --seed 3",
+			want:   &promptFlags{Prompt: "a cat", Model: "my model", Seed: intPointer(3)},
+		},
+		{
+			name:   "quotes in the prompt are kept",
+			prompt: `a sign that says "hello world" --seed 5`,
+			want:   &promptFlags{Prompt: `a sign that says "hello world"`, Seed: intPointer(5)},
+		},
+		{
+			name:   "unclosed quote",
+			prompt: `a "cat --seed 5`,
+			want:   &promptFlags{Prompt: `a "cat`, Seed: intPointer(5)},
+		},
+		{
+			name:    "unknown flag",
+			prompt:  "a cat --chaos 50",
+			wantErr: &UnknownFlagError{},
+		},
+		{
+			name:    "seed is not a number",
+			prompt:  "a cat --seed abc",
+			wantErr: &InvalidFlagValueError{},
+		},
+		{
+			name:    "steps out of range",
+			prompt:  "a cat --steps 500",
+			wantErr: &InvalidFlagValueError{},
+		},
+		{
+			name:    "cfg out of range",
+			prompt:  "a cat --cfg 0.5",
+			wantErr: &InvalidFlagValueError{},
+		},
+		{
+			name:    "batch out of range",
+			prompt:  "a cat --batch 5",
+			wantErr: &InvalidFlagValueError{},
+		},
+		{
+			name:    "variation strength out of range",
+			prompt:  "a cat --v 2",
+			wantErr: &InvalidFlagValueError{},
+		},
+		{
+			name:    "malformed aspect ratio",
+			prompt:  "a cat --ar 16x9",
+			wantErr: &InvalidFlagValueError{},
+		},
+		{
+			name:    "aspect ratio too wide",
+			prompt:  "a cat --ar 5:1",
+			wantErr: &InvalidFlagValueError{},
+		},
+		{
+			name:    "seed at the end without a value",
+			prompt:  "a cat --seed",
+			wantErr: &InvalidFlagValueError{},
+		},
+		{
+			name:    "negative terms at the end without a value",
+			prompt:  "a cat --no",
+			wantErr: &InvalidFlagValueError{},
+		},
+		{
+			name:    "flag followed by another flag",
+			prompt:  "a cat --steps --seed 1",
+			wantErr: &InvalidFlagValueError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePromptFlags(tt.prompt)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("parsePromptFlags(%q) error = %v, want %T", tt.prompt, err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parsePromptFlags(%q) unexpected error: %v", tt.prompt, err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePromptFlags(%q) = %+v, want %+v", tt.prompt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptFlagsApplyTo(t *testing.T) {
+	flags, err := parsePromptFlags("a cat --no blurry --seed 7 --batch 3 --hires off")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	options := NewQueueItemOptions()
+	options.NegativePrompt = "ugly"
+	options.BatchSize = 4
+
+	flags.applyTo(&options)
+
+	if options.Prompt != "a cat" {
+		t.Errorf("Prompt = %q, want %q", options.Prompt, "a cat")
+	}
+
+	if options.NegativePrompt != "ugly, blurry" {
+		t.Errorf("NegativePrompt = %q, want %q", options.NegativePrompt, "ugly, blurry")
+	}
+
+	if options.Seed != 7 {
+		t.Errorf("Seed = %d, want 7", options.Seed)
+	}
+
+	if options.BatchCount != 3 || options.BatchSize != 1 {
+		t.Errorf("batch = %dx%d, want 3x1", options.BatchCount, options.BatchSize)
+	}
+
+	if options.HiresOverride == nil || *options.HiresOverride {
+		t.Errorf("HiresOverride = %v, want false", options.HiresOverride)
+	}
+}
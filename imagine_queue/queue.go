@@ -1,28 +1,29 @@
 package imagine_queue
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"os/signal"
-	"regexp"
 	"stable_diffusion_bot/composite_renderer"
 	"stable_diffusion_bot/entities"
-	"stable_diffusion_bot/repositories"
+	"stable_diffusion_bot/image_store"
+	"stable_diffusion_bot/repositories/channel_profiles"
 	"stable_diffusion_bot/repositories/default_settings"
+	"stable_diffusion_bot/repositories/favorites"
 	"stable_diffusion_bot/repositories/image_generations"
+	"stable_diffusion_bot/repositories/moderation_decisions"
+	"stable_diffusion_bot/repositories/moderation_rules"
+	"stable_diffusion_bot/repositories/permissions"
 	"stable_diffusion_bot/stable_diffusion_api"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/bwmarrin/discordgo"
 )
 
 const (
@@ -33,27 +34,65 @@ const (
 	initializedBatchCount = 4
 	initializedBatchSize  = 1
 
-	defaultNegativePrompt = "ugly, tiling, poorly drawn hands, poorly drawn feet, poorly drawn face, out of frame, " +
+	initializedUpscaleMethod = UpscaleMethodHiresFix
+	initializedUpscaler      = "R-ESRGAN 4x+"
+	initializedUpscaleFactor = 2
+
+	initializedSubtleVariationStrength = 0.15
+	initializedStrongVariationStrength = 0.4
+
+	initializedSampler      = "Euler a"
+	initializedSteps        = 20
+	initializedCFGScale     = 7
+	initializedEnableHR     = true
+	initializedRestoreFaces = false
+
+	initializedNegativePrompt = "ugly, tiling, poorly drawn hands, poorly drawn feet, poorly drawn face, out of frame, " +
 		"mutation, mutated, extra limbs, extra legs, extra arms, disfigured, deformed, cross-eye, " +
 		"body out of frame, blurry, bad art, bad anatomy, blurred, text, watermark, grainy"
 )
 
 type queueImpl struct {
-	botSession          *discordgo.Session
-	stableDiffusionAPI  stable_diffusion_api.StableDiffusionAPI
-	queue               chan *QueueItem
-	currentImagine      *QueueItem
-	mu                  sync.Mutex
-	imageGenerationRepo image_generations.Repository
-	compositeRenderer   composite_renderer.Renderer
-	defaultSettingsRepo default_settings.Repository
-	botDefaultSettings  *entities.DefaultSettings
+	stableDiffusionAPI     stable_diffusion_api.StableDiffusionAPI
+	queue                  chan *QueueItem
+	currentImagine         *QueueItem
+	mu                     sync.Mutex
+	imageGenerationRepo    image_generations.Repository
+	compositeRenderer      composite_renderer.Renderer
+	defaultSettingsRepo    default_settings.Repository
+	channelProfileRepo     channel_profiles.Repository
+	promptExpander         *promptExpander
+	eventBus               *EventBus
+	imageStore             image_store.Store
+	purgeDeletedImages     bool
+	moderationRuleRepo     moderation_rules.Repository
+	moderationDecisionRepo moderation_decisions.Repository
+	favoriteRepo           favorites.Repository
+	permissionRepo         permissions.Repository
+	// heldPrompts are the items waiting for a moderator, by the ID of the decision that held them
+	heldPrompts map[int64]*heldPrompt
+	heldMu      sync.Mutex
+	// settingsCache holds the stored settings rows by member ID, nil for rows that don't exist
+	settingsCache map[string]*entities.DefaultSettings
+	settingsMu    sync.Mutex
 }
 
 type Config struct {
-	StableDiffusionAPI  stable_diffusion_api.StableDiffusionAPI
-	ImageGenerationRepo image_generations.Repository
-	DefaultSettingsRepo default_settings.Repository
+	StableDiffusionAPI     stable_diffusion_api.StableDiffusionAPI
+	ImageGenerationRepo    image_generations.Repository
+	DefaultSettingsRepo    default_settings.Repository
+	ChannelProfileRepo     channel_profiles.Repository
+	ModerationRuleRepo     moderation_rules.Repository
+	ModerationDecisionRepo moderation_decisions.Repository
+	FavoriteRepo           favorites.Repository
+	PermissionRepo         permissions.Repository
+	WildcardsDir           string
+	// EventBus receives the events of the jobs, a new one is made when it is nil
+	EventBus *EventBus
+	// ImageStore archives the generated images, they aren't archived when it is nil
+	ImageStore image_store.Store
+	// PurgeDeletedImages removes the archived images of deleted results from the image store
+	PurgeDeletedImages bool
 }
 
 func New(cfg Config) (Queue, error) {
@@ -69,17 +108,53 @@ func New(cfg Config) (Queue, error) {
 		return nil, errors.New("missing default settings repository")
 	}
 
+	if cfg.ChannelProfileRepo == nil {
+		return nil, errors.New("missing channel profile repository")
+	}
+
+	if cfg.ModerationRuleRepo == nil {
+		return nil, errors.New("missing moderation rule repository")
+	}
+
+	if cfg.ModerationDecisionRepo == nil {
+		return nil, errors.New("missing moderation decision repository")
+	}
+
+	if cfg.FavoriteRepo == nil {
+		return nil, errors.New("missing favorite repository")
+	}
+
+	if cfg.PermissionRepo == nil {
+		return nil, errors.New("missing permission repository")
+	}
+
 	compositeRenderer, err := composite_renderer.New(composite_renderer.Config{})
 	if err != nil {
 		return nil, err
 	}
 
+	eventBus := cfg.EventBus
+	if eventBus == nil {
+		eventBus = NewEventBus()
+	}
+
 	return &queueImpl{
-		stableDiffusionAPI:  cfg.StableDiffusionAPI,
-		imageGenerationRepo: cfg.ImageGenerationRepo,
-		queue:               make(chan *QueueItem, 100),
-		compositeRenderer:   compositeRenderer,
-		defaultSettingsRepo: cfg.DefaultSettingsRepo,
+		stableDiffusionAPI:     cfg.StableDiffusionAPI,
+		imageGenerationRepo:    cfg.ImageGenerationRepo,
+		queue:                  make(chan *QueueItem, 100),
+		compositeRenderer:      compositeRenderer,
+		defaultSettingsRepo:    cfg.DefaultSettingsRepo,
+		channelProfileRepo:     cfg.ChannelProfileRepo,
+		settingsCache:          make(map[string]*entities.DefaultSettings),
+		promptExpander:         newPromptExpander(cfg.WildcardsDir),
+		eventBus:               eventBus,
+		imageStore:             cfg.ImageStore,
+		purgeDeletedImages:     cfg.PurgeDeletedImages,
+		moderationRuleRepo:     cfg.ModerationRuleRepo,
+		moderationDecisionRepo: cfg.ModerationDecisionRepo,
+		favoriteRepo:           cfg.FavoriteRepo,
+		permissionRepo:         cfg.PermissionRepo,
+		heldPrompts:            make(map[int64]*heldPrompt),
 	}, nil
 }
 
@@ -90,15 +165,19 @@ const (
 	ItemTypeReroll
 	ItemTypeUpscale
 	ItemTypeVariation
+	ItemTypePlot
+	ItemTypeRemix
+	ItemTypeOutpaint
+	ItemTypeReimagine
 )
 
 type QueueItemOptions struct {
-	Prompt            string
-	NegativePrompt    string
-	Width             int
-	Height            int
-	RestoreFaces      bool
-	EnableHR          bool
+	Prompt         string
+	NegativePrompt string
+	Width          int
+	Height         int
+	// RestoreFaces is nil to use the member's default
+	RestoreFaces      *bool
 	HiresWidth        int
 	HiresHeight       int
 	DenoisingStrength float64
@@ -106,243 +185,301 @@ type QueueItemOptions struct {
 	CfgScale          float64
 	Steps             int
 	Seed              int
+	SubseedStrength   float64
+	AspectWidth       int
+	AspectHeight      int
+	HiresOverride     *bool
+	BatchCount        int
+	BatchSize         int
+	Model             string
+	Style             string
+	Combinatorial     bool
+	PromptTemplate    string
 }
 
+// NewQueueItemOptions returns options that leave the negative prompt, sampler, steps, CFG scale and restore faces
+// empty, they are filled in from the member's default settings when the item is added.
 func NewQueueItemOptions() QueueItemOptions {
 	return QueueItemOptions{
-		NegativePrompt:    DefaultNegative,
-		RestoreFaces:      DefaultRestoreFaces,
-		EnableHR:          DefaultHiRes,
 		DenoisingStrength: DefaultDenoisingStrength,
-		SamplerName:       DefaultSampler,
-		CfgScale:          DefaultCFGScale,
-		Steps:             DefaultSteps,
 		Seed:              DefaultSeed,
 	}
 }
 
 type QueueItem struct {
-	Prompt             string
-	Options            QueueItemOptions
-	NegativePrompt     string
-	Type               ItemType
-	InteractionIndex   int
-	DiscordInteraction *discordgo.Interaction
+	// JobID tells the jobs apart in events, it is set when the item is added
+	JobID            string
+	Prompt           string
+	Options          QueueItemOptions
+	NegativePrompt   string
+	Type             ItemType
+	InteractionIndex int
+	Source           JobSource
+	Plot             *PlotOptions
+	Outpaint         *OutpaintOptions
+	Upscale          *UpscaleOptions
+	// Variation is how strong a variation should be, subtle when not set
+	Variation VariationStrength
+	// IsFollowup is set for the extra items of a combinatorial prompt, which each post their own message
+	IsFollowup bool
+	// ResultMessageID is the message the results are posted in, set once the job has started
+	ResultMessageID string
+	// moderationApproved is set once a moderator approved the held prompt
+	moderationApproved bool
+	// startedAt is when the job was taken from the queue, for the time it took
+	startedAt time.Time
 }
 
 func (q *queueImpl) AddImagine(item *QueueItem) (int, error) {
-	q.queue <- item
-
-	linePosition := len(q.queue)
-
-	return linePosition, nil
-}
-
-func (q *queueImpl) StartPolling(botSession *discordgo.Session) {
-	q.botSession = botSession
+	if item.Source.Sink == nil {
+		return 0, errors.New("missing result sink")
+	}
 
-	botDefaultSettings, err := q.initializeOrGetBotDefaults()
+	// moderation comes first, so a held item is queued as it was given once it is approved
+	negativeTerms, err := q.moderate(item)
 	if err != nil {
-		log.Printf("Error getting/initializing bot default settings: %v", err)
-
-		return
+		return 0, err
 	}
 
-	q.botDefaultSettings = botDefaultSettings
+	items := []*QueueItem{item}
 
-	log.Println("Press Ctrl+C to exit")
+	if item.Type == ItemTypeImagine {
+		err := q.fillInDefaultOptions(item)
+		if err != nil {
+			return 0, err
+		}
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
+		flags, err := parsePromptFlags(item.Prompt)
+		if err != nil {
+			return 0, err
+		}
 
-	stopPolling := false
+		flags.applyTo(&item.Options)
 
-	for {
-		select {
-		case <-stop:
-			stopPolling = true
-		case <-time.After(1 * time.Second):
-			if q.currentImagine == nil {
-				q.pullNextInQueue()
-			}
-		}
+		item.Prompt = flags.Prompt
 
-		if stopPolling {
-			break
+		items, err = q.expandDynamicPrompt(item)
+		if err != nil {
+			return 0, err
 		}
 	}
 
-	log.Printf("Polling stopped...\n")
-}
+	if item.Type == ItemTypePlot {
+		if item.Plot == nil {
+			return 0, errors.New("missing plot options")
+		}
 
-func (q *queueImpl) pullNextInQueue() {
-	if len(q.queue) > 0 {
-		element := <-q.queue
+		err := q.fillInDefaultOptions(item)
+		if err != nil {
+			return 0, err
+		}
 
-		q.mu.Lock()
-		defer q.mu.Unlock()
+		flags, err := parsePromptFlags(item.Prompt)
+		if err != nil {
+			return 0, err
+		}
 
-		q.currentImagine = element
+		flags.applyTo(&item.Options)
 
-		q.processCurrentImagine()
-	}
-}
+		item.Prompt = flags.Prompt
+
+		// a plot is already one message with many images, so it doesn't fan out
+		item.Options.Combinatorial = false
 
-func (q *queueImpl) fillInBotDefaults(settings *entities.DefaultSettings) (*entities.DefaultSettings, bool) {
-	updated := false
+		_, err = q.expandDynamicPrompt(item)
+		if err != nil {
+			return 0, err
+		}
 
-	if settings == nil {
-		settings = &entities.DefaultSettings{
-			MemberID: botID,
+		err = item.Plot.validate(item.Prompt)
+		if err != nil {
+			return 0, err
+		}
+
+		profile, err := q.channelProfile(item)
+		if err != nil {
+			return 0, err
 		}
-	}
 
-	if settings.Width == 0 {
-		settings.Width = initializedWidth
-		updated = true
+		err = item.Plot.validateProfile(profile)
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	if settings.Height == 0 {
-		settings.Height = initializedHeight
-		updated = true
+	if item.Type == ItemTypeOutpaint && item.Outpaint == nil {
+		return 0, errors.New("missing outpaint options")
 	}
 
-	if settings.BatchCount == 0 {
-		settings.BatchCount = initializedBatchCount
-		updated = true
+	// images that aren't results of the bot are reimagined with the member's defaults
+	if item.Type == ItemTypeReimagine {
+		err := q.fillInDefaultOptions(item)
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	if settings.BatchSize == 0 {
-		settings.BatchSize = initializedBatchSize
-		updated = true
+	for _, queuedItem := range items {
+		appendNegativeTerms(&queuedItem.Options, negativeTerms)
 	}
 
-	if settings.NegativePrompt == "" {
-		settings.NegativePrompt = defaultNegativePrompt
-		updated = true
+	// wildcards and alternations can expand to text the check of the template didn't see
+	err = q.moderateExpanded(items)
+	if err != nil {
+		return 0, err
 	}
 
-	return settings, updated
+	return q.enqueue(items), nil
 }
 
-func (q *queueImpl) initializeOrGetBotDefaults() (*entities.DefaultSettings, error) {
-	botDefaultSettings, err := q.GetBotDefaultSettings()
-	if err != nil && !errors.Is(err, &repositories.NotFoundError{}) {
-		return nil, err
-	}
+// enqueue puts the items in line, and returns the position of the first one.
+func (q *queueImpl) enqueue(items []*QueueItem) int {
+	for _, queuedItem := range items {
+		queuedItem.JobID = newJobID()
 
-	botDefaultSettings, updated := q.fillInBotDefaults(botDefaultSettings)
-	if updated {
-		botDefaultSettings, err = q.defaultSettingsRepo.Upsert(context.Background(), botDefaultSettings)
-		if err != nil {
-			return nil, err
+		queuedItem.Source.Sink = &publishingSink{
+			next:                queuedItem.Source.Sink,
+			bus:                 q.eventBus,
+			imageGenerationRepo: q.imageGenerationRepo,
 		}
-
-		log.Printf("Initialized bot default settings: %+v\n", botDefaultSettings)
-	} else {
-		log.Printf("Retrieved bot default settings: %+v\n", botDefaultSettings)
 	}
 
-	return botDefaultSettings, nil
-}
+	q.queue <- items[0]
 
-func (q *queueImpl) GetBotDefaultSettings() (*entities.DefaultSettings, error) {
-	if q.botDefaultSettings != nil {
-		return q.botDefaultSettings, nil
-	}
-
-	defaultSettings, err := q.defaultSettingsRepo.GetByMemberID(context.Background(), botID)
-	if err != nil {
-		return nil, err
-	}
+	linePosition := len(q.queue)
 
-	q.botDefaultSettings = defaultSettings
+	q.eventBus.Publish(JobQueued{JobEvent: JobEvent{Item: items[0], Time: time.Now()}, Position: linePosition})
 
-	return defaultSettings, nil
-}
+	for _, followupItem := range items[1:] {
+		q.queue <- followupItem
 
-func (q *queueImpl) defaultWidth() (int, error) {
-	defaultSettings, err := q.GetBotDefaultSettings()
-	if err != nil {
-		return 0, err
+		q.eventBus.Publish(JobQueued{JobEvent: JobEvent{Item: followupItem, Time: time.Now()}, Position: len(q.queue)})
 	}
 
-	return defaultSettings.Width, nil
+	return linePosition
 }
 
-func (q *queueImpl) defaultHeight() (int, error) {
-	defaultSettings, err := q.GetBotDefaultSettings()
-	if err != nil {
-		return 0, err
-	}
-
-	return defaultSettings.Height, nil
+// Subscribe hands the events of all jobs to the subscriber.
+func (q *queueImpl) Subscribe(subscriber Subscriber) {
+	q.eventBus.Subscribe(subscriber)
 }
 
-func (q *queueImpl) defaultBatchCount() (int, error) {
-	defaultSettings, err := q.GetBotDefaultSettings()
-	if err != nil {
-		return 0, err
+// expandDynamicPrompt resolves alternations and wildcards in the prompt. In combinatorial mode every combination
+// becomes its own queue item, the first one being the passed item.
+func (q *queueImpl) expandDynamicPrompt(item *QueueItem) ([]*QueueItem, error) {
+	if !isDynamicPrompt(item.Prompt) && !isDynamicPrompt(item.Options.NegativePrompt) {
+		return []*QueueItem{item}, nil
 	}
 
-	return defaultSettings.BatchCount, nil
-}
-
-func (q *queueImpl) defaultBatchSize() (int, error) {
-	defaultSettings, err := q.GetBotDefaultSettings()
-	if err != nil {
-		return 0, err
+	// the seed also picks the expansion, so fix it up front to make the result reproducible
+	if item.Options.Seed == -1 {
+		item.Options.Seed = int(rand.Int31())
 	}
 
-	return defaultSettings.BatchSize, nil
-}
+	seed := int64(item.Options.Seed)
+	template := item.Prompt
 
-func (q *queueImpl) UpdateDefaultDimensions(width, height int) (*entities.DefaultSettings, error) {
-	defaultSettings, err := q.GetBotDefaultSettings()
+	negativePrompt, err := q.promptExpander.expandRandom(item.Options.NegativePrompt, seed)
 	if err != nil {
 		return nil, err
 	}
 
-	defaultSettings.Width = width
-	defaultSettings.Height = height
+	item.Options.NegativePrompt = negativePrompt
+	item.Options.PromptTemplate = template
 
-	newDefaultSettings, err := q.defaultSettingsRepo.Upsert(context.Background(), defaultSettings)
+	if !item.Options.Combinatorial {
+		prompt, err := q.promptExpander.expandRandom(template, seed)
+		if err != nil {
+			return nil, err
+		}
+
+		item.Prompt = prompt
+
+		return []*QueueItem{item}, nil
+	}
+
+	prompts, err := q.promptExpander.expandAll(template, maxCombinatorialPrompts)
 	if err != nil {
 		return nil, err
 	}
 
-	q.botDefaultSettings = newDefaultSettings
+	items := make([]*QueueItem, len(prompts))
+
+	for idx, prompt := range prompts {
+		if idx == 0 {
+			items[idx] = item
+		} else {
+			expandedItem := *item
+			expandedItem.IsFollowup = true
+
+			items[idx] = &expandedItem
+		}
 
-	log.Printf("Updated default dimensions to: %dx%d\n", width, height)
+		items[idx].Prompt = prompt
+	}
 
-	return newDefaultSettings, nil
+	log.Printf("Expanded combinatorial prompt \"%s\" into %d prompts", template, len(prompts))
+
+	return items, nil
 }
 
-func (q *queueImpl) UpdateDefaultBatch(batchCount, batchSize int) (*entities.DefaultSettings, error) {
-	defaultSettings, err := q.GetBotDefaultSettings()
+// startJob tells the sink that the job started, and remembers the message its results are posted in.
+func (q *queueImpl) startJob(imagine *QueueItem, status JobStatus) (string, error) {
+	messageID, err := imagine.Source.Sink.Started(imagine, status)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	defaultSettings.BatchCount = batchCount
-	defaultSettings.BatchSize = batchSize
+	imagine.ResultMessageID = messageID
+	imagine.startedAt = time.Now()
+
+	return messageID, nil
+}
 
-	newDefaultSettings, err := q.defaultSettingsRepo.Upsert(context.Background(), defaultSettings)
+func (q *queueImpl) StartPolling() {
+	_, err := q.initializeOrGetBotDefaults()
 	if err != nil {
-		return nil, err
+		log.Printf("Error getting/initializing bot default settings: %v", err)
+
+		return
 	}
 
-	q.botDefaultSettings = newDefaultSettings
+	log.Println("Press Ctrl+C to exit")
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+
+	stopPolling := false
+
+	for {
+		select {
+		case <-stop:
+			stopPolling = true
+		case <-time.After(1 * time.Second):
+			if q.currentImagine == nil {
+				q.pullNextInQueue()
+			}
+		}
 
-	log.Printf("Updated default batch count/size to: %d/%d\n", batchCount, batchSize)
+		if stopPolling {
+			break
+		}
+	}
 
-	return newDefaultSettings, nil
+	log.Printf("Polling stopped...\n")
 }
 
-type dimensionsResult struct {
-	SanitizedPrompt string
-	Width           int
-	Height          int
+func (q *queueImpl) pullNextInQueue() {
+	if len(q.queue) > 0 {
+		element := <-q.queue
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		q.currentImagine = element
+
+		q.processCurrentImagine()
+	}
 }
 
 const (
@@ -354,63 +491,98 @@ func fixEmDash(prompt string) string {
 	return strings.ReplaceAll(prompt, string(emdash), string(hyphen)+string(hyphen))
 }
 
-var arRegex = regexp.MustCompile(`\s?--ar ([\d]*):([\d]*)\s?`)
+// scaleToAspectRatio uses the default width or height, and calculates the other dimension from the aspect ratio.
+func scaleToAspectRatio(aspectWidth, aspectHeight, width, height int) (int, int) {
+	if aspectWidth > aspectHeight {
+		scaledWidth := float64(height) * (float64(aspectWidth) / float64(aspectHeight))
 
-func extractDimensionsFromPrompt(prompt string, width, height int) (*dimensionsResult, error) {
-	// Sanitize em dashes. Some phones will autocorrect to em dashes
-	prompt = fixEmDash(prompt)
+		// Round up to the nearest 8
+		width = (int(scaledWidth) + 7) & (-8)
+	} else if aspectHeight > aspectWidth {
+		scaledHeight := float64(width) * (float64(aspectHeight) / float64(aspectWidth))
 
-	arMatches := arRegex.FindStringSubmatch(prompt)
-
-	if len(arMatches) == 3 {
-		log.Printf("Aspect ratio overwrite: %#v", arMatches)
+		// Round up to the nearest 8
+		height = (int(scaledHeight) + 7) & (-8)
+	}
 
-		prompt = arRegex.ReplaceAllString(prompt, "")
+	return width, height
+}
 
-		firstDimension, err := strconv.Atoi(arMatches[1])
-		if err != nil {
-			return nil, err
-		}
+const (
+	DefaultDenoisingStrength = 0.5
+	DefaultSeed              = -1
+)
 
-		secondDimension, err := strconv.Atoi(arMatches[2])
-		if err != nil {
-			return nil, err
-		}
+// newGenerationFromOptions builds a generation from the member's defaults and the options passed with the queue item.
+func (q *queueImpl) newGenerationFromOptions(imagine *QueueItem) (*entities.ImageGeneration, error) {
+	defaultSettings, err := q.settingsForImagine(imagine)
+	if err != nil {
+		return nil, err
+	}
 
-		if firstDimension > secondDimension {
-			scaledWidth := float64(height) * (float64(firstDimension) / float64(secondDimension))
+	defaultWidth := defaultSettings.Width
+	defaultHeight := defaultSettings.Height
 
-			// Round up to the nearest 8
-			width = (int(scaledWidth) + 7) & (-8)
-		} else if secondDimension > firstDimension {
-			scaledHeight := float64(width) * (float64(secondDimension) / float64(firstDimension))
+	options := imagine.Options
 
-			// Round up to the nearest 8
-			height = (int(scaledHeight) + 7) & (-8)
-		}
+	width, height := scaleToAspectRatio(options.AspectWidth, options.AspectHeight, defaultWidth, defaultHeight)
 
+	if width != defaultWidth || height != defaultHeight {
 		log.Printf("New dimensions: width: %v, height: %v", width, height)
 	}
 
-	return &dimensionsResult{
-		SanitizedPrompt: prompt,
-		Width:           width,
-		Height:          height,
-	}, nil
-}
+	// bigger images are generated at the default size first and upscaled with hires fix, unless that is turned off
+	enableHR := (width > defaultWidth || height > defaultHeight) && defaultSettings.EnableHR != nil && *defaultSettings.EnableHR
 
-const (
-	DefaultCFGScale          = 7
-	DefaultDenoisingStrength = 0.5
-	DefaultNegative          = "ugly, tiling, poorly drawn hands, poorly drawn feet, poorly drawn face, out of frame, " +
-		"mutation, mutated, extra limbs, extra legs, extra arms, disfigured, deformed, cross-eye, " +
-		"body out of frame, blurry, bad art, bad anatomy, blurred, text, watermark, grainy"
-	DefaultRestoreFaces = false
-	DefaultSampler      = "Euler a"
-	DefaultSteps        = 20
-	DefaultSeed         = -1
-	DefaultHiRes        = true
-)
+	if options.HiresOverride != nil {
+		enableHR = *options.HiresOverride
+	}
+
+	generationWidth := width
+	generationHeight := height
+	hiresWidth := 0
+	hiresHeight := 0
+
+	if enableHR {
+		// generate at the default size, and let hires fix resize it to the requested dimensions
+		generationWidth = defaultWidth
+		generationHeight = defaultHeight
+		hiresWidth = width
+		hiresHeight = height
+
+		if width == defaultWidth && height == defaultHeight {
+			hiresWidth = width * 2
+			hiresHeight = height * 2
+		}
+	}
+
+	// new generation with defaults
+	newGeneration := &entities.ImageGeneration{
+		Prompt:            imagine.Prompt,
+		NegativePrompt:    options.NegativePrompt,
+		Width:             generationWidth,
+		Height:            generationHeight,
+		RestoreFaces:      options.RestoreFaces != nil && *options.RestoreFaces,
+		EnableHR:          enableHR,
+		HiresWidth:        hiresWidth,
+		HiresHeight:       hiresHeight,
+		DenoisingStrength: options.DenoisingStrength,
+		BatchCount:        options.BatchCount,
+		BatchSize:         options.BatchSize,
+		Seed:              options.Seed,
+		Subseed:           -1,
+		SubseedStrength:   options.SubseedStrength,
+		SamplerName:       options.SamplerName,
+		CfgScale:          options.CfgScale,
+		Steps:             options.Steps,
+		Checkpoint:        options.Model,
+		Style:             options.Style,
+		PromptTemplate:    options.PromptTemplate,
+		Processed:         false,
+	}
+
+	return newGeneration, nil
+}
 
 func (q *queueImpl) processCurrentImagine() {
 	go func() {
@@ -427,62 +599,40 @@ func (q *queueImpl) processCurrentImagine() {
 			return
 		}
 
-		defaultWidth, err := q.defaultWidth()
-		if err != nil {
-			log.Printf("Error getting default width: %v", err)
+		if q.currentImagine.Type == ItemTypePlot {
+			q.processPlotImagine(q.currentImagine)
 
 			return
 		}
 
-		defaultHeight, err := q.defaultHeight()
-		if err != nil {
-			log.Printf("Error getting default height: %v", err)
+		if q.currentImagine.Type == ItemTypeOutpaint {
+			q.processOutpaintImagine(q.currentImagine)
 
 			return
 		}
 
-		promptRes, err := extractDimensionsFromPrompt(q.currentImagine.Prompt, defaultWidth, defaultHeight)
-		if err != nil {
-			log.Printf("Error extracting dimensions from prompt: %v", err)
+		if q.currentImagine.Type == ItemTypeReimagine {
+			q.processReimagineImagine(q.currentImagine)
 
 			return
 		}
 
-		enableHR := false
-		hiresWidth := 0
-		hiresHeight := 0
+		newGeneration, err := q.newGenerationFromOptions(q.currentImagine)
+		if err != nil {
+			log.Printf("Error creating generation from options: %v", err)
 
-		if promptRes.Width > defaultWidth || promptRes.Height > defaultHeight {
-			enableHR = true
-			hiresWidth = promptRes.Width
-			hiresHeight = promptRes.Height
-		}
+			q.currentImagine.Source.Sink.Failed(q.currentImagine, err)
 
-		// new generation with defaults
-		newGeneration := &entities.ImageGeneration{
-			Prompt:            promptRes.SanitizedPrompt,
-			NegativePrompt:    q.currentImagine.Options.NegativePrompt,
-			Width:             defaultWidth,
-			Height:            defaultHeight,
-			RestoreFaces:      q.currentImagine.Options.RestoreFaces,
-			EnableHR:          enableHR,
-			HiresWidth:        hiresWidth,
-			HiresHeight:       hiresHeight,
-			DenoisingStrength: q.currentImagine.Options.DenoisingStrength,
-			Seed:              q.currentImagine.Options.Seed,
-			Subseed:           -1,
-			SubseedStrength:   0,
-			SamplerName:       q.currentImagine.Options.SamplerName,
-			CfgScale:          q.currentImagine.Options.CfgScale,
-			Steps:             q.currentImagine.Options.Steps,
-			Processed:         false,
+			return
 		}
 
 		if q.currentImagine.Type == ItemTypeReroll || q.currentImagine.Type == ItemTypeVariation {
-			foundGeneration, err := q.getPreviousGeneration(q.currentImagine, q.currentImagine.InteractionIndex)
+			foundGeneration, err := q.GetPreviousGeneration(q.currentImagine, q.currentImagine.InteractionIndex)
 			if err != nil {
 				log.Printf("Error getting prompt for reroll: %v", err)
 
+				q.currentImagine.Source.Sink.Failed(q.currentImagine, err)
+
 				return
 			}
 
@@ -499,8 +649,37 @@ func (q *queueImpl) processCurrentImagine() {
 
 			// for variations, the subseed strength determines how much variation we get
 			if q.currentImagine.Type == ItemTypeVariation {
-				newGeneration.SubseedStrength = 0.15
+				strength, err := q.variationSubseedStrength(q.currentImagine)
+				if err != nil {
+					log.Printf("Error getting variation strength: %v", err)
+
+					q.currentImagine.Source.Sink.Failed(q.currentImagine, err)
+
+					return
+				}
+
+				newGeneration.ParentID = foundGeneration.ID
+				newGeneration.VariationStrength = strength
+				newGeneration.SubseedStrength = stackedSubseedStrength(foundGeneration, strength)
+			}
+		}
+
+		if q.currentImagine.Type == ItemTypeRemix {
+			foundGeneration, err := q.GetPreviousGeneration(q.currentImagine, q.currentImagine.InteractionIndex)
+			if err != nil {
+				log.Printf("Error getting generation for remix: %v", err)
+
+				q.currentImagine.Source.Sink.Failed(q.currentImagine, err)
+
+				return
 			}
+
+			// a remix keeps the seed and settings of the original, only the prompts are edited
+			newGeneration = foundGeneration
+			newGeneration.ParentID = foundGeneration.ID
+			newGeneration.Prompt = q.currentImagine.Prompt
+			newGeneration.NegativePrompt = q.currentImagine.Options.NegativePrompt
+			newGeneration.PromptTemplate = ""
 		}
 
 		err = q.processImagineGrid(newGeneration, q.currentImagine)
@@ -512,13 +691,10 @@ func (q *queueImpl) processCurrentImagine() {
 	}()
 }
 
-func (q *queueImpl) getPreviousGeneration(imagine *QueueItem, sortOrder int) (*entities.ImageGeneration, error) {
-	interactionID := imagine.DiscordInteraction.ID
-	messageID := ""
-
-	if imagine.DiscordInteraction.Message != nil {
-		messageID = imagine.DiscordInteraction.Message.ID
-	}
+// GetPreviousGeneration looks up the generation with the sort order on the message the interaction belongs to.
+func (q *queueImpl) GetPreviousGeneration(imagine *QueueItem, sortOrder int) (*entities.ImageGeneration, error) {
+	interactionID := imagine.Source.RequestID
+	messageID := imagine.Source.MessageID
 
 	log.Printf("Reimagining interaction: %v, Message: %v", interactionID, messageID)
 
@@ -534,62 +710,105 @@ func (q *queueImpl) getPreviousGeneration(imagine *QueueItem, sortOrder int) (*e
 	return generation, nil
 }
 
-func imagineMessageContent(generation *entities.ImageGeneration, userID string, progress float64) string {
-	if progress >= 0 && progress < 1 {
-		return fmt.Sprintf("<@%s> asked me to imagine \"%s\" without \"%s\". Currently dreaming it up for them. Progress: %.0f%%",
-			userID, generation.Prompt, generation.NegativePrompt, progress*100)
-	} else {
-		return fmt.Sprintf("<@%s> asked me to imagine \"%s\" without \"%s\", here is what I imagined for them.",
-			userID,
-			generation.Prompt,
-			generation.NegativePrompt,
-		)
+// ListGenerationsByMember returns the images the member generated, the latest first.
+func (q *queueImpl) ListGenerationsByMember(memberID string, options image_generations.ListOptions) ([]*entities.ImageGeneration, error) {
+	return q.imageGenerationRepo.ListByMember(context.Background(), memberID, options)
+}
+
+// ListGenerationsByMessage returns the generations of a result message, by their sort order.
+func (q *queueImpl) ListGenerationsByMessage(messageID string) ([]*entities.ImageGeneration, error) {
+	return q.imageGenerationRepo.ListByMessage(context.Background(), messageID)
+}
+
+// SearchGenerations returns the images whose prompts match the query, the best matches first.
+func (q *queueImpl) SearchGenerations(query string, filters image_generations.SearchFilters) ([]*entities.ImageGeneration, error) {
+	return q.imageGenerationRepo.Search(context.Background(), query, filters)
+}
+
+// withoutHires turns hires fix off, the images are rendered at the size hires fix would have started from.
+func withoutHires(generation *entities.ImageGeneration) {
+	generation.EnableHR = false
+	generation.HiresWidth = 0
+	generation.HiresHeight = 0
+}
+
+// generationStyles returns the prompt styles to apply for the generation, if any.
+func generationStyles(generation *entities.ImageGeneration) []string {
+	if generation.Style == "" {
+		return nil
 	}
+
+	return []string{generation.Style}
 }
 
 func (q *queueImpl) processImagineGrid(newGeneration *entities.ImageGeneration, imagine *QueueItem) error {
 
-	interactionID := imagine.DiscordInteraction.ID
-	userID := ""
+	interactionID := imagine.Source.RequestID
+	userID := imagine.Source.MemberID
+
+	log.Printf("Processing imagine #%s: %v\n", interactionID, newGeneration.Prompt)
+
+	// variations repaint the archived image when there is one, so they stay close to it even when the model or the
+	// WebUI changed since it was rendered
+	var variationSource []byte
 
-	if imagine.DiscordInteraction.Member != nil && imagine.DiscordInteraction.Member.User != nil {
-		userID = imagine.DiscordInteraction.Member.User.ID
-	} else if imagine.DiscordInteraction.User != nil {
-		userID = imagine.DiscordInteraction.User.ID
+	if imagine.Type == ItemTypeVariation {
+		variationSource = q.archivedImage(newGeneration)
 	}
 
-	log.Printf("Processing imagine #%s: %v\n", interactionID, newGeneration.Prompt)
+	if variationSource != nil {
+		// img2img repaints the image at the size it was shown in, it has no hires pass
+		newGeneration.Width, newGeneration.Height = shownSize(newGeneration)
+		newGeneration.DenoisingStrength = archivedVariationDenoisingStrength
+		withoutHires(newGeneration)
+	}
 
-	newContent := imagineMessageContent(newGeneration, userID, 0)
+	// batch settings passed with the prompt, or stored on a rerolled generation, take precedence over the defaults
+	if newGeneration.BatchCount == 0 || newGeneration.BatchSize == 0 {
+		defaultSettings, err := q.settingsForImagine(imagine)
+		if err != nil {
+			log.Printf("Error getting default batch settings: %v", err)
 
-	message, err := q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-		Content: &newContent,
-	})
-	if err != nil {
-		log.Printf("Error editing interaction: %v", err)
+			imagine.Source.Sink.Failed(imagine, err)
+
+			return err
+		}
+
+		newGeneration.BatchCount = defaultSettings.BatchCount
+		newGeneration.BatchSize = defaultSettings.BatchSize
 	}
 
-	defaultBatchCount, err := q.defaultBatchCount()
+	// the channel's profile has the last word, whatever the member asked for
+	_, err := q.applyProfile(imagine, newGeneration)
 	if err != nil {
-		log.Printf("Error getting default batch count: %v", err)
+		log.Printf("Error getting channel profile: %v", err)
+
+		imagine.Source.Sink.Failed(imagine, err)
 
 		return err
 	}
 
-	defaultBatchSize, err := q.defaultBatchSize()
+	if imagine.Source.HiresDenied {
+		withoutHires(newGeneration)
+	}
+
+	messageID, err := q.startJob(imagine, JobStatus{Generation: newGeneration})
 	if err != nil {
-		log.Printf("Error getting default batch size: %v", err)
+		log.Printf("Error starting imagine: %v", err)
 
 		return err
 	}
 
 	newGeneration.InteractionID = interactionID
-	newGeneration.MessageID = message.ID
+	newGeneration.MessageID = messageID
 	newGeneration.MemberID = userID
+	newGeneration.GuildID = imagine.Source.GuildID
+	newGeneration.ChannelID = imagine.Source.ChannelID
 	newGeneration.SortOrder = 0
-	newGeneration.BatchCount = defaultBatchCount
-	newGeneration.BatchSize = defaultBatchSize
 	newGeneration.Processed = true
+	// rerolls and variations start from a stored image, but the base row of a grid has no image of its own
+	newGeneration.ImagePath = ""
+	newGeneration.ImageHash = ""
 
 	_, err = q.imageGenerationRepo.Create(context.Background(), newGeneration)
 	if err != nil {
@@ -615,13 +834,12 @@ func (q *queueImpl) processImagineGrid(newGeneration *entities.ImageGeneration,
 					continue
 				}
 
-				progressContent := imagineMessageContent(newGeneration, userID, progress.Progress)
-
-				_, progressErr = q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-					Content: &progressContent,
+				progressErr = imagine.Source.Sink.Progress(imagine, JobStatus{
+					Generation: newGeneration,
+					Progress:   progress.Progress,
 				})
 				if progressErr != nil {
-					log.Printf("Error editing interaction: %v", err)
+					log.Printf("Error reporting progress: %v", progressErr)
 				}
 			}
 		}
@@ -636,53 +854,80 @@ func (q *queueImpl) processImagineGrid(newGeneration *entities.ImageGeneration,
 		returnGrid = false
 	}
 
-	resp, err := q.stableDiffusionAPI.TextToImage(&stable_diffusion_api.TextToImageRequest{
-		Prompt:            newGeneration.Prompt,
-		NegativePrompt:    newGeneration.NegativePrompt,
-		Width:             newGeneration.Width,
-		Height:            newGeneration.Height,
-		RestoreFaces:      newGeneration.RestoreFaces,
-		EnableHR:          newGeneration.EnableHR,
-		HRResizeX:         newGeneration.HiresWidth,
-		HRResizeY:         newGeneration.HiresHeight,
-		DenoisingStrength: newGeneration.DenoisingStrength,
-		BatchSize:         newGeneration.BatchSize,
-		Seed:              newGeneration.Seed,
-		Subseed:           newGeneration.Subseed,
-		SubseedStrength:   newGeneration.SubseedStrength,
-		SamplerName:       newGeneration.SamplerName,
-		CfgScale:          newGeneration.CfgScale,
-		Steps:             newGeneration.Steps,
-		NIter:             newGeneration.BatchCount,
-		SaveImages:        true,
-		OverrideSettings: stable_diffusion_api.Txt2ImgOverrideSettings{
-			GridFormat:    "webp",
-			ReturnGrid:    &returnGrid,
-			SamplesFormat: "webp",
-		},
-	})
+	var resp *stable_diffusion_api.TextToImageResponse
+
+	if variationSource != nil {
+		resp, err = q.stableDiffusionAPI.ImageToImage(&stable_diffusion_api.ImageToImageRequest{
+			InitImages:        []string{base64.StdEncoding.EncodeToString(variationSource)},
+			Prompt:            newGeneration.Prompt,
+			NegativePrompt:    newGeneration.NegativePrompt,
+			Width:             newGeneration.Width,
+			Height:            newGeneration.Height,
+			RestoreFaces:      newGeneration.RestoreFaces,
+			DenoisingStrength: newGeneration.DenoisingStrength,
+			BatchSize:         newGeneration.BatchSize,
+			Seed:              newGeneration.Seed,
+			Subseed:           newGeneration.Subseed,
+			SubseedStrength:   newGeneration.SubseedStrength,
+			SamplerName:       newGeneration.SamplerName,
+			CfgScale:          newGeneration.CfgScale,
+			Steps:             newGeneration.Steps,
+			NIter:             newGeneration.BatchCount,
+			Styles:            generationStyles(newGeneration),
+			SaveImages:        true,
+			OverrideSettings: stable_diffusion_api.Txt2ImgOverrideSettings{
+				SamplesFormat:     "webp",
+				SDModelCheckpoint: newGeneration.Checkpoint,
+			},
+		})
+	} else {
+		resp, err = q.stableDiffusionAPI.TextToImage(&stable_diffusion_api.TextToImageRequest{
+			Prompt:            newGeneration.Prompt,
+			NegativePrompt:    newGeneration.NegativePrompt,
+			Width:             newGeneration.Width,
+			Height:            newGeneration.Height,
+			RestoreFaces:      newGeneration.RestoreFaces,
+			EnableHR:          newGeneration.EnableHR,
+			HRResizeX:         newGeneration.HiresWidth,
+			HRResizeY:         newGeneration.HiresHeight,
+			DenoisingStrength: newGeneration.DenoisingStrength,
+			BatchSize:         newGeneration.BatchSize,
+			Seed:              newGeneration.Seed,
+			Subseed:           newGeneration.Subseed,
+			SubseedStrength:   newGeneration.SubseedStrength,
+			SamplerName:       newGeneration.SamplerName,
+			CfgScale:          newGeneration.CfgScale,
+			Steps:             newGeneration.Steps,
+			NIter:             newGeneration.BatchCount,
+			Styles:            generationStyles(newGeneration),
+			SaveImages:        true,
+			OverrideSettings: stable_diffusion_api.Txt2ImgOverrideSettings{
+				GridFormat:        "webp",
+				ReturnGrid:        &returnGrid,
+				SamplesFormat:     "webp",
+				SDModelCheckpoint: newGeneration.Checkpoint,
+			},
+		})
+	}
+
 	if err != nil {
 		log.Printf("Error processing image: %v\n", err)
 
-		b, err := json.MarshalIndent(newGeneration, "", "\t")
-		log.Printf("req: \n%s\n%v", b, err)
+		b, marshalErr := json.MarshalIndent(newGeneration, "", "\t")
+		log.Printf("req: \n%s\n%v", b, marshalErr)
 
-		errorContent := "I'm sorry, but I had a problem imagining your image."
+		close(generationDone)
 
-		_, err = q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-			Content: &errorContent,
-		})
+		imagine.Source.Sink.Failed(imagine, err)
 
 		return err
 	}
 
 	generationDone <- true
 
-	finishedContent := imagineMessageContent(newGeneration, userID, 1)
-
 	log.Printf("Seeds: %v Subseeds:%v", resp.Seeds, resp.Subseeds)
 
-	var files []*discordgo.File
+	var images []ResultImage
 
 	if useDistinctImagesGrid {
 		for idx, image := range resp.Images {
@@ -691,12 +936,12 @@ func (q *queueImpl) processImagineGrid(newGeneration *entities.ImageGeneration,
 				log.Printf("Error decoding image: %v\n", decodeErr)
 			}
 
-			files = append(files, &discordgo.File{
+			images = append(images, ResultImage{
 				// Actually undefined file type comes here since it depends on settings set on WEB UI called samples_format (overriding format is not working for txt2img API for some reason).
 				// But it's fine! Discord handles it anyway
 				ContentType: "image/png",
 				Name:        fmt.Sprintf("seed-%d-%s.png", resp.Seeds[idx], resp.Model),
-				Reader:      bytes.NewBuffer(decodedImage),
+				Data:        decodedImage,
 			})
 		}
 	} else {
@@ -704,12 +949,12 @@ func (q *queueImpl) processImagineGrid(newGeneration *entities.ImageGeneration,
 		if decodeErr != nil {
 			log.Printf("Error decoding image: %v\n", decodeErr)
 		}
-		files = append(files, &discordgo.File{
+		images = append(images, ResultImage{
 			// Actually undefined file type comes here since it depends on settings set on WEB UI called samples_format (overriding format is not working for txt2img API for some reason).
 			// But it's fine! Discord handles it anyway
 			ContentType: "image/png",
 			Name:        fmt.Sprintf("seeds-%d-%s.png", resp.Seeds, resp.Model),
-			Reader:      bytes.NewBuffer(decodedGrid),
+			Data:        decodedGrid,
 		})
 	}
 
@@ -720,6 +965,8 @@ func (q *queueImpl) processImagineGrid(newGeneration *entities.ImageGeneration,
 			InteractionID:     newGeneration.InteractionID,
 			MessageID:         newGeneration.MessageID,
 			MemberID:          newGeneration.MemberID,
+			GuildID:           newGeneration.GuildID,
+			ChannelID:         newGeneration.ChannelID,
 			SortOrder:         idx + 1,
 			Prompt:            newGeneration.Prompt,
 			NegativePrompt:    newGeneration.NegativePrompt,
@@ -738,480 +985,38 @@ func (q *queueImpl) processImagineGrid(newGeneration *entities.ImageGeneration,
 			SamplerName:       newGeneration.SamplerName,
 			CfgScale:          newGeneration.CfgScale,
 			Steps:             newGeneration.Steps,
+			Checkpoint:        newGeneration.Checkpoint,
+			Style:             newGeneration.Style,
+			PromptTemplate:    newGeneration.PromptTemplate,
+			ParentID:          newGeneration.ParentID,
+			VariationStrength: newGeneration.VariationStrength,
 			Processed:         true,
 		}
 
+		if idx < len(resp.Infotexts) {
+			subGeneration.Infotext = resp.Infotexts[idx]
+		}
+
+		if useDistinctImagesGrid && idx < len(images) {
+			images[idx].URL = q.archivedImageURL(q.archiveImage(subGeneration, images[idx].Data))
+		}
+
 		_, createErr := q.imageGenerationRepo.Create(context.Background(), subGeneration)
 		if createErr != nil {
 			log.Printf("Error creating image generation record: %v\n", createErr)
 		}
 	}
 
-	_, err = q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-		Content: &finishedContent,
-		Files:   files,
-		Components: &[]discordgo.MessageComponent{
-			discordgo.ActionsRow{
-				Components: []discordgo.MessageComponent{
-					discordgo.Button{
-						// Label is what the user will see on the button.
-						Label: "V1",
-						// Style provides coloring of the button. There are not so many styles tho.
-						Style: discordgo.SecondaryButton,
-						// Disabled allows bot to disable some buttons for users.
-						Disabled: false,
-						// CustomID is a thing telling Discord which data to send when this button will be pressed.
-						CustomID: "imagine_variation_1",
-						Emoji: discordgo.ComponentEmoji{
-							Name: "♻️",
-						},
-					},
-					discordgo.Button{
-						// Label is what the user will see on the button.
-						Label: "V2",
-						// Style provides coloring of the button. There are not so many styles tho.
-						Style: discordgo.SecondaryButton,
-						// Disabled allows bot to disable some buttons for users.
-						Disabled: false,
-						// CustomID is a thing telling Discord which data to send when this button will be pressed.
-						CustomID: "imagine_variation_2",
-						Emoji: discordgo.ComponentEmoji{
-							Name: "♻️",
-						},
-					},
-					discordgo.Button{
-						// Label is what the user will see on the button.
-						Label: "V3",
-						// Style provides coloring of the button. There are not so many styles tho.
-						Style: discordgo.SecondaryButton,
-						// Disabled allows bot to disable some buttons for users.
-						Disabled: false,
-						// CustomID is a thing telling Discord which data to send when this button will be pressed.
-						CustomID: "imagine_variation_3",
-						Emoji: discordgo.ComponentEmoji{
-							Name: "♻️",
-						},
-					},
-					discordgo.Button{
-						// Label is what the user will see on the button.
-						Label: "V4",
-						// Style provides coloring of the button. There are not so many styles tho.
-						Style: discordgo.SecondaryButton,
-						// Disabled allows bot to disable some buttons for users.
-						Disabled: false,
-						// CustomID is a thing telling Discord which data to send when this button will be pressed.
-						CustomID: "imagine_variation_4",
-						Emoji: discordgo.ComponentEmoji{
-							Name: "♻️",
-						},
-					},
-					discordgo.Button{
-						// Label is what the user will see on the button.
-						Label: "Re-roll",
-						// Style provides coloring of the button. There are not so many styles tho.
-						Style: discordgo.PrimaryButton,
-						// Disabled allows bot to disable some buttons for users.
-						Disabled: false,
-						// CustomID is a thing telling Discord which data to send when this button will be pressed.
-						CustomID: "imagine_reroll",
-						Emoji: discordgo.ComponentEmoji{
-							Name: "🎲",
-						},
-					},
-				},
-			},
-			discordgo.ActionsRow{
-				Components: []discordgo.MessageComponent{
-					discordgo.Button{
-						// Label is what the user will see on the button.
-						Label: "U1",
-						// Style provides coloring of the button. There are not so many styles tho.
-						Style: discordgo.SecondaryButton,
-						// Disabled allows bot to disable some buttons for users.
-						Disabled: false,
-						// CustomID is a thing telling Discord which data to send when this button will be pressed.
-						CustomID: "imagine_upscale_1",
-						Emoji: discordgo.ComponentEmoji{
-							Name: "⬆️",
-						},
-					},
-					discordgo.Button{
-						// Label is what the user will see on the button.
-						Label: "U2",
-						// Style provides coloring of the button. There are not so many styles tho.
-						Style: discordgo.SecondaryButton,
-						// Disabled allows bot to disable some buttons for users.
-						Disabled: false,
-						// CustomID is a thing telling Discord which data to send when this button will be pressed.
-						CustomID: "imagine_upscale_2",
-						Emoji: discordgo.ComponentEmoji{
-							Name: "⬆️",
-						},
-					},
-					discordgo.Button{
-						// Label is what the user will see on the button.
-						Label: "U3",
-						// Style provides coloring of the button. There are not so many styles tho.
-						Style: discordgo.SecondaryButton,
-						// Disabled allows bot to disable some buttons for users.
-						Disabled: false,
-						// CustomID is a thing telling Discord which data to send when this button will be pressed.
-						CustomID: "imagine_upscale_3",
-						Emoji: discordgo.ComponentEmoji{
-							Name: "⬆️",
-						},
-					},
-					discordgo.Button{
-						// Label is what the user will see on the button.
-						Label: "U4",
-						// Style provides coloring of the button. There are not so many styles tho.
-						Style: discordgo.SecondaryButton,
-						// Disabled allows bot to disable some buttons for users.
-						Disabled: false,
-						// CustomID is a thing telling Discord which data to send when this button will be pressed.
-						CustomID: "imagine_upscale_4",
-						Emoji: discordgo.ComponentEmoji{
-							Name: "⬆️",
-						},
-					},
-				},
-			},
-		},
+	err = q.finishJob(imagine, &JobResult{
+		Generation: newGeneration,
+		Images:     images,
+		Model:      resp.Model,
 	})
 	if err != nil {
-		log.Printf("Error editing interaction: %v\n", err)
+		log.Printf("Error posting imagine result: %v\n", err)
 
 		return err
 	}
 
 	return nil
 }
-
-func upscaleMessageContent(userID string, fetchProgress, upscaleProgress float64) string {
-	if fetchProgress >= 0 && fetchProgress <= 1 && upscaleProgress < 1 {
-		if upscaleProgress == 0 {
-			return fmt.Sprintf("Currently upscaling the image for you... Fetch progress: %.0f%%", fetchProgress*100)
-		} else {
-			return fmt.Sprintf("Currently upscaling the image for you... Fetch progress: %.0f%% Upscale progress: %.0f%%",
-				fetchProgress*100, upscaleProgress*100)
-		}
-	} else {
-		return fmt.Sprintf("<@%s> asked me to upscale their image. Here's the result:",
-			userID)
-	}
-}
-
-func (q *queueImpl) processUpscaleImagine(imagine *QueueItem) {
-	if true {
-		q.processUpscaleImagineAlternative(q.currentImagine)
-		return
-	}
-
-	interactionID := imagine.DiscordInteraction.ID
-	messageID := ""
-	userID := ""
-
-	if imagine.DiscordInteraction.Message != nil {
-		messageID = imagine.DiscordInteraction.Message.ID
-	}
-
-	if imagine.DiscordInteraction.Member != nil && imagine.DiscordInteraction.Member.User != nil {
-		userID = imagine.DiscordInteraction.Member.User.ID
-	} else if imagine.DiscordInteraction.User != nil {
-		userID = imagine.DiscordInteraction.User.ID
-	}
-
-	log.Printf("Upscaling image: %v, Message: %v, Upscale Index: %d",
-		interactionID, messageID, imagine.InteractionIndex)
-
-	generation, err := q.imageGenerationRepo.GetByMessageAndSort(context.Background(), messageID, imagine.InteractionIndex)
-	if err != nil {
-		log.Printf("Error getting image generation: %v", err)
-
-		return
-	}
-
-	log.Printf("Found generation: %v", generation)
-
-	newContent := upscaleMessageContent(userID, 0, 0)
-
-	_, err = q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-		Content: &newContent,
-	})
-	if err != nil {
-		log.Printf("Error editing interaction: %v", err)
-	}
-
-	generationDone := make(chan bool)
-
-	go func() {
-		lastProgress := float64(0)
-		fetchProgress := float64(0)
-		upscaleProgress := float64(0)
-
-		for {
-			select {
-			case <-generationDone:
-				return
-			case <-time.After(1 * time.Second):
-				progress, progressErr := q.stableDiffusionAPI.GetCurrentProgress()
-				if progressErr != nil {
-					log.Printf("Error getting current progress: %v", progressErr)
-
-					return
-				}
-
-				if progress.Progress == 0 {
-					continue
-				}
-
-				if progress.Progress < lastProgress || upscaleProgress > 0 {
-					upscaleProgress = progress.Progress
-					fetchProgress = 1
-				} else {
-					fetchProgress = progress.Progress
-				}
-
-				lastProgress = progress.Progress
-
-				progressContent := upscaleMessageContent(userID, fetchProgress, upscaleProgress)
-
-				_, progressErr = q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-					Content: &progressContent,
-				})
-				if progressErr != nil {
-					log.Printf("Error editing interaction: %v", err)
-				}
-			}
-		}
-	}()
-
-	resp, err := q.stableDiffusionAPI.UpscaleImage(&stable_diffusion_api.UpscaleRequest{
-		ResizeMode:      0,
-		UpscalingResize: 2,
-		Upscaler1:       "ESRGAN_4x",
-		TextToImageRequest: &stable_diffusion_api.TextToImageRequest{
-			Prompt:            generation.Prompt,
-			NegativePrompt:    generation.NegativePrompt,
-			Width:             generation.Width,
-			Height:            generation.Height,
-			RestoreFaces:      generation.RestoreFaces,
-			EnableHR:          generation.EnableHR,
-			HRResizeX:         generation.HiresWidth,
-			HRResizeY:         generation.HiresHeight,
-			DenoisingStrength: generation.DenoisingStrength,
-			BatchSize:         1,
-			Seed:              generation.Seed,
-			Subseed:           generation.Subseed,
-			SubseedStrength:   generation.SubseedStrength,
-			SamplerName:       generation.SamplerName,
-			CfgScale:          generation.CfgScale,
-			Steps:             generation.Steps,
-			NIter:             1,
-			SaveImages:        true,
-			OverrideSettings: stable_diffusion_api.Txt2ImgOverrideSettings{
-				SamplesFormat: "webp",
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("Error processing image upscale: %v\n", err)
-
-		errorContent := "I'm sorry, but I had a problem upscaling your image."
-
-		_, err = q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-			Content: &errorContent,
-		})
-
-		return
-	}
-
-	generationDone <- true
-
-	decodedImage, decodeErr := base64.StdEncoding.DecodeString(resp.Image)
-	if decodeErr != nil {
-		log.Printf("Error decoding image: %v\n", decodeErr)
-
-		return
-	}
-
-	imageBuf := bytes.NewBuffer(decodedImage)
-
-	log.Printf("Successfully upscaled image: %v, Message: %v, Upscale Index: %d",
-		interactionID, messageID, imagine.InteractionIndex)
-
-	finishedContent := fmt.Sprintf("<@%s> asked me to upscale their image. Here's the result:",
-		userID)
-
-	_, err = q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-		Content: &finishedContent,
-		Files: []*discordgo.File{
-			{
-				ContentType: "image/png",
-				Name:        fmt.Sprintf("seed-%d.png", generation.Seed),
-				Reader:      imageBuf,
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("Error editing interaction: %v\n", err)
-
-		return
-	}
-}
-
-func (q *queueImpl) processUpscaleImagineAlternative(imagine *QueueItem) {
-	interactionID := imagine.DiscordInteraction.ID
-	messageID := ""
-	userID := ""
-
-	if imagine.DiscordInteraction.Message != nil {
-		messageID = imagine.DiscordInteraction.Message.ID
-	}
-
-	if imagine.DiscordInteraction.Member != nil && imagine.DiscordInteraction.Member.User != nil {
-		userID = imagine.DiscordInteraction.Member.User.ID
-	} else if imagine.DiscordInteraction.User != nil {
-		userID = imagine.DiscordInteraction.User.ID
-	}
-
-	log.Printf("Upscaling image: %v, Message: %v, Upscale Index: %d",
-		interactionID, messageID, imagine.InteractionIndex)
-
-	generation, err := q.imageGenerationRepo.GetByMessageAndSort(context.Background(), messageID, imagine.InteractionIndex)
-	if err != nil {
-		log.Printf("Error getting image generation: %v", err)
-
-		return
-	}
-
-	log.Printf("Found generation: %v", generation)
-
-	newContent := upscaleMessageContent(userID, 0, 0)
-
-	_, err = q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-		Content: &newContent,
-	})
-	if err != nil {
-		log.Printf("Error editing interaction: %v", err)
-	}
-
-	generationDone := make(chan bool)
-
-	go func() {
-		lastProgress := float64(0)
-		fetchProgress := float64(0)
-		upscaleProgress := float64(0)
-
-		for {
-			select {
-			case <-generationDone:
-				return
-			case <-time.After(1 * time.Second):
-				progress, progressErr := q.stableDiffusionAPI.GetCurrentProgress()
-				if progressErr != nil {
-					log.Printf("Error getting current progress: %v", progressErr)
-
-					return
-				}
-
-				if progress.Progress == 0 {
-					continue
-				}
-
-				if progress.Progress < lastProgress || upscaleProgress > 0 {
-					upscaleProgress = progress.Progress
-					fetchProgress = 1
-				} else {
-					fetchProgress = progress.Progress
-				}
-
-				lastProgress = progress.Progress
-
-				progressContent := upscaleMessageContent(userID, fetchProgress, upscaleProgress)
-
-				_, progressErr = q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-					Content: &progressContent,
-				})
-				if progressErr != nil {
-					log.Printf("Error editing interaction: %v", err)
-				}
-			}
-		}
-	}()
-
-	//generation.EnableHR = true
-	const hiresCoeff = 2
-	//// Round up to the nearest 8
-	generation.HiresWidth = (int(float32(generation.HiresWidth)*hiresCoeff) + 7) & (-8)
-	generation.HiresHeight = (int(float32(generation.HiresHeight)*hiresCoeff) + 7) & (-8)
-
-	resp, err := q.stableDiffusionAPI.TextToImage(&stable_diffusion_api.TextToImageRequest{
-		Prompt:         generation.Prompt,
-		NegativePrompt: generation.NegativePrompt,
-		Width:          generation.Width,
-		Height:         generation.Height,
-		RestoreFaces:   generation.RestoreFaces,
-		EnableHR:       true,
-		//HrScale:           2,
-		HrUpscaler:        "4x_escale_100000_G",
-		HRResizeX:         generation.HiresWidth,
-		HRResizeY:         generation.HiresHeight,
-		DenoisingStrength: generation.DenoisingStrength,
-		BatchSize:         generation.BatchSize,
-		Seed:              generation.Seed,
-		Subseed:           generation.Subseed,
-		SubseedStrength:   generation.SubseedStrength,
-		SamplerName:       generation.SamplerName,
-		CfgScale:          generation.CfgScale,
-		Steps:             generation.Steps,
-		NIter:             1,
-		SaveImages:        true,
-		OverrideSettings: stable_diffusion_api.Txt2ImgOverrideSettings{
-			SamplesFormat: "webp",
-		},
-	})
-	if err != nil {
-		log.Printf("Error processing image upscale: %v\n", err)
-
-		errorContent := "I'm sorry, but I had a problem upscaling your image."
-
-		_, err = q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-			Content: &errorContent,
-		})
-
-		return
-	}
-
-	generationDone <- true
-
-	decodedImage, decodeErr := base64.StdEncoding.DecodeString(resp.Images[0])
-	if decodeErr != nil {
-		log.Printf("Error decoding image: %v\n", decodeErr)
-
-		return
-	}
-	imageBuf := bytes.NewBuffer(decodedImage)
-
-	log.Printf("Successfully upscaled image: %v, Message: %v, Upscale Index: %d",
-		interactionID, messageID, imagine.InteractionIndex)
-
-	finishedContent := fmt.Sprintf("<@%s> asked me to upscale their image. Here's the result:",
-		userID)
-
-	_, err = q.botSession.InteractionResponseEdit(imagine.DiscordInteraction, &discordgo.WebhookEdit{
-		Content: &finishedContent,
-		Files: []*discordgo.File{
-			{
-				ContentType: "image/png",
-				Name:        fmt.Sprintf("seed-%d.png", generation.Seed),
-				Reader:      imageBuf,
-			},
-		},
-	})
-	if err != nil {
-		log.Printf("Error editing interaction: %v\n", err)
-
-		return
-	}
-}
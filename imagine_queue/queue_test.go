@@ -0,0 +1,896 @@
+package imagine_queue
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"stable_diffusion_bot/databases/sqlite"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/image_store"
+	"stable_diffusion_bot/moderation"
+	"stable_diffusion_bot/repositories/channel_profiles"
+	"stable_diffusion_bot/repositories/default_settings"
+	"stable_diffusion_bot/repositories/favorites"
+	"stable_diffusion_bot/repositories/image_generations"
+	"stable_diffusion_bot/repositories/moderation_decisions"
+	"stable_diffusion_bot/repositories/moderation_rules"
+	"stable_diffusion_bot/repositories/permissions"
+	"stable_diffusion_bot/stable_diffusion_api"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jobTimeout is how long a test waits for a job, the queue reports progress about every second
+const jobTimeout = 10 * time.Second
+
+// fakeSink records the calls the queue makes, and tells when the job is over.
+type fakeSink struct {
+	// messageID is the message the results are posted in
+	messageID string
+
+	mu       sync.Mutex
+	calls    []string
+	err      error
+	result   *JobResult
+	progress chan struct{}
+	done     chan struct{}
+}
+
+func newFakeSink() *fakeSink {
+	return &fakeSink{
+		messageID: "message-1",
+		progress:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+func (s *fakeSink) record(call string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, call)
+}
+
+func (s *fakeSink) Started(_ *QueueItem, _ JobStatus) (string, error) {
+	s.record("Started")
+
+	return s.messageID, nil
+}
+
+func (s *fakeSink) Progress(_ *QueueItem, _ JobStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.calls) == 0 || s.calls[len(s.calls)-1] != "Progress" {
+		s.calls = append(s.calls, "Progress")
+	}
+
+	select {
+	case <-s.progress:
+	default:
+		close(s.progress)
+	}
+
+	return nil
+}
+
+func (s *fakeSink) Finished(_ *QueueItem, result *JobResult) error {
+	s.record("Finished")
+
+	s.mu.Lock()
+	s.result = result
+	s.mu.Unlock()
+
+	close(s.done)
+
+	return nil
+}
+
+func (s *fakeSink) Failed(_ *QueueItem, err error) {
+	s.record("Failed")
+
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+
+	close(s.done)
+}
+
+func (s *fakeSink) wait(t *testing.T) []string {
+	t.Helper()
+
+	select {
+	case <-s.done:
+	case <-time.After(jobTimeout):
+		t.Fatal("timed out waiting for the job")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.calls...)
+}
+
+// stubAPI renders solid images without a WebUI. It waits for the queue to report progress before returning, so the
+// order of the sink calls is always the same.
+type stubAPI struct {
+	stable_diffusion_api.StableDiffusionAPI
+
+	sink        *fakeSink
+	textToImage func(req *stable_diffusion_api.TextToImageRequest) (*stable_diffusion_api.TextToImageResponse, error)
+	requests    []*stable_diffusion_api.TextToImageRequest
+	img2img     []*stable_diffusion_api.ImageToImageRequest
+	upscales    []*stable_diffusion_api.UpscaleRequest
+}
+
+func (a *stubAPI) GetCurrentProgress() (*stable_diffusion_api.ProgressResponse, error) {
+	return &stable_diffusion_api.ProgressResponse{Progress: 0.5}, nil
+}
+
+func (a *stubAPI) TextToImage(req *stable_diffusion_api.TextToImageRequest) (*stable_diffusion_api.TextToImageResponse, error) {
+	a.requests = append(a.requests, req)
+
+	if a.sink != nil {
+		select {
+		case <-a.sink.progress:
+		case <-time.After(jobTimeout):
+		}
+	}
+
+	if a.textToImage != nil {
+		return a.textToImage(req)
+	}
+
+	resp := &stable_diffusion_api.TextToImageResponse{Model: "stub"}
+
+	for idx := 0; idx < req.BatchSize*req.NIter; idx++ {
+		resp.Images = append(resp.Images, base64.StdEncoding.EncodeToString(testImage(req.Width, req.Height)))
+		resp.Seeds = append(resp.Seeds, 100+idx)
+		resp.Subseeds = append(resp.Subseeds, 200+idx)
+	}
+
+	return resp, nil
+}
+
+func (a *stubAPI) ImageToImage(req *stable_diffusion_api.ImageToImageRequest) (*stable_diffusion_api.TextToImageResponse, error) {
+	a.img2img = append(a.img2img, req)
+
+	resp := &stable_diffusion_api.TextToImageResponse{Model: "stub"}
+
+	for idx := 0; idx < max(1, req.BatchSize*req.NIter); idx++ {
+		resp.Images = append(resp.Images, base64.StdEncoding.EncodeToString(testImage(req.Width, req.Height)))
+		resp.Seeds = append(resp.Seeds, 300+idx)
+		resp.Subseeds = append(resp.Subseeds, 400+idx)
+	}
+
+	return resp, nil
+}
+
+func (a *stubAPI) UpscaleImage(req *stable_diffusion_api.UpscaleRequest) (*stable_diffusion_api.UpscaleResponse, error) {
+	a.upscales = append(a.upscales, req)
+
+	width := int(float64(req.TextToImageRequest.Width) * req.UpscalingResize)
+	height := int(float64(req.TextToImageRequest.Height) * req.UpscalingResize)
+
+	return &stable_diffusion_api.UpscaleResponse{Image: base64.StdEncoding.EncodeToString(testImage(width, height))}, nil
+}
+
+// testImage is a solid PNG of the size, small images stand in for the big ones the WebUI renders.
+func testImage(width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, max(1, width/8), max(1, height/8)))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{R: 200, G: 100, B: 50, A: 255}}, image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+
+	_ = png.Encode(&buf, img)
+
+	return buf.Bytes()
+}
+
+// newTestQueue makes a queue on a fresh database in a temporary directory.
+func newTestQueue(t *testing.T, api stable_diffusion_api.StableDiffusionAPI) *queueImpl {
+	t.Helper()
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the database is created in the working directory
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = os.Chdir(workingDir)
+	})
+
+	db, err := sqlite.New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	cfg := Config{StableDiffusionAPI: api}
+
+	cfg.ImageGenerationRepo, err = image_generations.NewRepository(&image_generations.Config{DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.DefaultSettingsRepo, err = default_settings.NewRepository(&default_settings.Config{DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.ChannelProfileRepo, err = channel_profiles.NewRepository(&channel_profiles.Config{DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.ModerationRuleRepo, err = moderation_rules.NewRepository(&moderation_rules.Config{DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.ModerationDecisionRepo, err = moderation_decisions.NewRepository(&moderation_decisions.Config{DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.FavoriteRepo, err = favorites.NewRepository(&favorites.Config{DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.PermissionRepo, err = permissions.NewRepository(&permissions.Config{DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := queue.(*queueImpl)
+
+	_, err = q.initializeOrGetBotDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return q
+}
+
+func testSource(sink ResultSink) JobSource {
+	return JobSource{
+		RequestID: "request-1",
+		MemberID:  "member-1",
+		ChannelID: "channel-1",
+		GuildID:   "guild-1",
+		Sink:      sink,
+	}
+}
+
+// runNext takes the next item from the queue and processes it, once the current one is done like the polling loop
+// does.
+func runNext(t *testing.T, q *queueImpl) {
+	t.Helper()
+
+	if len(q.queue) == 0 {
+		t.Fatal("nothing was queued")
+	}
+
+	deadline := time.Now().Add(jobTimeout)
+
+	for {
+		q.mu.Lock()
+		idle := q.currentImagine == nil
+		q.mu.Unlock()
+
+		if idle {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the current job")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	q.pullNextInQueue()
+}
+
+func TestQueueReportsToSink(t *testing.T) {
+	sink := newFakeSink()
+	api := &stubAPI{sink: sink}
+	q := newTestQueue(t, api)
+
+	_, err := q.AddImagine(&QueueItem{
+		Prompt:  "a cat --batch 2",
+		Type:    ItemTypeImagine,
+		Options: NewQueueItemOptions(),
+		Source:  testSource(sink),
+	})
+	if err != nil {
+		t.Fatalf("AddImagine: %v", err)
+	}
+
+	runNext(t, q)
+
+	calls := sink.wait(t)
+
+	want := []string{"Started", "Progress", "Finished"}
+	if len(calls) != len(want) {
+		t.Fatalf("sink calls = %v, want %v", calls, want)
+	}
+
+	for idx := range want {
+		if calls[idx] != want[idx] {
+			t.Fatalf("sink calls = %v, want %v", calls, want)
+		}
+	}
+
+	if len(sink.result.Images) != 2 {
+		t.Errorf("got %d images, want 2", len(sink.result.Images))
+	}
+
+	if sink.result.Generation.Prompt != "a cat" {
+		t.Errorf("prompt = %q, want %q", sink.result.Generation.Prompt, "a cat")
+	}
+
+	generations, err := q.ListGenerationsByMessage("message-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the base row of the grid and a row per image
+	if len(generations) != 3 {
+		t.Errorf("stored %d generations, want 3", len(generations))
+	}
+}
+
+func TestQueueReportsFailureToSink(t *testing.T) {
+	sink := newFakeSink()
+	api := &stubAPI{
+		sink: sink,
+		textToImage: func(_ *stable_diffusion_api.TextToImageRequest) (*stable_diffusion_api.TextToImageResponse, error) {
+			return nil, errors.New("out of memory")
+		},
+	}
+	q := newTestQueue(t, api)
+
+	_, err := q.AddImagine(&QueueItem{
+		Prompt:  "a cat",
+		Type:    ItemTypeImagine,
+		Options: NewQueueItemOptions(),
+		Source:  testSource(sink),
+	})
+	if err != nil {
+		t.Fatalf("AddImagine: %v", err)
+	}
+
+	runNext(t, q)
+
+	calls := sink.wait(t)
+
+	if len(calls) == 0 || calls[0] != "Started" || calls[len(calls)-1] != "Failed" {
+		t.Fatalf("sink calls = %v, want Started first and Failed last", calls)
+	}
+
+	for _, call := range calls {
+		if call == "Finished" {
+			t.Fatalf("sink calls = %v, want no Finished", calls)
+		}
+	}
+
+	if sink.err == nil || sink.err.Error() != "out of memory" {
+		t.Errorf("Failed with %v, want the error of the API", sink.err)
+	}
+}
+
+func TestQueueReportsMissingGenerationToSink(t *testing.T) {
+	sink := newFakeSink()
+	q := newTestQueue(t, &stubAPI{sink: sink})
+
+	source := testSource(sink)
+	source.MessageID = "missing-message"
+
+	_, err := q.AddImagine(&QueueItem{
+		Type:             ItemTypeReroll,
+		InteractionIndex: 1,
+		Source:           source,
+	})
+	if err != nil {
+		t.Fatalf("AddImagine: %v", err)
+	}
+
+	runNext(t, q)
+
+	calls := sink.wait(t)
+
+	if len(calls) != 1 || calls[0] != "Failed" {
+		t.Fatalf("sink calls = %v, want [Failed]", calls)
+	}
+}
+
+func TestAddImagineRejectsBadFlags(t *testing.T) {
+	q := newTestQueue(t, &stubAPI{})
+
+	_, err := q.AddImagine(&QueueItem{
+		Prompt:  "a cat --steps 1000",
+		Type:    ItemTypeImagine,
+		Options: NewQueueItemOptions(),
+		Source:  testSource(newFakeSink()),
+	})
+	if !errors.Is(err, &InvalidFlagValueError{}) {
+		t.Fatalf("AddImagine error = %v, want an InvalidFlagValueError", err)
+	}
+
+	if len(q.queue) != 0 {
+		t.Errorf("queued %d items, want none", len(q.queue))
+	}
+}
+
+func TestPlotKeepsToChannelProfile(t *testing.T) {
+	api := &stubAPI{}
+	q := newTestQueue(t, api)
+
+	_, err := q.UpdateChannelProfile(&entities.ChannelProfile{
+		ChannelID:       "channel-1",
+		GuildID:         "guild-1",
+		Model:           "safe",
+		AllowedSamplers: []string{"Euler a"},
+		MaxWidth:        512,
+		MaxHeight:       512,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refused := []PlotAxis{
+		{Type: PlotAxisCheckpoint, Values: []string{"safe", "other"}},
+		{Type: PlotAxisSampler, Values: []string{"Euler a", "DDIM"}},
+	}
+
+	for _, axis := range refused {
+		_, err = q.AddImagine(&QueueItem{
+			Prompt:  "a cat",
+			Type:    ItemTypePlot,
+			Options: NewQueueItemOptions(),
+			Plot:    &PlotOptions{Axes: []PlotAxis{axis}},
+			Source:  testSource(newFakeSink()),
+		})
+		if !errors.Is(err, &InvalidPlotAxisError{}) {
+			t.Errorf("plotting %s: error = %v, want an InvalidPlotAxisError", axis.Type, err)
+		}
+	}
+
+	sink := newFakeSink()
+
+	_, err = q.AddImagine(&QueueItem{
+		Prompt:  "a cat --model other --ar 2:1",
+		Type:    ItemTypePlot,
+		Options: NewQueueItemOptions(),
+		Plot:    &PlotOptions{Axes: []PlotAxis{{Type: PlotAxisCFGScale, Values: []string{"5", "7"}}}},
+		Source:  testSource(sink),
+	})
+	if err != nil {
+		t.Fatalf("AddImagine: %v", err)
+	}
+
+	runNext(t, q)
+
+	calls := sink.wait(t)
+	if calls[len(calls)-1] != "Finished" {
+		t.Fatalf("sink calls = %v, want Finished last (error: %v)", calls, sink.err)
+	}
+
+	if len(api.requests) != 2 {
+		t.Fatalf("rendered %d cells, want 2", len(api.requests))
+	}
+
+	for _, req := range api.requests {
+		if req.OverrideSettings.SDModelCheckpoint != "safe" {
+			t.Errorf("cell rendered with %q, want the model of the profile", req.OverrideSettings.SDModelCheckpoint)
+		}
+
+		width, height := req.Width, req.Height
+		if req.EnableHR {
+			width, height = req.HRResizeX, req.HRResizeY
+		}
+
+		if width > 512 || height > 512 {
+			t.Errorf("cell rendered at %dx%d, want at most 512x512", width, height)
+		}
+	}
+}
+
+func TestUpscaleAndVariationUseArchivedImage(t *testing.T) {
+	sink := newFakeSink()
+	api := &stubAPI{sink: sink}
+	q := newTestQueue(t, api)
+
+	store, err := image_store.NewLocalStore(image_store.LocalConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.imageStore = store
+
+	_, err = q.AddImagine(&QueueItem{
+		Prompt:  "a cat",
+		Type:    ItemTypeImagine,
+		Options: NewQueueItemOptions(),
+		Source:  testSource(sink),
+	})
+	if err != nil {
+		t.Fatalf("AddImagine: %v", err)
+	}
+
+	runNext(t, q)
+	sink.wait(t)
+
+	rendered := len(api.requests)
+
+	followups := []struct {
+		itemType  ItemType
+		messageID string
+	}{
+		{itemType: ItemTypeUpscale, messageID: "message-2"},
+		{itemType: ItemTypeVariation, messageID: "message-3"},
+	}
+
+	for _, followup := range followups {
+		followupSink := newFakeSink()
+		followupSink.messageID = followup.messageID
+
+		source := testSource(followupSink)
+		source.MessageID = "message-1"
+
+		_, err = q.AddImagine(&QueueItem{
+			Type:             followup.itemType,
+			InteractionIndex: 1,
+			Source:           source,
+		})
+		if err != nil {
+			t.Fatalf("AddImagine: %v", err)
+		}
+
+		runNext(t, q)
+
+		calls := followupSink.wait(t)
+		if calls[len(calls)-1] != "Finished" {
+			t.Fatalf("sink calls = %v, want Finished last (error: %v)", calls, followupSink.err)
+		}
+	}
+
+	if len(api.requests) != rendered {
+		t.Errorf("rendered %d images with txt2img again, want none", len(api.requests)-rendered)
+	}
+
+	if len(api.img2img) != len(followups) {
+		t.Fatalf("repainted %d images with img2img, want %d", len(api.img2img), len(followups))
+	}
+
+	for _, req := range api.img2img {
+		if len(req.InitImages) != 1 || req.InitImages[0] == "" {
+			t.Errorf("img2img without the archived image")
+		}
+	}
+
+	if api.img2img[0].OverrideSettings.UpscalerForImg2Img == "" {
+		t.Errorf("upscale without an upscaler")
+	}
+}
+
+func TestUpscaleWithoutHiresUsesExtras(t *testing.T) {
+	sink := newFakeSink()
+	api := &stubAPI{sink: sink}
+	q := newTestQueue(t, api)
+
+	// the grid is rendered with hires fix by a member who may use it
+	_, err := q.AddImagine(&QueueItem{
+		Prompt:  "a cat --hires",
+		Type:    ItemTypeImagine,
+		Options: NewQueueItemOptions(),
+		Source:  testSource(sink),
+	})
+	if err != nil {
+		t.Fatalf("AddImagine: %v", err)
+	}
+
+	runNext(t, q)
+	sink.wait(t)
+
+	rendered := len(api.requests)
+
+	upscaleSink := newFakeSink()
+	upscaleSink.messageID = "message-2"
+
+	source := testSource(upscaleSink)
+	source.MessageID = "message-1"
+	source.HiresDenied = true
+
+	_, err = q.AddImagine(&QueueItem{
+		Type:             ItemTypeUpscale,
+		InteractionIndex: 1,
+		Source:           source,
+	})
+	if err != nil {
+		t.Fatalf("AddImagine: %v", err)
+	}
+
+	runNext(t, q)
+
+	calls := upscaleSink.wait(t)
+	if calls[len(calls)-1] != "Finished" {
+		t.Fatalf("sink calls = %v, want Finished last (error: %v)", calls, upscaleSink.err)
+	}
+
+	if len(api.upscales) != 1 {
+		t.Fatalf("upscaled %d images with the extras upscaler, want 1", len(api.upscales))
+	}
+
+	if !api.requests[0].EnableHR {
+		t.Errorf("grid rendered without hires fix, want it with")
+	}
+
+	// without an archived image the extras upscaler renders the image again
+	if api.upscales[0].TextToImageRequest.EnableHR {
+		t.Errorf("upscale rendered the image again with hires fix, which the member may not use")
+	}
+
+	if len(api.requests) != rendered || len(api.img2img) != 0 {
+		t.Errorf("upscaled with hires fix, which the member may not use")
+	}
+}
+
+func TestAddImagineModeratesExpandedPrompts(t *testing.T) {
+	q := newTestQueue(t, &stubAPI{})
+
+	wildcardsDir := t.TempDir()
+
+	wildcards := map[string]string{
+		"animals.txt": "wolf\n",
+		"fish.txt":    "shark\n",
+		"things.txt":  "cat\ndog\ngore\n",
+		"mixed.txt":   "wolf\nshark\n",
+		"beasts.txt":  "wolf\ngore\ncat\n",
+	}
+
+	for name, lines := range wildcards {
+		err := os.WriteFile(filepath.Join(wildcardsDir, name), []byte(lines), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q.promptExpander = newPromptExpander(wildcardsDir)
+
+	rules := []*entities.ModerationRule{
+		{GuildID: "guild-1", List: moderation.ListBlock, Match: moderation.MatchWord, Pattern: "wolf",
+			Action: moderation.ActionHold},
+		{GuildID: "guild-1", List: moderation.ListBlock, Match: moderation.MatchWord, Pattern: "shark",
+			Action: moderation.ActionReject},
+		{GuildID: "guild-1", List: moderation.ListBlock, Match: moderation.MatchWord, Pattern: "gore",
+			Action: moderation.ActionNegative},
+	}
+
+	for _, rule := range rules {
+		_, err := q.AddModerationRule(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := q.SetReviewChannel("guild-1", "review-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add := func(prompt string) error {
+		_, err := q.AddImagine(&QueueItem{
+			Prompt:  prompt,
+			Type:    ItemTypeImagine,
+			Options: NewQueueItemOptions(),
+			Source:  testSource(newFakeSink()),
+		})
+
+		return err
+	}
+
+	err = add("a __fish__ in the sea")
+	if !errors.Is(err, &PromptRejectedError{}) {
+		t.Errorf("wildcard of a rejected term = %v, want PromptRejectedError", err)
+	}
+
+	// the shark is rejected after the wolf was already up for a hold
+	err = add("a __mixed__ --combinatorial")
+	if !errors.Is(err, &PromptRejectedError{}) {
+		t.Errorf("held and rejected expansions = %v, want PromptRejectedError", err)
+	}
+
+	if len(q.heldPrompts) != 0 {
+		t.Errorf("held %d prompts of a rejected expansion", len(q.heldPrompts))
+	}
+
+	err = add("a __things__ --combinatorial")
+	if err != nil {
+		t.Fatalf("AddImagine: %v", err)
+	}
+
+	if len(q.queue) != 3 {
+		t.Fatalf("queued %d items, want the 3 combinations", len(q.queue))
+	}
+
+	for idx := 0; idx < 3; idx++ {
+		item := <-q.queue
+
+		if (item.Prompt == "a gore") != strings.Contains(item.Options.NegativePrompt, "gore") {
+			t.Errorf("%q has negative prompt %q, want the term only for the matching expansion", item.Prompt,
+				item.Options.NegativePrompt)
+		}
+	}
+
+	err = add("a __animals__ in the forest")
+
+	var heldErr *PromptHeldError
+	if !errors.As(err, &heldErr) {
+		t.Fatalf("wildcard of a held term = %v, want PromptHeldError", err)
+	}
+
+	if heldErr.Prompt != "a wolf in the forest" {
+		t.Errorf("held prompt = %q, want the expanded one", heldErr.Prompt)
+	}
+
+	if len(q.queue) != 0 {
+		t.Fatalf("queued %d items while the prompt is held", len(q.queue))
+	}
+
+	_, err = q.ApproveHeldPrompt(heldErr.HeldID, "moderator-1")
+	if err != nil {
+		t.Fatalf("ApproveHeldPrompt: %v", err)
+	}
+
+	if len(q.queue) != 1 {
+		t.Fatalf("queued %d items once approved, want 1", len(q.queue))
+	}
+
+	if item := <-q.queue; item.Prompt != "a wolf in the forest" || item.JobID == "" {
+		t.Errorf("approved item = %q with job %q, want the expanded prompt in line", item.Prompt, item.JobID)
+	}
+
+	// every expansion is checked before the hold, so the moderator sees them all and each gets its negative terms
+	err = add("a __beasts__ --combinatorial")
+	if !errors.As(err, &heldErr) {
+		t.Fatalf("combinations with a held term = %v, want PromptHeldError", err)
+	}
+
+	if !reflect.DeepEqual(heldErr.Prompts, []string{"a wolf", "a gore", "a cat"}) {
+		t.Errorf("held prompts = %q, want every combination", heldErr.Prompts)
+	}
+
+	_, err = q.ApproveHeldPrompt(heldErr.HeldID, "moderator-1")
+	if err != nil {
+		t.Fatalf("ApproveHeldPrompt: %v", err)
+	}
+
+	if len(q.queue) != 3 {
+		t.Fatalf("queued %d items once approved, want the 3 combinations", len(q.queue))
+	}
+
+	for idx := 0; idx < 3; idx++ {
+		item := <-q.queue
+
+		if (item.Prompt == "a gore") != strings.Contains(item.Options.NegativePrompt, "gore") {
+			t.Errorf("%q has negative prompt %q, want the term only for the matching expansion", item.Prompt,
+				item.Options.NegativePrompt)
+		}
+	}
+}
+
+// succeededRecorder hands on the JobSucceeded events of the queue.
+type succeededRecorder struct {
+	events chan JobSucceeded
+}
+
+func (r *succeededRecorder) HandleEvent(event Event) {
+	if succeeded, ok := event.(JobSucceeded); ok {
+		r.events <- succeeded
+	}
+}
+
+func TestSucceededEventsHaveTheGenerations(t *testing.T) {
+	sink := newFakeSink()
+	api := &stubAPI{sink: sink}
+	q := newTestQueue(t, api)
+
+	// outpainting needs the image, which the archive has without downloading it
+	store, err := image_store.NewLocalStore(image_store.LocalConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.imageStore = store
+
+	recorder := &succeededRecorder{events: make(chan JobSucceeded, 10)}
+	q.Subscribe(recorder)
+
+	_, err = q.AddImagine(&QueueItem{
+		Prompt:  "a cat",
+		Type:    ItemTypeImagine,
+		Options: NewQueueItemOptions(),
+		Source:  testSource(sink),
+	})
+	if err != nil {
+		t.Fatalf("AddImagine: %v", err)
+	}
+
+	runNext(t, q)
+	sink.wait(t)
+
+	// the upscaled image is outpainted from its own message
+	followups := []struct {
+		itemType        ItemType
+		sourceMessageID string
+		messageID       string
+		outpaint        *OutpaintOptions
+	}{
+		{itemType: ItemTypeUpscale, sourceMessageID: "message-1", messageID: "message-2"},
+		{itemType: ItemTypeOutpaint, sourceMessageID: "message-2", messageID: "message-3",
+			outpaint: &OutpaintOptions{Direction: OutpaintZoomOut, ZoomFactor: 1.5}},
+	}
+
+	for _, followup := range followups {
+		followupSink := newFakeSink()
+		followupSink.messageID = followup.messageID
+
+		source := testSource(followupSink)
+		source.MessageID = followup.sourceMessageID
+
+		_, err = q.AddImagine(&QueueItem{
+			Type:             followup.itemType,
+			InteractionIndex: 1,
+			Outpaint:         followup.outpaint,
+			Source:           source,
+		})
+		if err != nil {
+			t.Fatalf("AddImagine: %v", err)
+		}
+
+		runNext(t, q)
+
+		calls := followupSink.wait(t)
+		if calls[len(calls)-1] != "Finished" {
+			t.Fatalf("sink calls = %v, want Finished last (error: %v)", calls, followupSink.err)
+		}
+	}
+
+	for idx := 0; idx < 1+len(followups); idx++ {
+		select {
+		case event := <-recorder.events:
+			if len(event.Generations) == 0 {
+				t.Errorf("job of type %d succeeded without generations", event.Item.Type)
+			}
+		case <-time.After(jobTimeout):
+			t.Fatal("timed out waiting for the events")
+		}
+	}
+}
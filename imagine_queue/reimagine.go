@@ -0,0 +1,238 @@
+package imagine_queue
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/repositories"
+	"stable_diffusion_bot/stable_diffusion_api"
+	"time"
+)
+
+// reimagineDenoisingStrength keeps the composition of the image, while giving the prompt room to change the rest
+const reimagineDenoisingStrength = 0.6
+
+func (q *queueImpl) processReimagineImagine(imagine *QueueItem) {
+	err := q.reimagineImagine(imagine)
+	if err != nil {
+		log.Printf("Error processing reimagine: %v\n", err)
+
+		imagine.Source.Sink.Failed(imagine, err)
+	}
+}
+
+// reimagineGeneration returns the generation the image is reimagined with. Results of the bot keep their prompt and
+// settings, any other image is described by the API and rendered with the member's defaults.
+func (q *queueImpl) reimagineGeneration(imagine *QueueItem, sourceImage []byte) (*entities.ImageGeneration, error) {
+	generation, err := q.imageGenerationRepo.GetByMessage(context.Background(), imagine.Source.MessageID)
+	if err == nil {
+		if generation.Deleted {
+			return nil, repositories.NewNotFoundError("image generation for message " + imagine.Source.MessageID)
+		}
+
+		return generation, nil
+	}
+
+	if !errors.Is(err, &repositories.NotFoundError{}) {
+		return nil, err
+	}
+
+	generation, err = q.newGenerationFromOptions(imagine)
+	if err != nil {
+		return nil, err
+	}
+
+	if generation.Prompt == "" {
+		resp, err := q.stableDiffusionAPI.Interrogate(&stable_diffusion_api.InterrogateRequest{
+			Image: base64.StdEncoding.EncodeToString(sourceImage),
+			Model: stable_diffusion_api.InterrogateModelClip,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		generation.Prompt = resp.Caption
+	}
+
+	return generation, nil
+}
+
+func (q *queueImpl) reimagineImagine(imagine *QueueItem) error {
+	interactionID := imagine.Source.RequestID
+
+	if imagine.Source.ImageURL == "" {
+		return errors.New("no image to reimagine")
+	}
+
+	sourceImage, err := DownloadImage(imagine.Source.ImageURL)
+	if err != nil {
+		return err
+	}
+
+	generation, err := q.reimagineGeneration(imagine, sourceImage.Bytes())
+	if err != nil {
+		return err
+	}
+
+	profile, err := q.applyProfile(imagine, generation)
+	if err != nil {
+		return err
+	}
+
+	// the image is rendered again at the size it was shown in, scaled down to stay renderable
+	width, height := imagine.Options.Width, imagine.Options.Height
+	if width == 0 || height == 0 {
+		width, height = shownSize(generation)
+	}
+
+	width, height = outpaintSourceSize(width, height)
+	width, height = roundDownTo8(width), roundDownTo8(height)
+
+	if profile != nil {
+		width, height = fitWithin(width, height, profile.MaxWidth, profile.MaxHeight)
+	}
+
+	resultMessageID, err := q.startJob(imagine, JobStatus{Generation: generation})
+	if err != nil {
+		return err
+	}
+
+	generationDone := make(chan bool)
+
+	go func() {
+		for {
+			select {
+			case <-generationDone:
+				return
+			case <-time.After(1 * time.Second):
+				progress, progressErr := q.stableDiffusionAPI.GetCurrentProgress()
+				if progressErr != nil {
+					log.Printf("Error getting current progress: %v", progressErr)
+
+					return
+				}
+
+				if progress.Progress == 0 {
+					continue
+				}
+
+				progressErr = imagine.Source.Sink.Progress(imagine, JobStatus{
+					Generation: generation,
+					Progress:   progress.Progress,
+				})
+				if progressErr != nil {
+					log.Printf("Error reporting progress: %v", progressErr)
+				}
+			}
+		}
+	}()
+
+	resp, err := q.stableDiffusionAPI.ImageToImage(&stable_diffusion_api.ImageToImageRequest{
+		InitImages:        []string{base64.StdEncoding.EncodeToString(sourceImage.Bytes())},
+		Prompt:            generation.Prompt,
+		NegativePrompt:    generation.NegativePrompt,
+		Width:             width,
+		Height:            height,
+		RestoreFaces:      generation.RestoreFaces,
+		DenoisingStrength: reimagineDenoisingStrength,
+		BatchSize:         1,
+		// a new seed, so the image comes out different from the one it started from
+		Seed:        -1,
+		Subseed:     -1,
+		SamplerName: generation.SamplerName,
+		CfgScale:    generation.CfgScale,
+		Steps:       generation.Steps,
+		NIter:       1,
+		Styles:      generationStyles(generation),
+		SaveImages:  true,
+		OverrideSettings: stable_diffusion_api.Txt2ImgOverrideSettings{
+			SamplesFormat:     "webp",
+			SDModelCheckpoint: generation.Checkpoint,
+		},
+	})
+
+	// closed rather than sent to, the progress loop stops by itself when the progress can't be fetched
+	close(generationDone)
+
+	if err != nil {
+		return err
+	}
+
+	if len(resp.Images) == 0 {
+		return errors.New("no image returned")
+	}
+
+	decodedImage, err := base64.StdEncoding.DecodeString(resp.Images[0])
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Successfully reimagined image: %v, Message: %v", interactionID, imagine.Source.MessageID)
+
+	// the reimagined image is a child of the result it came from, images of others have no generation to point to
+	reimaginedGeneration := *generation
+	reimaginedGeneration.ID = 0
+	reimaginedGeneration.ParentID = generation.ID
+	reimaginedGeneration.InteractionID = interactionID
+	reimaginedGeneration.MessageID = resultMessageID
+	reimaginedGeneration.MemberID = imagine.Source.MemberID
+	reimaginedGeneration.GuildID = imagine.Source.GuildID
+	reimaginedGeneration.ChannelID = imagine.Source.ChannelID
+	reimaginedGeneration.SortOrder = 0
+	reimaginedGeneration.Width = width
+	reimaginedGeneration.Height = height
+	reimaginedGeneration.EnableHR = false
+	reimaginedGeneration.HiresWidth = 0
+	reimaginedGeneration.HiresHeight = 0
+	reimaginedGeneration.DenoisingStrength = reimagineDenoisingStrength
+	reimaginedGeneration.SubseedStrength = 0
+	reimaginedGeneration.BatchCount = 1
+	reimaginedGeneration.BatchSize = 1
+	reimaginedGeneration.Processed = true
+	reimaginedGeneration.ImagePath = ""
+	reimaginedGeneration.ImageHash = ""
+	reimaginedGeneration.Infotext = ""
+
+	if len(resp.Seeds) > 0 {
+		reimaginedGeneration.Seed = resp.Seeds[0]
+	}
+
+	if len(resp.Subseeds) > 0 {
+		reimaginedGeneration.Subseed = resp.Subseeds[0]
+	}
+
+	if len(resp.Infotexts) > 0 {
+		reimaginedGeneration.Infotext = resp.Infotexts[0]
+	}
+
+	storedImage := q.archive(decodedImage)
+
+	// stored before the result is posted, so the events of the job have it
+	setArchivedImage(&reimaginedGeneration, storedImage)
+
+	_, err = q.imageGenerationRepo.Create(context.Background(), &reimaginedGeneration)
+	if err != nil {
+		log.Printf("Error creating image generation record: %v\n", err)
+	}
+
+	err = q.finishJob(imagine, &JobResult{
+		Generation: &reimaginedGeneration,
+		Model:      resp.Model,
+		Images: []ResultImage{
+			{
+				ContentType: "image/png",
+				Name:        fmt.Sprintf("reimagine-seed-%d.png", reimaginedGeneration.Seed),
+				Data:        decodedImage,
+				URL:         q.archivedImageURL(storedImage),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package imagine_queue
+
+import (
+	"context"
+	"log"
+	"stable_diffusion_bot/moderation"
+	"time"
+)
+
+const (
+	// RoutingSpoiler posts the results hidden behind spoilers
+	RoutingSpoiler = "spoiler"
+	// RoutingRedirect posts the results in the NSFW channel of the server, with links between the messages
+	RoutingRedirect = "redirect"
+)
+
+// finishJob posts the results of the job, routed away from where they were asked for when they are sensitive.
+func (q *queueImpl) finishJob(imagine *QueueItem, result *JobResult) error {
+	if !imagine.startedAt.IsZero() {
+		result.Elapsed = time.Since(imagine.startedAt)
+	}
+
+	if result.Generation != nil {
+		q.routeResult(imagine, result)
+	}
+
+	return imagine.Source.Sink.Finished(imagine, result)
+}
+
+// routeResult decides how the results are posted, and records it on the generations of the result message.
+// Sensitive results go to the NSFW channel of the server, or are spoilered when it has none.
+func (q *queueImpl) routeResult(imagine *QueueItem, result *JobResult) {
+	routing, routedChannelID, err := q.resultRouting(imagine, result)
+	if err != nil {
+		// better to hide a result that wasn't sensitive than the other way around
+		log.Printf("Error routing results, spoilering them: %v", err)
+
+		routing = RoutingSpoiler
+	}
+
+	result.Generation.Routing = routing
+	result.Generation.RoutedChannelID = routedChannelID
+
+	if imagine.ResultMessageID == "" {
+		return
+	}
+
+	err = q.imageGenerationRepo.SetRouting(context.Background(), imagine.ResultMessageID, routing, routedChannelID)
+	if err != nil {
+		log.Printf("Error recording routing of message %s: %v", imagine.ResultMessageID, err)
+	}
+}
+
+func (q *queueImpl) resultRouting(imagine *QueueItem, result *JobResult) (string, string, error) {
+	if imagine.Source.GuildID == "" || imagine.Source.AgeRestricted {
+		return "", "", nil
+	}
+
+	rules, err := q.moderationRules(imagine.Source.GuildID, imagine.Source.ChannelID)
+	if err != nil {
+		return "", "", err
+	}
+
+	checkpoints := []string{result.Generation.Checkpoint, result.Model}
+
+	if imagine.Plot != nil {
+		for _, axis := range imagine.Plot.Axes {
+			if axis.Type == PlotAxisCheckpoint {
+				checkpoints = append(checkpoints, axis.Values...)
+			}
+		}
+	}
+
+	if !moderation.IsSensitive(rules, result.Generation.Prompt, checkpoints...) {
+		return "", "", nil
+	}
+
+	nsfwChannelID, err := q.moderationRuleRepo.GetNSFWChannel(context.Background(), imagine.Source.GuildID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if nsfwChannelID == "" || nsfwChannelID == imagine.Source.ChannelID {
+		return RoutingSpoiler, "", nil
+	}
+
+	log.Printf("Redirecting sensitive results of message %s to channel %s", imagine.ResultMessageID, nsfwChannelID)
+
+	return RoutingRedirect, nsfwChannelID, nil
+}
+
+func (q *queueImpl) GetNSFWChannel(guildID string) (string, error) {
+	return q.moderationRuleRepo.GetNSFWChannel(context.Background(), guildID)
+}
+
+func (q *queueImpl) SetNSFWChannel(guildID, channelID string) error {
+	return q.moderationRuleRepo.SetNSFWChannel(context.Background(), guildID, channelID)
+}
@@ -0,0 +1,527 @@
+package imagine_queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/repositories"
+)
+
+// SettingsScope is who a row of default settings applies to.
+type SettingsScope string
+
+const (
+	SettingsScopeMember  SettingsScope = "member"
+	SettingsScopeChannel SettingsScope = "channel"
+	SettingsScopeGuild   SettingsScope = "guild"
+	SettingsScopeBot     SettingsScope = "bot"
+)
+
+// SettingsKey points at the default settings of a member, channel, guild or the whole bot.
+// Settings are resolved from the key's own scope upwards: member, channel, guild and then bot.
+type SettingsKey struct {
+	Scope     SettingsScope
+	MemberID  string
+	ChannelID string
+	GuildID   string
+}
+
+// rowID is the member_id the settings are stored under.
+func (k SettingsKey) rowID() string {
+	switch k.Scope {
+	case SettingsScopeMember:
+		return k.MemberID
+	case SettingsScopeChannel:
+		return "channel_" + k.ChannelID
+	case SettingsScopeGuild:
+		return "guild_" + k.GuildID
+	default:
+		return botID
+	}
+}
+
+// chain returns the row IDs to resolve, from the key's own scope up to the bot.
+func (k SettingsKey) chain() []string {
+	var rowIDs []string
+
+	switch k.Scope {
+	case SettingsScopeMember:
+		if k.MemberID != "" {
+			rowIDs = append(rowIDs, k.rowID())
+		}
+
+		fallthrough
+	case SettingsScopeChannel:
+		if k.ChannelID != "" {
+			rowIDs = append(rowIDs, SettingsKey{Scope: SettingsScopeChannel, ChannelID: k.ChannelID}.rowID())
+		}
+
+		fallthrough
+	case SettingsScopeGuild:
+		if k.GuildID != "" {
+			rowIDs = append(rowIDs, SettingsKey{Scope: SettingsScopeGuild, GuildID: k.GuildID}.rowID())
+		}
+	}
+
+	return append(rowIDs, botID)
+}
+
+func (q *queueImpl) fillInBotDefaults(settings *entities.DefaultSettings) (*entities.DefaultSettings, bool) {
+	updated := false
+
+	if settings == nil {
+		settings = &entities.DefaultSettings{
+			MemberID: botID,
+		}
+	}
+
+	if settings.Width == 0 {
+		settings.Width = initializedWidth
+		updated = true
+	}
+
+	if settings.Height == 0 {
+		settings.Height = initializedHeight
+		updated = true
+	}
+
+	if settings.BatchCount == 0 {
+		settings.BatchCount = initializedBatchCount
+		updated = true
+	}
+
+	if settings.BatchSize == 0 {
+		settings.BatchSize = initializedBatchSize
+		updated = true
+	}
+
+	if settings.UpscaleMethod == "" {
+		settings.UpscaleMethod = string(initializedUpscaleMethod)
+		updated = true
+	}
+
+	if settings.Upscaler == "" {
+		settings.Upscaler = initializedUpscaler
+		updated = true
+	}
+
+	if settings.UpscaleFactor == 0 {
+		settings.UpscaleFactor = initializedUpscaleFactor
+		updated = true
+	}
+
+	if settings.SubtleVariationStrength == 0 {
+		settings.SubtleVariationStrength = initializedSubtleVariationStrength
+		updated = true
+	}
+
+	if settings.StrongVariationStrength == 0 {
+		settings.StrongVariationStrength = initializedStrongVariationStrength
+		updated = true
+	}
+
+	if settings.NegativePrompt == "" {
+		settings.NegativePrompt = initializedNegativePrompt
+		updated = true
+	}
+
+	if settings.SamplerName == "" {
+		settings.SamplerName = initializedSampler
+		updated = true
+	}
+
+	if settings.Steps == 0 {
+		settings.Steps = initializedSteps
+		updated = true
+	}
+
+	if settings.CfgScale == 0 {
+		settings.CfgScale = initializedCFGScale
+		updated = true
+	}
+
+	if settings.EnableHR == nil {
+		enableHR := initializedEnableHR
+		settings.EnableHR = &enableHR
+		updated = true
+	}
+
+	if settings.RestoreFaces == nil {
+		restoreFaces := initializedRestoreFaces
+		settings.RestoreFaces = &restoreFaces
+		updated = true
+	}
+
+	return settings, updated
+}
+
+// mergeSettings fills in the settings that aren't set yet from the fallback.
+func mergeSettings(settings, fallback *entities.DefaultSettings) {
+	if settings.Width == 0 || settings.Height == 0 {
+		settings.Width = fallback.Width
+		settings.Height = fallback.Height
+	}
+
+	if settings.BatchCount == 0 || settings.BatchSize == 0 {
+		settings.BatchCount = fallback.BatchCount
+		settings.BatchSize = fallback.BatchSize
+	}
+
+	if settings.UpscaleMethod == "" {
+		settings.UpscaleMethod = fallback.UpscaleMethod
+	}
+
+	if settings.Upscaler == "" {
+		settings.Upscaler = fallback.Upscaler
+	}
+
+	if settings.UpscaleFactor == 0 {
+		settings.UpscaleFactor = fallback.UpscaleFactor
+	}
+
+	if settings.SubtleVariationStrength == 0 || settings.StrongVariationStrength == 0 {
+		settings.SubtleVariationStrength = fallback.SubtleVariationStrength
+		settings.StrongVariationStrength = fallback.StrongVariationStrength
+	}
+
+	if settings.NegativePrompt == "" {
+		settings.NegativePrompt = fallback.NegativePrompt
+	}
+
+	if settings.SamplerName == "" {
+		settings.SamplerName = fallback.SamplerName
+	}
+
+	if settings.Steps == 0 {
+		settings.Steps = fallback.Steps
+	}
+
+	if settings.CfgScale == 0 {
+		settings.CfgScale = fallback.CfgScale
+	}
+
+	if settings.EnableHR == nil {
+		settings.EnableHR = fallback.EnableHR
+	}
+
+	if settings.RestoreFaces == nil {
+		settings.RestoreFaces = fallback.RestoreFaces
+	}
+}
+
+func (q *queueImpl) initializeOrGetBotDefaults() (*entities.DefaultSettings, error) {
+	botDefaultSettings, err := q.getSettingsRow(botID)
+	if err != nil {
+		return nil, err
+	}
+
+	botDefaultSettings, updated := q.fillInBotDefaults(botDefaultSettings)
+	if updated {
+		botDefaultSettings, err = q.defaultSettingsRepo.Upsert(context.Background(), botDefaultSettings)
+		if err != nil {
+			return nil, err
+		}
+
+		q.invalidateSettings(botID)
+
+		log.Printf("Initialized bot default settings: %+v\n", botDefaultSettings)
+	} else {
+		log.Printf("Retrieved bot default settings: %+v\n", botDefaultSettings)
+	}
+
+	return botDefaultSettings, nil
+}
+
+// getSettingsRow returns a copy of the stored settings row, or nil when there is none.
+func (q *queueImpl) getSettingsRow(rowID string) (*entities.DefaultSettings, error) {
+	q.settingsMu.Lock()
+	defer q.settingsMu.Unlock()
+
+	row, ok := q.settingsCache[rowID]
+	if !ok {
+		var err error
+
+		row, err = q.defaultSettingsRepo.GetByMemberID(context.Background(), rowID)
+		if err != nil && !errors.Is(err, &repositories.NotFoundError{}) {
+			return nil, err
+		}
+
+		// missing rows are cached too, most members never change their settings
+		q.settingsCache[rowID] = row
+	}
+
+	if row == nil {
+		return nil, nil
+	}
+
+	rowCopy := *row
+
+	return &rowCopy, nil
+}
+
+func (q *queueImpl) invalidateSettings(rowID string) {
+	q.settingsMu.Lock()
+	defer q.settingsMu.Unlock()
+
+	delete(q.settingsCache, rowID)
+}
+
+// GetDefaultSettings resolves the effective settings for the key, falling back scope by scope up to the bot.
+func (q *queueImpl) GetDefaultSettings(key SettingsKey) (*entities.DefaultSettings, error) {
+	settings := &entities.DefaultSettings{
+		MemberID: key.rowID(),
+	}
+
+	for _, rowID := range key.chain() {
+		row, err := q.getSettingsRow(rowID)
+		if err != nil {
+			return nil, err
+		}
+
+		if row != nil {
+			mergeSettings(settings, row)
+		}
+	}
+
+	return settings, nil
+}
+
+// fillInDefaultOptions sets the options that weren't passed with the item to the member's defaults.
+func (q *queueImpl) fillInDefaultOptions(item *QueueItem) error {
+	defaultSettings, err := q.settingsForImagine(item)
+	if err != nil {
+		return err
+	}
+
+	options := &item.Options
+
+	if options.NegativePrompt == "" {
+		options.NegativePrompt = defaultSettings.NegativePrompt
+	}
+
+	if options.SamplerName == "" {
+		options.SamplerName = defaultSettings.SamplerName
+	}
+
+	if options.Steps == 0 {
+		options.Steps = defaultSettings.Steps
+	}
+
+	if options.CfgScale == 0 {
+		options.CfgScale = defaultSettings.CfgScale
+	}
+
+	if options.RestoreFaces == nil {
+		options.RestoreFaces = defaultSettings.RestoreFaces
+	}
+
+	return nil
+}
+
+// settingsForImagine returns the settings of the member who queued the item.
+func (q *queueImpl) settingsForImagine(imagine *QueueItem) (*entities.DefaultSettings, error) {
+	return q.GetDefaultSettings(imagine.Source.SettingsKey(SettingsScopeMember))
+}
+
+// updateSettings changes the row of the key's own scope, creating it when needed, and returns the effective settings.
+func (q *queueImpl) updateSettings(key SettingsKey, update func(row *entities.DefaultSettings)) (*entities.DefaultSettings, error) {
+	rowID := key.rowID()
+
+	row, err := q.getSettingsRow(rowID)
+	if err != nil {
+		return nil, err
+	}
+
+	if row == nil {
+		row = &entities.DefaultSettings{
+			MemberID: rowID,
+		}
+	}
+
+	update(row)
+
+	_, err = q.defaultSettingsRepo.Upsert(context.Background(), row)
+
+	q.invalidateSettings(rowID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return q.GetDefaultSettings(key)
+}
+
+func (q *queueImpl) UpdateDefaultDimensions(key SettingsKey, width, height int) (*entities.DefaultSettings, error) {
+	settings, err := q.updateSettings(key, func(row *entities.DefaultSettings) {
+		row.Width = width
+		row.Height = height
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Updated default dimensions of %s to: %dx%d\n", key.rowID(), width, height)
+
+	return settings, nil
+}
+
+func (q *queueImpl) UpdateDefaultBatch(key SettingsKey, batchCount, batchSize int) (*entities.DefaultSettings, error) {
+	settings, err := q.updateSettings(key, func(row *entities.DefaultSettings) {
+		row.BatchCount = batchCount
+		row.BatchSize = batchSize
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Updated default batch count/size of %s to: %d/%d\n", key.rowID(), batchCount, batchSize)
+
+	return settings, nil
+}
+
+// InvalidSettingError is returned when a default setting is out of range.
+type InvalidSettingError struct {
+	Setting string
+	Reason  string
+}
+
+func (e *InvalidSettingError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Setting, e.Reason)
+}
+
+func (e *InvalidSettingError) Is(tgt error) bool {
+	_, ok := tgt.(*InvalidSettingError)
+
+	return ok
+}
+
+// GenerationDefaults are the generation parameters that can be stored as defaults, empty ones are inherited.
+type GenerationDefaults struct {
+	NegativePrompt string
+	SamplerName    string
+	Steps          int
+	CfgScale       float64
+}
+
+func (d *GenerationDefaults) validate() error {
+	if d.Steps != 0 && (d.Steps < minSteps || d.Steps > maxSteps) {
+		return &InvalidSettingError{Setting: "steps", Reason: fmt.Sprintf("must be between %d and %d", minSteps, maxSteps)}
+	}
+
+	if d.CfgScale != 0 && (d.CfgScale < minCFGScale || d.CfgScale > maxCFGScale) {
+		return &InvalidSettingError{Setting: "CFG scale", Reason: fmt.Sprintf("must be between %d and %d", minCFGScale, maxCFGScale)}
+	}
+
+	return nil
+}
+
+func (q *queueImpl) UpdateDefaultGenerationParameters(key SettingsKey, defaults GenerationDefaults) (*entities.DefaultSettings, error) {
+	err := defaults.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := q.updateSettings(key, func(row *entities.DefaultSettings) {
+		row.NegativePrompt = defaults.NegativePrompt
+		row.SamplerName = defaults.SamplerName
+		row.Steps = defaults.Steps
+		row.CfgScale = defaults.CfgScale
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Updated default generation parameters of %s to: %+v\n", key.rowID(), defaults)
+
+	return settings, nil
+}
+
+func (q *queueImpl) UpdateDefaultHiresFix(key SettingsKey, enableHR bool) (*entities.DefaultSettings, error) {
+	settings, err := q.updateSettings(key, func(row *entities.DefaultSettings) {
+		row.EnableHR = &enableHR
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Updated default hires fix of %s to: %v\n", key.rowID(), enableHR)
+
+	return settings, nil
+}
+
+func (q *queueImpl) UpdateDefaultRestoreFaces(key SettingsKey, restoreFaces bool) (*entities.DefaultSettings, error) {
+	settings, err := q.updateSettings(key, func(row *entities.DefaultSettings) {
+		row.RestoreFaces = &restoreFaces
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Updated default restore faces of %s to: %v\n", key.rowID(), restoreFaces)
+
+	return settings, nil
+}
+
+func (q *queueImpl) UpdateDefaultUpscale(key SettingsKey, method UpscaleMethod, factor float64) (*entities.DefaultSettings, error) {
+	settings, err := q.updateSettings(key, func(row *entities.DefaultSettings) {
+		row.UpscaleMethod = string(method)
+		row.UpscaleFactor = factor
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Updated default upscale method/factor of %s to: %s/%v\n", key.rowID(), method, factor)
+
+	return settings, nil
+}
+
+func (q *queueImpl) UpdateDefaultUpscaler(key SettingsKey, upscaler string) (*entities.DefaultSettings, error) {
+	settings, err := q.updateSettings(key, func(row *entities.DefaultSettings) {
+		row.Upscaler = upscaler
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Updated default upscaler of %s to: %s\n", key.rowID(), upscaler)
+
+	return settings, nil
+}
+
+func (q *queueImpl) UpdateDefaultVariationStrengths(key SettingsKey, subtle, strong float64) (*entities.DefaultSettings, error) {
+	settings, err := q.updateSettings(key, func(row *entities.DefaultSettings) {
+		row.SubtleVariationStrength = subtle
+		row.StrongVariationStrength = strong
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Updated default variation strengths of %s to: %v/%v\n", key.rowID(), subtle, strong)
+
+	return settings, nil
+}
+
+// ResetDefaultSettings removes the settings of the key's own scope, so the ones above it apply again.
+func (q *queueImpl) ResetDefaultSettings(key SettingsKey) (*entities.DefaultSettings, error) {
+	if key.Scope == SettingsScopeBot {
+		return nil, errors.New("the bot defaults can't be reset")
+	}
+
+	rowID := key.rowID()
+
+	err := q.defaultSettingsRepo.Delete(context.Background(), rowID)
+
+	q.invalidateSettings(rowID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Reset default settings of %s\n", rowID)
+
+	return q.GetDefaultSettings(key)
+}
@@ -0,0 +1,532 @@
+package imagine_queue
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/stable_diffusion_api"
+	"time"
+)
+
+// UpscaleMethod is how an image from the grid gets upscaled.
+type UpscaleMethod string
+
+const (
+	// UpscaleMethodHiresFix renders the image again with hires fix at the bigger size
+	UpscaleMethodHiresFix UpscaleMethod = "hires_fix"
+	// UpscaleMethodExtras renders the image again, then enlarges it with an upscaler from the extras tab
+	UpscaleMethodExtras UpscaleMethod = "extras"
+	// UpscaleMethodTiled renders the image again, then upscales it with img2img tile by tile
+	UpscaleMethodTiled UpscaleMethod = "tiled"
+)
+
+var UpscaleMethods = []UpscaleMethod{UpscaleMethodHiresFix, UpscaleMethodExtras, UpscaleMethodTiled}
+
+// UpscaleFactors are the factors users can pick from.
+var UpscaleFactors = []float64{1.5, 2, 4}
+
+const (
+	tiledUpscaleScriptName        = "sd upscale"
+	tiledUpscaleTileOverlap       = 64
+	tiledUpscaleDenoisingStrength = 0.3
+)
+
+func (m UpscaleMethod) Label() string {
+	switch m {
+	case UpscaleMethodHiresFix:
+		return "Hires fix"
+	case UpscaleMethodExtras:
+		return "Extras upscaler"
+	case UpscaleMethodTiled:
+		return "Tiled img2img"
+	default:
+		return string(m)
+	}
+}
+
+type UpscaleOptions struct {
+	// Upscaler and Factor override the server defaults when set
+	Upscaler string
+	Factor   float64
+}
+
+type upscaleResult struct {
+	image   []byte
+	width   int
+	height  int
+	seed    int
+	subseed int
+	// infotext is the parameters text of the image, when it was rendered again
+	infotext string
+}
+
+// upscaleStrategy upscales the image of the generation. The archived image is passed when there is one, so it doesn't
+// need to be rendered again, otherwise it is nil.
+type upscaleStrategy func(q *queueImpl, generation *entities.ImageGeneration, archivedImage []byte, upscaler string, factor float64) (*upscaleResult, error)
+
+var upscaleStrategies = map[UpscaleMethod]upscaleStrategy{
+	UpscaleMethodHiresFix: upscaleWithHiresFix,
+	UpscaleMethodExtras:   upscaleWithExtras,
+	UpscaleMethodTiled:    upscaleWithTiledImageToImage,
+}
+
+// roundUpTo8 rounds up to the nearest multiple of 8, which the model expects for image dimensions
+func roundUpTo8(value int) int {
+	return (value + 7) &^ 7
+}
+
+// shownSize returns the size of the image as it was shown in the grid.
+func shownSize(generation *entities.ImageGeneration) (int, int) {
+	if generation.EnableHR && generation.HiresWidth > 0 && generation.HiresHeight > 0 {
+		return generation.HiresWidth, generation.HiresHeight
+	}
+
+	return generation.Width, generation.Height
+}
+
+func upscaledSize(generation *entities.ImageGeneration, factor float64) (int, int) {
+	width, height := shownSize(generation)
+
+	return roundUpTo8(int(float64(width) * factor)), roundUpTo8(int(float64(height) * factor))
+}
+
+// GenerationParameters are the txt2img parameters that render a single image of the generation again, exactly as it
+// was in the grid. They are also the parameters shown for the image in the format of the A1111 API.
+func GenerationParameters(generation *entities.ImageGeneration) *stable_diffusion_api.TextToImageRequest {
+	return &stable_diffusion_api.TextToImageRequest{
+		Prompt:            generation.Prompt,
+		NegativePrompt:    generation.NegativePrompt,
+		Width:             generation.Width,
+		Height:            generation.Height,
+		RestoreFaces:      generation.RestoreFaces,
+		EnableHR:          generation.EnableHR,
+		HRResizeX:         generation.HiresWidth,
+		HRResizeY:         generation.HiresHeight,
+		DenoisingStrength: generation.DenoisingStrength,
+		BatchSize:         1,
+		Seed:              generation.Seed,
+		Subseed:           generation.Subseed,
+		SubseedStrength:   generation.SubseedStrength,
+		SamplerName:       generation.SamplerName,
+		CfgScale:          generation.CfgScale,
+		Steps:             generation.Steps,
+		NIter:             1,
+		Styles:            generationStyles(generation),
+		SaveImages:        true,
+		OverrideSettings: stable_diffusion_api.Txt2ImgOverrideSettings{
+			SamplesFormat:     "webp",
+			SDModelCheckpoint: generation.Checkpoint,
+		},
+	}
+}
+
+func decodeFirstImage(resp *stable_diffusion_api.TextToImageResponse) (*upscaleResult, error) {
+	if len(resp.Images) == 0 {
+		return nil, errors.New("no image returned")
+	}
+
+	decodedImage, err := base64.StdEncoding.DecodeString(resp.Images[0])
+	if err != nil {
+		return nil, err
+	}
+
+	result := &upscaleResult{image: decodedImage}
+
+	if len(resp.Seeds) > 0 {
+		result.seed = resp.Seeds[0]
+	}
+
+	if len(resp.Subseeds) > 0 {
+		result.subseed = resp.Subseeds[0]
+	}
+
+	if len(resp.Infotexts) > 0 {
+		result.infotext = resp.Infotexts[0]
+	}
+
+	return result, nil
+}
+
+// upscaleWithHiresFix repaints the archived image at the bigger size, like the second pass of hires fix does. Without
+// an archived image it renders the image again with hires fix, which only works as part of the generation.
+func upscaleWithHiresFix(q *queueImpl, generation *entities.ImageGeneration, archivedImage []byte, upscaler string, factor float64) (*upscaleResult, error) {
+	width, height := upscaledSize(generation, factor)
+
+	var resp *stable_diffusion_api.TextToImageResponse
+	var err error
+
+	if archivedImage != nil {
+		resp, err = q.stableDiffusionAPI.ImageToImage(&stable_diffusion_api.ImageToImageRequest{
+			InitImages:        []string{base64.StdEncoding.EncodeToString(archivedImage)},
+			Prompt:            generation.Prompt,
+			NegativePrompt:    generation.NegativePrompt,
+			Width:             width,
+			Height:            height,
+			RestoreFaces:      generation.RestoreFaces,
+			DenoisingStrength: generation.DenoisingStrength,
+			BatchSize:         1,
+			Seed:              generation.Seed,
+			Subseed:           generation.Subseed,
+			SubseedStrength:   generation.SubseedStrength,
+			SamplerName:       generation.SamplerName,
+			CfgScale:          generation.CfgScale,
+			Steps:             generation.Steps,
+			NIter:             1,
+			Styles:            generationStyles(generation),
+			SaveImages:        true,
+			OverrideSettings: stable_diffusion_api.Txt2ImgOverrideSettings{
+				SamplesFormat:      "webp",
+				SDModelCheckpoint:  generation.Checkpoint,
+				UpscalerForImg2Img: upscaler,
+			},
+		})
+	} else {
+		req := GenerationParameters(generation)
+		req.EnableHR = true
+		req.HrUpscaler = upscaler
+		req.HRResizeX = width
+		req.HRResizeY = height
+
+		resp, err = q.stableDiffusionAPI.TextToImage(req)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := decodeFirstImage(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	result.width = width
+	result.height = height
+
+	return result, nil
+}
+
+func upscaleWithExtras(q *queueImpl, generation *entities.ImageGeneration, archivedImage []byte, upscaler string, factor float64) (*upscaleResult, error) {
+	req := &stable_diffusion_api.UpscaleRequest{
+		ResizeMode:         0,
+		UpscalingResize:    factor,
+		Upscaler1:          upscaler,
+		TextToImageRequest: GenerationParameters(generation),
+	}
+
+	if archivedImage != nil {
+		req.Image = base64.StdEncoding.EncodeToString(archivedImage)
+	}
+
+	resp, err := q.stableDiffusionAPI.UpscaleImage(req)
+	if err != nil {
+		return nil, err
+	}
+
+	decodedImage, err := base64.StdEncoding.DecodeString(resp.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := upscaledSize(generation, factor)
+
+	return &upscaleResult{
+		image:   decodedImage,
+		width:   width,
+		height:  height,
+		seed:    generation.Seed,
+		subseed: generation.Subseed,
+	}, nil
+}
+
+func upscaleWithTiledImageToImage(q *queueImpl, generation *entities.ImageGeneration, archivedImage []byte, upscaler string, factor float64) (*upscaleResult, error) {
+	// the upscale script takes the position of the upscaler in the web UI's list rather than its name
+	upscalers, err := q.stableDiffusionAPI.GetUpscalers()
+	if err != nil {
+		return nil, err
+	}
+
+	upscalerIndex := -1
+
+	for idx, name := range upscalers {
+		if name == upscaler {
+			upscalerIndex = idx
+
+			break
+		}
+	}
+
+	if upscalerIndex < 0 {
+		return nil, fmt.Errorf("unknown upscaler: %s", upscaler)
+	}
+
+	initImage := base64.StdEncoding.EncodeToString(archivedImage)
+
+	if archivedImage == nil {
+		rendered, err := q.stableDiffusionAPI.TextToImage(GenerationParameters(generation))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rendered.Images) == 0 {
+			return nil, errors.New("no image returned")
+		}
+
+		initImage = rendered.Images[0]
+	}
+
+	// the script upscales the image first, then repaints it in tiles the size of the original generation
+	resp, err := q.stableDiffusionAPI.ImageToImage(&stable_diffusion_api.ImageToImageRequest{
+		InitImages:        []string{initImage},
+		Prompt:            generation.Prompt,
+		NegativePrompt:    generation.NegativePrompt,
+		Width:             generation.Width,
+		Height:            generation.Height,
+		RestoreFaces:      generation.RestoreFaces,
+		DenoisingStrength: tiledUpscaleDenoisingStrength,
+		BatchSize:         1,
+		Seed:              generation.Seed,
+		Subseed:           generation.Subseed,
+		SubseedStrength:   generation.SubseedStrength,
+		SamplerName:       generation.SamplerName,
+		CfgScale:          generation.CfgScale,
+		Steps:             generation.Steps,
+		NIter:             1,
+		Styles:            generationStyles(generation),
+		ScriptName:        tiledUpscaleScriptName,
+		ScriptArgs:        []interface{}{nil, tiledUpscaleTileOverlap, upscalerIndex, factor},
+		SaveImages:        true,
+		OverrideSettings: stable_diffusion_api.Txt2ImgOverrideSettings{
+			SamplesFormat:     "webp",
+			SDModelCheckpoint: generation.Checkpoint,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := decodeFirstImage(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	result.width, result.height = upscaledSize(generation, factor)
+
+	return result, nil
+}
+
+// upscaleSettings resolves the method, upscaler and factor for the item, falling back to the member's defaults.
+func (q *queueImpl) upscaleSettings(imagine *QueueItem) (UpscaleMethod, string, float64, error) {
+	defaultSettings, err := q.settingsForImagine(imagine)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	method := UpscaleMethod(defaultSettings.UpscaleMethod)
+	upscaler := defaultSettings.Upscaler
+	factor := defaultSettings.UpscaleFactor
+
+	if imagine.Upscale != nil {
+		if imagine.Upscale.Upscaler != "" {
+			upscaler = imagine.Upscale.Upscaler
+		}
+
+		if imagine.Upscale.Factor > 0 {
+			factor = imagine.Upscale.Factor
+		}
+	}
+
+	return method, upscaler, factor, nil
+}
+
+func (q *queueImpl) processUpscaleImagine(imagine *QueueItem) {
+	interactionID := imagine.Source.RequestID
+	messageID := imagine.Source.MessageID
+	userID := imagine.Source.MemberID
+
+	log.Printf("Upscaling image: %v, Message: %v, Upscale Index: %d",
+		interactionID, messageID, imagine.InteractionIndex)
+
+	generation, err := q.imageGenerationRepo.GetByMessageAndSort(context.Background(), messageID, imagine.InteractionIndex)
+	if err != nil {
+		log.Printf("Error getting image generation: %v", err)
+
+		imagine.Source.Sink.Failed(imagine, err)
+
+		return
+	}
+
+	log.Printf("Found generation: %v", generation)
+
+	method, upscaler, factor, err := q.upscaleSettings(imagine)
+	if err != nil {
+		log.Printf("Error getting upscale settings: %v", err)
+
+		imagine.Source.Sink.Failed(imagine, err)
+
+		return
+	}
+
+	// the image is rendered again, so it keeps to the profile of the channel it is upscaled in
+	profile, err := q.applyProfile(imagine, generation)
+	if err != nil {
+		log.Printf("Error getting channel profile: %v", err)
+
+		imagine.Source.Sink.Failed(imagine, err)
+
+		return
+	}
+
+	factor = profileUpscaleFactor(generation, profile, factor)
+	if factor <= 1 {
+		imagine.Source.Sink.Failed(imagine, &InvalidSettingError{Setting: "upscale factor",
+			Reason: "the profile of this channel doesn't allow a bigger image"})
+
+		return
+	}
+
+	strategy, ok := upscaleStrategies[method]
+	if !ok {
+		log.Printf("Unknown upscale method %q, using %q", method, UpscaleMethodHiresFix)
+
+		method = UpscaleMethodHiresFix
+		strategy = upscaleWithHiresFix
+	}
+
+	// members who may not use hires fix get the extras upscaler instead, which doesn't need it
+	if method == UpscaleMethodHiresFix && imagine.Source.HiresDenied {
+		log.Printf("Hires fix is denied to %v, upscaling with %q", userID, UpscaleMethodExtras)
+
+		method = UpscaleMethodExtras
+		strategy = upscaleWithExtras
+	}
+
+	resultMessageID, err := q.startJob(imagine, JobStatus{Generation: generation})
+	if err != nil {
+		log.Printf("Error starting upscale: %v", err)
+
+		return
+	}
+
+	generationDone := make(chan bool)
+
+	go func() {
+		lastProgress := float64(0)
+		fetchProgress := float64(0)
+		upscaleProgress := float64(0)
+
+		for {
+			select {
+			case <-generationDone:
+				return
+			case <-time.After(1 * time.Second):
+				progress, progressErr := q.stableDiffusionAPI.GetCurrentProgress()
+				if progressErr != nil {
+					log.Printf("Error getting current progress: %v", progressErr)
+
+					return
+				}
+
+				if progress.Progress == 0 {
+					continue
+				}
+
+				if progress.Progress < lastProgress || upscaleProgress > 0 {
+					upscaleProgress = progress.Progress
+					fetchProgress = 1
+				} else {
+					fetchProgress = progress.Progress
+				}
+
+				lastProgress = progress.Progress
+
+				progressErr = imagine.Source.Sink.Progress(imagine, JobStatus{
+					Generation:      generation,
+					Progress:        fetchProgress,
+					UpscaleProgress: upscaleProgress,
+				})
+				if progressErr != nil {
+					log.Printf("Error reporting progress: %v", progressErr)
+				}
+			}
+		}
+	}()
+
+	archivedImage := q.archivedImage(generation)
+
+	// without an archived image the image is rendered again, members who may not use hires fix get it without
+	renderedGeneration := generation
+	if archivedImage == nil && imagine.Source.HiresDenied {
+		lowresGeneration := *generation
+		withoutHires(&lowresGeneration)
+
+		renderedGeneration = &lowresGeneration
+	}
+
+	result, err := strategy(q, renderedGeneration, archivedImage, upscaler, factor)
+
+	// closed rather than sent to, the progress loop stops by itself when the progress can't be fetched
+	close(generationDone)
+
+	if err != nil {
+		log.Printf("Error processing image upscale: %v\n", err)
+
+		imagine.Source.Sink.Failed(imagine, err)
+
+		return
+	}
+
+	log.Printf("Successfully upscaled image: %v, Message: %v, Upscale Index: %d, Method: %v, Upscaler: %v, Factor: %v",
+		interactionID, messageID, imagine.InteractionIndex, method, upscaler, factor)
+
+	storedImage := q.archive(result.image)
+
+	// the upscaled image gets its own generation, so it can be zoomed out or panned from its message. It is stored
+	// before the result is posted, so the events of the job have it.
+	upscaledGeneration := *generation
+	upscaledGeneration.ID = 0
+	upscaledGeneration.ParentID = generation.ID
+	upscaledGeneration.InteractionID = interactionID
+	upscaledGeneration.MessageID = resultMessageID
+	upscaledGeneration.MemberID = userID
+	upscaledGeneration.GuildID = imagine.Source.GuildID
+	upscaledGeneration.ChannelID = imagine.Source.ChannelID
+	upscaledGeneration.SortOrder = 0
+	upscaledGeneration.EnableHR = true
+	upscaledGeneration.HiresWidth = result.width
+	upscaledGeneration.HiresHeight = result.height
+	upscaledGeneration.BatchCount = 1
+	upscaledGeneration.BatchSize = 1
+	upscaledGeneration.Seed = result.seed
+	upscaledGeneration.Subseed = result.subseed
+	upscaledGeneration.Infotext = result.infotext
+	upscaledGeneration.Processed = true
+	upscaledGeneration.ImagePath = ""
+	upscaledGeneration.ImageHash = ""
+
+	setArchivedImage(&upscaledGeneration, storedImage)
+
+	_, err = q.imageGenerationRepo.Create(context.Background(), &upscaledGeneration)
+	if err != nil {
+		log.Printf("Error creating image generation record: %v\n", err)
+	}
+
+	err = q.finishJob(imagine, &JobResult{
+		Generation: generation,
+		Images: []ResultImage{
+			{
+				ContentType: "image/png",
+				Name:        fmt.Sprintf("seed-%d.png", generation.Seed),
+				Data:        result.image,
+				URL:         q.archivedImageURL(storedImage),
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("Error posting upscale result: %v\n", err)
+
+		return
+	}
+}
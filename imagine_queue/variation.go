@@ -0,0 +1,51 @@
+package imagine_queue
+
+import "stable_diffusion_bot/entities"
+
+// VariationStrength picks one of the server's variation strengths.
+type VariationStrength string
+
+const (
+	VariationSubtle VariationStrength = "subtle"
+	VariationStrong VariationStrength = "strong"
+)
+
+var VariationStrengths = []VariationStrength{VariationSubtle, VariationStrong}
+
+// archivedVariationDenoisingStrength is how much of an archived image a variation repaints. The seed is kept, so the
+// subseed strength still decides how far the variation strays from the image.
+const archivedVariationDenoisingStrength = 0.75
+
+func (v VariationStrength) Label() string {
+	switch v {
+	case VariationStrong:
+		return "Strong"
+	default:
+		return "Subtle"
+	}
+}
+
+// variationSubseedStrength returns the strength picked for the variation, from the member's defaults.
+func (q *queueImpl) variationSubseedStrength(imagine *QueueItem) (float64, error) {
+	defaultSettings, err := q.settingsForImagine(imagine)
+	if err != nil {
+		return 0, err
+	}
+
+	if imagine.Variation == VariationStrong {
+		return defaultSettings.StrongVariationStrength, nil
+	}
+
+	return defaultSettings.SubtleVariationStrength, nil
+}
+
+// stackedSubseedStrength combines the strength of the generation being varied with the new one.
+// The web UI only mixes a single subseed into the seed, so a variation of a variation keeps the original seed with
+// fresh subseeds, and moves as far from the original as both steps together would.
+func stackedSubseedStrength(parent *entities.ImageGeneration, strength float64) float64 {
+	if parent.SubseedStrength <= 0 {
+		return strength
+	}
+
+	return min(1, 1-(1-parent.SubseedStrength)*(1-strength))
+}
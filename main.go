@@ -3,15 +3,28 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"stable_diffusion_bot/databases/sqlite"
 	"stable_diffusion_bot/discord_bot"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/http_api"
+	"stable_diffusion_bot/image_store"
 	"stable_diffusion_bot/imagine_queue"
+	"stable_diffusion_bot/repositories/api_keys"
+	"stable_diffusion_bot/repositories/channel_profiles"
 	"stable_diffusion_bot/repositories/default_settings"
+	"stable_diffusion_bot/repositories/favorites"
 	"stable_diffusion_bot/repositories/image_generations"
+	"stable_diffusion_bot/repositories/moderation_decisions"
+	"stable_diffusion_bot/repositories/moderation_rules"
+	"stable_diffusion_bot/repositories/permissions"
 	"stable_diffusion_bot/stable_diffusion_api"
+	"stable_diffusion_bot/webhooks"
 )
 
 // Bot parameters
@@ -22,8 +35,28 @@ var (
 	imagineCommand     = flag.String("imagine", "imagine", "Imagine command name. Default is \"imagine\"")
 	removeCommandsFlag = flag.Bool("remove", false, "Delete all commands when bot exits")
 	devModeFlag        = flag.Bool("dev", false, "Start in development mode, using \"dev_\" prefixed commands instead")
+	wildcardsDirFlag   = flag.String("wildcards", "wildcards", "Directory with wildcard text files, used for __name__ in prompts")
+	httpListenFlag     = flag.String("http-listen", "", "Address for the HTTP API, e.g. \":8080\". If not passed - the API is disabled")
+	createAPIKeyFlag   = flag.String("create-api-key", "", "Create an HTTP API key with the given name, print it and exit")
+	revokeAPIKeyFlag   = flag.String("revoke-api-key", "", "Revoke the HTTP API key with the given name and exit")
+	webhookURLsFlag    = flag.String("webhook-urls", "", "Comma separated URLs that job events are POSTed to")
+	webhookSecretFlag  = flag.String("webhook-secret", "", "Secret used to sign the webhook requests")
+	imageDirFlag       = flag.String("image-dir", "", "Directory to archive generated images in. If not passed - images are not archived")
+	s3BucketFlag       = flag.String("s3-bucket", "", "S3 bucket to archive generated images in, instead of -image-dir")
+	s3PrefixFlag       = flag.String("s3-prefix", "", "Prefix for the keys of archived images in the S3 bucket, e.g. \"images/\"")
+	s3EndpointFlag     = flag.String("s3-endpoint", "", "URL of an S3-compatible API, e.g. \"http://127.0.0.1:9000\". Default is AWS")
+	s3RegionFlag       = flag.String("s3-region", "", "Region of the S3 bucket. Default is \"us-east-1\"")
+	s3PathStyleFlag    = flag.Bool("s3-path-style", false, "Put the S3 bucket in the path instead of the host name, which MinIO needs")
+	galleryLinksFlag   = flag.Bool("gallery-links", false, "Link to results in the S3 bucket when they are too big to upload to Discord")
+	imageMaxAgeFlag    = flag.Duration("image-max-age", 0, "Remove archived images older than this, e.g. \"720h\". Default is to keep them")
+	imageMaxSizeFlag   = flag.Int64("image-max-size-mb", 0, "Remove the oldest archived images when they take more megabytes than this")
+	purgeDeletedFlag   = flag.Bool("purge-deleted-images", false, "Remove the archived images of results that are deleted from Discord")
+	webhookEventsFlag  = flag.String("webhook-events", "", "Comma separated job events sent to the webhooks. Default is all but job.progress")
 )
 
+// imageRetentionInterval is how often archived images are checked against the retention policy
+const imageRetentionInterval = 1 * time.Hour
+
 func getFlagValue(flag *string, envVar string) string {
 	if flag != nil && *flag != "" {
 		return *flag
@@ -31,12 +64,81 @@ func getFlagValue(flag *string, envVar string) string {
 	return os.Getenv(envVar)
 }
 
+// splitList splits a comma separated flag value, leaving out empty entries.
+func splitList(value string) []string {
+	var values []string
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			values = append(values, entry)
+		}
+	}
+
+	return values
+}
+
+// manageAPIKeys creates or revokes the API key named by the flags.
+func manageAPIKeys(ctx context.Context, apiKeyRepo api_keys.Repository) {
+	if *createAPIKeyFlag != "" {
+		key, keyHash, err := http_api.GenerateAPIKey()
+		if err != nil {
+			log.Fatalf("Failed to generate API key: %v", err)
+		}
+
+		_, err = apiKeyRepo.Create(ctx, &entities.APIKey{Name: *createAPIKeyFlag, KeyHash: keyHash})
+		if err != nil {
+			log.Fatalf("Failed to create API key: %v", err)
+		}
+
+		log.Printf("Created API key %q, it is only shown once:", *createAPIKeyFlag)
+		fmt.Println(key)
+
+		return
+	}
+
+	deleted, err := apiKeyRepo.DeleteByName(ctx, *revokeAPIKeyFlag)
+	if err != nil {
+		log.Fatalf("Failed to revoke API key: %v", err)
+	}
+
+	if !deleted {
+		log.Fatalf("No API key named %q", *revokeAPIKeyFlag)
+	}
+
+	log.Printf("Revoked API key %q", *revokeAPIKeyFlag)
+}
+
 func main() {
 	flag.Parse()
 
+	if *createAPIKeyFlag != "" || *revokeAPIKeyFlag != "" {
+		ctx := context.Background()
+
+		sqliteDB, err := sqlite.New(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create sqlite database: %v", err)
+		}
+
+		apiKeyRepo, err := api_keys.NewRepository(&api_keys.Config{DB: sqliteDB})
+		if err != nil {
+			log.Fatalf("Failed to create API key repository: %v", err)
+		}
+
+		manageAPIKeys(ctx, apiKeyRepo)
+
+		return
+	}
+
 	guildID := getFlagValue(guildIDFlag, "DISCORD_GUILDID")
 	botToken := getFlagValue(botTokenFlag, "DISCORD_TOKEN")
 	apiHost := getFlagValue(apiHostFlag, "SD_API_HOST")
+	httpListen := getFlagValue(httpListenFlag, "HTTP_LISTEN")
+	webhookURLs := getFlagValue(webhookURLsFlag, "WEBHOOK_URLS")
+	webhookSecret := getFlagValue(webhookSecretFlag, "WEBHOOK_SECRET")
+	imageDir := getFlagValue(imageDirFlag, "IMAGE_DIR")
+	s3Bucket := getFlagValue(s3BucketFlag, "S3_BUCKET")
+	s3Endpoint := getFlagValue(s3EndpointFlag, "S3_ENDPOINT")
 
 	if guildID == "" {
 		log.Fatalf("Guild ID is required")
@@ -93,15 +195,102 @@ func main() {
 		log.Fatalf("Failed to create default settings repository: %v", err)
 	}
 
+	channelProfileRepo, err := channel_profiles.NewRepository(&channel_profiles.Config{DB: sqliteDB})
+	if err != nil {
+		log.Fatalf("Failed to create channel profile repository: %v", err)
+	}
+
+	moderationRuleRepo, err := moderation_rules.NewRepository(&moderation_rules.Config{DB: sqliteDB})
+	if err != nil {
+		log.Fatalf("Failed to create moderation rule repository: %v", err)
+	}
+
+	moderationDecisionRepo, err := moderation_decisions.NewRepository(&moderation_decisions.Config{DB: sqliteDB})
+	if err != nil {
+		log.Fatalf("Failed to create moderation decision repository: %v", err)
+	}
+
+	favoriteRepo, err := favorites.NewRepository(&favorites.Config{DB: sqliteDB})
+	if err != nil {
+		log.Fatalf("Failed to create favorite repository: %v", err)
+	}
+
+	permissionRepo, err := permissions.NewRepository(&permissions.Config{DB: sqliteDB})
+	if err != nil {
+		log.Fatalf("Failed to create permission repository: %v", err)
+	}
+
+	var imageStore image_store.Store
+
+	switch {
+	case s3Bucket != "":
+		imageStore, err = image_store.NewS3Store(image_store.S3Config{
+			Endpoint:        s3Endpoint,
+			Region:          *s3RegionFlag,
+			Bucket:          s3Bucket,
+			Prefix:          *s3PrefixFlag,
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       *s3PathStyleFlag,
+		})
+	case imageDir != "":
+		imageStore, err = image_store.NewLocalStore(image_store.LocalConfig{Dir: imageDir})
+	}
+
+	if err != nil {
+		log.Fatalf("Failed to create image store: %v", err)
+	}
+
+	if imageStore != nil {
+		image_store.StartRetention(ctx, imageStore, image_store.RetentionPolicy{
+			MaxAge:       *imageMaxAgeFlag,
+			MaxTotalSize: *imageMaxSizeFlag * 1024 * 1024,
+		}, imageRetentionInterval, func(paths []string) {
+			for _, path := range paths {
+				clearErr := generationRepo.ClearImagePath(ctx, path)
+				if clearErr != nil {
+					log.Printf("Error clearing archived image %s: %v", path, clearErr)
+				}
+			}
+		})
+	}
+
 	imagineQueue, err := imagine_queue.New(imagine_queue.Config{
-		StableDiffusionAPI:  stableDiffusionAPI,
-		ImageGenerationRepo: generationRepo,
-		DefaultSettingsRepo: defaultSettingsRepo,
+		StableDiffusionAPI:     stableDiffusionAPI,
+		ImageGenerationRepo:    generationRepo,
+		DefaultSettingsRepo:    defaultSettingsRepo,
+		ChannelProfileRepo:     channelProfileRepo,
+		ModerationRuleRepo:     moderationRuleRepo,
+		ModerationDecisionRepo: moderationDecisionRepo,
+		FavoriteRepo:           favoriteRepo,
+		PermissionRepo:         permissionRepo,
+		WildcardsDir:           *wildcardsDirFlag,
+		ImageStore:             imageStore,
+		PurgeDeletedImages:     *purgeDeletedFlag,
 	})
 	if err != nil {
 		log.Fatalf("Failed to create imagine queue: %v", err)
 	}
 
+	if webhookURLs != "" {
+		var webhookEvents []imagine_queue.EventType
+
+		for _, eventType := range splitList(*webhookEventsFlag) {
+			webhookEvents = append(webhookEvents, imagine_queue.EventType(eventType))
+		}
+
+		webhookSubscriber, err := webhooks.New(webhooks.Config{
+			URLs:   splitList(webhookURLs),
+			Secret: webhookSecret,
+			Events: webhookEvents,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create webhooks: %v", err)
+		}
+
+		imagineQueue.Subscribe(webhookSubscriber)
+	}
+
 	bot, err := discord_bot.New(discord_bot.Config{
 		DevelopmentMode:    devMode,
 		BotToken:           botToken,
@@ -110,13 +299,47 @@ func main() {
 		ImagineCommand:     *imagineCommand,
 		RemoveCommands:     removeCommands,
 		StableDiffusionAPI: stableDiffusionAPI,
+		GalleryLinks:       *galleryLinksFlag,
 	})
 
 	if err != nil {
 		log.Fatalf("Error creating Discord bot: %v", err)
 	}
 
+	var httpServer http_api.Server
+
+	if httpListen != "" {
+		apiKeyRepo, err := api_keys.NewRepository(&api_keys.Config{DB: sqliteDB})
+		if err != nil {
+			log.Fatalf("Failed to create API key repository: %v", err)
+		}
+
+		httpServer, err = http_api.New(http_api.Config{
+			Listen:              httpListen,
+			ImagineQueue:        imagineQueue,
+			ImageGenerationRepo: generationRepo,
+			APIKeyRepo:          apiKeyRepo,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create HTTP API: %v", err)
+		}
+
+		go func() {
+			startErr := httpServer.Start()
+			if startErr != nil {
+				log.Fatalf("HTTP API stopped: %v", startErr)
+			}
+		}()
+	}
+
 	bot.Start()
 
+	if httpServer != nil {
+		err = httpServer.Close()
+		if err != nil {
+			log.Printf("Error closing HTTP API: %v", err)
+		}
+	}
+
 	log.Println("Gracefully shutting down.")
 }
@@ -0,0 +1,287 @@
+package moderation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"stable_diffusion_bot/entities"
+)
+
+const (
+	ListBlock = "block"
+	ListAllow = "allow"
+
+	MatchWord  = "word"
+	MatchRegex = "regex"
+	// MatchCheckpoint matches the checkpoint a result is rendered with instead of the prompt, for sensitive rules
+	MatchCheckpoint = "checkpoint"
+
+	ActionReject   = "reject"
+	ActionNegative = "negative"
+	ActionHold     = "hold"
+	// ActionSensitive lets the prompt through, but spoilers its results outside of age restricted channels
+	ActionSensitive = "sensitive"
+)
+
+var (
+	Lists   = []string{ListBlock, ListAllow}
+	Matches = []string{MatchWord, MatchRegex, MatchCheckpoint}
+	Actions = []string{ActionReject, ActionNegative, ActionHold, ActionSensitive}
+)
+
+// actionSeverity orders the actions, the most severe one of all matching rules is taken.
+var actionSeverity = map[string]int{
+	ActionSensitive: 1,
+	ActionNegative:  2,
+	ActionHold:      3,
+	ActionReject:    4,
+}
+
+// InvalidRuleError is returned for a rule that can't be used.
+type InvalidRuleError struct {
+	Reason string
+}
+
+func (e *InvalidRuleError) Error() string {
+	return fmt.Sprintf("invalid moderation rule: %s", e.Reason)
+}
+
+func (e *InvalidRuleError) Is(tgt error) bool {
+	_, ok := tgt.(*InvalidRuleError)
+
+	return ok
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compile turns the rule into a regular expression. The pattern of a word rule is normalized like the text it is
+// matched with.
+func compile(rule *entities.ModerationRule, normalize func(string) string) (*regexp.Regexp, error) {
+	switch rule.Match {
+	case MatchWord:
+		words := strings.Fields(normalize(rule.Pattern))
+		if len(words) == 0 {
+			return nil, &InvalidRuleError{Reason: "the pattern is empty"}
+		}
+
+		for idx, word := range words {
+			words[idx] = regexp.QuoteMeta(word)
+		}
+
+		// words of a phrase may be split by anything that isn't a letter, like "bad_word" or "bad-word"
+		return regexp.MustCompile(`(^|[^\pL\pN])` + strings.Join(words, `[^\pL\pN]+`) + `($|[^\pL\pN])`), nil
+	case MatchRegex:
+		compiled, err := regexp.Compile(`(?i)` + rule.Pattern)
+		if err != nil {
+			return nil, &InvalidRuleError{Reason: err.Error()}
+		}
+
+		return compiled, nil
+	default:
+		return nil, &InvalidRuleError{Reason: fmt.Sprintf("unknown match \"%s\"", rule.Match)}
+	}
+}
+
+// Validate checks the rule before it is stored.
+func Validate(rule *entities.ModerationRule) error {
+	if !contains(Lists, rule.List) {
+		return &InvalidRuleError{Reason: fmt.Sprintf("unknown list \"%s\"", rule.List)}
+	}
+
+	if rule.List == ListBlock && !contains(Actions, rule.Action) {
+		return &InvalidRuleError{Reason: fmt.Sprintf("unknown action \"%s\"", rule.Action)}
+	}
+
+	if strings.TrimSpace(rule.Pattern) == "" {
+		return &InvalidRuleError{Reason: "the pattern is empty"}
+	}
+
+	if rule.Match == MatchCheckpoint {
+		if rule.List != ListBlock || rule.Action != ActionSensitive {
+			return &InvalidRuleError{Reason: "checkpoints can only be marked as sensitive"}
+		}
+
+		return nil
+	}
+
+	compiled, err := compile(rule, Normalize)
+	if err != nil {
+		return err
+	}
+
+	if compiled.MatchString("") {
+		return &InvalidRuleError{Reason: "the pattern matches every prompt"}
+	}
+
+	return nil
+}
+
+// Match is a block rule that matched a prompt.
+type Match struct {
+	Rule *entities.ModerationRule
+	// Text is the part of the folded or normalized prompt that matched
+	Text string
+}
+
+// Result is what should happen to a prompt.
+type Result struct {
+	// Action is the most severe action of the matches, empty when nothing matched
+	Action  string
+	Matches []Match
+}
+
+// Rule returns the rule of the most severe match.
+func (r *Result) Rule() *entities.ModerationRule {
+	for _, match := range r.Matches {
+		if match.Rule.Action == r.Action {
+			return match.Rule
+		}
+	}
+
+	return nil
+}
+
+// NegativeTerms are the terms to add to the negative prompt, from the rules with the negative action.
+func (r *Result) NegativeTerms() []string {
+	var terms []string
+
+	for _, match := range r.Matches {
+		if match.Rule.Action == ActionNegative {
+			terms = append(terms, strings.TrimSpace(match.Text))
+		}
+	}
+
+	return terms
+}
+
+// Sensitive reports whether a sensitive rule matched.
+func (r *Result) Sensitive() bool {
+	for _, match := range r.Matches {
+		if match.Rule.Action == ActionSensitive {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsSensitive reports whether results of the prompt, rendered with any of the checkpoints, should be spoilered.
+// Checkpoints are matched by name, so a pattern matches the title of a checkpoint with or without its hash.
+func IsSensitive(rules []*entities.ModerationRule, prompt string, checkpoints ...string) bool {
+	for _, rule := range rules {
+		if rule.Match != MatchCheckpoint || rule.Action != ActionSensitive {
+			continue
+		}
+
+		pattern := strings.ToLower(strings.TrimSpace(rule.Pattern))
+
+		for _, checkpoint := range checkpoints {
+			if checkpoint != "" && strings.Contains(strings.ToLower(checkpoint), pattern) {
+				return true
+			}
+		}
+	}
+
+	return Check(rules, prompt).Sensitive()
+}
+
+// find returns where the rule matches the prompt, and the first text it matched. Word rules match the folded prompt,
+// and the one with leetspeak turned into letters. Regex rules only match the folded one, so their digits stay digits.
+// Both texts have the same length, so the places are the same in either.
+func find(rule *entities.ModerationRule, folded, normalized string) ([][]int, string) {
+	var (
+		spans   [][]int
+		matched string
+	)
+
+	texts := []string{folded}
+	normalizers := []func(string) string{fold}
+
+	if rule.Match == MatchWord {
+		texts = append(texts, normalized)
+		normalizers = append(normalizers, Normalize)
+	}
+
+	for idx, text := range texts {
+		compiled, err := compile(rule, normalizers[idx])
+		if err != nil {
+			return nil, ""
+		}
+
+		found := compiled.FindAllStringIndex(text, -1)
+		if len(found) > 0 && len(spans) == 0 {
+			matched = text[found[0][0]:found[0][1]]
+		}
+
+		spans = append(spans, found...)
+	}
+
+	return spans, matched
+}
+
+// blank replaces the places in the text with spaces, keeping its length.
+func blank(text string, spans [][]int) string {
+	blanked := []byte(text)
+
+	for _, span := range spans {
+		for idx := span[0]; idx < span[1]; idx++ {
+			blanked[idx] = ' '
+		}
+	}
+
+	return string(blanked)
+}
+
+// isNotWordRune tells the characters around a match apart from the matched term.
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// Check matches the prompt with the rules. Invalid rules are skipped.
+func Check(rules []*entities.ModerationRule, prompt string) *Result {
+	folded := fold(prompt)
+	normalized := Normalize(prompt)
+
+	// allowed terms are blanked out first, so e.g. blocking "ass" doesn't block "ass hat" when that is allowed
+	for _, rule := range rules {
+		if rule.List != ListAllow || rule.Match == MatchCheckpoint {
+			continue
+		}
+
+		spans, _ := find(rule, folded, normalized)
+
+		folded = blank(folded, spans)
+		normalized = blank(normalized, spans)
+	}
+
+	result := &Result{}
+
+	for _, rule := range rules {
+		if rule.List != ListBlock || rule.Match == MatchCheckpoint {
+			continue
+		}
+
+		spans, matched := find(rule, folded, normalized)
+		if len(spans) == 0 {
+			continue
+		}
+
+		result.Matches = append(result.Matches, Match{Rule: rule, Text: strings.TrimFunc(matched, isNotWordRune)})
+
+		if actionSeverity[rule.Action] > actionSeverity[result.Action] {
+			result.Action = rule.Action
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,148 @@
+package moderation
+
+import (
+	"errors"
+	"testing"
+
+	"stable_diffusion_bot/entities"
+)
+
+func blockRule(id int64, match, pattern, action string) *entities.ModerationRule {
+	return &entities.ModerationRule{ID: id, List: ListBlock, Match: match, Pattern: pattern, Action: action}
+}
+
+func allowRule(id int64, match, pattern string) *entities.ModerationRule {
+	return &entities.ModerationRule{ID: id, List: ListAllow, Match: match, Pattern: pattern}
+}
+
+func TestCheck(t *testing.T) {
+	nude := blockRule(1, MatchWord, "nude", ActionReject)
+	ass := blockRule(2, MatchWord, "ass", ActionReject)
+	age := blockRule(3, MatchRegex, `\b1[0-7] ?(yo|years?)\b`, ActionHold)
+	blood := blockRule(4, MatchWord, "blood", ActionNegative)
+	sensitive := blockRule(5, MatchWord, "bikini", ActionSensitive)
+	phrase := blockRule(6, MatchWord, "bad word", ActionReject)
+
+	tests := []struct {
+		name       string
+		rules      []*entities.ModerationRule
+		prompt     string
+		wantAction string
+		wantText   string
+	}{
+		{name: "no match", rules: []*entities.ModerationRule{nude}, prompt: "a cat in a hat"},
+		{name: "word", rules: []*entities.ModerationRule{nude}, prompt: "a nude woman", wantAction: ActionReject,
+			wantText: "nude"},
+		{name: "word inside another word", rules: []*entities.ModerationRule{ass}, prompt: "a glass of water"},
+		{name: "exclamation after the word", rules: []*entities.ModerationRule{nude}, prompt: "a nude! woman",
+			wantAction: ActionReject, wantText: "nude"},
+		{name: "dollar after the word", rules: []*entities.ModerationRule{nude}, prompt: "nude$",
+			wantAction: ActionReject, wantText: "nude"},
+		{name: "plus after the word", rules: []*entities.ModerationRule{nude}, prompt: "nude+, woman",
+			wantAction: ActionReject, wantText: "nude"},
+		{name: "pipes around the word", rules: []*entities.ModerationRule{nude}, prompt: "|nude|",
+			wantAction: ActionReject, wantText: "nude"},
+		{name: "leetspeak", rules: []*entities.ModerationRule{nude}, prompt: "a nud3 woman",
+			wantAction: ActionReject, wantText: "nude"},
+		{name: "look-alike letters", rules: []*entities.ModerationRule{nude}, prompt: "a nudе woman",
+			wantAction: ActionReject, wantText: "nude"},
+		{name: "phrase across punctuation", rules: []*entities.ModerationRule{phrase}, prompt: "a bad_word here",
+			wantAction: ActionReject, wantText: "bad_word"},
+		{name: "regex with digits", rules: []*entities.ModerationRule{age}, prompt: "girl, 15yo",
+			wantAction: ActionHold, wantText: "15yo"},
+		{name: "regex with digits and a space", rules: []*entities.ModerationRule{age}, prompt: "16 years old",
+			wantAction: ActionHold, wantText: "16 years"},
+		{name: "regex without a match", rules: []*entities.ModerationRule{age}, prompt: "25 years old"},
+		{name: "allowlist blanks the term", rules: []*entities.ModerationRule{ass, allowRule(7, MatchWord, "ass hat")},
+			prompt: "a man in an ass hat"},
+		{name: "allowlist keeps other matches", rules: []*entities.ModerationRule{ass, allowRule(7, MatchWord, "ass hat")},
+			prompt: "an ass hat and an ass", wantAction: ActionReject, wantText: "ass"},
+		{name: "allowlist blanks leetspeak", rules: []*entities.ModerationRule{ass, allowRule(7, MatchWord, "ass hat")},
+			prompt: "an @ss hat"},
+		{name: "regex allowlist", rules: []*entities.ModerationRule{age, allowRule(7, MatchRegex, `1[0-7] years? ago`)},
+			prompt: "a photo from 15 years ago"},
+		{name: "most severe action", rules: []*entities.ModerationRule{blood, sensitive, nude},
+			prompt: "nude, bikini, blood", wantAction: ActionReject, wantText: "nude"},
+		{name: "negative", rules: []*entities.ModerationRule{blood}, prompt: "blood!", wantAction: ActionNegative,
+			wantText: "blood"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Check(tt.rules, tt.prompt)
+
+			if result.Action != tt.wantAction {
+				t.Fatalf("Check(%q) action = %q, want %q", tt.prompt, result.Action, tt.wantAction)
+			}
+
+			if tt.wantAction == "" {
+				return
+			}
+
+			var text string
+
+			for _, match := range result.Matches {
+				if match.Rule == result.Rule() {
+					text = match.Text
+				}
+			}
+
+			if text != tt.wantText {
+				t.Errorf("Check(%q) matched %q, want %q", tt.prompt, text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestCheckNegativeTerms(t *testing.T) {
+	rules := []*entities.ModerationRule{
+		blockRule(1, MatchWord, "blood", ActionNegative),
+		blockRule(2, MatchWord, "gore", ActionNegative),
+		blockRule(3, MatchWord, "bikini", ActionSensitive),
+	}
+
+	result := Check(rules, "g0re and blood, bikini")
+
+	terms := result.NegativeTerms()
+	if len(terms) != 2 || terms[0] != "blood" || terms[1] != "gore" {
+		t.Errorf("NegativeTerms() = %q, want blood and gore", terms)
+	}
+
+	if !result.Sensitive() {
+		t.Errorf("Sensitive() = false, want true")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    *entities.ModerationRule
+		wantErr bool
+	}{
+		{name: "word", rule: blockRule(1, MatchWord, "nude", ActionReject)},
+		{name: "regex", rule: blockRule(1, MatchRegex, `\bnude\b`, ActionReject)},
+		{name: "allow", rule: allowRule(1, MatchWord, "ass hat")},
+		{name: "checkpoint", rule: blockRule(1, MatchCheckpoint, "nsfw_model", ActionSensitive)},
+		{name: "unknown list", rule: &entities.ModerationRule{List: "deny", Match: MatchWord, Pattern: "a"},
+			wantErr: true},
+		{name: "unknown action", rule: blockRule(1, MatchWord, "nude", "ban"), wantErr: true},
+		{name: "empty pattern", rule: blockRule(1, MatchWord, "  ", ActionReject), wantErr: true},
+		{name: "broken regex", rule: blockRule(1, MatchRegex, "(nude", ActionReject), wantErr: true},
+		{name: "regex matching everything", rule: blockRule(1, MatchRegex, ".*", ActionReject), wantErr: true},
+		{name: "checkpoint that rejects", rule: blockRule(1, MatchCheckpoint, "model", ActionReject), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.rule)
+
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Validate() = %v, want an error: %v", err, tt.wantErr)
+			}
+
+			if err != nil && !errors.Is(err, &InvalidRuleError{}) {
+				t.Errorf("Validate() = %v, want an InvalidRuleError", err)
+			}
+		})
+	}
+}
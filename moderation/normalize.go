@@ -0,0 +1,158 @@
+package moderation
+
+import (
+	"strings"
+	"unicode"
+)
+
+// leetspeak maps the characters used to dodge word filters to the letters they stand for.
+var leetspeak = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'8': 'b',
+	'9': 'g',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'l',
+	'+': 't',
+}
+
+// confusables maps letters of other scripts that look like latin ones, e.g. the cyrillic "а" in "nаked".
+var confusables = map[rune]rune{
+	// cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't',
+	'у': 'y', 'х': 'x', 'і': 'i', 'ї': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ɡ': 'g',
+	// greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u',
+	'χ': 'x', 'ω': 'w',
+	// latin letters with marks, which are often not decomposed by the fonts that show them
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a', 'ā': 'a', 'ă': 'a', 'ą': 'a',
+	'ç': 'c', 'ć': 'c', 'č': 'c',
+	'ď': 'd', 'đ': 'd',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e', 'ē': 'e', 'ė': 'e', 'ę': 'e', 'ě': 'e',
+	'ğ': 'g',
+	'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i', 'ī': 'i', 'ı': 'i',
+	'ł': 'l',
+	'ñ': 'n', 'ń': 'n', 'ň': 'n',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ø': 'o', 'ō': 'o', 'ő': 'o',
+	'ř': 'r',
+	'ś': 's', 'š': 's', 'ş': 's', 'ß': 's',
+	'ť': 't',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u', 'ū': 'u', 'ů': 'u', 'ű': 'u',
+	'ý': 'y', 'ÿ': 'y',
+	'ź': 'z', 'ż': 'z', 'ž': 'z',
+}
+
+// fold lowercases the text and turns look-alike letters into plain latin ones. Zero-width and combining characters
+// are dropped. Digits and punctuation are left as they are, regex rules match them.
+func fold(text string) string {
+	var folded strings.Builder
+
+	folded.Grow(len(text))
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r >= '！' && r <= '～':
+			// fullwidth forms of the ASCII characters
+			r = unicode.ToLower(r - 0xFEE0)
+		case unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r):
+			continue
+		}
+
+		if mapped, ok := confusables[r]; ok {
+			r = mapped
+		}
+
+		folded.WriteRune(r)
+	}
+
+	return folded.String()
+}
+
+// maxLeetDigits is the longest run of digits taken for leetspeak, longer ones are numbers like in "1024x768"
+const maxLeetDigits = 2
+
+// unleet turns the leetspeak of a word into the letters it stands for. Only words that mix letters with look-alike
+// characters are changed, so numbers stay numbers, and punctuation at the end of a word stays punctuation, like the
+// "!" of "nude!".
+func unleet(word string) string {
+	runes := []rune(word)
+
+	end := len(runes)
+	for end > 0 && !unicode.IsLetter(runes[end-1]) && !unicode.IsDigit(runes[end-1]) {
+		end--
+	}
+
+	hasLetter, hasLeet := false, false
+	digits := 0
+
+	for _, r := range runes[:end] {
+		_, isLeet := leetspeak[r]
+
+		hasLetter = hasLetter || unicode.IsLetter(r)
+		hasLeet = hasLeet || isLeet
+
+		if unicode.IsDigit(r) {
+			digits++
+		} else {
+			digits = 0
+		}
+
+		if digits > maxLeetDigits {
+			return word
+		}
+	}
+
+	if !hasLetter || !hasLeet {
+		return word
+	}
+
+	for idx, r := range runes[:end] {
+		if mapped, ok := leetspeak[r]; ok {
+			runes[idx] = mapped
+		}
+	}
+
+	return string(runes)
+}
+
+// Normalize folds the text to plain lowercase latin letters, so look-alike characters and leetspeak match the rules.
+// Zero-width and combining characters are dropped. Leetspeak only maps ASCII to ASCII, so the normalized text has the
+// same length as the folded one, and a match in one is at the same place in the other.
+func Normalize(text string) string {
+	folded := fold(text)
+
+	var normalized strings.Builder
+
+	normalized.Grow(len(folded))
+
+	start := -1
+
+	for idx, r := range folded {
+		if !unicode.IsSpace(r) {
+			if start < 0 {
+				start = idx
+			}
+
+			continue
+		}
+
+		if start >= 0 {
+			normalized.WriteString(unleet(folded[start:idx]))
+			start = -1
+		}
+
+		normalized.WriteRune(r)
+	}
+
+	if start >= 0 {
+		normalized.WriteString(unleet(folded[start:]))
+	}
+
+	return normalized.String()
+}
@@ -0,0 +1,33 @@
+package moderation
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "lowercase", text: "A Naked Woman", want: "a naked woman"},
+		{name: "leetspeak", text: "n4k3d", want: "naked"},
+		{name: "leetspeak symbols", text: "$3x and @ss", want: "sex and ass"},
+		{name: "punctuation after a word", text: "a nude! woman, nude$ nude+", want: "a nude! woman, nude$ nude+"},
+		{name: "leetspeak before punctuation", text: "n00d!!", want: "nood!!"},
+		{name: "numbers stay numbers", text: "girl, 15 years old, 1024x768", want: "girl, 15 years old, 1024x768"},
+		{name: "number with letters", text: "15yo", want: "isyo"},
+		{name: "long number with letters", text: "1080p", want: "1080p"},
+		{name: "cyrillic look-alikes", text: "nаkеd", want: "naked"},
+		{name: "fullwidth", text: "ＮＵＤＥ", want: "nude"},
+		{name: "zero-width and combining", text: "nu​dé", want: "nude"},
+		{name: "accents", text: "nüdé", want: "nude"},
+		{name: "whitespace kept", text: "a\tb\nc", want: "a\tb\nc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.text); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
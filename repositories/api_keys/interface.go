@@ -0,0 +1,12 @@
+package api_keys
+
+import (
+	"context"
+	"stable_diffusion_bot/entities"
+)
+
+type Repository interface {
+	Create(ctx context.Context, key *entities.APIKey) (*entities.APIKey, error)
+	GetByKeyHash(ctx context.Context, keyHash string) (*entities.APIKey, error)
+	DeleteByName(ctx context.Context, name string) (bool, error)
+}
@@ -0,0 +1,93 @@
+package api_keys
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"stable_diffusion_bot/clock"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/repositories"
+)
+
+const insertAPIKeyQuery string = `
+INSERT INTO api_keys (name, key_hash, created_at) VALUES (?, ?, ?);
+`
+
+const getAPIKeyByKeyHash string = `
+SELECT id, name, key_hash, created_at FROM api_keys WHERE key_hash = ?;
+`
+
+const deleteAPIKeyByName string = `
+DELETE FROM api_keys WHERE name = ?;
+`
+
+type sqliteRepo struct {
+	dbConn *sql.DB
+	clock  clock.Clock
+}
+
+type Config struct {
+	DB *sql.DB
+}
+
+func NewRepository(cfg *Config) (Repository, error) {
+	if cfg.DB == nil {
+		return nil, errors.New("missing DB parameter")
+	}
+
+	newRepo := &sqliteRepo{
+		dbConn: cfg.DB,
+		clock:  clock.NewClock(),
+	}
+
+	return newRepo, nil
+}
+
+func (repo *sqliteRepo) Create(ctx context.Context, key *entities.APIKey) (*entities.APIKey, error) {
+	key.CreatedAt = repo.clock.Now()
+
+	res, err := repo.dbConn.ExecContext(ctx, insertAPIKeyQuery, key.Name, key.KeyHash, key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = lastID
+
+	return key, nil
+}
+
+func (repo *sqliteRepo) GetByKeyHash(ctx context.Context, keyHash string) (*entities.APIKey, error) {
+	var key entities.APIKey
+
+	err := repo.dbConn.QueryRowContext(ctx, getAPIKeyByKeyHash, keyHash).Scan(
+		&key.ID, &key.Name, &key.KeyHash, &key.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositories.NewNotFoundError("api key")
+		}
+
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// DeleteByName removes the key with the name, and reports whether there was one.
+func (repo *sqliteRepo) DeleteByName(ctx context.Context, name string) (bool, error) {
+	res, err := repo.dbConn.ExecContext(ctx, deleteAPIKeyByName, name)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return deleted > 0, nil
+}
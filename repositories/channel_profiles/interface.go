@@ -0,0 +1,13 @@
+package channel_profiles
+
+import (
+	"context"
+	"stable_diffusion_bot/entities"
+)
+
+type Repository interface {
+	Upsert(ctx context.Context, profile *entities.ChannelProfile) (*entities.ChannelProfile, error)
+	GetByChannelID(ctx context.Context, channelID string) (*entities.ChannelProfile, error)
+	ListByGuildID(ctx context.Context, guildID string) ([]*entities.ChannelProfile, error)
+	Delete(ctx context.Context, channelID string) error
+}
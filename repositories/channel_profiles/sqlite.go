@@ -0,0 +1,125 @@
+package channel_profiles
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/repositories"
+	"strings"
+)
+
+const upsertProfile string = `
+INSERT OR REPLACE INTO channel_profiles (channel_id, guild_id, model, negative_prompt, allowed_samplers, max_width, max_height, max_batch)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+`
+
+const getProfileByChannelID string = `
+SELECT channel_id, guild_id, model, negative_prompt, allowed_samplers, max_width, max_height, max_batch
+FROM channel_profiles WHERE channel_id = ?;
+`
+
+const listProfilesByGuildID string = `
+SELECT channel_id, guild_id, model, negative_prompt, allowed_samplers, max_width, max_height, max_batch
+FROM channel_profiles WHERE guild_id = ? ORDER BY channel_id;
+`
+
+const deleteProfileByChannelID string = `
+DELETE FROM channel_profiles WHERE channel_id = ?;
+`
+
+// samplerSeparator joins the allowed samplers in a single column, sampler names don't contain commas
+const samplerSeparator = ","
+
+type sqliteRepo struct {
+	dbConn *sql.DB
+}
+
+type Config struct {
+	DB *sql.DB
+}
+
+func NewRepository(cfg *Config) (Repository, error) {
+	if cfg.DB == nil {
+		return nil, errors.New("missing DB parameter")
+	}
+
+	newRepo := &sqliteRepo{
+		dbConn: cfg.DB,
+	}
+
+	return newRepo, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanProfile(row rowScanner) (*entities.ChannelProfile, error) {
+	var profile entities.ChannelProfile
+	var allowedSamplers string
+
+	err := row.Scan(&profile.ChannelID, &profile.GuildID, &profile.Model, &profile.NegativePrompt, &allowedSamplers,
+		&profile.MaxWidth, &profile.MaxHeight, &profile.MaxBatch)
+	if err != nil {
+		return nil, err
+	}
+
+	if allowedSamplers != "" {
+		profile.AllowedSamplers = strings.Split(allowedSamplers, samplerSeparator)
+	}
+
+	return &profile, nil
+}
+
+func (repo *sqliteRepo) Upsert(ctx context.Context, profile *entities.ChannelProfile) (*entities.ChannelProfile, error) {
+	_, err := repo.dbConn.ExecContext(ctx, upsertProfile,
+		profile.ChannelID, profile.GuildID, profile.Model, profile.NegativePrompt,
+		strings.Join(profile.AllowedSamplers, samplerSeparator), profile.MaxWidth, profile.MaxHeight, profile.MaxBatch)
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+func (repo *sqliteRepo) GetByChannelID(ctx context.Context, channelID string) (*entities.ChannelProfile, error) {
+	profile, err := scanProfile(repo.dbConn.QueryRowContext(ctx, getProfileByChannelID, channelID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repositories.NewNotFoundError(fmt.Sprintf("channel profile for channel ID %s", channelID))
+		}
+
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+func (repo *sqliteRepo) ListByGuildID(ctx context.Context, guildID string) ([]*entities.ChannelProfile, error) {
+	rows, err := repo.dbConn.QueryContext(ctx, listProfilesByGuildID, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []*entities.ChannelProfile
+
+	for rows.Next() {
+		profile, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, rows.Err()
+}
+
+func (repo *sqliteRepo) Delete(ctx context.Context, channelID string) error {
+	_, err := repo.dbConn.ExecContext(ctx, deleteProfileByChannelID, channelID)
+
+	return err
+}
@@ -11,11 +11,19 @@ import (
 )
 
 const upsertSetting string = `
-INSERT OR REPLACE INTO default_settings (member_id, width, height, batch_count, batch_size) VALUES (?, ?, ?, ?, ?);
+INSERT OR REPLACE INTO default_settings (member_id, width, height, batch_count, batch_size, upscale_method, upscaler, upscale_factor,
+    subtle_variation_strength, strong_variation_strength, negative_prompt, sampler_name, steps, cfg_scale, enable_hr, restore_faces)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
 `
 
 const getSettingByMemberID string = `
-SELECT member_id, width, height, batch_count, batch_size FROM default_settings WHERE member_id = ?;
+SELECT member_id, width, height, batch_count, batch_size, upscale_method, upscaler, upscale_factor,
+    subtle_variation_strength, strong_variation_strength, negative_prompt, sampler_name, steps, cfg_scale, enable_hr, restore_faces
+FROM default_settings WHERE member_id = ?;
+`
+
+const deleteSettingByMemberID string = `
+DELETE FROM default_settings WHERE member_id = ?;
 `
 
 type sqliteRepo struct {
@@ -42,7 +50,10 @@ func NewRepository(cfg *Config) (Repository, error) {
 
 func (repo *sqliteRepo) Upsert(ctx context.Context, setting *entities.DefaultSettings) (*entities.DefaultSettings, error) {
 	_, err := repo.dbConn.ExecContext(ctx, upsertSetting,
-		setting.MemberID, setting.Width, setting.Height, setting.BatchCount, setting.BatchSize)
+		setting.MemberID, setting.Width, setting.Height, setting.BatchCount, setting.BatchSize,
+		setting.UpscaleMethod, setting.Upscaler, setting.UpscaleFactor,
+		setting.SubtleVariationStrength, setting.StrongVariationStrength, setting.NegativePrompt, setting.SamplerName,
+		setting.Steps, setting.CfgScale, setting.EnableHR, setting.RestoreFaces)
 	if err != nil {
 		return nil, err
 	}
@@ -52,9 +63,13 @@ func (repo *sqliteRepo) Upsert(ctx context.Context, setting *entities.DefaultSet
 
 func (repo *sqliteRepo) GetByMemberID(ctx context.Context, memberID string) (*entities.DefaultSettings, error) {
 	var setting entities.DefaultSettings
+	var enableHR, restoreFaces sql.NullBool
 
 	err := repo.dbConn.QueryRowContext(ctx, getSettingByMemberID, memberID).Scan(
-		&setting.MemberID, &setting.Width, &setting.Height, &setting.BatchCount, &setting.BatchSize)
+		&setting.MemberID, &setting.Width, &setting.Height, &setting.BatchCount, &setting.BatchSize,
+		&setting.UpscaleMethod, &setting.Upscaler, &setting.UpscaleFactor,
+		&setting.SubtleVariationStrength, &setting.StrongVariationStrength, &setting.NegativePrompt, &setting.SamplerName,
+		&setting.Steps, &setting.CfgScale, &enableHR, &restoreFaces)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, repositories.NewNotFoundError(fmt.Sprintf("default setting for member ID %s", memberID))
@@ -63,5 +78,19 @@ func (repo *sqliteRepo) GetByMemberID(ctx context.Context, memberID string) (*en
 		return nil, err
 	}
 
+	if enableHR.Valid {
+		setting.EnableHR = &enableHR.Bool
+	}
+
+	if restoreFaces.Valid {
+		setting.RestoreFaces = &restoreFaces.Bool
+	}
+
 	return &setting, nil
 }
+
+func (repo *sqliteRepo) Delete(ctx context.Context, memberID string) error {
+	_, err := repo.dbConn.ExecContext(ctx, deleteSettingByMemberID, memberID)
+
+	return err
+}
@@ -0,0 +1,24 @@
+package favorites
+
+import (
+	"context"
+	"stable_diffusion_bot/entities"
+)
+
+type Repository interface {
+	// Add stores the favorite, and reports whether the member hadn't favorited the message yet.
+	Add(ctx context.Context, favorite *entities.Favorite) (bool, error)
+	// Remove deletes the favorite of the member, and reports whether there was one.
+	Remove(ctx context.Context, messageID, memberID string) (bool, error)
+	CountByMessage(ctx context.Context, messageID string) (int, error)
+	// ListByMember returns the favorites of the member, the latest first.
+	ListByMember(ctx context.Context, memberID string, limit int) ([]*entities.Favorite, error)
+	// GetGallerySettings returns the gallery of the server, without a channel when the server has none.
+	GetGallerySettings(ctx context.Context, guildID string) (*entities.GallerySettings, error)
+	SetGallerySettings(ctx context.Context, settings *entities.GallerySettings) error
+	// ClaimGalleryPost marks the message as reposted to the gallery, and reports whether it wasn't already.
+	ClaimGalleryPost(ctx context.Context, messageID, guildID string) (bool, error)
+	SetGalleryMessage(ctx context.Context, messageID, galleryMessageID string) error
+	// ReleaseGalleryPost forgets about a repost that didn't happen, so the message can be reposted later.
+	ReleaseGalleryPost(ctx context.Context, messageID string) error
+}
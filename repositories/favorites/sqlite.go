@@ -0,0 +1,168 @@
+package favorites
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"stable_diffusion_bot/clock"
+	"stable_diffusion_bot/entities"
+)
+
+const insertFavoriteQuery string = `
+INSERT INTO favorites (message_id, guild_id, channel_id, member_id, created_at) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(message_id, member_id) DO NOTHING;
+`
+
+const deleteFavoriteQuery string = `
+DELETE FROM favorites WHERE message_id = ? AND member_id = ?;
+`
+
+const countFavoritesByMessageID string = `
+SELECT COUNT(*) FROM favorites WHERE message_id = ?;
+`
+
+const listFavoritesByMemberID string = `
+SELECT id, message_id, guild_id, channel_id, member_id, created_at
+FROM favorites WHERE member_id = ? ORDER BY id DESC LIMIT ?;
+`
+
+const getGallerySettingsQuery string = `
+SELECT guild_id, channel_id, threshold FROM gallery_settings WHERE guild_id = ?;
+`
+
+const upsertGallerySettingsQuery string = `
+INSERT INTO gallery_settings (guild_id, channel_id, threshold) VALUES (?, ?, ?)
+ON CONFLICT(guild_id) DO UPDATE SET channel_id = excluded.channel_id, threshold = excluded.threshold;
+`
+
+const insertGalleryPostQuery string = `
+INSERT INTO gallery_posts (message_id, guild_id, gallery_message_id, created_at) VALUES (?, ?, '', ?)
+ON CONFLICT(message_id) DO NOTHING;
+`
+
+const setGalleryMessageQuery string = `
+UPDATE gallery_posts SET gallery_message_id = ? WHERE message_id = ?;
+`
+
+const deleteGalleryPostQuery string = `
+DELETE FROM gallery_posts WHERE message_id = ?;
+`
+
+type sqliteRepo struct {
+	dbConn *sql.DB
+	clock  clock.Clock
+}
+
+type Config struct {
+	DB *sql.DB
+}
+
+func NewRepository(cfg *Config) (Repository, error) {
+	if cfg.DB == nil {
+		return nil, errors.New("missing DB parameter")
+	}
+
+	newRepo := &sqliteRepo{
+		dbConn: cfg.DB,
+		clock:  clock.NewClock(),
+	}
+
+	return newRepo, nil
+}
+
+// execAffected runs the statement and reports whether it changed a row.
+func (repo *sqliteRepo) execAffected(ctx context.Context, query string, args ...any) (bool, error) {
+	res, err := repo.dbConn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (repo *sqliteRepo) Add(ctx context.Context, favorite *entities.Favorite) (bool, error) {
+	favorite.CreatedAt = repo.clock.Now()
+
+	return repo.execAffected(ctx, insertFavoriteQuery, favorite.MessageID, favorite.GuildID, favorite.ChannelID,
+		favorite.MemberID, favorite.CreatedAt)
+}
+
+func (repo *sqliteRepo) Remove(ctx context.Context, messageID, memberID string) (bool, error) {
+	return repo.execAffected(ctx, deleteFavoriteQuery, messageID, memberID)
+}
+
+func (repo *sqliteRepo) CountByMessage(ctx context.Context, messageID string) (int, error) {
+	var count int
+
+	err := repo.dbConn.QueryRowContext(ctx, countFavoritesByMessageID, messageID).Scan(&count)
+
+	return count, err
+}
+
+func (repo *sqliteRepo) ListByMember(ctx context.Context, memberID string, limit int) ([]*entities.Favorite, error) {
+	rows, err := repo.dbConn.QueryContext(ctx, listFavoritesByMemberID, memberID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var favorites []*entities.Favorite
+
+	for rows.Next() {
+		var favorite entities.Favorite
+
+		err := rows.Scan(&favorite.ID, &favorite.MessageID, &favorite.GuildID, &favorite.ChannelID, &favorite.MemberID,
+			&favorite.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		favorites = append(favorites, &favorite)
+	}
+
+	return favorites, rows.Err()
+}
+
+func (repo *sqliteRepo) GetGallerySettings(ctx context.Context, guildID string) (*entities.GallerySettings, error) {
+	var settings entities.GallerySettings
+
+	err := repo.dbConn.QueryRowContext(ctx, getGallerySettingsQuery, guildID).Scan(&settings.GuildID,
+		&settings.ChannelID, &settings.Threshold)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &entities.GallerySettings{GuildID: guildID}, nil
+		}
+
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+func (repo *sqliteRepo) SetGallerySettings(ctx context.Context, settings *entities.GallerySettings) error {
+	_, err := repo.dbConn.ExecContext(ctx, upsertGallerySettingsQuery, settings.GuildID, settings.ChannelID,
+		settings.Threshold)
+
+	return err
+}
+
+func (repo *sqliteRepo) ClaimGalleryPost(ctx context.Context, messageID, guildID string) (bool, error) {
+	return repo.execAffected(ctx, insertGalleryPostQuery, messageID, guildID, repo.clock.Now())
+}
+
+func (repo *sqliteRepo) SetGalleryMessage(ctx context.Context, messageID, galleryMessageID string) error {
+	_, err := repo.dbConn.ExecContext(ctx, setGalleryMessageQuery, galleryMessageID, messageID)
+
+	return err
+}
+
+func (repo *sqliteRepo) ReleaseGalleryPost(ctx context.Context, messageID string) error {
+	_, err := repo.dbConn.ExecContext(ctx, deleteGalleryPostQuery, messageID)
+
+	return err
+}
@@ -3,10 +3,40 @@ package image_generations
 import (
 	"context"
 	"stable_diffusion_bot/entities"
+	"time"
 )
 
+// ListOptions filters and pages the generations that are listed.
+type ListOptions struct {
+	// Since and Until limit when the generations were made, a zero time is no limit. Until is exclusive.
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int
+}
+
+// SearchFilters narrow down a search, empty fields don't filter.
+type SearchFilters struct {
+	GuildID  string
+	MemberID string
+	// Model matches part of the checkpoint title, generations that used the default checkpoint don't name it
+	Model string
+	// Since and Until limit when the generations were made, Until is exclusive
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int
+}
+
 type Repository interface {
 	Create(ctx context.Context, generation *entities.ImageGeneration) (*entities.ImageGeneration, error)
 	GetByMessage(ctx context.Context, messageID string) (*entities.ImageGeneration, error)
 	GetByMessageAndSort(ctx context.Context, messageID string, sortOrder int) (*entities.ImageGeneration, error)
+	ListByMessage(ctx context.Context, messageID string) ([]*entities.ImageGeneration, error)
+	ListByMember(ctx context.Context, memberID string, options ListOptions) ([]*entities.ImageGeneration, error)
+	Search(ctx context.Context, query string, filters SearchFilters) ([]*entities.ImageGeneration, error)
+	ClearImagePath(ctx context.Context, imagePath string) error
+	SetRouting(ctx context.Context, messageID, routing, routedChannelID string) error
+	MarkDeleted(ctx context.Context, messageID string) error
+	CountByImagePath(ctx context.Context, imagePath string) (int, error)
 }
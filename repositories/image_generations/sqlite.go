@@ -4,20 +4,55 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"stable_diffusion_bot/clock"
 	"stable_diffusion_bot/entities"
+	"strings"
+	"time"
 )
 
 const insertGenerationQuery string = `
-INSERT INTO image_generations (interaction_id, message_id, member_id, sort_order, prompt, negative_prompt, width, height, restore_faces, enable_hr, hires_width, hires_height, denoising_strength, batch_count, batch_size, seed, subseed, subseed_strength, sampler_name, cfg_scale, steps, processed, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+INSERT INTO image_generations (interaction_id, message_id, member_id, guild_id, channel_id, sort_order, prompt, negative_prompt, width, height, restore_faces, enable_hr, hires_width, hires_height, denoising_strength, batch_count, batch_size, seed, subseed, subseed_strength, sampler_name, cfg_scale, steps, checkpoint, style, prompt_template, parent_id, variation_strength, image_path, image_hash, routing, routed_channel_id, infotext, processed, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
 `
 
 const getGenerationByMessageID string = `
-SELECT id, interaction_id, message_id, member_id, sort_order, prompt, negative_prompt, width, height, restore_faces, enable_hr, hires_width, hires_height, denoising_strength, batch_count, batch_size, seed, subseed, subseed_strength, sampler_name, cfg_scale, steps, processed, created_at FROM image_generations WHERE message_id = ?;
+SELECT id, interaction_id, message_id, member_id, guild_id, channel_id, sort_order, prompt, negative_prompt, width, height, restore_faces, enable_hr, hires_width, hires_height, denoising_strength, batch_count, batch_size, seed, subseed, subseed_strength, sampler_name, cfg_scale, steps, checkpoint, style, prompt_template, parent_id, variation_strength, image_path, image_hash, routing, routed_channel_id, infotext, deleted, processed, created_at FROM image_generations WHERE message_id = ?;
 `
 
 const getGenerationByMessageIDAndSortOrder string = `
-SELECT id, interaction_id, message_id, member_id, sort_order, prompt, negative_prompt, width, height, restore_faces, enable_hr, hires_width, hires_height, denoising_strength, batch_count, batch_size, seed, subseed, subseed_strength, sampler_name, cfg_scale, steps, processed, created_at FROM image_generations WHERE message_id = ? AND sort_order = ?;
+SELECT id, interaction_id, message_id, member_id, guild_id, channel_id, sort_order, prompt, negative_prompt, width, height, restore_faces, enable_hr, hires_width, hires_height, denoising_strength, batch_count, batch_size, seed, subseed, subseed_strength, sampler_name, cfg_scale, steps, checkpoint, style, prompt_template, parent_id, variation_strength, image_path, image_hash, routing, routed_channel_id, infotext, deleted, processed, created_at FROM image_generations WHERE message_id = ? AND sort_order = ?;
+`
+
+const listGenerationsByMessageID string = `
+SELECT id, interaction_id, message_id, member_id, guild_id, channel_id, sort_order, prompt, negative_prompt, width, height, restore_faces, enable_hr, hires_width, hires_height, denoising_strength, batch_count, batch_size, seed, subseed, subseed_strength, sampler_name, cfg_scale, steps, checkpoint, style, prompt_template, parent_id, variation_strength, image_path, image_hash, routing, routed_channel_id, infotext, deleted, processed, created_at FROM image_generations WHERE message_id = ? ORDER BY sort_order;
+`
+
+const listGenerationsByMemberID string = `
+SELECT id, interaction_id, message_id, member_id, guild_id, channel_id, sort_order, prompt, negative_prompt, width, height, restore_faces, enable_hr, hires_width, hires_height, denoising_strength, batch_count, batch_size, seed, subseed, subseed_strength, sampler_name, cfg_scale, steps, checkpoint, style, prompt_template, parent_id, variation_strength, image_path, image_hash, routing, routed_channel_id, infotext, deleted, processed, created_at FROM image_generations WHERE member_id = ? AND sort_order > 0 AND deleted = 0`
+
+// searchGenerations ranks the prompt above the negative prompt, matching only the negative prompt is a weak hint
+const searchGenerations string = `
+SELECT g.id, g.interaction_id, g.message_id, g.member_id, g.guild_id, g.channel_id, g.sort_order, g.prompt, g.negative_prompt, g.width, g.height, g.restore_faces, g.enable_hr, g.hires_width, g.hires_height, g.denoising_strength, g.batch_count, g.batch_size, g.seed, g.subseed, g.subseed_strength, g.sampler_name, g.cfg_scale, g.steps, g.checkpoint, g.style, g.prompt_template, g.parent_id, g.variation_strength, g.image_path, g.image_hash, g.routing, g.routed_channel_id, g.infotext, g.deleted, g.processed, g.created_at
+FROM image_generations_fts JOIN image_generations g ON g.id = image_generations_fts.rowid
+WHERE image_generations_fts MATCH ? AND g.sort_order > 0 AND g.deleted = 0`
+
+// createdAtLayout is how far created_at is compared in date filters, it is stored with time.Time.String
+const createdAtLayout = "2006-01-02 15:04:05"
+
+const clearGenerationImagePath string = `
+UPDATE image_generations SET image_path = '', image_hash = '' WHERE image_path = ?;
+`
+
+const setGenerationRouting string = `
+UPDATE image_generations SET routing = ?, routed_channel_id = ? WHERE message_id = ?;
+`
+
+const markGenerationsDeleted string = `
+UPDATE image_generations SET deleted = 1 WHERE message_id = ?;
+`
+
+const countGenerationsByImagePath string = `
+SELECT COUNT(*) FROM image_generations WHERE image_path = ? AND deleted = 0;
 `
 
 type sqliteRepo struct {
@@ -46,11 +81,11 @@ func (repo *sqliteRepo) Create(ctx context.Context, generation *entities.ImageGe
 	generation.CreatedAt = repo.clock.Now()
 
 	res, err := repo.dbConn.ExecContext(ctx, insertGenerationQuery,
-		generation.InteractionID, generation.MessageID, generation.MemberID, generation.SortOrder, generation.Prompt,
+		generation.InteractionID, generation.MessageID, generation.MemberID, generation.GuildID, generation.ChannelID, generation.SortOrder, generation.Prompt,
 		generation.NegativePrompt, generation.Width, generation.Height, generation.RestoreFaces,
 		generation.EnableHR, generation.HiresWidth, generation.HiresHeight, generation.DenoisingStrength,
 		generation.BatchCount, generation.BatchSize, generation.Seed, generation.Subseed,
-		generation.SubseedStrength, generation.SamplerName, generation.CfgScale, generation.Steps, generation.Processed, generation.CreatedAt)
+		generation.SubseedStrength, generation.SamplerName, generation.CfgScale, generation.Steps, generation.Checkpoint, generation.Style, generation.PromptTemplate, generation.ParentID, generation.VariationStrength, generation.ImagePath, generation.ImageHash, generation.Routing, generation.RoutedChannelID, generation.Infotext, generation.Processed, generation.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -66,33 +101,155 @@ func (repo *sqliteRepo) Create(ctx context.Context, generation *entities.ImageGe
 }
 
 func (repo *sqliteRepo) GetByMessage(ctx context.Context, messageID string) (*entities.ImageGeneration, error) {
-	var generation entities.ImageGeneration
+	return scanGeneration(repo.dbConn.QueryRowContext(ctx, getGenerationByMessageID, messageID))
+}
 
-	err := repo.dbConn.QueryRowContext(ctx, getGenerationByMessageID, messageID).Scan(
-		&generation.ID, &generation.InteractionID, &generation.MessageID, &generation.MemberID, &generation.SortOrder, &generation.Prompt,
-		&generation.NegativePrompt, &generation.Width, &generation.Height, &generation.RestoreFaces,
-		&generation.EnableHR, &generation.HiresWidth, &generation.HiresHeight, &generation.DenoisingStrength,
-		&generation.BatchCount, &generation.BatchSize, &generation.Seed, &generation.Subseed,
-		&generation.SubseedStrength, &generation.SamplerName, &generation.CfgScale, &generation.Steps, &generation.Processed, &generation.CreatedAt)
+func (repo *sqliteRepo) GetByMessageAndSort(ctx context.Context, messageID string, sortOrder int) (*entities.ImageGeneration, error) {
+	return scanGeneration(repo.dbConn.QueryRowContext(ctx, getGenerationByMessageIDAndSortOrder, messageID, sortOrder))
+}
+
+// ListByMessage returns all generations of the message, ordered by sort order.
+func (repo *sqliteRepo) ListByMessage(ctx context.Context, messageID string) ([]*entities.ImageGeneration, error) {
+	return repo.queryGenerations(ctx, listGenerationsByMessageID, messageID)
+}
+
+// ListByMember returns the images the member generated, the latest first. The base rows of grids aren't listed, only
+// the images in them.
+func (repo *sqliteRepo) ListByMember(ctx context.Context, memberID string, options ListOptions) ([]*entities.ImageGeneration, error) {
+	query, args := withDateFilter(listGenerationsByMemberID, []any{memberID}, "created_at", options.Since, options.Until)
+
+	query += ` ORDER BY id DESC LIMIT ? OFFSET ?;`
+	args = append(args, options.Limit, options.Offset)
+
+	return repo.queryGenerations(ctx, query, args...)
+}
+
+// withDateFilter adds the conditions for a date range to the query, zero times don't limit it.
+func withDateFilter(query string, args []any, column string, since, until time.Time) (string, []any) {
+	if !since.IsZero() {
+		query += fmt.Sprintf(` AND substr(%s, 1, 19) >= ?`, column)
+		args = append(args, since.In(time.Local).Format(createdAtLayout))
+	}
+
+	if !until.IsZero() {
+		query += fmt.Sprintf(` AND substr(%s, 1, 19) < ?`, column)
+		args = append(args, until.In(time.Local).Format(createdAtLayout))
+	}
+
+	return query, args
+}
+
+// matchQuery quotes every word of the search, so they are all looked for and nothing is read as FTS5 syntax.
+func matchQuery(search string) string {
+	words := strings.Fields(search)
+
+	for idx, word := range words {
+		words[idx] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+
+	return strings.Join(words, " ")
+}
+
+// Search finds the images whose prompts contain all words of the query, the best matches first.
+func (repo *sqliteRepo) Search(ctx context.Context, search string, filters SearchFilters) ([]*entities.ImageGeneration, error) {
+	match := matchQuery(search)
+	if match == "" {
+		return nil, nil
+	}
+
+	query := searchGenerations
+	args := []any{match}
+
+	if filters.GuildID != "" {
+		query += ` AND g.guild_id = ?`
+		args = append(args, filters.GuildID)
+	}
+
+	if filters.MemberID != "" {
+		query += ` AND g.member_id = ?`
+		args = append(args, filters.MemberID)
+	}
+
+	if filters.Model != "" {
+		query += ` AND instr(lower(g.checkpoint), lower(?)) > 0`
+		args = append(args, filters.Model)
+	}
+
+	query, args = withDateFilter(query, args, "g.created_at", filters.Since, filters.Until)
+
+	query += ` ORDER BY bm25(image_generations_fts, 1.0, 0.2), g.id DESC LIMIT ? OFFSET ?;`
+	args = append(args, filters.Limit, filters.Offset)
+
+	return repo.queryGenerations(ctx, query, args...)
+}
+
+func (repo *sqliteRepo) queryGenerations(ctx context.Context, query string, args ...any) ([]*entities.ImageGeneration, error) {
+	rows, err := repo.dbConn.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &generation, nil
+	var generations []*entities.ImageGeneration
+
+	for rows.Next() {
+		generation, scanErr := scanGeneration(rows)
+		if scanErr != nil {
+			return nil, scanErr
+		}
+
+		generations = append(generations, generation)
+	}
+
+	return generations, rows.Err()
 }
 
-func (repo *sqliteRepo) GetByMessageAndSort(ctx context.Context, messageID string, sortOrder int) (*entities.ImageGeneration, error) {
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanGeneration(row rowScanner) (*entities.ImageGeneration, error) {
 	var generation entities.ImageGeneration
 
-	err := repo.dbConn.QueryRowContext(ctx, getGenerationByMessageIDAndSortOrder, messageID, sortOrder).Scan(
-		&generation.ID, &generation.InteractionID, &generation.MessageID, &generation.MemberID, &generation.SortOrder, &generation.Prompt,
+	err := row.Scan(
+		&generation.ID, &generation.InteractionID, &generation.MessageID, &generation.MemberID, &generation.GuildID, &generation.ChannelID, &generation.SortOrder, &generation.Prompt,
 		&generation.NegativePrompt, &generation.Width, &generation.Height, &generation.RestoreFaces,
 		&generation.EnableHR, &generation.HiresWidth, &generation.HiresHeight, &generation.DenoisingStrength,
 		&generation.BatchCount, &generation.BatchSize, &generation.Seed, &generation.Subseed,
-		&generation.SubseedStrength, &generation.SamplerName, &generation.CfgScale, &generation.Steps, &generation.Processed, &generation.CreatedAt)
+		&generation.SubseedStrength, &generation.SamplerName, &generation.CfgScale, &generation.Steps, &generation.Checkpoint, &generation.Style, &generation.PromptTemplate, &generation.ParentID, &generation.VariationStrength, &generation.ImagePath, &generation.ImageHash, &generation.Routing, &generation.RoutedChannelID, &generation.Infotext, &generation.Deleted, &generation.Processed, &generation.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 
 	return &generation, nil
 }
+
+// ClearImagePath forgets the archived image on all generations that share it, once it was removed from the store.
+func (repo *sqliteRepo) ClearImagePath(ctx context.Context, imagePath string) error {
+	_, err := repo.dbConn.ExecContext(ctx, clearGenerationImagePath, imagePath)
+
+	return err
+}
+
+// SetRouting records how the results of the message were posted, on all its generations.
+func (repo *sqliteRepo) SetRouting(ctx context.Context, messageID, routing, routedChannelID string) error {
+	_, err := repo.dbConn.ExecContext(ctx, setGenerationRouting, routing, routedChannelID, messageID)
+
+	return err
+}
+
+// MarkDeleted records that the message was deleted, on all its generations. They aren't listed or searched anymore.
+func (repo *sqliteRepo) MarkDeleted(ctx context.Context, messageID string) error {
+	_, err := repo.dbConn.ExecContext(ctx, markGenerationsDeleted, messageID)
+
+	return err
+}
+
+// CountByImagePath counts the generations that weren't deleted and share the archived image.
+func (repo *sqliteRepo) CountByImagePath(ctx context.Context, imagePath string) (int, error) {
+	var count int
+
+	err := repo.dbConn.QueryRowContext(ctx, countGenerationsByImagePath, imagePath).Scan(&count)
+
+	return count, err
+}
@@ -0,0 +1,12 @@
+package moderation_decisions
+
+import (
+	"context"
+	"stable_diffusion_bot/entities"
+)
+
+type Repository interface {
+	Create(ctx context.Context, decision *entities.ModerationDecision) (*entities.ModerationDecision, error)
+	// ListByGuildID returns the latest decisions of the server first.
+	ListByGuildID(ctx context.Context, guildID string, limit int) ([]*entities.ModerationDecision, error)
+}
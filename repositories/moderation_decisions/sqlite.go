@@ -0,0 +1,87 @@
+package moderation_decisions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"stable_diffusion_bot/clock"
+	"stable_diffusion_bot/entities"
+)
+
+const insertDecisionQuery string = `
+INSERT INTO moderation_decisions (guild_id, channel_id, member_id, prompt, rule_id, pattern, decision, moderator_id,
+held_id, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+`
+
+const listDecisionsByGuildID string = `
+SELECT id, guild_id, channel_id, member_id, prompt, rule_id, pattern, decision, moderator_id, held_id, created_at
+FROM moderation_decisions WHERE guild_id = ? ORDER BY created_at DESC, id DESC LIMIT ?;
+`
+
+type sqliteRepo struct {
+	dbConn *sql.DB
+	clock  clock.Clock
+}
+
+type Config struct {
+	DB *sql.DB
+}
+
+func NewRepository(cfg *Config) (Repository, error) {
+	if cfg.DB == nil {
+		return nil, errors.New("missing DB parameter")
+	}
+
+	newRepo := &sqliteRepo{
+		dbConn: cfg.DB,
+		clock:  clock.NewClock(),
+	}
+
+	return newRepo, nil
+}
+
+func (repo *sqliteRepo) Create(ctx context.Context, decision *entities.ModerationDecision) (*entities.ModerationDecision, error) {
+	decision.CreatedAt = repo.clock.Now()
+
+	res, err := repo.dbConn.ExecContext(ctx, insertDecisionQuery, decision.GuildID, decision.ChannelID,
+		decision.MemberID, decision.Prompt, decision.RuleID, decision.Pattern, decision.Decision, decision.ModeratorID,
+		decision.HeldID, decision.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	decision.ID = lastID
+
+	return decision, nil
+}
+
+func (repo *sqliteRepo) ListByGuildID(ctx context.Context, guildID string, limit int) ([]*entities.ModerationDecision, error) {
+	rows, err := repo.dbConn.QueryContext(ctx, listDecisionsByGuildID, guildID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var decisions []*entities.ModerationDecision
+
+	for rows.Next() {
+		var decision entities.ModerationDecision
+
+		err := rows.Scan(&decision.ID, &decision.GuildID, &decision.ChannelID, &decision.MemberID, &decision.Prompt,
+			&decision.RuleID, &decision.Pattern, &decision.Decision, &decision.ModeratorID, &decision.HeldID,
+			&decision.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		decisions = append(decisions, &decision)
+	}
+
+	return decisions, rows.Err()
+}
@@ -0,0 +1,19 @@
+package moderation_rules
+
+import (
+	"context"
+	"stable_diffusion_bot/entities"
+)
+
+type Repository interface {
+	Create(ctx context.Context, rule *entities.ModerationRule) (*entities.ModerationRule, error)
+	ListByGuildID(ctx context.Context, guildID string) ([]*entities.ModerationRule, error)
+	// Delete removes the rule of the server, and reports whether there was one.
+	Delete(ctx context.Context, guildID string, id int64) (bool, error)
+	// GetReviewChannel returns the channel held prompts are posted to, empty when the server has none.
+	GetReviewChannel(ctx context.Context, guildID string) (string, error)
+	SetReviewChannel(ctx context.Context, guildID, channelID string) error
+	// GetNSFWChannel returns the channel sensitive results are posted to, empty when they are spoilered instead.
+	GetNSFWChannel(ctx context.Context, guildID string) (string, error)
+	SetNSFWChannel(ctx context.Context, guildID, channelID string) error
+}
@@ -0,0 +1,156 @@
+package moderation_rules
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"stable_diffusion_bot/clock"
+	"stable_diffusion_bot/entities"
+)
+
+const insertRuleQuery string = `
+INSERT INTO moderation_rules (guild_id, channel_id, list, match_type, pattern, action, created_by, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+`
+
+const listRulesByGuildID string = `
+SELECT id, guild_id, channel_id, list, match_type, pattern, action, created_by, created_at
+FROM moderation_rules WHERE guild_id = ? ORDER BY id;
+`
+
+const deleteRuleQuery string = `
+DELETE FROM moderation_rules WHERE guild_id = ? AND id = ?;
+`
+
+const getReviewChannelQuery string = `
+SELECT review_channel_id FROM moderation_settings WHERE guild_id = ?;
+`
+
+const upsertReviewChannelQuery string = `
+INSERT INTO moderation_settings (guild_id, review_channel_id) VALUES (?, ?)
+ON CONFLICT(guild_id) DO UPDATE SET review_channel_id = excluded.review_channel_id;
+`
+
+const getNSFWChannelQuery string = `
+SELECT nsfw_channel_id FROM moderation_settings WHERE guild_id = ?;
+`
+
+const upsertNSFWChannelQuery string = `
+INSERT INTO moderation_settings (guild_id, review_channel_id, nsfw_channel_id) VALUES (?, '', ?)
+ON CONFLICT(guild_id) DO UPDATE SET nsfw_channel_id = excluded.nsfw_channel_id;
+`
+
+type sqliteRepo struct {
+	dbConn *sql.DB
+	clock  clock.Clock
+}
+
+type Config struct {
+	DB *sql.DB
+}
+
+func NewRepository(cfg *Config) (Repository, error) {
+	if cfg.DB == nil {
+		return nil, errors.New("missing DB parameter")
+	}
+
+	newRepo := &sqliteRepo{
+		dbConn: cfg.DB,
+		clock:  clock.NewClock(),
+	}
+
+	return newRepo, nil
+}
+
+func (repo *sqliteRepo) Create(ctx context.Context, rule *entities.ModerationRule) (*entities.ModerationRule, error) {
+	rule.CreatedAt = repo.clock.Now()
+
+	res, err := repo.dbConn.ExecContext(ctx, insertRuleQuery, rule.GuildID, rule.ChannelID, rule.List, rule.Match,
+		rule.Pattern, rule.Action, rule.CreatedBy, rule.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	rule.ID = lastID
+
+	return rule, nil
+}
+
+func (repo *sqliteRepo) ListByGuildID(ctx context.Context, guildID string) ([]*entities.ModerationRule, error) {
+	rows, err := repo.dbConn.QueryContext(ctx, listRulesByGuildID, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*entities.ModerationRule
+
+	for rows.Next() {
+		var rule entities.ModerationRule
+
+		err := rows.Scan(&rule.ID, &rule.GuildID, &rule.ChannelID, &rule.List, &rule.Match, &rule.Pattern,
+			&rule.Action, &rule.CreatedBy, &rule.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, &rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (repo *sqliteRepo) Delete(ctx context.Context, guildID string, id int64) (bool, error) {
+	res, err := repo.dbConn.ExecContext(ctx, deleteRuleQuery, guildID, id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// getChannel returns a channel setting of the server, empty when the server has no settings.
+func (repo *sqliteRepo) getChannel(ctx context.Context, query, guildID string) (string, error) {
+	var channelID string
+
+	err := repo.dbConn.QueryRowContext(ctx, query, guildID).Scan(&channelID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return channelID, nil
+}
+
+func (repo *sqliteRepo) GetReviewChannel(ctx context.Context, guildID string) (string, error) {
+	return repo.getChannel(ctx, getReviewChannelQuery, guildID)
+}
+
+func (repo *sqliteRepo) SetReviewChannel(ctx context.Context, guildID, channelID string) error {
+	_, err := repo.dbConn.ExecContext(ctx, upsertReviewChannelQuery, guildID, channelID)
+
+	return err
+}
+
+func (repo *sqliteRepo) GetNSFWChannel(ctx context.Context, guildID string) (string, error) {
+	return repo.getChannel(ctx, getNSFWChannelQuery, guildID)
+}
+
+func (repo *sqliteRepo) SetNSFWChannel(ctx context.Context, guildID, channelID string) error {
+	_, err := repo.dbConn.ExecContext(ctx, upsertNSFWChannelQuery, guildID, channelID)
+
+	return err
+}
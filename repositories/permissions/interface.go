@@ -0,0 +1,15 @@
+package permissions
+
+import (
+	"context"
+	"stable_diffusion_bot/entities"
+)
+
+type Repository interface {
+	// Grant stores the grant, and reports whether the role or user didn't have the capability yet.
+	Grant(ctx context.Context, grant *entities.PermissionGrant) (bool, error)
+	// Revoke deletes the grant, and reports whether there was one.
+	Revoke(ctx context.Context, guildID, capability, subjectType, subjectID string) (bool, error)
+	// ListByGuildID returns the grants of the server, by capability.
+	ListByGuildID(ctx context.Context, guildID string) ([]*entities.PermissionGrant, error)
+}
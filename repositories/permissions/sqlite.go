@@ -0,0 +1,96 @@
+package permissions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"stable_diffusion_bot/clock"
+	"stable_diffusion_bot/entities"
+)
+
+const insertGrantQuery string = `
+INSERT INTO permission_grants (guild_id, capability, subject_type, subject_id, created_by, created_at)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(guild_id, capability, subject_type, subject_id) DO NOTHING;
+`
+
+const deleteGrantQuery string = `
+DELETE FROM permission_grants WHERE guild_id = ? AND capability = ? AND subject_type = ? AND subject_id = ?;
+`
+
+const listGrantsByGuildID string = `
+SELECT id, guild_id, capability, subject_type, subject_id, created_by, created_at
+FROM permission_grants WHERE guild_id = ? ORDER BY capability, id;
+`
+
+type sqliteRepo struct {
+	dbConn *sql.DB
+	clock  clock.Clock
+}
+
+type Config struct {
+	DB *sql.DB
+}
+
+func NewRepository(cfg *Config) (Repository, error) {
+	if cfg.DB == nil {
+		return nil, errors.New("missing DB parameter")
+	}
+
+	newRepo := &sqliteRepo{
+		dbConn: cfg.DB,
+		clock:  clock.NewClock(),
+	}
+
+	return newRepo, nil
+}
+
+// execAffected runs the statement and reports whether it changed a row.
+func (repo *sqliteRepo) execAffected(ctx context.Context, query string, args ...any) (bool, error) {
+	res, err := repo.dbConn.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (repo *sqliteRepo) Grant(ctx context.Context, grant *entities.PermissionGrant) (bool, error) {
+	grant.CreatedAt = repo.clock.Now()
+
+	return repo.execAffected(ctx, insertGrantQuery, grant.GuildID, grant.Capability, grant.SubjectType, grant.SubjectID,
+		grant.CreatedBy, grant.CreatedAt)
+}
+
+func (repo *sqliteRepo) Revoke(ctx context.Context, guildID, capability, subjectType, subjectID string) (bool, error) {
+	return repo.execAffected(ctx, deleteGrantQuery, guildID, capability, subjectType, subjectID)
+}
+
+func (repo *sqliteRepo) ListByGuildID(ctx context.Context, guildID string) ([]*entities.PermissionGrant, error) {
+	rows, err := repo.dbConn.QueryContext(ctx, listGrantsByGuildID, guildID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []*entities.PermissionGrant
+
+	for rows.Next() {
+		var grant entities.PermissionGrant
+
+		err := rows.Scan(&grant.ID, &grant.GuildID, &grant.Capability, &grant.SubjectType, &grant.SubjectID,
+			&grant.CreatedBy, &grant.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		grants = append(grants, &grant)
+	}
+
+	return grants, rows.Err()
+}
@@ -2,9 +2,13 @@ package stable_diffusion_api
 
 type StableDiffusionAPI interface {
 	TextToImage(req *TextToImageRequest) (*TextToImageResponse, error)
+	ImageToImage(req *ImageToImageRequest) (*TextToImageResponse, error)
 	UpscaleImage(upscaleReq *UpscaleRequest) (*UpscaleResponse, error)
+	Interrogate(req *InterrogateRequest) (*InterrogateResponse, error)
 	GetCurrentProgress() (*ProgressResponse, error)
 	GetEmbeddings() (*EmbeddingsResponseMinimal, error)
 	GetModels() ([]string, error)
+	GetUpscalers() ([]string, error)
 	SetSelectedModel(string) error
+	GetOptions() (*OptionsResponse, error)
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -39,9 +40,10 @@ type jsonTextToImageResponse struct {
 }
 
 type jsonInfoResponse struct {
-	Seed        int   `json:"seed"`
-	AllSeeds    []int `json:"all_seeds"`
-	AllSubseeds []int `json:"all_subseeds"`
+	Seed        int      `json:"seed"`
+	AllSeeds    []int    `json:"all_seeds"`
+	AllSubseeds []int    `json:"all_subseeds"`
+	Infotexts   []string `json:"infotexts"`
 }
 
 type TextToImageResponse struct {
@@ -49,6 +51,8 @@ type TextToImageResponse struct {
 	Seeds    []int    `json:"seeds"`
 	Subseeds []int    `json:"subseeds"`
 	Model    string   `json:"model"`
+	// Infotexts are the parameters texts of the images, in the format the web UI writes into the files
+	Infotexts []string `json:"infotexts"`
 }
 
 type Txt2ImgOverrideSettings struct {
@@ -59,6 +63,10 @@ type Txt2ImgOverrideSettings struct {
 	SamplesFormat string `json:"samples_format,omitempty"`
 	// new option since 04/29/2023 https://github.com/AUTOMATIC1111/stable-diffusion-webui/pull/9177
 	NegativeGuidanceMinimumSigma float32 `json:"s_min_uncond,omitempty"`
+	// checkpoint to use for this request only, the previous one is restored afterwards
+	SDModelCheckpoint string `json:"sd_model_checkpoint,omitempty"`
+	// upscaler img2img enlarges the init image with before repainting it, when it is smaller than the output
+	UpscalerForImg2Img string `json:"upscaler_for_img2img,omitempty"`
 
 	// this is in blacklist. See stable-diffusion-webui/modules/shared.py:124:restricted_opts
 	OutdirTxt2ImgSamples string `json:"outdir_txt2img_samples,omitempty"`
@@ -85,6 +93,8 @@ type TextToImageRequest struct {
 	CfgScale          float64 `json:"cfg_scale"`
 	Steps             int     `json:"steps"`
 	NIter             int     `json:"n_iter"`
+	// names of prompt styles saved in the web UI
+	Styles []string `json:"styles,omitempty"`
 
 	// Save sample images AND grid copies to output dir
 	SaveImages       bool                    `json:"save_images"`
@@ -145,33 +155,146 @@ func (api *apiImpl) TextToImage(req *TextToImageRequest) (*TextToImageResponse,
 	}
 
 	return &TextToImageResponse{
-		Images:   respStruct.Images,
-		Seeds:    infoStruct.AllSeeds,
-		Subseeds: infoStruct.AllSubseeds,
-		Model:    extractModel(respStruct.Info),
+		Images:    respStruct.Images,
+		Seeds:     infoStruct.AllSeeds,
+		Subseeds:  infoStruct.AllSubseeds,
+		Model:     extractModel(respStruct.Info),
+		Infotexts: infoStruct.Infotexts,
 	}, nil
 }
 
-var modelRegex = regexp.MustCompile(`, (Model hash: \w+, Model: [^,]+),`)
+type ImageToImageRequest struct {
+	// base64 encoded images, the first one is used
+	InitImages []string `json:"init_images"`
+	// base64 encoded mask, white areas are repainted when inpainting
+	Mask              string  `json:"mask,omitempty"`
+	MaskBlur          int     `json:"mask_blur,omitempty"`
+	InpaintingFill    int     `json:"inpainting_fill"`
+	InpaintFullRes    bool    `json:"inpaint_full_res"`
+	ResizeMode        int     `json:"resize_mode"`
+	Prompt            string  `json:"prompt"`
+	NegativePrompt    string  `json:"negative_prompt"`
+	Width             int     `json:"width"`
+	Height            int     `json:"height"`
+	RestoreFaces      bool    `json:"restore_faces"`
+	DenoisingStrength float64 `json:"denoising_strength"`
+	BatchSize         int     `json:"batch_size"`
+	Seed              int     `json:"seed"`
+	Subseed           int     `json:"subseed"`
+	SubseedStrength   float64 `json:"subseed_strength"`
+	SamplerName       string  `json:"sampler_name"`
+	CfgScale          float64 `json:"cfg_scale"`
+	Steps             int     `json:"steps"`
+	NIter             int     `json:"n_iter"`
+	// names of prompt styles saved in the web UI
+	Styles []string `json:"styles,omitempty"`
+
+	// ScriptName runs one of the web UI scripts, e.g. "sd upscale", with ScriptArgs as its arguments
+	ScriptName string        `json:"script_name,omitempty"`
+	ScriptArgs []interface{} `json:"script_args,omitempty"`
+
+	SaveImages       bool                    `json:"save_images"`
+	OverrideSettings Txt2ImgOverrideSettings `json:"override_settings"`
+}
+
+// InpaintingFill values, for what goes under the mask before it is repainted
+const (
+	InpaintingFillFill          = 0
+	InpaintingFillOriginal      = 1
+	InpaintingFillLatentNoise   = 2
+	InpaintingFillLatentNothing = 3
+)
+
+func (api *apiImpl) ImageToImage(req *ImageToImageRequest) (*TextToImageResponse, error) {
+	if req == nil {
+		return nil, errors.New("missing request")
+	}
+
+	postURL := api.host + "/sdapi/v1/img2img"
+
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("POST", postURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	client := &http.Client{}
+
+	response, err := client.Do(request)
+	if err != nil {
+		log.Printf("API URL: %s", postURL)
+		log.Printf("Error with API Request: %v", err)
+
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(response.Body)
 
+	respStruct := &jsonTextToImageResponse{}
+
+	err = json.Unmarshal(body, respStruct)
+	if err != nil {
+		log.Printf("API URL: %s", postURL)
+		log.Printf("Unexpected API response: %s", string(body))
+
+		return nil, err
+	}
+
+	infoStruct := &jsonInfoResponse{}
+
+	err = json.Unmarshal([]byte(respStruct.Info), infoStruct)
+	if err != nil {
+		log.Printf("API URL: %s", postURL)
+		log.Printf("Unexpected API response: %s", string(body))
+
+		return nil, err
+	}
+
+	return &TextToImageResponse{
+		Images:    respStruct.Images,
+		Seeds:     infoStruct.AllSeeds,
+		Subseeds:  infoStruct.AllSubseeds,
+		Model:     extractModel(respStruct.Info),
+		Infotexts: infoStruct.Infotexts,
+	}, nil
+}
+
+var modelRegex = regexp.MustCompile(`, Model hash: \w+, Model: ([^,"]+)[,"]`)
+
+// extractModel returns the name of the model, like "anything-v4.5", empty when the info doesn't name it.
 func extractModel(infoJson string) string {
 	// It's in "infotexts" string so using regex
 	// <...>"infotexts": ["prompt text\\n<...>, Size: 512x512, Model hash: 1d1e459f9f, Model: anything-v4.5, <...>"], <...>
-	return string(modelRegex.Find([]byte(infoJson)))
+	match := modelRegex.FindStringSubmatch(infoJson)
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
 }
 
 type UpscaleRequest struct {
 	ResizeMode         int                 `json:"resize_mode"`
-	UpscalingResize    int                 `json:"upscaling_resize"`
+	UpscalingResize    float64             `json:"upscaling_resize"`
 	Upscaler1          string              `json:"upscaler1"`
 	TextToImageRequest *TextToImageRequest `json:"text_to_image_request"`
+	// Image is the base64 image to upscale, it is rendered from TextToImageRequest when empty
+	Image string `json:"image,omitempty"`
 }
 
 type upscaleJSONRequest struct {
-	ResizeMode      int    `json:"resize_mode"`
-	UpscalingResize int    `json:"upscaling_resize"`
-	Upscaler1       string `json:"upscaler1"`
-	Image           string `json:"image"`
+	ResizeMode      int     `json:"resize_mode"`
+	UpscalingResize float64 `json:"upscaling_resize"`
+	Upscaler1       string  `json:"upscaler1"`
+	Image           string  `json:"image"`
 }
 
 type UpscaleResponse struct {
@@ -183,24 +306,30 @@ func (api *apiImpl) UpscaleImage(upscaleReq *UpscaleRequest) (*UpscaleResponse,
 		return nil, errors.New("missing request")
 	}
 
-	textToImageReq := upscaleReq.TextToImageRequest
+	image := upscaleReq.Image
 
-	if textToImageReq == nil {
-		return nil, errors.New("missing text to image request")
-	}
+	if image == "" {
+		textToImageReq := upscaleReq.TextToImageRequest
 
-	textToImageReq.NIter = 1
+		if textToImageReq == nil {
+			return nil, errors.New("missing text to image request")
+		}
 
-	regeneratedImage, err := api.TextToImage(textToImageReq)
-	if err != nil {
-		return nil, err
+		textToImageReq.NIter = 1
+
+		regeneratedImage, err := api.TextToImage(textToImageReq)
+		if err != nil {
+			return nil, err
+		}
+
+		image = regeneratedImage.Images[0]
 	}
 
 	jsonReq := &upscaleJSONRequest{
 		ResizeMode:      upscaleReq.ResizeMode,
 		UpscalingResize: upscaleReq.UpscalingResize,
 		Upscaler1:       upscaleReq.Upscaler1,
-		Image:           regeneratedImage.Images[0],
+		Image:           image,
 	}
 
 	postURL := api.host + "/sdapi/v1/extra-single-image"
@@ -244,6 +373,68 @@ func (api *apiImpl) UpscaleImage(upscaleReq *UpscaleRequest) (*UpscaleResponse,
 	return respStruct, nil
 }
 
+// InterrogateModelClip describes the image in words, InterrogateModelDeepDanbooru in tags
+const (
+	InterrogateModelClip         = "clip"
+	InterrogateModelDeepDanbooru = "deepdanbooru"
+)
+
+type InterrogateRequest struct {
+	// Image is the base64 image to describe
+	Image string `json:"image"`
+	Model string `json:"model"`
+}
+
+type InterrogateResponse struct {
+	Caption string `json:"caption"`
+}
+
+func (api *apiImpl) Interrogate(req *InterrogateRequest) (*InterrogateResponse, error) {
+	if req == nil {
+		return nil, errors.New("missing request")
+	}
+
+	postURL := api.host + "/sdapi/v1/interrogate"
+
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("POST", postURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	client := &http.Client{}
+
+	response, err := client.Do(request)
+	if err != nil {
+		log.Printf("API URL: %s", postURL)
+		log.Printf("Error with API Request: %v", err)
+
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(response.Body)
+
+	respStruct := &InterrogateResponse{}
+
+	err = json.Unmarshal(body, respStruct)
+	if err != nil {
+		log.Printf("API URL: %s", postURL)
+		log.Printf("Unexpected API response: %s", string(body))
+
+		return nil, err
+	}
+
+	return respStruct, nil
+}
+
 type ProgressResponse struct {
 	Progress    float64 `json:"progress"`
 	EtaRelative float64 `json:"eta_relative"`
@@ -402,6 +593,57 @@ func (api *apiImpl) GetModels() ([]string, error) {
 	return titles, nil
 }
 
+type UpscalerEntry struct {
+	Name      string  `json:"name"`
+	ModelName string  `json:"model_name"`
+	ModelPath string  `json:"model_path"`
+	ModelURL  string  `json:"model_url"`
+	Scale     float64 `json:"scale"`
+}
+
+type UpscalersResponse []UpscalerEntry
+
+// GetUpscalers returns the upscaler names, in the order the web UI lists them.
+func (api *apiImpl) GetUpscalers() ([]string, error) {
+	getURL := api.host + "/sdapi/v1/upscalers"
+
+	request, err := http.NewRequest("GET", getURL, nil)
+	if err != nil {
+		log.Printf("Failed to create request for URL: %s, error: %v", getURL, err)
+		return nil, err
+	}
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		log.Printf("API URL: %s", getURL)
+		log.Printf("Error with API Request: %v", err)
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		log.Printf("Failed to read response body, error: %v", err)
+		return nil, err
+	}
+
+	var upscalers UpscalersResponse
+	err = json.Unmarshal(body, &upscalers)
+	if err != nil {
+		log.Printf("API URL: %s", getURL)
+		log.Printf("Unexpected API response: %s", string(body))
+		return nil, err
+	}
+
+	var names []string
+	for _, upscaler := range upscalers {
+		names = append(names, upscaler.Name)
+	}
+
+	return names, nil
+}
+
 func (api *apiImpl) SetSelectedModel(selectedModel string) error {
 	postURL := api.host + "/sdapi/v1/options"
 
@@ -428,5 +670,57 @@ func (api *apiImpl) SetSelectedModel(selectedModel string) error {
 	}
 	defer response.Body.Close()
 
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+
+		log.Printf("API URL: %s", postURL)
+		log.Printf("Unexpected API response: %s", string(body))
+
+		return fmt.Errorf("unexpected status setting the model: %s", response.Status)
+	}
+
 	return nil
 }
+
+// OptionsResponse holds the options of the WebUI the bot looks at.
+type OptionsResponse struct {
+	// SDModelCheckpoint is the title of the loaded model
+	SDModelCheckpoint string `json:"sd_model_checkpoint"`
+	// SDCheckpointHash is the SHA256 hash of the loaded model
+	SDCheckpointHash string `json:"sd_checkpoint_hash"`
+}
+
+func (api *apiImpl) GetOptions() (*OptionsResponse, error) {
+	getURL := api.host + "/sdapi/v1/options"
+
+	request, err := http.NewRequest("GET", getURL, nil)
+	if err != nil {
+		log.Printf("Failed to create request for URL: %s, error: %v", getURL, err)
+		return nil, err
+	}
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		log.Printf("API URL: %s", getURL)
+		log.Printf("Error with API Request: %v", err)
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		log.Printf("Failed to read response body, error: %v", err)
+		return nil, err
+	}
+
+	options := &OptionsResponse{}
+	err = json.Unmarshal(body, options)
+	if err != nil {
+		log.Printf("API URL: %s", getURL)
+		log.Printf("Unexpected API response: %s", string(body))
+		return nil, err
+	}
+
+	return options, nil
+}
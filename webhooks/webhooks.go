@@ -0,0 +1,304 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"stable_diffusion_bot/entities"
+	"stable_diffusion_bot/imagine_queue"
+)
+
+const (
+	// SignatureHeader carries the HMAC-SHA256 of the body, as "sha256=<hex>"
+	SignatureHeader = "X-Webhook-Signature-256"
+	// EventHeader carries the type of the event, e.g. "job.succeeded"
+	EventHeader = "X-Webhook-Event"
+	// DeliveryHeader is the same for every attempt to deliver an event
+	DeliveryHeader = "X-Webhook-Delivery"
+
+	defaultMaxAttempts  = 4
+	defaultRetryBackoff = 2 * time.Second
+	requestTimeout      = 15 * time.Second
+)
+
+// DefaultEvents leaves out the progress events, which are sent every second.
+var DefaultEvents = []imagine_queue.EventType{
+	imagine_queue.EventJobQueued,
+	imagine_queue.EventJobStarted,
+	imagine_queue.EventJobSucceeded,
+	imagine_queue.EventJobFailed,
+}
+
+var knownEvents = map[imagine_queue.EventType]bool{
+	imagine_queue.EventJobQueued:    true,
+	imagine_queue.EventJobStarted:   true,
+	imagine_queue.EventJobProgress:  true,
+	imagine_queue.EventJobSucceeded: true,
+	imagine_queue.EventJobFailed:    true,
+}
+
+type subscriberImpl struct {
+	urls         []string
+	secret       []byte
+	events       map[imagine_queue.EventType]bool
+	maxAttempts  int
+	retryBackoff time.Duration
+	client       *http.Client
+}
+
+type Config struct {
+	URLs []string
+	// Secret signs the body of every request
+	Secret string
+	// Events are the types of events to send, DefaultEvents when empty
+	Events []imagine_queue.EventType
+	// MaxAttempts is how often a delivery is tried, the wait between attempts doubles every time
+	MaxAttempts  int
+	RetryBackoff time.Duration
+}
+
+// New returns a subscriber that POSTs the events of the queue as signed JSON to the URLs.
+func New(cfg Config) (imagine_queue.Subscriber, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("missing webhook URLs")
+	}
+
+	if cfg.Secret == "" {
+		return nil, errors.New("missing webhook secret")
+	}
+
+	eventTypes := cfg.Events
+	if len(eventTypes) == 0 {
+		eventTypes = DefaultEvents
+	}
+
+	events := make(map[imagine_queue.EventType]bool, len(eventTypes))
+	for _, eventType := range eventTypes {
+		if !knownEvents[eventType] {
+			return nil, fmt.Errorf("unknown webhook event %q", eventType)
+		}
+
+		events[eventType] = true
+	}
+
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	retryBackoff := cfg.RetryBackoff
+	if retryBackoff <= 0 {
+		retryBackoff = defaultRetryBackoff
+	}
+
+	return &subscriberImpl{
+		urls:         cfg.URLs,
+		secret:       []byte(cfg.Secret),
+		events:       events,
+		maxAttempts:  maxAttempts,
+		retryBackoff: retryBackoff,
+		client:       &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+type jobPayload struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Prompt    string `json:"prompt"`
+	RequestID string `json:"request_id"`
+	MemberID  string `json:"member_id"`
+	ChannelID string `json:"channel_id,omitempty"`
+	GuildID   string `json:"guild_id,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+type imagePayload struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	// Data is encoded as base64
+	Data []byte `json:"data"`
+}
+
+type payload struct {
+	Event      imagine_queue.EventType `json:"event"`
+	DeliveryID string                  `json:"delivery_id"`
+	OccurredAt time.Time               `json:"occurred_at"`
+	Job        jobPayload              `json:"job"`
+
+	Position        int                         `json:"position,omitempty"`
+	Progress        float64                     `json:"progress,omitempty"`
+	UpscaleProgress float64                     `json:"upscale_progress,omitempty"`
+	Images          []imagePayload              `json:"images,omitempty"`
+	Generations     []*entities.ImageGeneration `json:"generations,omitempty"`
+	Error           string                      `json:"error,omitempty"`
+}
+
+var itemTypeNames = map[imagine_queue.ItemType]string{
+	imagine_queue.ItemTypeImagine:   "imagine",
+	imagine_queue.ItemTypeReroll:    "reroll",
+	imagine_queue.ItemTypeUpscale:   "upscale",
+	imagine_queue.ItemTypeVariation: "variation",
+	imagine_queue.ItemTypeRemix:     "remix",
+	imagine_queue.ItemTypePlot:      "plot",
+	imagine_queue.ItemTypeOutpaint:  "outpaint",
+}
+
+func newPayload(event imagine_queue.Event) *payload {
+	item := event.Job()
+
+	body := &payload{
+		Event:      event.Type(),
+		DeliveryID: fmt.Sprintf("%s-%s-%d", item.JobID, event.Type(), event.OccurredAt().UnixNano()),
+		OccurredAt: event.OccurredAt(),
+		Job: jobPayload{
+			ID:        item.JobID,
+			Type:      itemTypeNames[item.Type],
+			Prompt:    item.Prompt,
+			RequestID: item.Source.RequestID,
+			MemberID:  item.Source.MemberID,
+			ChannelID: item.Source.ChannelID,
+			GuildID:   item.Source.GuildID,
+			MessageID: item.ResultMessageID,
+		},
+	}
+
+	switch typedEvent := event.(type) {
+	case imagine_queue.JobQueued:
+		body.Position = typedEvent.Position
+	case imagine_queue.JobStarted:
+		body.Job.MessageID = typedEvent.MessageID
+		body.Progress = typedEvent.Status.Progress
+	case imagine_queue.JobProgress:
+		body.Progress = typedEvent.Status.Progress
+		body.UpscaleProgress = typedEvent.Status.UpscaleProgress
+	case imagine_queue.JobSucceeded:
+		body.Progress = 1
+		body.Generations = typedEvent.Generations
+
+		if typedEvent.Result != nil {
+			for _, image := range typedEvent.Result.Images {
+				body.Images = append(body.Images, imagePayload{
+					Name:        image.Name,
+					ContentType: http.DetectContentType(image.Data),
+					Data:        image.Data,
+				})
+			}
+		}
+	case imagine_queue.JobFailed:
+		if typedEvent.Err != nil {
+			body.Error = typedEvent.Err.Error()
+		}
+	}
+
+	return body
+}
+
+func (s *subscriberImpl) HandleEvent(event imagine_queue.Event) {
+	if !s.events[event.Type()] {
+		return
+	}
+
+	eventPayload := newPayload(event)
+
+	body, err := json.Marshal(eventPayload)
+	if err != nil {
+		log.Printf("Error encoding webhook payload: %v", err)
+
+		return
+	}
+
+	for _, url := range s.urls {
+		err = s.deliver(url, event.Type(), eventPayload.DeliveryID, body)
+		if err != nil {
+			log.Printf("Error delivering %s webhook to %s: %v", event.Type(), url, err)
+		}
+	}
+}
+
+// Sign returns the signature of the body, as sent in the SignatureHeader.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryableError is a failed delivery that may work when tried again.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+// deliver POSTs the body to the URL, trying again after network errors, rate limits and server errors.
+func (s *subscriberImpl) deliver(url string, eventType imagine_queue.EventType, deliveryID string, body []byte) error {
+	backoff := s.retryBackoff
+
+	var err error
+
+	for attempt := 1; attempt <= s.maxAttempts; attempt++ {
+		err = s.post(url, eventType, deliveryID, body)
+		if err == nil {
+			return nil
+		}
+
+		var retryErr *retryableError
+		if !errors.As(err, &retryErr) || attempt == s.maxAttempts {
+			break
+		}
+
+		log.Printf("Webhook delivery to %s failed (attempt %d of %d), retrying in %v: %v",
+			url, attempt, s.maxAttempts, backoff, err)
+
+		time.Sleep(backoff)
+
+		backoff *= 2
+	}
+
+	return err
+}
+
+func (s *subscriberImpl) post(url string, eventType imagine_queue.EventType, deliveryID string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "stable-diffusion-discord-bot")
+	request.Header.Set(EventHeader, string(eventType))
+	request.Header.Set(DeliveryHeader, deliveryID)
+	request.Header.Set(SignatureHeader, Sign(s.secret, body))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return &retryableError{err: err}
+	}
+	defer response.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16))
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+
+	statusErr := errors.New("unexpected status " + strconv.Itoa(response.StatusCode))
+
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500 {
+		return &retryableError{err: statusErr}
+	}
+
+	return statusErr
+}